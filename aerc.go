@@ -6,9 +6,11 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path"
 	"time"
 
 	"git.sr.ht/~sircmpwn/getopt"
+	"github.com/kyoh86/xdg"
 	"github.com/mattn/go-isatty"
 
 	"git.sr.ht/~sircmpwn/aerc/commands"
@@ -16,9 +18,11 @@ import (
 	"git.sr.ht/~sircmpwn/aerc/commands/compose"
 	"git.sr.ht/~sircmpwn/aerc/commands/msg"
 	"git.sr.ht/~sircmpwn/aerc/commands/msgview"
+	"git.sr.ht/~sircmpwn/aerc/commands/script"
 	"git.sr.ht/~sircmpwn/aerc/commands/terminal"
 	"git.sr.ht/~sircmpwn/aerc/config"
 	"git.sr.ht/~sircmpwn/aerc/lib"
+	"git.sr.ht/~sircmpwn/aerc/lib/outbox"
 	libui "git.sr.ht/~sircmpwn/aerc/lib/ui"
 	"git.sr.ht/~sircmpwn/aerc/widgets"
 )
@@ -30,25 +34,37 @@ func getCommands(selected libui.Drawable) []*commands.Commands {
 			account.AccountCommands,
 			msg.MessageCommands,
 			commands.GlobalCommands,
+			script.ScriptCommands,
+			commands.AliasCommands,
 		}
 	case *widgets.Composer:
 		return []*commands.Commands{
 			compose.ComposeCommands,
 			commands.GlobalCommands,
+			script.ScriptCommands,
+			commands.AliasCommands,
 		}
 	case *widgets.MessageViewer:
 		return []*commands.Commands{
 			msgview.MessageViewCommands,
 			msg.MessageCommands,
 			commands.GlobalCommands,
+			script.ScriptCommands,
+			commands.AliasCommands,
 		}
 	case *widgets.Terminal:
 		return []*commands.Commands{
 			terminal.TerminalCommands,
 			commands.GlobalCommands,
+			script.ScriptCommands,
+			commands.AliasCommands,
 		}
 	default:
-		return []*commands.Commands{commands.GlobalCommands}
+		return []*commands.Commands{
+			commands.GlobalCommands,
+			script.ScriptCommands,
+			commands.AliasCommands,
+		}
 	}
 }
 
@@ -94,29 +110,43 @@ var (
 )
 
 func usage() {
-	log.Fatal("Usage: aerc [-v] [mailto:...]")
+	log.Fatal("Usage: aerc [-v] [-c <command>] [mailto:...]")
+}
+
+// Runs a method of the aerc instance which is already running
+func remoteCall(method string, params map[string]string) {
+	if err := lib.ConnectAndCall(method, params, nil); err != nil {
+		fmt.Fprintf(os.Stderr, "aerc: %v\n", err)
+		os.Exit(1)
+	}
 }
 
 func main() {
-	opts, optind, err := getopt.Getopts(os.Args, "v")
+	opts, optind, err := getopt.Getopts(os.Args, "vc:")
 	if err != nil {
 		log.Print(err)
 		usage()
 		return
 	}
+	var command string
 	for _, opt := range opts {
 		switch opt.Option {
 		case 'v':
 			fmt.Println("aerc " + Version)
 			return
+		case 'c':
+			command = opt.Value
 		}
 	}
 	args := os.Args[optind:]
-	if len(args) > 1 {
+	if len(args) > 1 || (len(args) == 1 && command != "") {
 		usage()
 		return
+	} else if command != "" {
+		remoteCall("command", map[string]string{"command": command})
+		return
 	} else if len(args) == 1 {
-		lib.ConnectAndExec(args[0])
+		remoteCall("mailto", map[string]string{"url": args[0]})
 		return
 	}
 
@@ -139,6 +169,8 @@ func main() {
 		os.Exit(1)
 	}
 
+	commands.RegisterAliases(conf.Aliases)
+
 	var (
 		aerc *widgets.Aerc
 		ui   *libui.UI
@@ -167,8 +199,40 @@ func main() {
 	} else {
 		defer as.Close()
 		as.OnMailto = aerc.Mailto
+		as.OnRequest = aerc.HandleRequest
+		aerc.OnEvent(as.Notify)
 	}
 
+	deliver := func(msg *outbox.Message, content []byte) error {
+		return compose.Deliver(aerc, msg, content)
+	}
+	outboxDir := path.Join(xdg.DataHome(), "aerc", "outbox")
+	ob, err := outbox.New(outboxDir, deliver)
+	var ownOutbox string
+	if err == outbox.ErrLocked {
+		// Another aerc sends the messages of the shared outbox: this one
+		// keeps its own while it runs, and leaves what is unsent in the
+		// shared one when it exits
+		logger.Println("The outbox is used by another aerc")
+		ownOutbox, err = ioutil.TempDir("", "aerc-outbox-")
+		if err == nil {
+			ob, err = outbox.New(ownOutbox, deliver)
+		}
+	}
+	if err != nil {
+		aerc.PushError(" Failed to load the outbox: " + err.Error())
+	} else {
+		aerc.SetOutbox(ob)
+		go ob.Run()
+	}
+
+	scripts := path.Join(xdg.ConfigHome(), "aerc", "scripts")
+	if err := script.Load(aerc, scripts); err != nil {
+		aerc.PushError(" " + err.Error())
+	}
+
+	conf.Hooks.Run("aerc-startup", config.HookContext{})
+
 	for !ui.ShouldExit() {
 		for aerc.Tick() {
 			// Continue updating our internal state
@@ -178,5 +242,17 @@ func main() {
 			time.Sleep(16 * time.Millisecond)
 		}
 	}
+	aerc.SendPending()
+	if ob != nil && ownOutbox != "" {
+		if err := ob.MoveTo(outboxDir); err != nil {
+			logger.Printf("Failed to move the messages of %s: %v",
+				ownOutbox, err)
+		} else if len(ob.Messages()) == 0 {
+			ob.Close()
+			os.RemoveAll(ownOutbox)
+		}
+	}
 	aerc.CloseBackends()
+	conf.Hooks.Run("aerc-shutdown", config.HookContext{})
+	conf.Hooks.Wait()
 }
@@ -0,0 +1,75 @@
+package account
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"git.sr.ht/~sircmpwn/aerc/models"
+	"git.sr.ht/~sircmpwn/aerc/widgets"
+	"git.sr.ht/~sircmpwn/aerc/worker/types"
+)
+
+type ApplyRules struct{}
+
+func init() {
+	register(ApplyRules{})
+}
+
+func (_ ApplyRules) Aliases() []string {
+	return []string{"apply-rules"}
+}
+
+func (_ ApplyRules) Complete(aerc *widgets.Aerc, args []string) []string {
+	return nil
+}
+
+func (_ ApplyRules) Execute(aerc *widgets.Aerc, args []string) error {
+	if len(args) != 1 {
+		return errors.New("Usage: :apply-rules")
+	}
+	acct := aerc.SelectedAccount()
+	if acct == nil {
+		return errors.New("No account selected")
+	}
+	store := acct.Store()
+	if store == nil {
+		return errors.New("Cannot perform action. Messages still loading")
+	}
+	if len(aerc.Config().Rules) == 0 {
+		return errors.New("No rules in rules.conf")
+	}
+	apply := func() {
+		var msgs []*models.MessageInfo
+		for _, msg := range store.Messages {
+			msgs = append(msgs, msg)
+		}
+		n := acct.ApplyRules(msgs)
+		aerc.PushStatus(fmt.Sprintf("Rules matched %d messages", n),
+			10*time.Second)
+	}
+	// The rules need the headers of every message of the folder, even those
+	// hidden by a filter
+	var missing []uint32
+	for uid, msg := range store.Messages {
+		if msg == nil || msg.Envelope == nil {
+			missing = append(missing, uid)
+		}
+	}
+	if len(missing) == 0 {
+		apply()
+		return nil
+	}
+	aerc.PushStatus("Fetching message headers...", 10*time.Second)
+	acct.Worker().PostAction(&types.FetchMessageHeaders{
+		Uids: missing,
+	}, func(msg types.WorkerMessage) {
+		switch msg := msg.(type) {
+		case *types.Done:
+			apply()
+		case *types.Error:
+			aerc.PushError(" " + msg.Error.Error())
+		}
+	})
+	return nil
+}
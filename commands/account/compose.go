@@ -5,6 +5,7 @@ import (
 	"regexp"
 	"strings"
 
+	"git.sr.ht/~sircmpwn/aerc/models"
 	"git.sr.ht/~sircmpwn/aerc/widgets"
 	"git.sr.ht/~sircmpwn/getopt"
 )
@@ -24,13 +25,20 @@ func (_ Compose) Complete(aerc *widgets.Aerc, args []string) []string {
 }
 
 func (_ Compose) Execute(aerc *widgets.Aerc, args []string) error {
-	body, err := buildBody(args)
+	body, template, err := buildBody(args)
 	if err != nil {
 		return err
 	}
+	if template == "" {
+		template = aerc.Config().Templates.NewMessage
+	}
 	acct := aerc.SelectedAccount()
-	composer := widgets.NewComposer(
-		aerc.Config(), acct.AccountConfig(), acct.Worker(), nil)
+	composer, err := widgets.NewComposer(aerc.Config(),
+		acct.AccountConfig(), acct.Worker(), template, nil,
+		models.OriginalMail{})
+	if err != nil {
+		return err
+	}
 	tab := aerc.NewTab(composer, "New email")
 	tab.OnClose(func() bool {
 		return false
@@ -43,18 +51,23 @@ func (_ Compose) Execute(aerc *widgets.Aerc, args []string) error {
 		}
 		tab.Content.Invalidate()
 	})
-	go composer.SetContents(strings.NewReader(body))
+	if body != "" {
+		// The signature is kept
+		composer.SetContents(strings.NewReader(body))
+	}
 	return nil
 }
 
-func buildBody(args []string) (string, error) {
-	var body, headers string
-	opts, optind, err := getopt.Getopts(args, "H:")
+func buildBody(args []string) (string, string, error) {
+	var body, template, headers string
+	opts, optind, err := getopt.Getopts(args, "H:T:")
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 	for _, opt := range opts {
 		switch opt.Option {
+		case 'T':
+			template = opt.Value
 		case 'H':
 			if strings.Index(opt.Value, ":") != -1 {
 				// ensure first colon is followed by a single space
@@ -67,7 +80,7 @@ func buildBody(args []string) (string, error) {
 	}
 	posargs := args[optind:]
 	if len(posargs) > 1 {
-		return "", errors.New("Usage: compose [-H] [body]")
+		return "", "", errors.New("Usage: compose [-H header] [-T template] [body]")
 	}
 	if len(posargs) == 1 {
 		body = posargs[0]
@@ -79,5 +92,5 @@ func buildBody(args []string) (string, error) {
 			body = headers + "\n\n"
 		}
 	}
-	return body, nil
+	return body, template, nil
 }
@@ -4,8 +4,6 @@ import (
 	"errors"
 	"time"
 
-	"github.com/gdamore/tcell"
-
 	"git.sr.ht/~sircmpwn/aerc/widgets"
 	"git.sr.ht/~sircmpwn/aerc/worker/types"
 )
@@ -41,8 +39,7 @@ func (_ MakeDir) Execute(aerc *widgets.Aerc, args []string) error {
 			aerc.PushStatus("Directory created.", 10*time.Second)
 			acct.Directories().Select(name)
 		case *types.Error:
-			aerc.PushStatus(" "+msg.Error.Error(), 10*time.Second).
-				Color(tcell.ColorDefault, tcell.ColorRed)
+			aerc.PushError(" " + msg.Error.Error())
 		}
 	})
 	return nil
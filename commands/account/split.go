@@ -0,0 +1,58 @@
+package account
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"git.sr.ht/~sircmpwn/aerc/widgets"
+)
+
+type Split struct{}
+
+func init() {
+	register(Split{})
+}
+
+func (_ Split) Aliases() []string {
+	return []string{"split", "vsplit", "close-split"}
+}
+
+func (_ Split) Complete(aerc *widgets.Aerc, args []string) []string {
+	return nil
+}
+
+func (_ Split) Execute(aerc *widgets.Aerc, args []string) error {
+	if len(args) > 2 || (args[0] == "close-split" && len(args) > 1) {
+		return splitUsage(args[0])
+	}
+	var (
+		n   int
+		err error
+	)
+	if len(args) > 1 {
+		n, err = strconv.Atoi(args[1])
+		if err != nil {
+			return splitUsage(args[0])
+		}
+	}
+	acct := aerc.SelectedAccount()
+	if acct == nil {
+		return errors.New("No account selected")
+	}
+	switch args[0] {
+	case "split":
+		return acct.Split(n)
+	case "vsplit":
+		return acct.Vsplit(n)
+	default:
+		return acct.CloseSplit()
+	}
+}
+
+func splitUsage(cmd string) error {
+	if cmd == "close-split" {
+		return errors.New("Usage: close-split")
+	}
+	return errors.New(fmt.Sprintf("Usage: %s [n]", cmd))
+}
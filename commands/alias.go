@@ -0,0 +1,140 @@
+package commands
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"git.sr.ht/~sircmpwn/aerc/lib"
+	"git.sr.ht/~sircmpwn/aerc/widgets"
+)
+
+var (
+	// The aliases from the [aliases] section of aerc.conf. They are tried
+	// after the built-in commands.
+	AliasCommands = NewCommands()
+
+	aliasArg = regexp.MustCompile(`\$(\$|@|[1-9])`)
+	// How deep aliases and sourced files may run other aliases and files
+	maxNesting = 16
+	nesting    = 0
+)
+
+type Alias struct {
+	Name    string
+	Command string
+}
+
+func RegisterAliases(aliases map[string]string) {
+	for name, command := range aliases {
+		AliasCommands.Register(Alias{Name: name, Command: command})
+	}
+}
+
+func (alias Alias) Aliases() []string {
+	return []string{alias.Name}
+}
+
+// Complete completes the arguments of an alias like those of the command they
+// go to: the first command referring to arguments, or the last one if none
+// does.
+func (alias Alias) Complete(aerc *widgets.Aerc, args []string) []string {
+	cmds, err := lib.SplitCommands(alias.Command)
+	if err != nil || len(cmds) == 0 {
+		return nil
+	}
+	target := cmds[len(cmds)-1]
+	for _, cmd := range cmds {
+		if i := argumentIndex(cmd); i != -1 {
+			target = cmd[:i]
+			break
+		}
+	}
+	if len(target) == 0 {
+		return nil
+	}
+	prefix := quoteArgs(target) + " "
+	var completions []string
+	nested(alias.Name, func() error {
+		for _, c := range aerc.Complete(prefix + strings.Join(args, " ")) {
+			if strings.HasPrefix(c, prefix) {
+				completions = append(completions, c[len(prefix):])
+			}
+		}
+		return nil
+	})
+	return completions
+}
+
+// Returns the index of the first argument of a command which refers to the
+// arguments of the alias, or -1
+func argumentIndex(cmd []string) int {
+	for i, arg := range cmd {
+		for _, ref := range aliasArg.FindAllString(arg, -1) {
+			if ref != "$$" {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func (alias Alias) Execute(aerc *widgets.Aerc, args []string) error {
+	return nested(alias.Name, func() error {
+		return aerc.RunCommands(alias.Expand(args[1:]))
+	})
+}
+
+// Runs fn unless aliases and sourced files are nested too deeply, which
+// usually means that one of them runs itself
+func nested(name string, fn func() error) error {
+	if nesting >= maxNesting {
+		return errors.New("Too many nested commands in " + name)
+	}
+	nesting++
+	defer func() { nesting-- }()
+	return fn()
+}
+
+// Expand substitutes the arguments of the alias for $1 to $9 and $@ in its
+// commands. If the commands do not refer to arguments, the arguments are
+// appended to the last command.
+func (alias Alias) Expand(args []string) string {
+	used := false
+	line := aliasArg.ReplaceAllStringFunc(alias.Command, func(ref string) string {
+		switch ref[1] {
+		case '$':
+			return "$"
+		case '@':
+			used = true
+			return quoteArgs(args)
+		}
+		used = true
+		n, _ := strconv.Atoi(ref[1:])
+		if n > len(args) {
+			return "''"
+		}
+		return quoteArg(args[n-1])
+	})
+	if !used && len(args) != 0 {
+		line += " " + quoteArgs(args)
+	}
+	return line
+}
+
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quoteArg(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Quotes an argument so that it is parsed back as is
+func quoteArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\;#") {
+		return arg
+	}
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
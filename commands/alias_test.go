@@ -0,0 +1,54 @@
+package commands
+
+import (
+	"reflect"
+	"testing"
+
+	"git.sr.ht/~sircmpwn/aerc/lib"
+)
+
+func TestAliasExpand(t *testing.T) {
+	type tc struct {
+		command  string
+		args     []string
+		expected [][]string
+	}
+	cases := []*tc{
+		&tc{"read; archive flat; next", nil, [][]string{
+			{"read"}, {"archive", "flat"}, {"next"},
+		}},
+		&tc{":move $1; :next", []string{"Lists/aerc devel"}, [][]string{
+			{"move", "Lists/aerc devel"}, {"next"},
+		}},
+		&tc{"cf", []string{"It's; here"}, [][]string{
+			{"cf", "It's; here"},
+		}},
+		&tc{"exec echo $2 $@ $$1", []string{"a", "#b"}, [][]string{
+			{"exec", "echo", "#b", "a", "#b", "$1"},
+		}},
+		&tc{"exec echo '$1;$1'", []string{"x"}, [][]string{
+			{"exec", "echo", "x;x"},
+		}},
+		&tc{"exec echo $3", []string{"x"}, [][]string{
+			{"exec", "echo", ""},
+		}},
+		// The arguments of exec and pipe are the rest of the line
+		&tc{"read; exec sh -c a; b", nil, [][]string{
+			{"read"}, {"exec", "sh", "-c", "a;", "b"},
+		}},
+		&tc{"pipe -m sh -c $1; next", []string{"x"}, [][]string{
+			{"pipe", "-m", "sh", "-c", "x;", "next"},
+		}},
+	}
+	for _, c := range cases {
+		line := Alias{Name: "test", Command: c.command}.Expand(c.args)
+		cmds, err := lib.SplitCommands(line)
+		if err != nil {
+			t.Errorf("%s: %v", line, err)
+			continue
+		}
+		if !reflect.DeepEqual(cmds, c.expected) {
+			t.Errorf("%s: got %q, expected %q", line, cmds, c.expected)
+		}
+	}
+}
@@ -3,11 +3,9 @@ package compose
 import (
 	"fmt"
 	"os"
-	"time"
 
 	"git.sr.ht/~sircmpwn/aerc/commands"
 	"git.sr.ht/~sircmpwn/aerc/widgets"
-	"github.com/gdamore/tcell"
 	"github.com/mitchellh/go-homedir"
 )
 
@@ -55,8 +53,7 @@ func (_ Attach) Execute(aerc *widgets.Aerc, args []string) error {
 	composer, _ := aerc.SelectedTab().(*widgets.Composer)
 	composer.AddAttachment(path)
 
-	aerc.PushStatus(fmt.Sprintf("Attached %s", pathinfo.Name()), 10*time.Second).
-		Color(tcell.ColorDefault, tcell.ColorGreen)
+	aerc.PushSuccess(fmt.Sprintf("Attached %s", pathinfo.Name()))
 
 	return nil
 }
@@ -2,10 +2,8 @@ package compose
 
 import (
 	"fmt"
-	"time"
 
 	"git.sr.ht/~sircmpwn/aerc/widgets"
-	"github.com/gdamore/tcell"
 )
 
 type Detach struct{}
@@ -48,8 +46,7 @@ func (_ Detach) Execute(aerc *widgets.Aerc, args []string) error {
 		return err
 	}
 
-	aerc.PushStatus(fmt.Sprintf("Detached %s", path), 10*time.Second).
-		Color(tcell.ColorDefault, tcell.ColorGreen)
+	aerc.PushSuccess(fmt.Sprintf("Detached %s", path))
 
 	return nil
 }
@@ -3,11 +3,9 @@ package compose
 import (
 	"fmt"
 	// "os"
-	"time"
 
 	// "git.sr.ht/~sircmpwn/aerc/commands"
 	"git.sr.ht/~sircmpwn/aerc/widgets"
-	"github.com/gdamore/tcell"
 	// "github.com/mitchellh/go-homedir"
 )
 
@@ -58,8 +56,7 @@ func (_ Postpone) Execute(aerc *widgets.Aerc, args []string) error {
 	// composer.WriteMessage(header, w)
 	// w.Close()
 
-	aerc.PushStatus(fmt.Sprintf("Attached %s", ""), 10*time.Second).
-		Color(tcell.ColorDefault, tcell.ColorGreen)
+	aerc.PushSuccess(fmt.Sprintf("Attached %s", ""))
 
 	return nil
 }
@@ -1,9 +1,9 @@
 package compose
 
 import (
+	"bytes"
 	"crypto/tls"
 	"fmt"
-	"io"
 	"net/mail"
 	"net/url"
 	"os/exec"
@@ -13,11 +13,11 @@ import (
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-sasl"
 	"github.com/emersion/go-smtp"
-	"github.com/gdamore/tcell"
 	"github.com/google/shlex"
-	"github.com/miolini/datacounter"
 	"github.com/pkg/errors"
 
+	"git.sr.ht/~sircmpwn/aerc/config"
+	"git.sr.ht/~sircmpwn/aerc/lib/outbox"
 	"git.sr.ht/~sircmpwn/aerc/widgets"
 	"git.sr.ht/~sircmpwn/aerc/worker/types"
 )
@@ -37,225 +37,382 @@ func (_ Send) Complete(aerc *widgets.Aerc, args []string) []string {
 }
 
 func (_ Send) Execute(aerc *widgets.Aerc, args []string) error {
-	if len(args) > 1 {
-		return errors.New("Usage: send")
+	var at time.Time
+	if len(args) > 2 && args[1] == "-at" {
+		var err error
+		at, err = outbox.ParseTime(strings.Join(args[2:], " "), time.Now())
+		if err != nil {
+			return err
+		}
+	} else if len(args) > 1 {
+		return errors.New("Usage: send [-at <time>]")
+	}
+	ob := aerc.Outbox()
+	if ob == nil {
+		return errors.New("No outbox to send the message from")
 	}
 	composer, _ := aerc.SelectedTab().(*widgets.Composer)
+	if composer.Sending() {
+		return errors.New("The message is being sent already")
+	}
 	config := composer.Config()
 
 	if config.Outgoing == "" {
 		return errors.New(
 			"No outgoing mail transport configured for this account")
 	}
+	if _, _, err := parseOutgoing(config.Outgoing); err != nil {
+		return err
+	}
 
-	aerc.Logger().Println("Sending mail")
+	header, rcpts, err := composer.PrepareHeader()
+	if err != nil {
+		return errors.Wrap(err, "PrepareHeader")
+	}
 
-	uri, err := url.Parse(config.Outgoing)
+	if config.From == "" {
+		return errors.New("No 'From' configured for this account")
+	}
+	from, err := mail.ParseAddress(config.From)
 	if err != nil {
-		return errors.Wrap(err, "url.Parse(outgoing)")
+		return errors.Wrap(err, "ParseAddress(config.From)")
 	}
-	var (
-		scheme string
-		auth   string = "plain"
-	)
+
+	var buf bytes.Buffer
+	if err := composer.WriteMessage(header, &buf); err != nil {
+		return errors.Wrap(err, "WriteMessage")
+	}
+	msg, err := composer.OutgoingMessage(header, buf.Bytes())
+	if err != nil {
+		return errors.Wrap(err, "OutgoingMessage")
+	}
+	subject, _ := header.Subject()
+	queue := func() error {
+		_, err := ob.Queue(outbox.Message{
+			Account: config.Name,
+			From:    from.Address,
+			Rcpts:   rcpts,
+			Subject: subject,
+			At:      at,
+		}, buf.Bytes())
+		if err != nil {
+			return errors.Wrap(err, "Queue")
+		}
+		composer.Close()
+		return nil
+	}
+	send := func() {
+		composer.SetSending(false)
+		// Scheduled messages can be canceled in the outbox instead
+		if delay := aerc.Config().Compose.SendDelay; delay > 0 && at.IsZero() {
+			aerc.SendLater(composer, delay, queue)
+			return
+		}
+		if err := queue(); err != nil {
+			aerc.PushError(" " + err.Error())
+			return
+		}
+		aerc.RemoveTab(composer)
+		if !at.IsZero() {
+			aerc.PushSuccess("Message scheduled for " +
+				at.Format("Mon Jan 2 15:04"))
+		}
+	}
+
+	// The check commands may take a while
+	checks := &aerc.Config().Checks
+	if len(checks.Commands) != 0 {
+		aerc.SetStatus("Checking the message...")
+	}
+	composer.SetSending(true)
+	go func() {
+		warnings := checks.Run(msg)
+		aerc.Post(func() {
+			// The message may have been discarded in the meantime
+			if !aerc.HasTab(composer) {
+				return
+			}
+			if len(checks.Commands) != 0 {
+				aerc.SetStatus("Message checked.")
+			}
+			if len(warnings) == 0 {
+				send()
+				return
+			}
+			// The warnings are shown in the review
+			composer.ShowWarnings(warnings)
+			question := warnings[0] + ". Send anyway?"
+			if len(warnings) > 1 {
+				question = fmt.Sprintf("%d warnings. Send anyway?",
+					len(warnings))
+			}
+			aerc.Confirm(question, func(yes bool) {
+				if yes && aerc.HasTab(composer) {
+					send()
+				} else {
+					composer.SetSending(false)
+				}
+			})
+		})
+	}()
+	return nil
+}
+
+// Returns the scheme and the authentication mechanism of an outgoing transport
+func parseOutgoing(outgoing string) (*url.URL, string, error) {
+	uri, err := url.Parse(outgoing)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "url.Parse(outgoing)")
+	}
+	auth := "plain"
 	if uri.Scheme != "" {
 		parts := strings.Split(uri.Scheme, "+")
 		if len(parts) == 1 {
-			scheme = parts[0]
+			uri.Scheme = parts[0]
 		} else if len(parts) == 2 {
-			scheme = parts[0]
+			uri.Scheme = parts[0]
 			auth = parts[1]
 		} else {
-			return fmt.Errorf("Unknown transfer protocol %s", uri.Scheme)
+			return nil, "", fmt.Errorf("Unknown transfer protocol %s",
+				uri.Scheme)
 		}
 	}
+	switch uri.Scheme {
+	case "smtp", "smtps", "jmap", "":
+	default:
+		return nil, "", fmt.Errorf("Unknown transfer protocol %s", uri.Scheme)
+	}
+	switch auth {
+	case "", "none", "plain":
+	default:
+		return nil, "", fmt.Errorf("Unsupported auth mechanism %s", auth)
+	}
+	return uri, auth, nil
+}
 
-	header, rcpts, err := composer.PrepareHeader()
+// Deliver sends a message of the outbox with the outgoing transport of its
+// account, and then copies it to the copy-to folder of the account. It is
+// called by the outbox, on its own goroutine.
+func Deliver(aerc *widgets.Aerc, msg *outbox.Message, content []byte) error {
+	acct := aerc.Account(msg.Account)
+	if acct == nil {
+		return fmt.Errorf("No account named %s", msg.Account)
+	}
+	config := acct.AccountConfig()
+	uri, auth, err := parseOutgoing(config.Outgoing)
 	if err != nil {
-		return errors.Wrap(err, "PrepareHeader")
+		return err
 	}
 
-	if config.From == "" {
-		return errors.New("No 'From' configured for this account")
+	aerc.Logger().Println("Sending mail")
+	aerc.SetStatus("Sending...")
+	switch uri.Scheme {
+	case "smtp", "smtps":
+		err = sendSMTP(aerc, config, uri, auth, msg, content)
+	case "jmap":
+		err = sendJMAP(aerc, acct.Worker(), msg, content)
+	case "":
+		err = sendmail(uri, msg, content)
 	}
-	from, err := mail.ParseAddress(config.From)
 	if err != nil {
-		return errors.Wrap(err, "ParseAddress(config.From)")
+		aerc.SetError(" " + err.Error())
+		return err
 	}
 
-	var (
-		saslClient sasl.Client
-		conn       *smtp.Client
-	)
-	switch auth {
-	case "":
-		fallthrough
-	case "none":
-		saslClient = nil
-	case "plain":
+	var from string
+	if m, err := mail.ReadMessage(bytes.NewReader(content)); err == nil {
+		from = m.Header.Get("From")
+	}
+	aerc.Notify("message-sent", map[string]interface{}{
+		"account":    config.Name,
+		"subject":    msg.Subject,
+		"recipients": msg.Rcpts,
+	})
+	runSentHook(aerc, config.Name, from, msg.Subject, msg.Rcpts)
+	// JMAP keeps sent messages in the sent mailbox already
+	if config.CopyTo == "" || uri.Scheme == "jmap" {
+		aerc.SetStatus("Message sent.")
+		return nil
+	}
+	aerc.SetStatus("Copying to " + config.CopyTo)
+	aerc.Post(func() {
+		acct.Worker().PostAction(&types.AppendMessage{
+			Destination: config.CopyTo,
+			Flags:       []string{imap.SeenFlag},
+			Date:        time.Now(),
+			Reader:      bytes.NewReader(content),
+			Length:      len(content),
+		}, func(msg types.WorkerMessage) {
+			switch msg := msg.(type) {
+			case *types.Done:
+				aerc.SetStatus("Message sent.")
+			case *types.Error:
+				aerc.PushError(" " + msg.Error.Error())
+			}
+		})
+	})
+	return nil
+}
+
+func sendSMTP(aerc *widgets.Aerc, config *config.AccountConfig, uri *url.URL,
+	auth string, msg *outbox.Message, content []byte) error {
+
+	var saslClient sasl.Client
+	if auth == "plain" && uri.User != nil {
 		password, _ := uri.User.Password()
 		saslClient = sasl.NewPlainClient("", uri.User.Username(), password)
-	default:
-		return fmt.Errorf("Unsupported auth mechanism %s", auth)
 	}
 
-	aerc.RemoveTab(composer)
-
 	var starttls bool
 	if starttls_, ok := config.Params["smtp-starttls"]; ok {
 		starttls = starttls_ == "yes"
 	}
 
-	smtpAsync := func() (int, error) {
-		switch scheme {
-		case "smtp":
-			host := uri.Host
-			serverName := uri.Host
-			if !strings.ContainsRune(host, ':') {
-				host = host + ":587" // Default to submission port
-			} else {
-				serverName = host[:strings.IndexRune(host, ':')]
-			}
-			conn, err = smtp.Dial(host)
-			if err != nil {
-				return 0, errors.Wrap(err, "smtp.Dial")
-			}
-			defer conn.Close()
-			if sup, _ := conn.Extension("STARTTLS"); sup {
-				if !starttls {
-					err := errors.New("STARTTLS is supported by this server, " +
-						"but not set in accounts.conf. " +
-						"Add smtp-starttls=yes")
-					return 0, err
-				}
-				if err = conn.StartTLS(&tls.Config{
-					ServerName: serverName,
-				}); err != nil {
-					return 0, errors.Wrap(err, "StartTLS")
-				}
-			} else {
-				if starttls {
-					err := errors.New("STARTTLS requested, but not supported " +
-						"by this SMTP server. Is someone tampering with your " +
-						"connection?")
-					return 0, err
-				}
-			}
-		case "smtps":
-			host := uri.Host
-			serverName := uri.Host
-			if !strings.ContainsRune(host, ':') {
-				host = host + ":465" // Default to smtps port
-			} else {
-				serverName = host[:strings.IndexRune(host, ':')]
+	var (
+		conn *smtp.Client
+		err  error
+	)
+	switch uri.Scheme {
+	case "smtp":
+		host := uri.Host
+		serverName := uri.Host
+		if !strings.ContainsRune(host, ':') {
+			host = host + ":587" // Default to submission port
+		} else {
+			serverName = host[:strings.IndexRune(host, ':')]
+		}
+		conn, err = smtp.Dial(host)
+		if err != nil {
+			return errors.Wrap(err, "smtp.Dial")
+		}
+		defer conn.Close()
+		if sup, _ := conn.Extension("STARTTLS"); sup {
+			if !starttls {
+				err := errors.New("STARTTLS is supported by this server, " +
+					"but not set in accounts.conf. " +
+					"Add smtp-starttls=yes")
+				return err
 			}
-			conn, err = smtp.DialTLS(host, &tls.Config{
+			if err = conn.StartTLS(&tls.Config{
 				ServerName: serverName,
-			})
-			if err != nil {
-				return 0, errors.Wrap(err, "smtp.DialTLS")
+			}); err != nil {
+				return errors.Wrap(err, "StartTLS")
 			}
-			defer conn.Close()
-		}
-
-		if saslClient != nil {
-			if err = conn.Auth(saslClient); err != nil {
-				return 0, errors.Wrap(err, "conn.Auth")
+		} else {
+			if starttls {
+				err := errors.New("STARTTLS requested, but not supported " +
+					"by this SMTP server. Is someone tampering with your " +
+					"connection?")
+				return err
 			}
 		}
-		// TODO: the user could conceivably want to use a different From and sender
-		if err = conn.Mail(from.Address); err != nil {
-			return 0, errors.Wrap(err, "conn.Mail")
-		}
-		aerc.Logger().Printf("rcpt to: %v", rcpts)
-		for _, rcpt := range rcpts {
-			if err = conn.Rcpt(rcpt); err != nil {
-				return 0, errors.Wrap(err, "conn.Rcpt")
-			}
+	case "smtps":
+		host := uri.Host
+		serverName := uri.Host
+		if !strings.ContainsRune(host, ':') {
+			host = host + ":465" // Default to smtps port
+		} else {
+			serverName = host[:strings.IndexRune(host, ':')]
 		}
-		wc, err := conn.Data()
+		conn, err = smtp.DialTLS(host, &tls.Config{
+			ServerName: serverName,
+		})
 		if err != nil {
-			return 0, errors.Wrap(err, "conn.Data")
+			return errors.Wrap(err, "smtp.DialTLS")
 		}
-		defer wc.Close()
-		ctr := datacounter.NewWriterCounter(wc)
-		composer.WriteMessage(header, ctr)
-		return int(ctr.Count()), nil
+		defer conn.Close()
 	}
 
-	sendmailAsync := func() (int, error) {
-		args, err := shlex.Split(uri.Path)
-		if err != nil {
-			return 0, err
+	if saslClient != nil {
+		if err = conn.Auth(saslClient); err != nil {
+			return errors.Wrap(err, "conn.Auth")
 		}
-		if len(args) == 0 {
-			return 0, fmt.Errorf("no command specified")
-		}
-		bin := args[0]
-		args = append(args[1:], rcpts...)
-		cmd := exec.Command(bin, args...)
-		wc, err := cmd.StdinPipe()
-		if err != nil {
-			return 0, errors.Wrap(err, "cmd.StdinPipe")
-		}
-		defer wc.Close()
-		go cmd.Run()
-		ctr := datacounter.NewWriterCounter(wc)
-		composer.WriteMessage(header, ctr)
-		return int(ctr.Count()), nil
 	}
-
-	sendAsync := func() (int, error) {
-		fmt.Println(scheme)
-		switch scheme {
-		case "smtp":
-			fallthrough
-		case "smtps":
-			return smtpAsync()
-		case "":
-			return sendmailAsync()
+	// TODO: the user could conceivably want to use a different From and sender
+	if err = conn.Mail(msg.From); err != nil {
+		return errors.Wrap(err, "conn.Mail")
+	}
+	aerc.Logger().Printf("rcpt to: %v", msg.Rcpts)
+	for _, rcpt := range msg.Rcpts {
+		if err = conn.Rcpt(rcpt); err != nil {
+			return errors.Wrap(err, "conn.Rcpt")
 		}
-		return 0, errors.New("Unknown scheme")
 	}
+	wc, err := conn.Data()
+	if err != nil {
+		return errors.Wrap(err, "conn.Data")
+	}
+	if _, err := wc.Write(content); err != nil {
+		wc.Close()
+		return errors.Wrap(err, "conn.Data")
+	}
+	if err := wc.Close(); err != nil {
+		return errors.Wrap(err, "conn.Data")
+	}
+	return nil
+}
 
-	go func() {
-		aerc.SetStatus("Sending...")
-		nbytes, err := sendAsync()
-		if err != nil {
-			aerc.SetStatus(" "+err.Error()).
-				Color(tcell.ColorDefault, tcell.ColorRed)
-			return
-		}
-		if config.CopyTo != "" {
-			aerc.SetStatus("Copying to " + config.CopyTo)
-			worker := composer.Worker()
-			r, w := io.Pipe()
-			worker.PostAction(&types.AppendMessage{
-				Destination: config.CopyTo,
-				Flags:       []string{imap.SeenFlag},
-				Date:        time.Now(),
-				Reader:      r,
-				Length:      nbytes,
-			}, func(msg types.WorkerMessage) {
-				switch msg := msg.(type) {
-				case *types.Done:
-					aerc.SetStatus("Message sent.")
-					r.Close()
-					composer.Close()
-				case *types.Error:
-					aerc.PushStatus(" "+msg.Error.Error(), 10*time.Second).
-						Color(tcell.ColorDefault, tcell.ColorRed)
-					r.Close()
-					composer.Close()
-				}
-			})
-			header, _, _ := composer.PrepareHeader()
-			composer.WriteMessage(header, w)
-			w.Close()
-		} else {
-			aerc.SetStatus("Message sent.")
-			composer.Close()
-		}
-	}()
+func sendmail(uri *url.URL, msg *outbox.Message, content []byte) error {
+	args, err := shlex.Split(uri.Path)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("no command specified")
+	}
+	bin := args[0]
+	args = append(args[1:], msg.Rcpts...)
+	cmd := exec.Command(bin, args...)
+	cmd.Stdin = bytes.NewReader(content)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %v: %s", bin, err,
+			strings.TrimSpace(string(out)))
+	}
 	return nil
 }
+
+// How long the JMAP worker may take to submit a message. The attempt fails
+// after that, and is retried later like any other.
+const jmapTimeout = 5 * time.Minute
+
+// The JMAP worker of the account submits the message itself
+func sendJMAP(aerc *widgets.Aerc, worker *types.Worker, msg *outbox.Message,
+	content []byte) error {
+
+	done := make(chan error, 1)
+	aerc.Post(func() {
+		worker.PostAction(&types.SendMessage{
+			From:   msg.From,
+			Rcpts:  msg.Rcpts,
+			Reader: bytes.NewReader(content),
+		}, func(msg types.WorkerMessage) {
+			switch msg := msg.(type) {
+			case *types.Done:
+				done <- nil
+			case *types.Error:
+				done <- msg.Error
+			case *types.Unsupported:
+				done <- errors.New(
+					"The jmap transport needs a jmap source")
+			}
+		})
+	})
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(jmapTimeout):
+		return fmt.Errorf("The jmap worker did not answer within %v",
+			jmapTimeout)
+	}
+}
+
+func runSentHook(aerc *widgets.Aerc, account string, from string,
+	subject string, rcpts []string) {
+
+	aerc.Config().Hooks.Run("mail-sent", config.HookContext{
+		Account: account,
+		From:    from,
+		To:      strings.Join(rcpts, ", "),
+		Subject: subject,
+	})
+}
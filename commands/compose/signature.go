@@ -0,0 +1,75 @@
+package compose
+
+import (
+	"errors"
+	"time"
+
+	"git.sr.ht/~sircmpwn/getopt"
+
+	"git.sr.ht/~sircmpwn/aerc/commands"
+	"git.sr.ht/~sircmpwn/aerc/widgets"
+)
+
+type Signature struct{}
+
+func init() {
+	register(Signature{})
+}
+
+func (_ Signature) Aliases() []string {
+	return []string{"signature"}
+}
+
+func (_ Signature) Complete(aerc *widgets.Aerc, args []string) []string {
+	path := ""
+	if len(args) >= 1 {
+		path = args[len(args)-1]
+	}
+	return commands.CompletePath(path)
+}
+
+func (_ Signature) Execute(aerc *widgets.Aerc, args []string) error {
+	opts, optind, err := getopt.Getopts(args, "c:d")
+	if err != nil {
+		return err
+	}
+	var (
+		file   string
+		cmd    string
+		remove bool
+	)
+	for _, opt := range opts {
+		switch opt.Option {
+		case 'c':
+			cmd = opt.Value
+		case 'd':
+			remove = true
+		}
+	}
+	switch len(args[optind:]) {
+	case 0:
+	case 1:
+		file = args[optind]
+	default:
+		return errors.New("Usage: signature [-d] [-c <cmd>] [path]")
+	}
+	if remove && (file != "" || cmd != "") {
+		return errors.New("Usage: signature [-d] [-c <cmd>] [path]")
+	}
+
+	composer, _ := aerc.SelectedTab().(*widgets.Composer)
+	if !remove && file == "" && cmd == "" {
+		// Restore the account's default signature
+		file = composer.Config().SignatureFile
+		cmd = composer.Config().SignatureCmd
+	}
+	if err := composer.SetSignature(file, cmd); err != nil {
+		return err
+	}
+	if remove {
+		aerc.PushStatus("Signature removed", 10*time.Second)
+	} else {
+		aerc.PushStatus("Signature updated", 10*time.Second)
+	}
+	return nil
+}
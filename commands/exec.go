@@ -6,9 +6,8 @@ import (
 	"os/exec"
 	"time"
 
+	"git.sr.ht/~sircmpwn/aerc/config"
 	"git.sr.ht/~sircmpwn/aerc/widgets"
-
-	"github.com/gdamore/tcell"
 )
 
 type ExecCmd struct{}
@@ -33,17 +32,16 @@ func (_ ExecCmd) Execute(aerc *widgets.Aerc, args []string) error {
 	go func() {
 		err := cmd.Run()
 		if err != nil {
-			aerc.PushStatus(" "+err.Error(), 10*time.Second).
-				Color(tcell.ColorDefault, tcell.ColorRed)
+			aerc.PushError(" " + err.Error())
 		} else {
-			color := tcell.ColorDefault
+			style := config.STYLE_STATUSLINE_DEFAULT
 			if cmd.ProcessState.ExitCode() != 0 {
-				color = tcell.ColorRed
+				style = config.STYLE_STATUSLINE_ERROR
 			}
 			aerc.PushStatus(fmt.Sprintf(
 				"%s: completed with status %d", args[0],
 				cmd.ProcessState.ExitCode()), 10*time.Second).
-				Color(tcell.ColorDefault, color)
+				Style(style)
 		}
 	}()
 	return nil
@@ -2,12 +2,22 @@ package commands
 
 import (
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/shlex"
 
 	"git.sr.ht/~sircmpwn/aerc/widgets"
 )
 
 type Help struct{}
 
+var helpTopics = []string{
+	"aliases", "config", "imap", "maildir", "notmuch", "sendmail", "smtp",
+	"scripting", "stylesets", "templates", "tutorial",
+}
+
 func init() {
 	register(Help{})
 }
@@ -17,15 +27,57 @@ func (_ Help) Aliases() []string {
 }
 
 func (_ Help) Complete(aerc *widgets.Aerc, args []string) []string {
-	return nil
+	if len(args) > 1 {
+		return nil
+	}
+	var topics []string
+	for _, topic := range helpTopics {
+		if len(args) == 0 || strings.HasPrefix(topic, args[0]) {
+			topics = append(topics, topic)
+		}
+	}
+	return topics
 }
 
 func (_ Help) Execute(aerc *widgets.Aerc, args []string) error {
 	page := "aerc"
 	if len(args) == 2 {
+		if args[1] == "aliases" {
+			return listAliases(aerc)
+		}
 		page = "aerc-" + args[1]
 	} else if len(args) > 2 {
 		return errors.New("Usage: help [topic]")
 	}
 	return TermCore(aerc, []string{"term", "man", page})
 }
+
+// Shows the aliases of the [aliases] section in the pager
+func listAliases(aerc *widgets.Aerc) error {
+	aliases := aerc.Config().Aliases
+	if len(aliases) == 0 {
+		return errors.New("No aliases defined")
+	}
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var list strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&list, ":%s = %s\n", name, aliases[name])
+	}
+	pager, err := shlex.Split(aerc.Config().Viewer.Pager)
+	if err != nil {
+		return err
+	}
+	if len(pager) == 0 {
+		pager = []string{"less"}
+	}
+	term, err := QuickTerm(aerc, pager, strings.NewReader(list.String()), true)
+	if err != nil {
+		return err
+	}
+	aerc.NewTab(term, "aliases")
+	return nil
+}
@@ -4,8 +4,7 @@ import (
 	"errors"
 	"time"
 
-	"github.com/gdamore/tcell"
-
+	"git.sr.ht/~sircmpwn/aerc/config"
 	"git.sr.ht/~sircmpwn/aerc/widgets"
 	"git.sr.ht/~sircmpwn/aerc/worker/types"
 )
@@ -55,13 +54,19 @@ func (_ Delete) Execute(aerc *widgets.Aerc, args []string) error {
 			aerc.ReplaceTab(mv, nextMv, nextMsg.Envelope.Subject)
 		}
 	}
+	deleted := msg
 	store.Delete([]uint32{msg.Uid}, func(msg types.WorkerMessage) {
 		switch msg := msg.(type) {
 		case *types.Done:
 			aerc.PushStatus("Messages deleted.", 10*time.Second)
+			aerc.Config().Hooks.Run("mail-deleted", config.HookContext{
+				Account:         acct.Name(),
+				Folder:          store.DirInfo.Name,
+				Message:         deleted,
+				TimestampFormat: aerc.Config().Ui.TimestampFormat,
+			})
 		case *types.Error:
-			aerc.PushStatus(" "+msg.Error.Error(), 10*time.Second).
-				Color(tcell.ColorDefault, tcell.ColorRed)
+			aerc.PushError(" " + msg.Error.Error())
 		}
 	})
 	return nil
@@ -1,15 +1,13 @@
 package msg
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
 	"git.sr.ht/~sircmpwn/aerc/lib"
 	"git.sr.ht/~sircmpwn/aerc/models"
 	"git.sr.ht/~sircmpwn/aerc/widgets"
+	"git.sr.ht/~sircmpwn/aerc/worker/types"
 	"git.sr.ht/~sircmpwn/getopt"
-	"github.com/emersion/go-message"
-	"github.com/emersion/go-message/mail"
 	"io"
 	"io/ioutil"
 	"os"
@@ -32,15 +30,18 @@ func (_ forward) Complete(aerc *widgets.Aerc, args []string) []string {
 }
 
 func (_ forward) Execute(aerc *widgets.Aerc, args []string) error {
-	opts, optind, err := getopt.Getopts(args, "A")
+	opts, optind, err := getopt.Getopts(args, "AT:")
 	if err != nil {
 		return err
 	}
 	attach := false
+	template := ""
 	for _, opt := range opts {
 		switch opt.Option {
 		case 'A':
 			attach = true
+		case 'T':
+			template = opt.Value
 		}
 	}
 
@@ -69,10 +70,18 @@ func (_ forward) Execute(aerc *widgets.Aerc, args []string) error {
 		"To":      to,
 		"Subject": subject,
 	}
-	composer := widgets.NewComposer(aerc.Config(), acct.AccountConfig(),
-		acct.Worker(), defaults)
+	original := models.OriginalMail{
+		Info: msg,
+	}
+
+	addTab := func() (*widgets.Composer, error) {
+		composer, err := widgets.NewComposer(aerc.Config(),
+			acct.AccountConfig(), acct.Worker(), template, defaults, original)
+		if err != nil {
+			aerc.PushError("Error: " + err.Error())
+			return nil, err
+		}
 
-	addTab := func() {
 		tab := aerc.NewTab(composer, subject)
 		if to == "" {
 			composer.FocusRecipient()
@@ -87,18 +96,22 @@ func (_ forward) Execute(aerc *widgets.Aerc, args []string) error {
 			}
 			tab.Content.Invalidate()
 		})
+		return composer, nil
 	}
 
 	if attach {
-		forwardAttach(store, composer, msg, addTab)
+		forwardAttach(store, msg, addTab)
 	} else {
-		forwardBodyPart(store, composer, msg, addTab)
+		if template == "" {
+			template = aerc.Config().Templates.Forwards
+		}
+		forwardBodyPart(aerc, acct.Worker(), store, msg, &original, addTab)
 	}
 	return nil
 }
 
-func forwardAttach(store *lib.MessageStore, composer *widgets.Composer,
-	msg *models.MessageInfo, addTab func()) {
+func forwardAttach(store *lib.MessageStore, msg *models.MessageInfo,
+	addTab func() (*widgets.Composer, error)) {
 
 	store.FetchFull([]uint32{msg.Uid}, func(reader io.Reader) {
 		tmpDir, err := ioutil.TempDir("", "aerc-tmp-attachment")
@@ -119,51 +132,146 @@ func forwardAttach(store *lib.MessageStore, composer *widgets.Composer,
 
 		defer tmpFile.Close()
 		io.Copy(tmpFile, reader)
+		composer, err := addTab()
+		if err != nil {
+			os.RemoveAll(tmpDir)
+			return
+		}
 		composer.AddAttachment(tmpFileName)
 		composer.OnClose(func(composer *widgets.Composer) {
 			os.RemoveAll(tmpDir)
 		})
-		addTab()
 	})
 }
 
-func forwardBodyPart(store *lib.MessageStore, composer *widgets.Composer,
-	msg *models.MessageInfo, addTab func()) {
-	// TODO: something more intelligent than fetching the 1st part
-	// TODO: add attachments!
-	store.FetchBodyPart(msg.Uid, []int{1}, func(reader io.Reader) {
-		header := message.Header{}
-		header.SetText(
-			"Content-Transfer-Encoding", msg.BodyStructure.Encoding)
-		header.SetContentType(
-			msg.BodyStructure.MIMEType, msg.BodyStructure.Params)
-		header.SetText("Content-Description", msg.BodyStructure.Description)
-		entity, err := message.New(header, reader)
-		if err != nil {
-			// TODO: Do something with the error
+func forwardBodyPart(aerc *widgets.Aerc, worker *types.Worker,
+	store *lib.MessageStore, msg *models.MessageInfo,
+	original *models.OriginalMail, addTab func() (*widgets.Composer, error)) {
+
+	attachments := findAttachments(msg.BodyStructure, nil)
+	withAttachments := func() {
+		if len(attachments) == 0 {
 			addTab()
 			return
 		}
-		mreader := mail.NewReader(entity)
-		part, err := mreader.NextPart()
+		forwardAttachments(aerc, worker, msg, attachments, addTab)
+	}
+
+	conf := aerc.Config()
+	part, path := findTextPart(msg.BodyStructure, conf.Viewer.Alternatives)
+	if part == nil {
+		withAttachments()
+		return
+	}
+	store.FetchBodyPart(msg.Uid, path, func(reader io.Reader) {
+		text, err := partText(conf, part, reader)
 		if err != nil {
-			// TODO: Do something with the error
-			addTab()
+			aerc.PushError(" Unable to read forwarded body: " + err.Error())
+		} else {
+			original.Text = text
+			original.MIMEType = mimeTypeOf(part)
+		}
+		withAttachments()
+	})
+}
+
+// forwardAttachments fetches every attachment of the original message into a
+// temporary directory, and then opens the composer with them attached. The
+// composer is not opened if one of them cannot be fetched, so that the message
+// is never sent without them.
+func forwardAttachments(aerc *widgets.Aerc, worker *types.Worker,
+	msg *models.MessageInfo, attachments []partPath,
+	addTab func() (*widgets.Composer, error)) {
+
+	tmpDir, err := ioutil.TempDir("", "aerc-tmp-attachment")
+	if err != nil {
+		aerc.PushError(" Unable to forward attachments: " + err.Error())
+		return
+	}
+	filenames := make([]string, len(attachments))
+	for i, a := range attachments {
+		filename := strings.ReplaceAll(partFilename(a.part, i+1), "/", "-")
+		filenames[i] = path.Join(tmpDir, fmt.Sprintf("%d", i+1), filename)
+	}
+	attach := func() {
+		composer, err := addTab()
+		if err != nil {
+			os.RemoveAll(tmpDir)
 			return
 		}
+		composer.OnClose(func(composer *widgets.Composer) {
+			os.RemoveAll(tmpDir)
+		})
+		for i, a := range attachments {
+			params := make(map[string]string)
+			for key, value := range a.part.Params {
+				if !strings.EqualFold(key, "name") {
+					params[key] = value
+				}
+			}
+			composer.AddPartAttachment(filenames[i], mimeTypeOf(a.part),
+				params)
+		}
+	}
+	fail := func(i int, err error) {
+		os.RemoveAll(tmpDir)
+		aerc.PushError(fmt.Sprintf(" Unable to forward %s: %v",
+			path.Base(filenames[i]), err))
+	}
 
-		pipeout, pipein := io.Pipe()
-		scanner := bufio.NewScanner(part.Body)
-		go composer.SetContents(pipeout)
-		// TODO: Let user customize the date format used here
-		io.WriteString(pipein, fmt.Sprintf("Forwarded message from %s on %s:\n\n",
-			msg.Envelope.From[0].Name,
-			msg.Envelope.Date.Format("Mon Jan 2, 2006 at 3:04 PM")))
-		for scanner.Scan() {
-			io.WriteString(pipein, fmt.Sprintf("%s\n", scanner.Text()))
+	// The attachments are fetched one after the other
+	var fetch func(i int)
+	fetch = func(i int) {
+		if i == len(attachments) {
+			aerc.SetStatus("Attachments fetched.")
+			attach()
+			return
 		}
-		pipein.Close()
-		pipeout.Close()
-		addTab()
-	})
+		fetched := false
+		worker.PostAction(&types.FetchMessageBodyPart{
+			Uid:  msg.Uid,
+			Part: attachments[i].path,
+		}, func(resp types.WorkerMessage) {
+			switch resp := resp.(type) {
+			case *types.MessageBodyPart:
+				fetched = true
+				err := saveAttachment(attachments[i].part,
+					resp.Part.Reader, filenames[i])
+				if err != nil {
+					fail(i, err)
+					return
+				}
+				fetch(i + 1)
+			case *types.Error:
+				fail(i, resp.Error)
+			case *types.Unsupported:
+				fail(i, errors.New("unsupported by the backend"))
+			case *types.Done:
+				if !fetched {
+					fail(i, errors.New("no content"))
+				}
+			}
+		})
+	}
+	aerc.SetStatus("Fetching attachments...")
+	fetch(0)
+}
+
+func saveAttachment(part *models.BodyStructure, reader io.Reader,
+	filename string) error {
+
+	body, err := decodeAttachment(part, reader)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(filename), 0700); err != nil {
+		return err
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, body)
+	return err
 }
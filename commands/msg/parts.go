@@ -0,0 +1,202 @@
+package msg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/danwakefield/fnmatch"
+	"github.com/emersion/go-message"
+	_ "github.com/emersion/go-message/charset"
+
+	"git.sr.ht/~sircmpwn/aerc/config"
+	"git.sr.ht/~sircmpwn/aerc/lib/flowed"
+	"git.sr.ht/~sircmpwn/aerc/models"
+)
+
+var ansi = regexp.MustCompile("\x1B\\[[0-?]*[ -/]*[@-~]")
+
+// mimeTypeOf returns the lowercased type/subtype of a body part
+func mimeTypeOf(part *models.BodyStructure) string {
+	return strings.ToLower(part.MIMEType + "/" + part.MIMESubType)
+}
+
+// isAttachment reports whether a text part is an attached file rather than
+// (an alternative of) the message body
+func isAttachment(part *models.BodyStructure) bool {
+	if strings.EqualFold(part.Disposition, "attachment") {
+		return true
+	}
+	_, ok := part.DispositionParams["filename"]
+	return ok
+}
+
+// findTextPart finds the text part of the message body best matching the
+// user's preferred alternatives, and returns it along with its path.
+func findTextPart(bs *models.BodyStructure,
+	alternatives []string) (*models.BodyStructure, []int) {
+
+	if len(bs.Parts) == 0 {
+		if strings.EqualFold(bs.MIMEType, "text") {
+			return bs, []int{1}
+		}
+		return nil, nil
+	}
+
+	var (
+		best         *models.BodyStructure
+		bestPath     []int
+		bestPriority = -1
+	)
+	var walk func(bs *models.BodyStructure, path []int)
+	walk = func(bs *models.BodyStructure, path []int) {
+		for i, part := range bs.Parts {
+			cur := append(append([]int{}, path...), i+1)
+			if strings.EqualFold(part.MIMEType, "multipart") {
+				walk(part, cur)
+				continue
+			}
+			if !strings.EqualFold(part.MIMEType, "text") || isAttachment(part) {
+				continue
+			}
+			priority := 0
+			for idx, m := range alternatives {
+				if m == mimeTypeOf(part) {
+					priority = len(alternatives) - idx
+					break
+				}
+			}
+			if priority > bestPriority {
+				best, bestPath, bestPriority = part, cur, priority
+			}
+		}
+	}
+	walk(bs, nil)
+	return best, bestPath
+}
+
+type partPath struct {
+	part *models.BodyStructure
+	path []int
+}
+
+// findAttachments lists every part of the message which is not part of its
+// text body.
+func findAttachments(bs *models.BodyStructure, path []int) []partPath {
+	var parts []partPath
+	for i, part := range bs.Parts {
+		cur := append(append([]int{}, path...), i+1)
+		if strings.EqualFold(part.MIMEType, "multipart") {
+			parts = append(parts, findAttachments(part, cur)...)
+			continue
+		}
+		if strings.EqualFold(part.MIMEType, "text") && !isAttachment(part) {
+			continue
+		}
+		parts = append(parts, partPath{part, cur})
+	}
+	return parts
+}
+
+// partParam looks up a Content-Type parameter of a body part, ignoring case
+func partParam(part *models.BodyStructure, key string) string {
+	for k, v := range part.Params {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+// partFilename returns the filename of an attachment, or makes one up based
+// on its content type
+func partFilename(part *models.BodyStructure, index int) string {
+	if filename, ok := part.DispositionParams["filename"]; ok {
+		return filename
+	}
+	if name, ok := part.Params["name"]; ok {
+		return name
+	}
+	name := fmt.Sprintf("attachment-%d", index)
+	if exts, _ := mime.ExtensionsByType(mimeTypeOf(part)); len(exts) > 0 {
+		name += exts[0]
+	}
+	return name
+}
+
+// decodePart undoes the transfer encoding and charset of a body part fetched
+// with FetchBodyPart.
+func decodePart(part *models.BodyStructure,
+	reader io.Reader) (io.Reader, error) {
+
+	header := message.Header{}
+	header.SetText("Content-Transfer-Encoding", part.Encoding)
+	header.SetContentType(part.MIMEType+"/"+part.MIMESubType, part.Params)
+	header.SetText("Content-Description", part.Description)
+	entity, err := message.New(header, reader)
+	if err != nil {
+		return nil, err
+	}
+	return entity.Body, nil
+}
+
+// decodeAttachment undoes only the transfer encoding of a body part, leaving
+// its contents in their original charset.
+func decodeAttachment(part *models.BodyStructure,
+	reader io.Reader) (io.Reader, error) {
+
+	header := message.Header{}
+	header.SetText("Content-Transfer-Encoding", part.Encoding)
+	entity, err := message.New(header, reader)
+	if err != nil {
+		return nil, err
+	}
+	return entity.Body, nil
+}
+
+// partText reads a decoded text part into a string, running it through the
+// configured filter if it is not text/plain, and unflowing it if it is
+// format=flowed.
+func partText(conf *config.AercConfig, part *models.BodyStructure,
+	reader io.Reader) (string, error) {
+
+	body, err := decodePart(part, reader)
+	if err != nil {
+		return "", err
+	}
+	mimeType := mimeTypeOf(part)
+	if mimeType == "text/plain" {
+		buf := new(bytes.Buffer)
+		if _, err := buf.ReadFrom(body); err != nil {
+			return "", err
+		}
+		if strings.EqualFold(partParam(part, "format"), "flowed") {
+			delsp := strings.EqualFold(partParam(part, "delsp"), "yes")
+			return flowed.Unflow(buf.String(), delsp), nil
+		}
+		return buf.String(), nil
+	}
+	var filter *exec.Cmd
+	for _, f := range conf.Filters {
+		if f.FilterType != config.FILTER_MIMETYPE {
+			continue
+		}
+		if fnmatch.Match(f.Filter, mimeType, 0) {
+			filter = exec.Command("sh", "-c", f.Command)
+			break
+		}
+	}
+	if filter == nil {
+		return "", fmt.Errorf("No filter configured for %s", mimeType)
+	}
+	filter.Stdin = body
+	out, err := filter.Output()
+	if err != nil {
+		return "", err
+	}
+	return ansi.ReplaceAllString(string(out), ""), nil
+}
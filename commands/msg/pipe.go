@@ -11,10 +11,10 @@ import (
 	"time"
 
 	"git.sr.ht/~sircmpwn/aerc/commands"
+	"git.sr.ht/~sircmpwn/aerc/config"
 	"git.sr.ht/~sircmpwn/aerc/widgets"
 
 	"git.sr.ht/~sircmpwn/getopt"
-	"github.com/gdamore/tcell"
 )
 
 type Pipe struct{}
@@ -103,17 +103,16 @@ func (_ Pipe) Execute(aerc *widgets.Aerc, args []string) error {
 		}()
 		err = ecmd.Run()
 		if err != nil {
-			aerc.PushStatus(" "+err.Error(), 10*time.Second).
-				Color(tcell.ColorDefault, tcell.ColorRed)
+			aerc.PushError(" " + err.Error())
 		} else {
-			color := tcell.ColorDefault
+			style := config.STYLE_STATUSLINE_DEFAULT
 			if ecmd.ProcessState.ExitCode() != 0 {
-				color = tcell.ColorRed
+				style = config.STYLE_STATUSLINE_ERROR
 			}
 			aerc.PushStatus(fmt.Sprintf(
 				"%s: completed with status %d", cmd[0],
 				ecmd.ProcessState.ExitCode()), 10*time.Second).
-				Color(tcell.ColorDefault, color)
+				Style(style)
 		}
 	}
 
@@ -1,7 +1,6 @@
 package msg
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
 	"io"
@@ -9,8 +8,6 @@ import (
 	"strings"
 
 	"git.sr.ht/~sircmpwn/getopt"
-	"github.com/emersion/go-message"
-	_ "github.com/emersion/go-message/charset"
 	"github.com/emersion/go-message/mail"
 
 	"git.sr.ht/~sircmpwn/aerc/models"
@@ -32,23 +29,29 @@ func (_ reply) Complete(aerc *widgets.Aerc, args []string) []string {
 }
 
 func (_ reply) Execute(aerc *widgets.Aerc, args []string) error {
-	opts, optind, err := getopt.Getopts(args, "aq")
+	opts, optind, err := getopt.Getopts(args, "alqT:")
 	if err != nil {
 		return err
 	}
 	if optind != len(args) {
-		return errors.New("Usage: reply [-aq]")
+		return errors.New("Usage: reply [-alq -T <template>]")
 	}
 	var (
-		quote    bool
-		replyAll bool
+		quote     bool
+		replyAll  bool
+		listReply bool
+		template  string
 	)
 	for _, opt := range opts {
 		switch opt.Option {
 		case 'a':
 			replyAll = true
+		case 'l':
+			listReply = true
 		case 'q':
 			quote = true
+		case 'T':
+			template = opt.Value
 		}
 	}
 
@@ -74,30 +77,53 @@ func (_ reply) Execute(aerc *widgets.Aerc, args []string) error {
 		cc     []string
 		toList []*models.Address
 	)
+	followup := headerAddresses(msg, "Mail-Followup-To")
 	if args[0] == "reply" {
-		if len(msg.Envelope.ReplyTo) != 0 {
-			toList = msg.Envelope.ReplyTo
+		if listReply {
+			post, err := listPostAddress(msg)
+			if err != nil {
+				return err
+			}
+			to = append(to, post)
+		} else if replyAll && len(followup) != 0 {
+			// The author asked for replies to go to exactly these
+			// addresses, see https://cr.yp.to/proto/replyto.html
+			for _, addr := range followup {
+				if addr.Address == us.Address {
+					continue
+				}
+				to = append(to, addr.String())
+			}
 		} else {
-			toList = msg.Envelope.From
-		}
-		for _, addr := range toList {
-			if addr.Name != "" {
-				to = append(to, fmt.Sprintf("%s <%s@%s>",
-					addr.Name, addr.Mailbox, addr.Host))
+			replyTo := headerAddresses(msg, "Mail-Reply-To")
+			if len(replyTo) != 0 {
+				for _, addr := range replyTo {
+					to = append(to, addr.String())
+				}
+			} else if len(msg.Envelope.ReplyTo) != 0 {
+				toList = msg.Envelope.ReplyTo
 			} else {
-				to = append(to, fmt.Sprintf("<%s@%s>", addr.Mailbox, addr.Host))
+				toList = msg.Envelope.From
 			}
-		}
-		if replyAll {
-			for _, addr := range msg.Envelope.Cc {
-				cc = append(cc, addr.Format())
+			for _, addr := range toList {
+				if addr.Name != "" {
+					to = append(to, fmt.Sprintf("%s <%s@%s>",
+						addr.Name, addr.Mailbox, addr.Host))
+				} else {
+					to = append(to, fmt.Sprintf("<%s@%s>", addr.Mailbox, addr.Host))
+				}
 			}
-			for _, addr := range msg.Envelope.To {
-				address := fmt.Sprintf("%s@%s", addr.Mailbox, addr.Host)
-				if address == us.Address {
-					continue
+			if replyAll {
+				for _, addr := range msg.Envelope.Cc {
+					cc = append(cc, addr.Format())
+				}
+				for _, addr := range msg.Envelope.To {
+					address := fmt.Sprintf("%s@%s", addr.Mailbox, addr.Host)
+					if address == us.Address {
+						continue
+					}
+					to = append(to, addr.Format())
 				}
-				to = append(to, addr.Format())
 			}
 		}
 	}
@@ -114,16 +140,24 @@ func (_ reply) Execute(aerc *widgets.Aerc, args []string) error {
 		"Cc":          strings.Join(cc, ", "),
 		"Subject":     subject,
 		"In-Reply-To": msg.Envelope.MessageId,
+		"References":  references(msg),
 	}
 
-	composer := widgets.NewComposer(
-		aerc.Config(), acct.AccountConfig(), acct.Worker(), defaults)
-
-	if args[0] == "reply" {
-		composer.FocusTerminal()
+	original := models.OriginalMail{
+		Info: msg,
 	}
 
-	addTab := func() {
+	addTab := func() error {
+		composer, err := widgets.NewComposer(aerc.Config(),
+			acct.AccountConfig(), acct.Worker(), template, defaults, original)
+		if err != nil {
+			aerc.PushError("Error: " + err.Error())
+			return err
+		}
+		if args[0] == "reply" {
+			composer.FocusTerminal()
+		}
+
 		tab := aerc.NewTab(composer, subject)
 		composer.OnHeaderChange("Subject", func(subject string) {
 			if subject == "" {
@@ -133,76 +167,76 @@ func (_ reply) Execute(aerc *widgets.Aerc, args []string) error {
 			}
 			tab.Content.Invalidate()
 		})
+		return nil
 	}
 
 	if quote {
-		var (
-			path []int
-			part *models.BodyStructure
-		)
-		if len(msg.BodyStructure.Parts) != 0 {
-			part, path = findPlaintext(msg.BodyStructure, path)
+		if template == "" {
+			template = aerc.Config().Templates.QuotedReply
 		}
+
+		part, path := findTextPart(msg.BodyStructure,
+			aerc.Config().Viewer.Alternatives)
 		if part == nil {
-			part = msg.BodyStructure
-			path = []int{1}
+			return addTab()
 		}
-
 		store.FetchBodyPart(msg.Uid, path, func(reader io.Reader) {
-			header := message.Header{}
-			header.SetText(
-				"Content-Transfer-Encoding", part.Encoding)
-			header.SetContentType(part.MIMEType, part.Params)
-			header.SetText("Content-Description", part.Description)
-			entity, err := message.New(header, reader)
-			if err != nil {
-				// TODO: Do something with the error
-				addTab()
-				return
-			}
-			mreader := mail.NewReader(entity)
-			part, err := mreader.NextPart()
+			text, err := partText(aerc.Config(), part, reader)
 			if err != nil {
-				// TODO: Do something with the error
-				addTab()
-				return
-			}
-
-			pipeout, pipein := io.Pipe()
-			scanner := bufio.NewScanner(part.Body)
-			go composer.SetContents(pipeout)
-			// TODO: Let user customize the date format used here
-			io.WriteString(pipein, fmt.Sprintf("On %s %s wrote:\n",
-				msg.Envelope.Date.Format("Mon Jan 2, 2006 at 3:04 PM"),
-				msg.Envelope.From[0].Name))
-			for scanner.Scan() {
-				io.WriteString(pipein, fmt.Sprintf("> %s\n", scanner.Text()))
+				aerc.PushError(" Unable to quote message: " + err.Error())
+			} else {
+				original.Text = text
+				original.MIMEType = mimeTypeOf(part)
 			}
-			pipein.Close()
-			pipeout.Close()
 			addTab()
 		})
-	} else {
-		addTab()
+		return nil
 	}
 
-	return nil
+	return addTab()
 }
 
-func findPlaintext(bs *models.BodyStructure,
-	path []int) (*models.BodyStructure, []int) {
-
-	for i, part := range bs.Parts {
-		cur := append(path, i+1)
-		if part.MIMEType == "text" && part.MIMESubType == "plain" {
-			return part, cur
-		}
-		if part.MIMEType == "multipart" {
-			if part, path := findPlaintext(part, cur); path != nil {
-				return part, path
-			}
+// references returns the References header of a reply to msg: the
+// references of msg itself, followed by its Message-Id.
+func references(msg *models.MessageInfo) string {
+	var refs []string
+	if msg.RFC822Headers != nil {
+		parent := msg.RFC822Headers.Get("References")
+		if parent == "" {
+			parent = msg.RFC822Headers.Get("In-Reply-To")
 		}
+		refs = strings.Fields(parent)
 	}
+	if msg.Envelope.MessageId != "" {
+		refs = append(refs, msg.Envelope.MessageId)
+	}
+	return strings.Join(refs, " ")
+}
 
-	return nil, nil
+// headerAddresses parses an address list header of msg, if it is present
+func headerAddresses(msg *models.MessageInfo, key string) []*mail.Address {
+	if msg.RFC822Headers == nil {
+		return nil
+	}
+	addrs, err := msg.RFC822Headers.AddressList(key)
+	if err != nil {
+		return nil
+	}
+	return addrs
+}
+
+// listPostAddress returns the posting address of the mailing list msg was
+// sent to, from its List-Post header. See RFC 2369.
+func listPostAddress(msg *models.MessageInfo) (string, error) {
+	if msg.RFC822Headers == nil || !msg.RFC822Headers.Has("List-Post") {
+		return "", errors.New("No List-Post header found")
+	}
+	for _, method := range parseUnsubscribeMethods(
+		msg.RFC822Headers.Get("List-Post")) {
+
+		if method.Scheme == "mailto" && method.Opaque != "" {
+			return method.Opaque, nil
+		}
+	}
+	return "", errors.New("Mailing list does not allow posting")
 }
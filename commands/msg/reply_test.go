@@ -0,0 +1,70 @@
+package msg
+
+import (
+	"testing"
+
+	"github.com/emersion/go-message/mail"
+
+	"git.sr.ht/~sircmpwn/aerc/models"
+)
+
+func testMessage(headers map[string]string) *models.MessageInfo {
+	hdr := &mail.Header{}
+	for key, value := range headers {
+		hdr.Set(key, value)
+	}
+	return &models.MessageInfo{
+		Envelope:      &models.Envelope{MessageId: "<c@example.org>"},
+		RFC822Headers: hdr,
+	}
+}
+
+func TestReferences(t *testing.T) {
+	type tc struct {
+		headers  map[string]string
+		expected string
+	}
+	cases := []*tc{
+		&tc{map[string]string{}, "<c@example.org>"},
+		&tc{map[string]string{
+			"In-Reply-To": "<b@example.org>",
+		}, "<b@example.org> <c@example.org>"},
+		&tc{map[string]string{
+			"In-Reply-To": "<b@example.org>",
+			"References":  "<a@example.org>\r\n <b@example.org>",
+		}, "<a@example.org> <b@example.org> <c@example.org>"},
+	}
+	for _, c := range cases {
+		result := references(testMessage(c.headers))
+		if result != c.expected {
+			t.Errorf("expected %q but got %q", c.expected, result)
+		}
+	}
+}
+
+func TestListPostAddress(t *testing.T) {
+	type tc struct {
+		header   string
+		expected string
+	}
+	cases := []*tc{
+		&tc{"<mailto:list@example.org>", "list@example.org"},
+		&tc{"<https://example.org/post>, <mailto:list@example.org>",
+			"list@example.org"},
+		&tc{"NO", ""},
+	}
+	for _, c := range cases {
+		result, err := listPostAddress(testMessage(map[string]string{
+			"List-Post": c.header,
+		}))
+		if c.expected == "" && err == nil {
+			t.Errorf("%q: expected an error", c.header)
+		}
+		if result != c.expected {
+			t.Errorf("%q: expected %q but got %q", c.header, c.expected, result)
+		}
+	}
+	if _, err := listPostAddress(testMessage(nil)); err == nil {
+		t.Errorf("expected an error without a List-Post header")
+	}
+}
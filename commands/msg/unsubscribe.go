@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"git.sr.ht/~sircmpwn/aerc/lib"
+	"git.sr.ht/~sircmpwn/aerc/models"
 	"git.sr.ht/~sircmpwn/aerc/widgets"
 )
 
@@ -87,12 +88,17 @@ func unsubscribeMailto(aerc *widgets.Aerc, u *url.URL) error {
 		"To":      u.Opaque,
 		"Subject": u.Query().Get("subject"),
 	}
-	composer := widgets.NewComposer(
+	composer, err := widgets.NewComposer(
 		aerc.Config(),
 		acct.AccountConfig(),
 		acct.Worker(),
+		"",
 		defaults,
+		models.OriginalMail{},
 	)
+	if err != nil {
+		return err
+	}
 	composer.SetContents(strings.NewReader(u.Query().Get("body")))
 	tab := aerc.NewTab(composer, "unsubscribe")
 	composer.OnHeaderChange("Subject", func(subject string) {
@@ -26,5 +26,6 @@ func (_ Close) Execute(aerc *widgets.Aerc, args []string) error {
 	}
 	mv, _ := aerc.SelectedTab().(*widgets.MessageViewer)
 	aerc.RemoveTab(mv)
+	mv.Close()
 	return nil
 }
@@ -0,0 +1,112 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/shlex"
+
+	"git.sr.ht/~sircmpwn/aerc/lib/outbox"
+	"git.sr.ht/~sircmpwn/aerc/widgets"
+)
+
+type Outbox struct{}
+
+func init() {
+	register(Outbox{})
+}
+
+func (_ Outbox) Aliases() []string {
+	return []string{"outbox"}
+}
+
+func (_ Outbox) Complete(aerc *widgets.Aerc, args []string) []string {
+	if len(args) <= 1 {
+		var actions []string
+		for _, action := range []string{"list", "cancel", "send"} {
+			if len(args) == 0 || strings.HasPrefix(action, args[0]) {
+				actions = append(actions, action)
+			}
+		}
+		return actions
+	}
+	if len(args) > 2 || aerc.Outbox() == nil ||
+		(args[0] != "cancel" && args[0] != "send") {
+
+		return nil
+	}
+	var ids []string
+	for _, msg := range aerc.Outbox().Messages() {
+		if strings.HasPrefix(msg.ID, args[1]) {
+			ids = append(ids, args[0]+" "+msg.ID)
+		}
+	}
+	return ids
+}
+
+func (_ Outbox) Execute(aerc *widgets.Aerc, args []string) error {
+	ob := aerc.Outbox()
+	if ob == nil {
+		return errors.New("No outbox")
+	}
+	action := "list"
+	if len(args) > 1 {
+		action = args[1]
+	}
+	switch {
+	case action == "list" && len(args) <= 2:
+		return listOutbox(aerc, ob)
+	case action == "cancel" && len(args) == 3:
+		if err := ob.Cancel(args[2]); err != nil {
+			return err
+		}
+		aerc.PushSuccess("Message canceled")
+		return nil
+	case action == "send" && len(args) == 3:
+		return ob.SendNow(args[2])
+	case action == "send" && len(args) == 2:
+		for _, msg := range ob.Messages() {
+			if err := ob.SendNow(msg.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return errors.New("Usage: outbox [list|cancel <id>|send [id]]")
+}
+
+// Shows the messages in the outbox in the pager
+func listOutbox(aerc *widgets.Aerc, ob *outbox.Outbox) error {
+	messages := ob.Messages()
+	if len(messages) == 0 {
+		aerc.PushStatus(" The outbox is empty", 10*time.Second)
+		return nil
+	}
+	var list strings.Builder
+	for _, msg := range messages {
+		fmt.Fprintf(&list, "%s  %s  %q to %s\n", msg.ID, msg.Account,
+			msg.Subject, strings.Join(msg.Rcpts, ", "))
+		if msg.Attempts > 0 {
+			fmt.Fprintf(&list, "\tfailed %d times, next attempt %s: %s\n",
+				msg.Attempts, msg.Due().Format("Mon Jan 2 15:04"), msg.Error)
+		} else if !msg.At.IsZero() {
+			fmt.Fprintf(&list, "\tscheduled for %s\n",
+				msg.At.Format("Mon Jan 2 15:04"))
+		}
+	}
+	pager, err := shlex.Split(aerc.Config().Viewer.Pager)
+	if err != nil {
+		return err
+	}
+	if len(pager) == 0 {
+		pager = []string{"less"}
+	}
+	term, err := QuickTerm(aerc, pager, strings.NewReader(list.String()), true)
+	if err != nil {
+		return err
+	}
+	aerc.NewTab(term, "outbox")
+	return nil
+}
@@ -0,0 +1,472 @@
+package script
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"git.sr.ht/~sircmpwn/aerc/config"
+	"git.sr.ht/~sircmpwn/aerc/lib"
+	"git.sr.ht/~sircmpwn/aerc/models"
+	"git.sr.ht/~sircmpwn/aerc/widgets"
+	"git.sr.ht/~sircmpwn/aerc/worker/types"
+)
+
+var flagNames = map[models.Flag]string{
+	models.SeenFlag:     "seen",
+	models.RecentFlag:   "recent",
+	models.AnsweredFlag: "answered",
+	models.DeletedFlag:  "deleted",
+	models.FlaggedFlag:  "flagged",
+}
+
+type builtinFunc func(aerc *widgets.Aerc, b *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error)
+
+// The members of the aerc module given to scripts
+var builtins = map[string]builtinFunc{
+	"command":  defineCommand,
+	"on":       onHook,
+	"cmd":      runCommands,
+	"status":   pushStatus,
+	"error":    pushError,
+	"accounts": listAccounts,
+	"account":  selectedAccount,
+	"folder":   selectedFolder,
+	"folders":  listFolders,
+	"selected": selectedMessage,
+	"messages": listMessages,
+	"move":     moveMessage,
+	"copy":     copyMessage,
+	"delete":   deleteMessage,
+	"read":     readMessage,
+}
+
+func newModule(aerc *widgets.Aerc) *starlarkstruct.Module {
+	members := make(starlark.StringDict)
+	for name, fn := range builtins {
+		fn := fn
+		members[name] = starlark.NewBuiltin(name, func(_ *starlark.Thread,
+			b *starlark.Builtin, args starlark.Tuple,
+			kwargs []starlark.Tuple) (starlark.Value, error) {
+
+			return fn(aerc, b, args, kwargs)
+		})
+	}
+	return &starlarkstruct.Module{Name: "aerc", Members: members}
+}
+
+func defineCommand(aerc *widgets.Aerc, b *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	var (
+		name string
+		fn   *starlark.Function
+	)
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2,
+		&name, &fn); err != nil {
+		return nil, err
+	}
+	if name == "" || strings.ContainsAny(name, " \t;:") {
+		return nil, fmt.Errorf("%s: invalid command name %q", b.Name(), name)
+	}
+	ScriptCommands.Register(ScriptCommand{Name: name, fn: fn})
+	return starlark.None, nil
+}
+
+func onHook(aerc *widgets.Aerc, b *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	var (
+		hook string
+		fn   *starlark.Function
+	)
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2,
+		&hook, &fn); err != nil {
+		return nil, err
+	}
+	for _, name := range config.HookNames {
+		if name == hook {
+			handlers[hook] = append(handlers[hook], fn)
+			return starlark.None, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: unknown hook %q", b.Name(), hook)
+}
+
+func runCommands(aerc *widgets.Aerc, b *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	var line string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1,
+		&line); err != nil {
+		return nil, err
+	}
+	if err := aerc.RunCommands(line); err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	return starlark.None, nil
+}
+
+func pushStatus(aerc *widgets.Aerc, b *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	var text string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1,
+		&text); err != nil {
+		return nil, err
+	}
+	aerc.PushStatus(text, 10*time.Second)
+	return starlark.None, nil
+}
+
+func pushError(aerc *widgets.Aerc, b *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	var text string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1,
+		&text); err != nil {
+		return nil, err
+	}
+	aerc.PushError(" " + text)
+	return starlark.None, nil
+}
+
+func listAccounts(aerc *widgets.Aerc, b *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs,
+		0); err != nil {
+		return nil, err
+	}
+	var names []starlark.Value
+	for _, acct := range aerc.Config().Accounts {
+		names = append(names, starlark.String(acct.Name))
+	}
+	return starlark.NewList(names), nil
+}
+
+func selectedAccount(aerc *widgets.Aerc, b *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs,
+		0); err != nil {
+		return nil, err
+	}
+	if acct := currentAccount(aerc); acct != nil {
+		return starlark.String(acct.Name()), nil
+	}
+	return starlark.None, nil
+}
+
+func selectedFolder(aerc *widgets.Aerc, b *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	acct, err := accountArg(aerc, b, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.String(acct.Directories().Selected()), nil
+}
+
+func listFolders(aerc *widgets.Aerc, b *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	acct, err := accountArg(aerc, b, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	var names []starlark.Value
+	for _, name := range acct.Directories().List() {
+		names = append(names, starlark.String(name))
+	}
+	return starlark.NewList(names), nil
+}
+
+func selectedMessage(aerc *widgets.Aerc, b *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs,
+		0); err != nil {
+		return nil, err
+	}
+	var (
+		acct *widgets.AccountView
+		msg  *models.MessageInfo
+	)
+	switch tab := aerc.SelectedTab().(type) {
+	case *widgets.AccountView:
+		acct = tab
+		msg, _ = tab.SelectedMessage()
+	case *widgets.MessageViewer:
+		acct = tab.SelectedAccount()
+		msg, _ = tab.SelectedMessage()
+	}
+	if acct == nil || msg == nil {
+		return starlark.None, nil
+	}
+	return newMessage(acct.Name(), acct.Directories().Selected(), msg), nil
+}
+
+// Lists the messages of the selected folder whose headers have been fetched
+func listMessages(aerc *widgets.Aerc, b *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	acct, err := accountArg(aerc, b, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	var msgs []starlark.Value
+	store, ok := acct.Directories().SelectedMsgStore()
+	if !ok {
+		return starlark.NewList(msgs), nil
+	}
+	folder := acct.Directories().Selected()
+	for _, uid := range store.Uids() {
+		if msg := store.Messages[uid]; msg != nil && msg.Envelope != nil {
+			msgs = append(msgs, newMessage(acct.Name(), folder, msg))
+		}
+	}
+	return starlark.NewList(msgs), nil
+}
+
+func moveMessage(aerc *widgets.Aerc, b *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	var (
+		msg  *starlarkstruct.Struct
+		dest string
+	)
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2,
+		&msg, &dest); err != nil {
+		return nil, err
+	}
+	store, uid, err := messageStore(aerc, msg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	store.Move([]uint32{uid}, dest, false, reportErrors(aerc))
+	return starlark.None, nil
+}
+
+func copyMessage(aerc *widgets.Aerc, b *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	var (
+		msg  *starlarkstruct.Struct
+		dest string
+	)
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2,
+		&msg, &dest); err != nil {
+		return nil, err
+	}
+	store, uid, err := messageStore(aerc, msg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	store.Copy([]uint32{uid}, dest, false, reportErrors(aerc))
+	return starlark.None, nil
+}
+
+func deleteMessage(aerc *widgets.Aerc, b *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	var msg *starlarkstruct.Struct
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1,
+		&msg); err != nil {
+		return nil, err
+	}
+	store, uid, err := messageStore(aerc, msg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	store.Delete([]uint32{uid}, reportErrors(aerc))
+	return starlark.None, nil
+}
+
+func readMessage(aerc *widgets.Aerc, b *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	var (
+		msg  *starlarkstruct.Struct
+		read = true
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"msg", &msg, "read?", &read); err != nil {
+		return nil, err
+	}
+	store, uid, err := messageStore(aerc, msg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	store.Read([]uint32{uid}, read, reportErrors(aerc))
+	return starlark.None, nil
+}
+
+func reportErrors(aerc *widgets.Aerc) func(msg types.WorkerMessage) {
+	return func(msg types.WorkerMessage) {
+		if msg, ok := msg.(*types.Error); ok {
+			aerc.PushError(" " + msg.Error.Error())
+		}
+	}
+}
+
+func currentAccount(aerc *widgets.Aerc) *widgets.AccountView {
+	if acct := aerc.SelectedAccount(); acct != nil {
+		return acct
+	}
+	if mv, ok := aerc.SelectedTab().(*widgets.MessageViewer); ok {
+		return mv.SelectedAccount()
+	}
+	return nil
+}
+
+// Unpacks the optional account argument of a builtin, defaulting to the
+// selected account
+func accountArg(aerc *widgets.Aerc, b *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (*widgets.AccountView, error) {
+
+	var name string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"account?", &name); err != nil {
+		return nil, err
+	}
+	acct, err := lookupAccount(aerc, name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	return acct, nil
+}
+
+func lookupAccount(aerc *widgets.Aerc, name string) (*widgets.AccountView, error) {
+	var acct *widgets.AccountView
+	if name == "" {
+		acct = currentAccount(aerc)
+		if acct == nil {
+			return nil, errors.New("no account selected")
+		}
+	} else {
+		acct = aerc.Account(name)
+		if acct == nil {
+			return nil, fmt.Errorf("no such account %s", name)
+		}
+	}
+	if acct.Directories() == nil {
+		return nil, fmt.Errorf("account %s is not connected", acct.Name())
+	}
+	return acct, nil
+}
+
+// Finds the store of a message passed by a script. Backends act on the
+// selected folder, so the message has to be in it.
+func messageStore(aerc *widgets.Aerc,
+	msg *starlarkstruct.Struct) (*lib.MessageStore, uint32, error) {
+
+	account, err := stringAttr(msg, "account")
+	if err != nil {
+		return nil, 0, err
+	}
+	folder, err := stringAttr(msg, "folder")
+	if err != nil {
+		return nil, 0, err
+	}
+	uidVal, err := msg.Attr("uid")
+	if err != nil {
+		return nil, 0, err
+	}
+	uid, err := starlark.AsInt32(uidVal)
+	if err != nil {
+		return nil, 0, err
+	}
+	acct, err := lookupAccount(aerc, account)
+	if err != nil {
+		return nil, 0, err
+	}
+	if acct.Directories().Selected() != folder {
+		return nil, 0, fmt.Errorf("folder %s is not selected", folder)
+	}
+	store, ok := acct.Directories().SelectedMsgStore()
+	if !ok {
+		return nil, 0, fmt.Errorf("folder %s is not loaded", folder)
+	}
+	return store, uint32(uid), nil
+}
+
+func stringAttr(s *starlarkstruct.Struct, name string) (string, error) {
+	val, err := s.Attr(name)
+	if err != nil {
+		return "", err
+	}
+	str, ok := starlark.AsString(val)
+	if !ok {
+		return "", fmt.Errorf("%s is not a string", name)
+	}
+	return str, nil
+}
+
+func stringList(strs []string) *starlark.List {
+	list := make([]starlark.Value, len(strs))
+	for i, s := range strs {
+		list[i] = starlark.String(s)
+	}
+	return starlark.NewList(list)
+}
+
+func addressList(addrs []*models.Address) *starlark.List {
+	list := make([]string, len(addrs))
+	for i, addr := range addrs {
+		list[i] = addr.Format()
+	}
+	return stringList(list)
+}
+
+func newMessage(account string, folder string,
+	msg *models.MessageInfo) *starlarkstruct.Struct {
+
+	var flags []string
+	for _, flag := range msg.Flags {
+		if name, ok := flagNames[flag]; ok {
+			flags = append(flags, name)
+		}
+	}
+	fields := starlark.StringDict{
+		"account": starlark.String(account),
+		"folder":  starlark.String(folder),
+		"uid":     starlark.MakeUint(uint(msg.Uid)),
+		"size":    starlark.MakeUint(uint(msg.Size)),
+		"flags":   stringList(flags),
+	}
+	env := msg.Envelope
+	if env == nil {
+		env = &models.Envelope{}
+	}
+	fields["subject"] = starlark.String(env.Subject)
+	fields["from_"] = addressList(env.From)
+	fields["to"] = addressList(env.To)
+	fields["cc"] = addressList(env.Cc)
+	fields["message_id"] = starlark.String(env.MessageId)
+	fields["date"] = starlark.String(env.Date.Format(time.RFC3339))
+	return starlarkstruct.FromStringDict(starlark.String("message"), fields)
+}
+
+func newEvent(name string, ctx config.HookContext) *starlarkstruct.Struct {
+	fields := starlark.StringDict{
+		"hook":       starlark.String(name),
+		"account":    starlark.String(ctx.Account),
+		"folder":     starlark.String(ctx.Folder),
+		"from_":      starlark.String(ctx.From),
+		"to":         starlark.String(ctx.To),
+		"subject":    starlark.String(ctx.Subject),
+		"flag":       starlark.String(ctx.Flag),
+		"flag_value": starlark.Bool(ctx.FlagValue),
+		"message":    starlark.None,
+	}
+	if ctx.Message != nil {
+		fields["message"] = newMessage(ctx.Account, ctx.Folder, ctx.Message)
+	}
+	return starlarkstruct.FromStringDict(starlark.String("event"), fields)
+}
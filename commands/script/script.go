@@ -0,0 +1,128 @@
+// Package script runs Starlark scripts which extend aerc with commands and
+// hook handlers.
+package script
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	"go.starlark.net/starlark"
+
+	"git.sr.ht/~sircmpwn/aerc/commands"
+	"git.sr.ht/~sircmpwn/aerc/config"
+	"git.sr.ht/~sircmpwn/aerc/widgets"
+)
+
+var (
+	// The commands defined by scripts. They are tried after the built-in
+	// commands.
+	ScriptCommands = commands.NewCommands()
+
+	// Hook handlers by hook name
+	handlers = make(map[string][]*starlark.Function)
+)
+
+// Load runs the *.star files of dir, in lexical order. The errors of all the
+// scripts are reported; a script which fails does not keep the others from
+// loading.
+func Load(aerc *widgets.Aerc, dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		// Scripts are optional
+		return nil
+	}
+	var names []string
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".star") {
+			names = append(names, file.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var errs []string
+	for _, name := range names {
+		filename := path.Join(dir, name)
+		_, err := starlark.ExecFile(newThread(aerc, name), filename, nil,
+			starlark.StringDict{"aerc": newModule(aerc)})
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	aerc.Config().Hooks.OnRun = func(name string, ctx config.HookContext) {
+		if len(handlers[name]) == 0 {
+			return
+		}
+		if name == "aerc-shutdown" {
+			// The UI is gone, so this is the last chance to run them
+			runHandlers(aerc, name, ctx)
+			return
+		}
+		aerc.Post(func() {
+			runHandlers(aerc, name, ctx)
+		})
+	}
+
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Scripts run on the UI goroutine, so one which runs for longer than this
+// many steps, e.g. stuck in a loop, is stopped rather than freezing aerc
+const maxSteps = 10000000
+
+func newThread(aerc *widgets.Aerc, name string) *starlark.Thread {
+	thread := &starlark.Thread{
+		Name: name,
+		Print: func(_ *starlark.Thread, msg string) {
+			aerc.Logger().Printf("%s: %s", name, msg)
+		},
+	}
+	thread.SetMaxExecutionSteps(maxSteps)
+	return thread
+}
+
+func runHandlers(aerc *widgets.Aerc, name string, ctx config.HookContext) {
+	event := newEvent(name, ctx)
+	for _, fn := range handlers[name] {
+		_, err := starlark.Call(newThread(aerc, fn.Name()), fn,
+			starlark.Tuple{event}, nil)
+		if err != nil {
+			aerc.PushError(fmt.Sprintf(" %s hook: %v", name, err))
+		}
+	}
+}
+
+// A command defined by a script with aerc.command
+type ScriptCommand struct {
+	Name string
+	fn   *starlark.Function
+}
+
+func (cmd ScriptCommand) Aliases() []string {
+	return []string{cmd.Name}
+}
+
+func (_ ScriptCommand) Complete(aerc *widgets.Aerc, args []string) []string {
+	return nil
+}
+
+func (cmd ScriptCommand) Execute(aerc *widgets.Aerc, args []string) error {
+	list := make([]starlark.Value, len(args)-1)
+	for i, arg := range args[1:] {
+		list[i] = starlark.String(arg)
+	}
+	_, err := starlark.Call(newThread(aerc, cmd.fn.Name()), cmd.fn,
+		starlark.Tuple{starlark.NewList(list)}, nil)
+	if evalErr, ok := err.(*starlark.EvalError); ok {
+		// Only the message fits on the status line
+		return errors.New(evalErr.Msg)
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+package script
+
+import (
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+
+	"git.sr.ht/~sircmpwn/aerc/models"
+)
+
+func TestDefinitions(t *testing.T) {
+	src := `
+def archive_jira(args):
+    pass
+
+def on_mail(event):
+    if "jira@" in event.message.from_[0]:
+        aerc.move(event.message, "Jira")
+
+aerc.command("archive-jira", archive_jira)
+aerc.on("mail-received", on_mail)
+`
+	thread := &starlark.Thread{Name: "test"}
+	_, err := starlark.ExecFile(thread, "test.star", src,
+		starlark.StringDict{"aerc": newModule(nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := (*ScriptCommands)["archive-jira"]; !ok {
+		t.Error("archive-jira was not defined")
+	}
+	if len(handlers["mail-received"]) != 1 {
+		t.Error("mail-received handler was not registered")
+	}
+
+	_, err = starlark.ExecFile(thread, "bad.star",
+		"def f(event):\n    pass\naerc.on(\"mail-burned\", f)\n",
+		starlark.StringDict{"aerc": newModule(nil)})
+	if err == nil || !strings.Contains(err.Error(), "unknown hook") {
+		t.Errorf("expected unknown hook error, got %v", err)
+	}
+}
+
+func TestMessage(t *testing.T) {
+	msg := newMessage("work", "INBOX", &models.MessageInfo{
+		Uid:   42,
+		Flags: []models.Flag{models.SeenFlag},
+		Envelope: &models.Envelope{
+			Subject: "hello",
+			From: []*models.Address{
+				{Name: "jira", Mailbox: "jira", Host: "example.org"},
+			},
+		},
+	})
+	for attr, expected := range map[string]string{
+		"account": `"work"`,
+		"folder":  `"INBOX"`,
+		"uid":     `42`,
+		"subject": `"hello"`,
+		"from_":   `["jira <jira@example.org>"]`,
+		"flags":   `["seen"]`,
+	} {
+		val, err := msg.Attr(attr)
+		if err != nil {
+			t.Errorf("%s: %v", attr, err)
+		} else if val.String() != expected {
+			t.Errorf("%s: got %s, expected %s", attr, val, expected)
+		}
+	}
+}
+
+func TestRunaway(t *testing.T) {
+	src := `
+def spin():
+    for i in range(1000000000):
+        pass
+
+spin()
+`
+	_, err := starlark.ExecFile(newThread(nil, "spin"), "spin.star", src, nil)
+	if err == nil || !strings.Contains(err.Error(), "too many steps") {
+		t.Errorf("expected the script to be stopped, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"git.sr.ht/~sircmpwn/aerc/widgets"
+	"github.com/mitchellh/go-homedir"
+)
+
+type Source struct{}
+
+func init() {
+	register(Source{})
+}
+
+func (_ Source) Aliases() []string {
+	return []string{"source"}
+}
+
+func (_ Source) Complete(aerc *widgets.Aerc, args []string) []string {
+	path := ""
+	if len(args) >= 1 {
+		path = strings.Join(args, " ")
+	}
+	return CompletePath(path)
+}
+
+func (_ Source) Execute(aerc *widgets.Aerc, args []string) error {
+	if len(args) != 2 {
+		return errors.New("Usage: source <file>")
+	}
+	path, err := homedir.Expand(args[1])
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return nested(args[1], func() error {
+		scanner := bufio.NewScanner(f)
+		for n := 1; scanner.Scan(); n++ {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if err := aerc.RunCommands(line); err != nil {
+				return fmt.Errorf("%s:%d: %v", args[1], n, err)
+			}
+		}
+		return scanner.Err()
+	})
+}
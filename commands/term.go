@@ -2,11 +2,9 @@ package commands
 
 import (
 	"os/exec"
-	"time"
 
 	"git.sr.ht/~sircmpwn/aerc/widgets"
 
-	"github.com/gdamore/tcell"
 	"github.com/riywo/loginshell"
 )
 
@@ -48,8 +46,7 @@ func TermCore(aerc *widgets.Aerc, args []string) error {
 	term.OnClose = func(err error) {
 		aerc.RemoveTab(term)
 		if err != nil {
-			aerc.PushStatus(" "+err.Error(), 10*time.Second).
-				Color(tcell.ColorDefault, tcell.ColorRed)
+			aerc.PushError(" " + err.Error())
 		}
 	}
 	return nil
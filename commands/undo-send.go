@@ -0,0 +1,28 @@
+package commands
+
+import (
+	"errors"
+
+	"git.sr.ht/~sircmpwn/aerc/widgets"
+)
+
+type UndoSend struct{}
+
+func init() {
+	register(UndoSend{})
+}
+
+func (_ UndoSend) Aliases() []string {
+	return []string{"undo-send"}
+}
+
+func (_ UndoSend) Complete(aerc *widgets.Aerc, args []string) []string {
+	return nil
+}
+
+func (_ UndoSend) Execute(aerc *widgets.Aerc, args []string) error {
+	if len(args) != 1 {
+		return errors.New("Usage: undo-send")
+	}
+	return aerc.UndoSend()
+}
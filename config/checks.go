@@ -0,0 +1,233 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-ini/ini"
+	"github.com/google/shlex"
+)
+
+// The keys of the [checks] section which are not check commands
+var checkOptions = map[string]bool{
+	"attachment-keywords": true,
+	"empty-subject":       true,
+	"cc-keywords":         true,
+	"max-attachment-size": true,
+	"internal-domains":    true,
+	"timeout":             true,
+}
+
+// ChecksConfig configures the checks run on a message before it is sent, which
+// warn about e.g. a forgotten attachment.
+type ChecksConfig struct {
+	AttachmentKeywords string        `ini:"attachment-keywords"`
+	EmptySubject       bool          `ini:"empty-subject"`
+	CcKeywords         string        `ini:"cc-keywords"`
+	MaxAttachmentSize  string        `ini:"max-attachment-size"`
+	InternalDomains    []string      `ini:"internal-domains" delim:","`
+	Timeout            time.Duration `ini:"timeout"`
+
+	// The check commands, by name, in the order of the section
+	Commands []CheckCommand `ini:"-"`
+
+	attachmentKeywords *regexp.Regexp
+	ccKeywords         *regexp.Regexp
+	maxAttachmentSize  int64
+}
+
+type CheckCommand struct {
+	Name    string
+	Command string
+}
+
+// OutgoingMessage is what the checks look at in a message about to be sent.
+type OutgoingMessage struct {
+	Subject string
+	// The addresses of the recipients
+	To  []string
+	Cc  []string
+	Bcc []string
+	// The text of the message, and the paths of its attachments
+	Body        string
+	Attachments []string
+	// The whole message, given to check commands
+	Content []byte
+}
+
+func (checks *ChecksConfig) load(section *ini.Section) error {
+	if section != nil {
+		if err := section.MapTo(checks); err != nil {
+			return err
+		}
+		for _, key := range section.Keys() {
+			if !checkOptions[key.Name()] {
+				checks.Commands = append(checks.Commands, CheckCommand{
+					Name:    key.Name(),
+					Command: key.Value(),
+				})
+			}
+		}
+	}
+	var err error
+	if checks.AttachmentKeywords != "" {
+		checks.attachmentKeywords, err = regexp.Compile(
+			checks.AttachmentKeywords)
+		if err != nil {
+			return fmt.Errorf("attachment-keywords: %v", err)
+		}
+	}
+	if checks.CcKeywords != "" {
+		checks.ccKeywords, err = regexp.Compile(checks.CcKeywords)
+		if err != nil {
+			return fmt.Errorf("cc-keywords: %v", err)
+		}
+	}
+	if checks.MaxAttachmentSize != "" {
+		size, err := parseSize(checks.MaxAttachmentSize)
+		if err != nil {
+			return fmt.Errorf("max-attachment-size: %v", err)
+		}
+		checks.maxAttachmentSize = int64(size)
+	}
+	for i, domain := range checks.InternalDomains {
+		checks.InternalDomains[i] = strings.ToLower(strings.TrimSpace(domain))
+	}
+	return nil
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fG", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fM", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fK", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// Returns the lines of a body which are not quoted from another message
+func unquoted(body string) string {
+	var lines []string
+	for _, line := range strings.Split(body, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), ">") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Run runs the checks on a message, and returns their warnings. The check
+// commands are run in turn and block until they finish, so the UI runs the
+// checks on a goroutine of their own.
+func (checks *ChecksConfig) Run(msg *OutgoingMessage) []string {
+	var warnings []string
+	body := unquoted(msg.Body)
+	if checks.attachmentKeywords != nil && len(msg.Attachments) == 0 &&
+		checks.attachmentKeywords.MatchString(body) {
+
+		warnings = append(warnings,
+			"The message mentions an attachment, but has none")
+	}
+	if checks.EmptySubject && strings.TrimSpace(msg.Subject) == "" {
+		warnings = append(warnings, "The subject is empty")
+	}
+	if checks.ccKeywords != nil && len(msg.Cc) == 0 &&
+		checks.ccKeywords.MatchString(body) {
+
+		warnings = append(warnings,
+			"The message mentions a Cc, but has no Cc recipient")
+	}
+	if checks.maxAttachmentSize > 0 {
+		for _, path := range msg.Attachments {
+			info, err := os.Stat(path)
+			if err == nil && info.Size() > checks.maxAttachmentSize {
+				warnings = append(warnings, fmt.Sprintf(
+					"The attachment %s is %s, more than %s",
+					filepath.Base(path), formatSize(info.Size()),
+					formatSize(checks.maxAttachmentSize)))
+			}
+		}
+	}
+	if external := checks.external(msg); len(external) != 0 {
+		warnings = append(warnings, fmt.Sprintf(
+			"Recipients outside of %s: %s",
+			strings.Join(checks.InternalDomains, ", "),
+			strings.Join(external, ", ")))
+	}
+	for _, check := range checks.Commands {
+		if err := checks.exec(check, msg); err != nil {
+			warnings = append(warnings, err.Error())
+		}
+	}
+	return warnings
+}
+
+// Returns the recipients whose domain is not internal, if internal domains are
+// configured
+func (checks *ChecksConfig) external(msg *OutgoingMessage) []string {
+	if len(checks.InternalDomains) == 0 {
+		return nil
+	}
+	var external []string
+	rcpts := append(append(append([]string{}, msg.To...), msg.Cc...),
+		msg.Bcc...)
+outer:
+	for _, rcpt := range rcpts {
+		domain := strings.ToLower(rcpt[strings.LastIndex(rcpt, "@")+1:])
+		for _, internal := range checks.InternalDomains {
+			if domain == internal || strings.HasSuffix(domain, "."+internal) {
+				continue outer
+			}
+		}
+		external = append(external, rcpt)
+	}
+	return external
+}
+
+// Runs a check command, which fails with a non-zero exit status. The first line
+// of its output is the warning.
+func (checks *ChecksConfig) exec(check CheckCommand, msg *OutgoingMessage) error {
+	args, err := shlex.Split(check.Command)
+	if err != nil || len(args) == 0 {
+		return fmt.Errorf("%s check: invalid command %q", check.Name,
+			check.Command)
+	}
+	timeout := checks.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	cctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(cctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(msg.Content)
+	cmd.Env = append(os.Environ(),
+		"AERC_CHECK="+check.Name,
+		"AERC_SUBJECT="+msg.Subject,
+		"AERC_TO="+strings.Join(msg.To, ", "),
+		"AERC_CC="+strings.Join(msg.Cc, ", "),
+		"AERC_BCC="+strings.Join(msg.Bcc, ", "),
+		fmt.Sprintf("AERC_ATTACHMENTS=%d", len(msg.Attachments)))
+	out, err := cmd.CombinedOutput()
+	if cctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s check: timed out after %v", check.Name, timeout)
+	}
+	if err != nil {
+		// Warnings are shown on a single line
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s check: %s", check.Name,
+				strings.SplitN(msg, "\n", 2)[0])
+		}
+		return fmt.Errorf("%s check: %v", check.Name, err)
+	}
+	return nil
+}
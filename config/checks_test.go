@@ -0,0 +1,72 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-ini/ini"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecks(t *testing.T) {
+	assert := assert.New(t)
+
+	file, err := ini.LoadSources(ini.LoadOptions{IgnoreInlineComment: true},
+		[]byte(`
+[checks]
+attachment-keywords = (?i)\b(attach(ed|ment)?)\b
+empty-subject = true
+cc-keywords = (?i)\bcc'?ing\b
+max-attachment-size = 1K
+internal-domains = example.org, Example.com
+draft = sh -c 'grep -q DRAFT && echo "Still a draft" && exit 1 || exit 0'
+`))
+	assert.Nil(err)
+	section, err := file.GetSection("checks")
+	assert.Nil(err)
+	var checks ChecksConfig
+	assert.Nil(checks.load(section))
+	assert.Equal([]CheckCommand{{
+		Name:    "draft",
+		Command: `sh -c 'grep -q DRAFT && echo "Still a draft" && exit 1 || exit 0'`,
+	}}, checks.Commands)
+	assert.Equal([]string{"example.org", "example.com"}, checks.InternalDomains)
+
+	msg := &OutgoingMessage{
+		Subject: "Report",
+		To:      []string{"a@example.org", "b@lists.example.com"},
+		Body:    "Hi,\n\nHere is the report.\n\n> See the attached file\n",
+		Content: []byte("Here is the report."),
+	}
+	assert.Empty(checks.Run(msg))
+
+	dir, err := ioutil.TempDir("", "aerc-checks")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	big := filepath.Join(dir, "big.pdf")
+	assert.Nil(ioutil.WriteFile(big, make([]byte, 2048), 0600))
+
+	msg = &OutgoingMessage{
+		To:      []string{"a@example.org", "c@example.net"},
+		Body:    "Hi,\n\nThe report is attached. I'm cc'ing Bob.\n",
+		Content: []byte("DRAFT"),
+	}
+	assert.Equal([]string{
+		"The message mentions an attachment, but has none",
+		"The subject is empty",
+		"The message mentions a Cc, but has no Cc recipient",
+		"Recipients outside of example.org, example.com: c@example.net",
+		"draft check: Still a draft",
+	}, checks.Run(msg))
+
+	msg.Subject = "Report"
+	msg.Cc = []string{"bob@example.org"}
+	msg.To = msg.To[:1]
+	msg.Attachments = []string{big}
+	msg.Content = nil
+	assert.Equal([]string{
+		"The attachment big.pdf is 2.0K, more than 1.0K",
+	}, checks.Run(msg))
+}
@@ -11,11 +11,14 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/gdamore/tcell"
 	"github.com/go-ini/ini"
 	"github.com/kyoh86/xdg"
+
+	"git.sr.ht/~sircmpwn/aerc/lib/templates"
 )
 
 type GeneralConfig struct {
@@ -33,6 +36,20 @@ type UIConfig struct {
 	EmptyDirlist      string   `ini:"empty-dirlist"`
 	MouseEnabled      bool     `ini:"mouse-enabled"`
 	NewMessageBell    bool     `ini:"new-message-bell"`
+	StyleSetName      string   `ini:"styleset-name"`
+	StyleSetDirs      []string `ini:"stylesets-dirs" delim:":"`
+
+	style StyleSet
+}
+
+func (ui *UIConfig) GetStyle(so StyleObject) tcell.Style {
+	return ui.style.Get(so)
+}
+
+func (ui *UIConfig) GetComposedStyle(base StyleObject,
+	overlays []StyleObject) tcell.Style {
+
+	return ui.style.Compose(base, overlays)
 }
 
 const (
@@ -52,6 +69,8 @@ type AccountConfig struct {
 	Params          map[string]string
 	Outgoing        string
 	OutgoingCredCmd string
+	SignatureFile   string
+	SignatureCmd    string
 }
 
 type BindingConfig struct {
@@ -68,6 +87,9 @@ type BindingConfig struct {
 type ComposeConfig struct {
 	Editor       string     `ini:"editor"`
 	HeaderLayout [][]string `ini:"-"`
+	FormatFlowed bool       `ini:"format-flowed"`
+	// How long :send waits before sending, so that it can be undone
+	SendDelay time.Duration `ini:"send-delay"`
 }
 
 type FilterConfig struct {
@@ -91,16 +113,53 @@ type TriggersConfig struct {
 	ExecuteCommand func(command []string) error
 }
 
+type NotificationsConfig struct {
+	Enabled       bool          `ini:"enabled"`
+	SummaryFormat string        `ini:"summary-format"`
+	BodyFormat    string        `ini:"body-format"`
+	Command       string        `ini:"command"`
+	GroupDelay    time.Duration `ini:"group-delay"`
+}
+
+type TemplateConfig struct {
+	TemplateDirs []string `ini:"template-dirs" delim:":"`
+	NewMessage   string   `ini:"new-message"`
+	QuotedReply  string   `ini:"quoted-reply"`
+	Forwards     string   `ini:"forwards"`
+}
+
+const (
+	UI_CONTEXT_FOLDER ContextType = iota
+	UI_CONTEXT_ACCOUNT
+)
+
+type ContextType int
+
+// UIConfigContext overrides the UI configuration for the accounts or folders
+// matching Regex, e.g. from a [ui:folder=~Lists/.*] section
+type UIConfigContext struct {
+	ContextType ContextType
+	Regex       *regexp.Regexp
+	Section     *ini.Section
+}
+
 type AercConfig struct {
-	Bindings BindingConfig
-	Compose  ComposeConfig
-	Ini      *ini.File       `ini:"-"`
-	Accounts []AccountConfig `ini:"-"`
-	Filters  []FilterConfig  `ini:"-"`
-	Viewer   ViewerConfig    `ini:"-"`
-	Triggers TriggersConfig  `ini:"-"`
-	Ui       UIConfig
-	General  GeneralConfig
+	Bindings      BindingConfig
+	Compose       ComposeConfig
+	Ini           *ini.File           `ini:"-"`
+	Accounts      []AccountConfig     `ini:"-"`
+	Aliases       map[string]string   `ini:"-"`
+	Filters       []FilterConfig      `ini:"-"`
+	Viewer        ViewerConfig        `ini:"-"`
+	Triggers      TriggersConfig      `ini:"-"`
+	Hooks         HooksConfig         `ini:"-"`
+	Checks        ChecksConfig        `ini:"-"`
+	Notifications NotificationsConfig `ini:"-"`
+	Rules         []RuleConfig        `ini:"-"`
+	Templates     TemplateConfig      `ini:"-"`
+	Ui            UIConfig
+	ContextualUis []UIConfigContext `ini:"-"`
+	General       GeneralConfig
 }
 
 // Input: TimestampFormat
@@ -158,6 +217,10 @@ func loadAccountConfig(path string) ([]AccountConfig, error) {
 				account.CopyTo = val
 			} else if key == "archive" {
 				account.Archive = val
+			} else if key == "signature-file" {
+				account.SignatureFile = val
+			} else if key == "signature-cmd" {
+				account.SignatureCmd = val
 			} else if key != "name" {
 				account.Params[key] = val
 			}
@@ -293,14 +356,164 @@ func (config *AercConfig) LoadConfig(file *ini.File) error {
 			return err
 		}
 	}
+	style, err := LoadStyleSet(config.Ui.StyleSetName, config.Ui.StyleSetDirs)
+	if err != nil {
+		return err
+	}
+	config.Ui.style = style
+	for _, section := range file.Sections() {
+		if !strings.HasPrefix(section.Name(), "ui:") {
+			continue
+		}
+		context, err := parseUiContext(section)
+		if err != nil {
+			return err
+		}
+		// Catch errors in the section now rather than when it is applied
+		if _, err := config.Ui.withContext(context); err != nil {
+			return fmt.Errorf("[%s]: %v", section.Name(), err)
+		}
+		config.ContextualUis = append(config.ContextualUis, context)
+	}
 	if triggers, err := file.GetSection("triggers"); err == nil {
 		if err := triggers.MapTo(&config.Triggers); err != nil {
 			return err
 		}
 	}
+	if hooks, err := file.GetSection("hooks"); err == nil {
+		if err := hooks.MapTo(&config.Hooks); err != nil {
+			return err
+		}
+	}
+	checks, err := file.GetSection("checks")
+	if err != nil {
+		checks = nil
+	}
+	if err := config.Checks.load(checks); err != nil {
+		return fmt.Errorf("[checks]: %v", err)
+	}
+	if notifications, err := file.GetSection("notifications"); err == nil {
+		if err := notifications.MapTo(&config.Notifications); err != nil {
+			return err
+		}
+	}
+	if templatesSec, err := file.GetSection("templates"); err == nil {
+		if err := templatesSec.MapTo(&config.Templates); err != nil {
+			return err
+		}
+		for _, name := range []string{
+			config.Templates.NewMessage,
+			config.Templates.QuotedReply,
+			config.Templates.Forwards,
+		} {
+			if name == "" {
+				continue
+			}
+			if err := templates.CheckTemplate(
+				name, config.Templates.TemplateDirs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// LoadAliases loads the [aliases] section. The file should be parsed without
+// inline comments, so that the semicolons separating commands are kept.
+func (config *AercConfig) LoadAliases(file *ini.File) error {
+	config.Aliases = make(map[string]string)
+	sec, err := file.GetSection("aliases")
+	if err != nil {
+		return nil
+	}
+	for _, key := range sec.Keys() {
+		name := key.Name()
+		if name == "" || strings.ContainsAny(name, " \t;:") {
+			return fmt.Errorf("[aliases]: invalid alias name %q", name)
+		}
+		config.Aliases[name] = key.Value()
+	}
 	return nil
 }
 
+// parseUiContext parses the name of a [ui:account=<name>] or
+// [ui:folder=<name>] section. Names prefixed with ~ are regular expressions.
+func parseUiContext(section *ini.Section) (UIConfigContext, error) {
+	context := UIConfigContext{Section: section}
+	spec := strings.TrimPrefix(section.Name(), "ui:")
+	eq := strings.Index(spec, "=")
+	if eq < 0 {
+		return context, fmt.Errorf(
+			"Invalid UI context [%s]: expected ui:<account|folder>=<name>",
+			section.Name())
+	}
+	switch spec[:eq] {
+	case "account":
+		context.ContextType = UI_CONTEXT_ACCOUNT
+	case "folder":
+		context.ContextType = UI_CONTEXT_FOLDER
+	default:
+		return context, fmt.Errorf("Unknown UI context %q in [%s]",
+			spec[:eq], section.Name())
+	}
+	value := spec[eq+1:]
+	var pattern string
+	if strings.HasPrefix(value, "~") {
+		pattern = value[1:]
+	} else {
+		pattern = "^" + regexp.QuoteMeta(value) + "$"
+	}
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return context, fmt.Errorf("[%s]: %v", section.Name(), err)
+	}
+	context.Regex = regex
+	return context, nil
+}
+
+// withContext returns a copy of the UI configuration with the options of the
+// given context applied on top
+func (ui UIConfig) withContext(context UIConfigContext) (UIConfig, error) {
+	styleSetName, styleSetDirs := ui.StyleSetName, ui.StyleSetDirs
+	if err := context.Section.MapTo(&ui); err != nil {
+		return ui, err
+	}
+	if ui.StyleSetName != styleSetName ||
+		strings.Join(ui.StyleSetDirs, ":") != strings.Join(styleSetDirs, ":") {
+
+		style, err := LoadStyleSet(ui.StyleSetName, ui.StyleSetDirs)
+		if err != nil {
+			return ui, err
+		}
+		ui.style = style
+	}
+	return ui, nil
+}
+
+// GetUiConfig returns the UI configuration for the given account and folder,
+// with the matching contextual sections applied in order: first those for
+// the account, then those for the folder.
+func (config *AercConfig) GetUiConfig(params map[ContextType]string) *UIConfig {
+	ui := config.Ui
+	for _, contextType := range []ContextType{
+		UI_CONTEXT_ACCOUNT, UI_CONTEXT_FOLDER,
+	} {
+		value, ok := params[contextType]
+		if !ok {
+			continue
+		}
+		for _, context := range config.ContextualUis {
+			if context.ContextType != contextType ||
+				!context.Regex.MatchString(value) {
+				continue
+			}
+			// Errors were already reported when loading the config
+			ui, _ = ui.withContext(context)
+		}
+	}
+	return &ui
+}
+
 func LoadConfigFromFile(root *string, sharedir string) (*AercConfig, error) {
 	if root == nil {
 		_root := path.Join(xdg.ConfigHome(), "aerc")
@@ -347,6 +560,11 @@ func LoadConfigFromFile(root *string, sharedir string) (*AercConfig, error) {
 			EmptyDirlist:      "(no folders)",
 			MouseEnabled:      false,
 			NewMessageBell:    true,
+			StyleSetName:      "default",
+			StyleSetDirs: []string{
+				path.Join(*root, "stylesets"),
+				path.Join(sharedir, "stylesets"),
+			},
 		},
 
 		Viewer: ViewerConfig{
@@ -367,6 +585,29 @@ func LoadConfigFromFile(root *string, sharedir string) (*AercConfig, error) {
 				{"Subject"},
 			},
 		},
+
+		Checks: ChecksConfig{
+			AttachmentKeywords: `(?i)\b(attach(ed|es|ing|ment|ments)?|enclosed)\b`,
+			EmptySubject:       true,
+			CcKeywords:         `(?i)\b(cc'?ing|cc'?ed|cc'?d)\b`,
+			MaxAttachmentSize:  "10M",
+		},
+
+		Notifications: NotificationsConfig{
+			SummaryFormat: "%n",
+			BodyFormat:    "%s",
+			GroupDelay:    2 * time.Second,
+		},
+
+		Templates: TemplateConfig{
+			TemplateDirs: []string{
+				path.Join(*root, "templates"),
+				path.Join(sharedir, "templates"),
+			},
+			NewMessage:  "new_message",
+			QuotedReply: "quoted_reply",
+			Forwards:    "forward_as_body",
+		},
 	}
 	// These bindings are not configurable
 	config.Bindings.AccountWizard.ExKey = KeyStroke{
@@ -383,6 +624,14 @@ func LoadConfigFromFile(root *string, sharedir string) (*AercConfig, error) {
 			return nil, err
 		}
 	}
+	aliases, err := ini.LoadSources(
+		ini.LoadOptions{IgnoreInlineComment: true}, filename)
+	if err != nil {
+		return nil, err
+	}
+	if err = config.LoadAliases(aliases); err != nil {
+		return nil, err
+	}
 
 	accountsPath := path.Join(*root, "accounts.conf")
 	if accounts, err := loadAccountConfig(accountsPath); err != nil {
@@ -390,6 +639,16 @@ func LoadConfigFromFile(root *string, sharedir string) (*AercConfig, error) {
 	} else {
 		config.Accounts = accounts
 	}
+	// Patterns may contain semicolons and number signs
+	rules, err := ini.LoadSources(ini.LoadOptions{IgnoreInlineComment: true},
+		path.Join(*root, "rules.conf"))
+	if err == nil {
+		if config.Rules, err = LoadRules(rules); err != nil {
+			return nil, fmt.Errorf("rules.conf: %v", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
 	filename = path.Join(*root, "binds.conf")
 	binds, err := ini.Load(filename)
 	if err != nil {
@@ -0,0 +1,208 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/shlex"
+
+	"git.sr.ht/~sircmpwn/aerc/lib/format"
+	"git.sr.ht/~sircmpwn/aerc/models"
+)
+
+// The names of the hooks, in the order of the [hooks] section
+var HookNames = []string{
+	"aerc-startup", "aerc-shutdown", "mail-received", "mail-sent",
+	"mail-deleted", "flag-changed", "folder-changed",
+}
+
+type HooksConfig struct {
+	AercStartup   string        `ini:"aerc-startup"`
+	AercShutdown  string        `ini:"aerc-shutdown"`
+	MailReceived  string        `ini:"mail-received"`
+	MailSent      string        `ini:"mail-sent"`
+	MailDeleted   string        `ini:"mail-deleted"`
+	FlagChanged   string        `ini:"flag-changed"`
+	FolderChanged string        `ini:"folder-changed"`
+	Timeout       time.Duration `ini:"timeout"`
+
+	// Called with the errors of hooks, from the goroutine running the hook
+	OnError func(err error) `ini:"-"`
+	// Called whenever a hook is run, whether or not a command is configured
+	// for it, from the goroutine calling Run
+	OnRun func(name string, ctx HookContext) `ini:"-"`
+
+	running sync.WaitGroup
+}
+
+// HookContext describes what a hook is run for. Empty fields are left out of
+// the hook's environment.
+type HookContext struct {
+	Account string
+	Folder  string
+	From    string
+	To      string
+	Subject string
+	// For flag-changed, the name of the flag and whether it is now set
+	Flag      string
+	FlagValue bool
+	// The message the hook is about, if any. Its format specifiers are
+	// expanded in the hook command.
+	Message *models.MessageInfo
+	// Used to expand format specifiers
+	TimestampFormat string
+}
+
+func (hooks *HooksConfig) command(name string) (string, error) {
+	switch name {
+	case "aerc-startup":
+		return hooks.AercStartup, nil
+	case "aerc-shutdown":
+		return hooks.AercShutdown, nil
+	case "mail-received":
+		return hooks.MailReceived, nil
+	case "mail-sent":
+		return hooks.MailSent, nil
+	case "mail-deleted":
+		return hooks.MailDeleted, nil
+	case "flag-changed":
+		return hooks.FlagChanged, nil
+	case "folder-changed":
+		return hooks.FolderChanged, nil
+	}
+	return "", fmt.Errorf("unknown hook %s", name)
+}
+
+func (ctx *HookContext) environ(name string) []string {
+	env := []string{"AERC_HOOK=" + name}
+	from, to, subject := ctx.From, ctx.To, ctx.Subject
+	if msg := ctx.Message; msg != nil && msg.Envelope != nil {
+		if from == "" {
+			from = models.FormatAddresses(msg.Envelope.From)
+		}
+		if to == "" {
+			to = models.FormatAddresses(msg.Envelope.To)
+		}
+		if subject == "" {
+			subject = msg.Envelope.Subject
+		}
+	}
+	vars := []struct {
+		key   string
+		value string
+	}{
+		{"AERC_ACCOUNT", ctx.Account},
+		{"AERC_FOLDER", ctx.Folder},
+		{"AERC_FROM", from},
+		{"AERC_TO", to},
+		{"AERC_SUBJECT", subject},
+		{"AERC_FLAG", ctx.Flag},
+	}
+	for _, v := range vars {
+		if v.value != "" {
+			env = append(env, v.key+"="+v.value)
+		}
+	}
+	if ctx.Flag != "" {
+		env = append(env, fmt.Sprintf("AERC_FLAG_VALUE=%t", ctx.FlagValue))
+	}
+	if ctx.Message != nil {
+		env = append(env, fmt.Sprintf("AERC_UID=%d", ctx.Message.Uid))
+	}
+	return env
+}
+
+func (ctx *HookContext) expand(part string) (string, error) {
+	if ctx.Message == nil || ctx.Message.Envelope == nil {
+		return part, nil
+	}
+	formatstr, args, err := format.ParseMessageFormat(part,
+		ctx.TimestampFormat, ctx.Account, 0, ctx.Message)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(formatstr, args...), nil
+}
+
+// Run starts the named hook, if one is configured, without waiting for it to
+// finish. The hook is killed if it runs longer than the configured timeout.
+// An unknown hook name is reported to OnError.
+func (hooks *HooksConfig) Run(name string, ctx HookContext) {
+	hookCmd, err := hooks.command(name)
+	if err != nil {
+		if hooks.OnError != nil {
+			hooks.OnError(err)
+		}
+		return
+	}
+	if hooks.OnRun != nil {
+		hooks.OnRun(name, ctx)
+	}
+	if hookCmd == "" {
+		return
+	}
+	// Expand the command now, as the message may change under our feet
+	args, err := ctx.args(hookCmd)
+	if err == nil && len(args) == 0 {
+		return
+	}
+	env := append(os.Environ(), ctx.environ(name)...)
+	hooks.running.Add(1)
+	go func() {
+		defer hooks.running.Done()
+		if err == nil {
+			err = hooks.exec(args, env)
+		}
+		if err != nil && hooks.OnError != nil {
+			hooks.OnError(fmt.Errorf("%s hook: %v", name, err))
+		}
+	}()
+}
+
+// Wait blocks until all running hooks have finished
+func (hooks *HooksConfig) Wait() {
+	hooks.running.Wait()
+}
+
+func (ctx *HookContext) args(hookCmd string) ([]string, error) {
+	parts, err := shlex.Split(hookCmd)
+	if err != nil {
+		return nil, err
+	}
+	var args []string
+	for _, part := range parts {
+		arg, err := ctx.expand(part)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+func (hooks *HooksConfig) exec(args []string, env []string) error {
+	timeout := hooks.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	cctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(cctx, args[0], args[1:]...)
+	cmd.Env = env
+	out, err := cmd.CombinedOutput()
+	if cctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %v", timeout)
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%v: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-ini/ini"
+	"github.com/stretchr/testify/assert"
+
+	"git.sr.ht/~sircmpwn/aerc/models"
+)
+
+func TestHookEnvironment(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := HookContext{
+		Account: "work",
+		Folder:  "INBOX",
+		Message: &models.MessageInfo{
+			Uid: 42,
+			Envelope: &models.Envelope{
+				Subject: "Hello",
+				From: []*models.Address{
+					{Name: "ann", Mailbox: "ann", Host: "example.org"},
+				},
+			},
+		},
+	}
+	env := ctx.environ("mail-received")
+	assert.Contains(env, "AERC_HOOK=mail-received")
+	assert.Contains(env, "AERC_ACCOUNT=work")
+	assert.Contains(env, "AERC_FOLDER=INBOX")
+	assert.Contains(env, "AERC_FROM=ann <ann@example.org>")
+	assert.Contains(env, "AERC_SUBJECT=Hello")
+	assert.Contains(env, "AERC_UID=42")
+	for _, v := range env {
+		assert.False(strings.HasPrefix(v, "AERC_TO="))
+		assert.False(strings.HasPrefix(v, "AERC_FLAG"))
+	}
+
+	args, err := ctx.args(`notify-send "New email from %n" %s`)
+	assert.Nil(err)
+	assert.Equal([]string{"notify-send", "New email from ann", "Hello"}, args)
+}
+
+func TestHookErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	file, err := ini.Load([]byte(`
+[hooks]
+aerc-startup=sh -c "echo broken >&2 && false"
+aerc-shutdown=sleep 5
+timeout=100ms
+`))
+	assert.Nil(err)
+	file.NameMapper = mapName
+	config := &AercConfig{Ui: UIConfig{StyleSetName: "default"}}
+	assert.Nil(config.LoadConfig(file))
+	assert.Equal(100*time.Millisecond, config.Hooks.Timeout)
+
+	var (
+		errs  []string
+		mutex sync.Mutex
+	)
+	config.Hooks.OnError = func(err error) {
+		mutex.Lock()
+		errs = append(errs, err.Error())
+		mutex.Unlock()
+	}
+	config.Hooks.Run("aerc-startup", HookContext{})
+	config.Hooks.Run("aerc-shutdown", HookContext{})
+	config.Hooks.Run("mail-sent", HookContext{})
+	config.Hooks.Run("mail-lost", HookContext{})
+	config.Hooks.Wait()
+	assert.Len(errs, 3)
+	assert.Contains(errs, "unknown hook mail-lost")
+	assert.Contains(errs, "aerc-startup hook: exit status 1: broken")
+	assert.Contains(errs, "aerc-shutdown hook: timed out after 100ms")
+}
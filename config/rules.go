@@ -0,0 +1,221 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-ini/ini"
+	"github.com/google/shlex"
+
+	"git.sr.ht/~sircmpwn/aerc/models"
+)
+
+// A RuleConfig is a section of rules.conf: messages matching all of its
+// conditions get its actions applied.
+type RuleConfig struct {
+	Name string
+
+	// Where the rule applies; nil means everywhere
+	Account *regexp.Regexp
+	Folder  *regexp.Regexp
+
+	Conditions  []RuleCondition
+	LargerThan  uint32
+	SmallerThan uint32
+
+	Move     string
+	Copy     string
+	SetFlags []models.Flag
+	Read     *bool
+	Tags     []string
+	Pipe     []string
+	Stop     bool
+}
+
+// A RuleCondition matches a field of a message against a pattern
+type RuleCondition struct {
+	// from, to, cc, subject or the name of a header
+	Field   string
+	Pattern *regexp.Regexp
+}
+
+var ruleFlags = map[string]models.Flag{
+	"answered": models.AnsweredFlag,
+	"flagged":  models.FlaggedFlag,
+	"seen":     models.SeenFlag,
+}
+
+// Values prefixed with ~ are regular expressions. Other values match
+// case-insensitively anywhere in the field, or exactly when exact is set.
+func parsePattern(value string, exact bool) (*regexp.Regexp, error) {
+	if strings.HasPrefix(value, "~") {
+		return regexp.Compile(value[1:])
+	}
+	if exact {
+		return regexp.Compile("^" + regexp.QuoteMeta(value) + "$")
+	}
+	return regexp.Compile("(?i)" + regexp.QuoteMeta(value))
+}
+
+// Parses a size like 512, 100K or 2M
+func parseSize(value string) (uint32, error) {
+	mult := uint64(1)
+	switch {
+	case strings.HasSuffix(value, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(value, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(value, "G"):
+		mult = 1 << 30
+	}
+	if mult != 1 {
+		value = value[:len(value)-1]
+	}
+	n, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || n*mult > 1<<32-1 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return uint32(n * mult), nil
+}
+
+func splitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
+
+func parseRule(section *ini.Section) (RuleConfig, error) {
+	rule := RuleConfig{Name: section.Name()}
+	for _, key := range section.Keys() {
+		name, value := key.Name(), key.Value()
+		var err error
+		switch {
+		case name == "account":
+			rule.Account, err = parsePattern(value, true)
+		case name == "folder":
+			rule.Folder, err = parsePattern(value, true)
+		case name == "from" || name == "to" || name == "cc" ||
+			name == "subject" || name == "list-id" ||
+			strings.HasPrefix(name, "header."):
+
+			field := strings.TrimPrefix(name, "header.")
+			if field == "" {
+				return rule, fmt.Errorf("[%s]: header name missing in %s",
+					rule.Name, name)
+			}
+			var pattern *regexp.Regexp
+			pattern, err = parsePattern(value, false)
+			rule.Conditions = append(rule.Conditions, RuleCondition{
+				Field:   field,
+				Pattern: pattern,
+			})
+		case name == "larger-than":
+			rule.LargerThan, err = parseSize(value)
+		case name == "smaller-than":
+			rule.SmallerThan, err = parseSize(value)
+		case name == "move":
+			rule.Move = value
+		case name == "copy":
+			rule.Copy = value
+		case name == "flag":
+			for _, flagName := range splitList(value) {
+				flag, ok := ruleFlags[flagName]
+				if !ok {
+					return rule, fmt.Errorf("[%s]: unknown flag %q",
+						rule.Name, flagName)
+				}
+				rule.SetFlags = append(rule.SetFlags, flag)
+			}
+		case name == "read":
+			var read bool
+			read, err = key.Bool()
+			rule.Read = &read
+		case name == "tag":
+			rule.Tags = splitList(value)
+		case name == "pipe":
+			rule.Pipe, err = shlex.Split(value)
+			if err == nil && len(rule.Pipe) == 0 {
+				err = fmt.Errorf("empty pipe command")
+			}
+		case name == "stop":
+			rule.Stop, err = key.Bool()
+		default:
+			return rule, fmt.Errorf("[%s]: unknown option %s", rule.Name, name)
+		}
+		if err != nil {
+			return rule, fmt.Errorf("[%s]: %s: %v", rule.Name, name, err)
+		}
+	}
+	if rule.Move == "" && rule.Copy == "" && len(rule.SetFlags) == 0 &&
+		rule.Read == nil && len(rule.Tags) == 0 && rule.Pipe == nil &&
+		!rule.Stop {
+
+		return rule, fmt.Errorf("[%s]: no action", rule.Name)
+	}
+	return rule, nil
+}
+
+// LoadRules parses the rules of rules.conf, in the order of the file
+func LoadRules(file *ini.File) ([]RuleConfig, error) {
+	var rules []RuleConfig
+	for _, section := range file.Sections() {
+		if section.Name() == ini.DEFAULT_SECTION {
+			if len(section.Keys()) != 0 {
+				return nil, fmt.Errorf("Options outside of a rule")
+			}
+			continue
+		}
+		rule, err := parseRule(section)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// AppliesTo reports whether the rule is used in the given folder
+func (rule *RuleConfig) AppliesTo(account string, folder string) bool {
+	if rule.Account != nil && !rule.Account.MatchString(account) {
+		return false
+	}
+	return rule.Folder == nil || rule.Folder.MatchString(folder)
+}
+
+// Matches reports whether the message meets all the conditions of the rule
+func (rule *RuleConfig) Matches(msg *models.MessageInfo) bool {
+	if msg.Envelope == nil {
+		return false
+	}
+	if rule.LargerThan != 0 && msg.Size <= rule.LargerThan {
+		return false
+	}
+	if rule.SmallerThan != 0 && msg.Size >= rule.SmallerThan {
+		return false
+	}
+	for _, cond := range rule.Conditions {
+		if !cond.Pattern.MatchString(messageField(msg, cond.Field)) {
+			return false
+		}
+	}
+	return true
+}
+
+func messageField(msg *models.MessageInfo, field string) string {
+	switch field {
+	case "from":
+		return models.FormatAddresses(msg.Envelope.From)
+	case "to":
+		return models.FormatAddresses(msg.Envelope.To)
+	case "cc":
+		return models.FormatAddresses(msg.Envelope.Cc)
+	case "subject":
+		return msg.Envelope.Subject
+	}
+	if msg.RFC822Headers == nil {
+		return ""
+	}
+	return msg.RFC822Headers.Get(field)
+}
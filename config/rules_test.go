@@ -0,0 +1,72 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/emersion/go-message/mail"
+	"github.com/go-ini/ini"
+	"github.com/stretchr/testify/assert"
+
+	"git.sr.ht/~sircmpwn/aerc/models"
+)
+
+func TestRules(t *testing.T) {
+	assert := assert.New(t)
+
+	file, err := ini.LoadSources(ini.LoadOptions{IgnoreInlineComment: true},
+		[]byte(`
+[lists]
+folder = INBOX
+list-id = ~<[^>]*\.lists\.example\.org>
+move = Lists
+
+[big]
+larger-than = 1M
+flag = flagged, answered
+read = true
+stop = true
+`))
+	assert.Nil(err)
+	rules, err := LoadRules(file)
+	assert.Nil(err)
+	assert.Len(rules, 2)
+
+	lists, big := &rules[0], &rules[1]
+	assert.True(lists.AppliesTo("work", "INBOX"))
+	assert.False(lists.AppliesTo("work", "INBOX/old"))
+	assert.True(big.AppliesTo("work", "Archive"))
+	assert.Equal(uint32(1<<20), big.LargerThan)
+	assert.Equal([]models.Flag{models.FlaggedFlag, models.AnsweredFlag},
+		big.SetFlags)
+
+	header := &mail.Header{}
+	header.Set("List-Id", "Dev <dev.lists.example.org>")
+	msg := &models.MessageInfo{
+		Envelope:      &models.Envelope{Subject: "Hello"},
+		RFC822Headers: header,
+		Size:          2 << 20,
+	}
+	assert.True(lists.Matches(msg))
+	assert.True(big.Matches(msg))
+	msg.RFC822Headers = &mail.Header{}
+	msg.Size = 100
+	assert.False(lists.Matches(msg))
+	assert.False(big.Matches(msg))
+}
+
+func TestRuleErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, source := range []string{
+		"[a]\nfrom = ann\n",
+		"[a]\nbogus = 1\nmove = x\n",
+		"[a]\nflag = deleted\n",
+		"[a]\nlarger-than = 2X\nmove = x\n",
+		"move = x\n",
+	} {
+		file, err := ini.Load([]byte(source))
+		assert.Nil(err)
+		_, err = LoadRules(file)
+		assert.NotNil(err, source)
+	}
+}
@@ -0,0 +1,321 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/danwakefield/fnmatch"
+	"github.com/gdamore/tcell"
+	"github.com/go-ini/ini"
+	"github.com/mitchellh/go-homedir"
+)
+
+type StyleObject int32
+
+const (
+	STYLE_DEFAULT StyleObject = iota
+	STYLE_ERROR
+	STYLE_WARNING
+	STYLE_SUCCESS
+
+	STYLE_TITLE
+	STYLE_HEADER
+
+	STYLE_STATUSLINE_DEFAULT
+	STYLE_STATUSLINE_ERROR
+	STYLE_STATUSLINE_SUCCESS
+
+	STYLE_MSGLIST_DEFAULT
+	STYLE_MSGLIST_UNREAD
+	STYLE_MSGLIST_READ
+	STYLE_MSGLIST_FLAGGED
+	STYLE_MSGLIST_DELETED
+	STYLE_MSGLIST_SELECTED
+
+	STYLE_DIRLIST_DEFAULT
+	STYLE_DIRLIST_SELECTED
+	STYLE_DIRLIST_SELECTING
+
+	STYLE_PART_SWITCHER
+	STYLE_PART_SWITCHER_SELECTED
+
+	STYLE_TAB
+	STYLE_TAB_SELECTED
+	STYLE_BORDER
+)
+
+var StyleNames = map[string]StyleObject{
+	"default": STYLE_DEFAULT,
+	"error":   STYLE_ERROR,
+	"warning": STYLE_WARNING,
+	"success": STYLE_SUCCESS,
+
+	"title":  STYLE_TITLE,
+	"header": STYLE_HEADER,
+
+	"statusline_default": STYLE_STATUSLINE_DEFAULT,
+	"statusline_error":   STYLE_STATUSLINE_ERROR,
+	"statusline_success": STYLE_STATUSLINE_SUCCESS,
+
+	"msglist_default":  STYLE_MSGLIST_DEFAULT,
+	"msglist_unread":   STYLE_MSGLIST_UNREAD,
+	"msglist_read":     STYLE_MSGLIST_READ,
+	"msglist_flagged":  STYLE_MSGLIST_FLAGGED,
+	"msglist_deleted":  STYLE_MSGLIST_DELETED,
+	"msglist_selected": STYLE_MSGLIST_SELECTED,
+
+	"dirlist_default":   STYLE_DIRLIST_DEFAULT,
+	"dirlist_selected":  STYLE_DIRLIST_SELECTED,
+	"dirlist_selecting": STYLE_DIRLIST_SELECTING,
+
+	"part_switcher":          STYLE_PART_SWITCHER,
+	"part_switcher_selected": STYLE_PART_SWITCHER_SELECTED,
+
+	"tab":          STYLE_TAB,
+	"tab_selected": STYLE_TAB_SELECTED,
+	"border":       STYLE_BORDER,
+}
+
+// Attributes of a Style which have been explicitly set, and therefore
+// override the attributes of the styles it is composed onto
+const (
+	STYLE_SET_FG = 1 << iota
+	STYLE_SET_BG
+	STYLE_SET_BOLD
+	STYLE_SET_BLINK
+	STYLE_SET_UNDERLINE
+	STYLE_SET_REVERSE
+)
+
+type Style struct {
+	Fg        tcell.Color
+	Bg        tcell.Color
+	Bold      bool
+	Blink     bool
+	Underline bool
+	Reverse   bool
+
+	set int
+}
+
+func (s Style) Get() tcell.Style {
+	style := tcell.StyleDefault.
+		Bold(s.Bold).
+		Blink(s.Blink).
+		Underline(s.Underline).
+		Reverse(s.Reverse)
+	if s.set&STYLE_SET_FG != 0 {
+		style = style.Foreground(s.Fg)
+	}
+	if s.set&STYLE_SET_BG != 0 {
+		style = style.Background(s.Bg)
+	}
+	return style
+}
+
+// Compose returns a copy of s with the attributes which have been set in
+// overlay replaced by those of overlay.
+func (s Style) Compose(overlay Style) Style {
+	if overlay.set&STYLE_SET_FG != 0 {
+		s.Fg = overlay.Fg
+	}
+	if overlay.set&STYLE_SET_BG != 0 {
+		s.Bg = overlay.Bg
+	}
+	if overlay.set&STYLE_SET_BOLD != 0 {
+		s.Bold = overlay.Bold
+	}
+	if overlay.set&STYLE_SET_BLINK != 0 {
+		s.Blink = overlay.Blink
+	}
+	if overlay.set&STYLE_SET_UNDERLINE != 0 {
+		s.Underline = overlay.Underline
+	}
+	if overlay.set&STYLE_SET_REVERSE != 0 {
+		s.Reverse = overlay.Reverse
+	}
+	s.set |= overlay.set
+	return s
+}
+
+func (s *Style) setAttr(attr string, value string) error {
+	switch attr {
+	case "fg", "bg":
+		color := tcell.ColorDefault
+		if n, err := strconv.Atoi(value); err == nil {
+			// A color from the terminal's 256-color palette
+			color = tcell.Color(n)
+		} else if value != "default" {
+			color = tcell.GetColor(value)
+			if color == tcell.ColorDefault {
+				return fmt.Errorf("Unknown color %q", value)
+			}
+		}
+		if attr == "fg" {
+			s.Fg = color
+			s.set |= STYLE_SET_FG
+		} else {
+			s.Bg = color
+			s.set |= STYLE_SET_BG
+		}
+		return nil
+	}
+	on, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("Invalid value %q for %s", value, attr)
+	}
+	switch attr {
+	case "bold":
+		s.Bold = on
+		s.set |= STYLE_SET_BOLD
+	case "blink":
+		s.Blink = on
+		s.set |= STYLE_SET_BLINK
+	case "underline":
+		s.Underline = on
+		s.set |= STYLE_SET_UNDERLINE
+	case "reverse":
+		s.Reverse = on
+		s.set |= STYLE_SET_REVERSE
+	default:
+		return fmt.Errorf("Unknown style attribute %q", attr)
+	}
+	return nil
+}
+
+type StyleSet struct {
+	objects map[StyleObject]Style
+}
+
+// NewStyleSet returns the built-in styleset, which matches the "default"
+// styleset shipped with aerc.
+func NewStyleSet() StyleSet {
+	ss := StyleSet{objects: make(map[StyleObject]Style)}
+	defaults := []struct {
+		object StyleObject
+		attr   string
+		value  string
+	}{
+		{STYLE_ERROR, "fg", "red"},
+		{STYLE_WARNING, "fg", "yellow"},
+		{STYLE_SUCCESS, "fg", "green"},
+		{STYLE_TITLE, "reverse", "true"},
+		{STYLE_HEADER, "bold", "true"},
+		{STYLE_STATUSLINE_DEFAULT, "reverse", "true"},
+		{STYLE_STATUSLINE_ERROR, "fg", "red"},
+		{STYLE_STATUSLINE_ERROR, "reverse", "true"},
+		{STYLE_STATUSLINE_SUCCESS, "fg", "green"},
+		{STYLE_STATUSLINE_SUCCESS, "reverse", "true"},
+		{STYLE_MSGLIST_UNREAD, "bold", "true"},
+		{STYLE_MSGLIST_DELETED, "fg", "gray"},
+		{STYLE_MSGLIST_SELECTED, "reverse", "true"},
+		{STYLE_DIRLIST_SELECTED, "reverse", "true"},
+		{STYLE_DIRLIST_SELECTING, "fg", "gray"},
+		{STYLE_DIRLIST_SELECTING, "reverse", "true"},
+		{STYLE_PART_SWITCHER_SELECTED, "reverse", "true"},
+		{STYLE_TAB, "reverse", "true"},
+		{STYLE_TAB_SELECTED, "reverse", "false"},
+		{STYLE_BORDER, "reverse", "true"},
+	}
+	for _, d := range defaults {
+		s := ss.objects[d.object]
+		s.setAttr(d.attr, d.value)
+		ss.objects[d.object] = s
+	}
+	return ss
+}
+
+// Get returns the style of the given object, composed onto the default
+// style.
+func (ss StyleSet) Get(so StyleObject) tcell.Style {
+	return ss.Compose(so, nil)
+}
+
+// Compose returns the style of the given object, with each of the overlay
+// objects applied on top of it in order.
+func (ss StyleSet) Compose(so StyleObject, overlays []StyleObject) tcell.Style {
+	style := ss.objects[STYLE_DEFAULT].Compose(ss.objects[so])
+	for _, overlay := range overlays {
+		style = style.Compose(ss.objects[overlay])
+	}
+	return style.Get()
+}
+
+// parse reads a styleset file. Each key has the form <object>.<attribute>,
+// where the object may contain wildcards, e.g. msglist_*.bg = black
+func (ss StyleSet) parse(file *ini.File) error {
+	for _, key := range file.Section("").Keys() {
+		dot := strings.LastIndex(key.Name(), ".")
+		if dot < 0 {
+			return fmt.Errorf("Invalid styleset key %q", key.Name())
+		}
+		pattern, attr := key.Name()[:dot], key.Name()[dot+1:]
+		matched := false
+		for name, so := range StyleNames {
+			if !fnmatch.Match(pattern, name, 0) {
+				continue
+			}
+			matched = true
+			s := ss.objects[so]
+			if err := s.setAttr(attr, key.Value()); err != nil {
+				return fmt.Errorf("%s: %v", key.Name(), err)
+			}
+			ss.objects[so] = s
+		}
+		if !matched {
+			return fmt.Errorf("Unknown style object %q", pattern)
+		}
+	}
+	return nil
+}
+
+func findStyleSet(name string, dirs []string) (string, error) {
+	for _, dir := range dirs {
+		dir, err := homedir.Expand(dir)
+		if err != nil {
+			continue
+		}
+		file := path.Join(dir, name)
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			continue
+		}
+		return file, nil
+	}
+	return "", fmt.Errorf(
+		"Can't find styleset %q in any of %v", name, dirs)
+}
+
+// LoadStyleSet loads the named styleset from the first of dirs which
+// contains it. The default styleset falls back to the built-in styles if it
+// is not installed.
+func LoadStyleSet(name string, dirs []string) (StyleSet, error) {
+	ss := NewStyleSet()
+	if name == "" {
+		return ss, errors.New("No styleset name given")
+	}
+	file, err := findStyleSet(name, dirs)
+	if err != nil {
+		if name == "default" {
+			return ss, nil
+		}
+		return ss, err
+	}
+	// Styles in the file are applied on a blank slate, so that stylesets
+	// need not undo the built-in styles
+	ss = StyleSet{objects: make(map[StyleObject]Style)}
+	// Inline comments would swallow hex colors like #ff0000
+	f, err := ini.LoadSources(ini.LoadOptions{
+		IgnoreInlineComment: true,
+	}, file)
+	if err != nil {
+		return ss, err
+	}
+	if err := ss.parse(f); err != nil {
+		return ss, fmt.Errorf("%s: %v", file, err)
+	}
+	return ss, nil
+}
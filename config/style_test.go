@@ -0,0 +1,62 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/gdamore/tcell"
+	"github.com/go-ini/ini"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultStyleSet(t *testing.T) {
+	assert := assert.New(t)
+
+	installed, err := LoadStyleSet("default", []string{"../stylesets"})
+	assert.Nil(err)
+	builtin := NewStyleSet()
+	for name, so := range StyleNames {
+		assert.Equal(builtin.Get(so), installed.Get(so), name)
+	}
+}
+
+func TestStyleSetParse(t *testing.T) {
+	assert := assert.New(t)
+
+	file, err := ini.LoadSources(ini.LoadOptions{
+		IgnoreInlineComment: true,
+	}, []byte(`
+msglist_*.bg = black
+msglist_unread.bold = true
+msglist_selected.reverse = true
+tab.fg = #ff0000
+border.fg = 4
+`))
+	assert.Nil(err)
+	ss := StyleSet{objects: make(map[StyleObject]Style)}
+	assert.Nil(ss.parse(file))
+
+	assert.Equal(tcell.StyleDefault.Background(tcell.ColorBlack),
+		ss.Get(STYLE_MSGLIST_DEFAULT))
+	assert.Equal(tcell.StyleDefault.Background(tcell.ColorBlack).
+		Bold(true).Reverse(true),
+		ss.Compose(STYLE_MSGLIST_UNREAD,
+			[]StyleObject{STYLE_MSGLIST_SELECTED}))
+	assert.Equal(tcell.StyleDefault.Foreground(tcell.NewHexColor(0xff0000)),
+		ss.Get(STYLE_TAB))
+	assert.Equal(tcell.StyleDefault.Foreground(tcell.Color(4)),
+		ss.Get(STYLE_BORDER))
+	assert.Equal(tcell.StyleDefault, ss.Get(STYLE_DIRLIST_DEFAULT))
+
+	for _, bad := range []string{
+		"nonexistent.fg = red",
+		"tab.fg = notacolor",
+		"tab.italic = true",
+		"tab.bold = maybe",
+		"tab = red",
+	} {
+		file, err := ini.Load([]byte(bad))
+		assert.Nil(err)
+		ss := StyleSet{objects: make(map[StyleObject]Style)}
+		assert.NotNil(ss.parse(file), bad)
+	}
+}
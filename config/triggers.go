@@ -33,7 +33,10 @@ func (trig *TriggersConfig) ExecTrigger(triggerCmd string,
 }
 
 func (trig *TriggersConfig) ExecNewEmail(account *AccountConfig,
-	conf *AercConfig, msg *models.MessageInfo) {
+	conf *AercConfig, msg *models.MessageInfo) error {
+	if trig.NewEmail == "" {
+		return nil
+	}
 	err := trig.ExecTrigger(trig.NewEmail,
 		func(part string) (string, error) {
 			formatstr, args, err := format.ParseMessageFormat(part,
@@ -44,6 +47,7 @@ func (trig *TriggersConfig) ExecNewEmail(account *AccountConfig,
 			return fmt.Sprintf(formatstr, args...), nil
 		})
 	if err != nil {
-		fmt.Printf("Error from the new-email trigger: %s\n", err)
+		return fmt.Errorf("new-email trigger: %v", err)
 	}
+	return nil
 }
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/go-ini/ini"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetUiConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	file, err := ini.Load([]byte(`
+[ui:account=work]
+index-format=work
+sidebar-width=10
+
+[ui:folder=~Lists/.*]
+index-format=lists
+
+[ui:folder=INBOX]
+timestamp-format=inbox
+`))
+	assert.Nil(err)
+	file.NameMapper = mapName
+	config := &AercConfig{
+		Ui: UIConfig{
+			IndexFormat:     "default",
+			TimestampFormat: "default",
+			SidebarWidth:    20,
+			StyleSetName:    "default",
+		},
+	}
+	assert.Nil(config.LoadConfig(file))
+	assert.Len(config.ContextualUis, 3)
+
+	ui := config.GetUiConfig(map[ContextType]string{
+		UI_CONTEXT_ACCOUNT: "personal",
+		UI_CONTEXT_FOLDER:  "INBOX",
+	})
+	assert.Equal("default", ui.IndexFormat)
+	assert.Equal("inbox", ui.TimestampFormat)
+	assert.Equal(20, ui.SidebarWidth)
+
+	ui = config.GetUiConfig(map[ContextType]string{
+		UI_CONTEXT_ACCOUNT: "work",
+		UI_CONTEXT_FOLDER:  "INBOX.old",
+	})
+	assert.Equal("work", ui.IndexFormat)
+	assert.Equal("default", ui.TimestampFormat)
+	assert.Equal(10, ui.SidebarWidth)
+
+	// Folder contexts take precedence over account contexts
+	ui = config.GetUiConfig(map[ContextType]string{
+		UI_CONTEXT_ACCOUNT: "work",
+		UI_CONTEXT_FOLDER:  "Lists/golang",
+	})
+	assert.Equal("lists", ui.IndexFormat)
+	assert.Equal(10, ui.SidebarWidth)
+
+	// The global configuration is left alone
+	assert.Equal("default", config.Ui.IndexFormat)
+
+	for _, bad := range []string{
+		"[ui:account]",
+		"[ui:tab=foo]",
+		"[ui:folder=~(]",
+		"[ui:folder=INBOX]\nstyleset-name=nonexistent",
+	} {
+		file, err := ini.Load([]byte(bad))
+		assert.Nil(err)
+		file.NameMapper = mapName
+		config := &AercConfig{Ui: UIConfig{StyleSetName: "default"}}
+		assert.NotNil(config.LoadConfig(file), bad)
+	}
+}
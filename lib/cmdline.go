@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"strings"
+
+	"github.com/google/shlex"
+)
+
+// The commands whose arguments are free-form text, e.g. a shell command or a
+// search. Like :! in vi, they take the rest of the line, semicolons included,
+// so that their semicolons mean what they did before commands could be
+// chained.
+var lineCommands = map[string]bool{
+	"exec":     true,
+	"pipe":     true,
+	"term":     true,
+	"terminal": true,
+	"search":   true,
+	"filter":   true,
+}
+
+// SplitCommands splits a line of commands separated by semicolons into the
+// arguments of each command. Semicolons in quotes or escaped with a backslash
+// do not separate commands, nor do those in the arguments of exec, pipe, term,
+// search and filter. A leading colon is stripped from each command.
+func SplitCommands(line string) ([][]string, error) {
+	var cmds [][]string
+	for line != "" {
+		part, rest := nextCommand(line)
+		args, err := splitCommand(part)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) != 0 && lineCommands[args[0]] && rest != "" {
+			if args, err = splitCommand(line); err != nil {
+				return nil, err
+			}
+			rest = ""
+		}
+		if len(args) != 0 {
+			cmds = append(cmds, args)
+		}
+		line = rest
+	}
+	return cmds, nil
+}
+
+// Returns the first command of a line, and the rest of the line after the
+// semicolon ending it
+func nextCommand(line string) (string, string) {
+	var (
+		quote   rune
+		escaped bool
+	)
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == ';':
+			return line[:i], line[i+1:]
+		}
+	}
+	return line, ""
+}
+
+func splitCommand(part string) ([]string, error) {
+	part = strings.TrimSpace(part)
+	return shlex.Split(strings.TrimPrefix(part, ":"))
+}
@@ -0,0 +1,122 @@
+// Package flowed implements the text/plain format=flowed encoding described
+// in RFC 3676.
+package flowed
+
+import (
+	"strings"
+)
+
+const sigSeparator = "-- "
+
+// quoteDepth splits a flowed line into its quote depth and content, removing
+// space-stuffing.
+func quoteDepth(line string) (int, string) {
+	depth := 0
+	for depth < len(line) && line[depth] == '>' {
+		depth++
+	}
+	line = line[depth:]
+	if strings.HasPrefix(line, " ") {
+		line = line[1:]
+	}
+	return depth, line
+}
+
+// Unflow joins the soft line breaks of format=flowed text, so that each
+// paragraph ends up on a single line. If delsp is set, the space preceding
+// each soft line break is removed as well. Quoted paragraphs are prefixed
+// with "> " for each level of quoting.
+func Unflow(text string, delsp bool) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	var (
+		out       strings.Builder
+		paragraph strings.Builder
+		depth     = -1
+	)
+	flush := func() {
+		if depth < 0 {
+			return
+		}
+		if depth > 0 {
+			out.WriteString(strings.Repeat(">", depth))
+			if paragraph.Len() > 0 {
+				out.WriteRune(' ')
+			}
+		}
+		out.WriteString(paragraph.String())
+		out.WriteRune('\n')
+		paragraph.Reset()
+		depth = -1
+	}
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	for _, line := range lines {
+		d, content := quoteDepth(line)
+		if depth >= 0 && d != depth {
+			// A change in quote depth ends the paragraph, even after a
+			// soft line break
+			flush()
+		}
+		depth = d
+		if content == sigSeparator ||
+			!strings.HasSuffix(content, " ") {
+
+			paragraph.WriteString(content)
+			flush()
+			continue
+		}
+		if delsp {
+			content = strings.TrimSuffix(content, " ")
+		}
+		paragraph.WriteString(content)
+	}
+	flush()
+	return out.String()
+}
+
+// Flow encodes text as format=flowed, wrapping lines longer than width with
+// soft line breaks. Quoted lines are left unwrapped.
+func Flow(text string, width int) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	var out strings.Builder
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	for _, line := range lines {
+		if line != sigSeparator {
+			line = strings.TrimRight(line, " ")
+		}
+		if strings.HasPrefix(line, ">") {
+			out.WriteString(line)
+			out.WriteRune('\n')
+			continue
+		}
+		for _, l := range wrap(line, width) {
+			if strings.HasPrefix(l, " ") || strings.HasPrefix(l, "From ") {
+				// Space-stuffing
+				out.WriteRune(' ')
+			}
+			out.WriteString(l)
+			out.WriteRune('\n')
+		}
+	}
+	return out.String()
+}
+
+// wrap breaks line into lines of at most width characters where possible,
+// leaving a trailing space on every line but the last to mark a soft break.
+func wrap(line string, width int) []string {
+	var lines []string
+	for len(line) > width {
+		i := strings.LastIndex(line[:width], " ")
+		if i <= 0 {
+			// No space to break on within width, break on the first
+			// space after it instead
+			i = strings.Index(line[width:], " ")
+			if i < 0 {
+				break
+			}
+			i += width
+		}
+		lines = append(lines, line[:i+1])
+		line = line[i+1:]
+	}
+	return append(lines, line)
+}
@@ -0,0 +1,57 @@
+package flowed
+
+import (
+	"testing"
+)
+
+func TestUnflow(t *testing.T) {
+	type tc struct {
+		text     string
+		delsp    bool
+		expected string
+	}
+	cases := []*tc{
+		&tc{"hello\n", false, "hello\n"},
+		&tc{"hello \nworld\n", false, "hello world\n"},
+		&tc{"hello \nworld\n", true, "helloworld\n"},
+		&tc{"first \nparagraph\n\nsecond\n", false,
+			"first paragraph\n\nsecond\n"},
+		&tc{"> quoted \n> text\nreply\n", false, "> quoted text\nreply\n"},
+		&tc{">> deeply \n> quoted\n", false, ">> deeply \n> quoted\n"},
+		&tc{" From the top\n", false, "From the top\n"},
+		&tc{"text\n-- \nsig\n", false, "text\n-- \nsig\n"},
+		&tc{"crlf \r\nline\r\n", false, "crlf line\n"},
+	}
+	for _, c := range cases {
+		result := Unflow(c.text, c.delsp)
+		if result != c.expected {
+			t.Errorf("Unflow(%q, %v): expected %q but got %q",
+				c.text, c.delsp, c.expected, result)
+		}
+	}
+}
+
+func TestFlow(t *testing.T) {
+	type tc struct {
+		text     string
+		width    int
+		expected string
+	}
+	cases := []*tc{
+		&tc{"short\n", 10, "short\n"},
+		&tc{"this line is too long\n", 10, "this line \nis too \nlong\n"},
+		&tc{"trailing   \n", 10, "trailing\n"},
+		&tc{"> quoted text is left alone\n", 10,
+			"> quoted text is left alone\n"},
+		&tc{"From here\n", 72, " From here\n"},
+		&tc{"text\n-- \nsig\n", 72, "text\n-- \nsig\n"},
+		&tc{"unbreakable words\n", 5, "unbreakable \nwords\n"},
+	}
+	for _, c := range cases {
+		result := Flow(c.text, c.width)
+		if result != c.expected {
+			t.Errorf("Flow(%q, %d): expected %q but got %q",
+				c.text, c.width, c.expected, result)
+		}
+	}
+}
@@ -154,7 +154,9 @@ func (store *MessageStore) Update(msg types.WorkerMessage) {
 	switch msg := msg.(type) {
 	case *types.DirectoryInfo:
 		store.DirInfo = *msg.Info
-		store.worker.PostAction(&types.FetchDirectoryContents{}, nil)
+		if !msg.SkipContents {
+			store.worker.PostAction(&types.FetchDirectoryContents{}, nil)
+		}
 		update = true
 	case *types.DirectoryContents:
 		newMap := make(map[uint32]*models.MessageInfo)
@@ -169,6 +171,16 @@ func (store *MessageStore) Update(msg types.WorkerMessage) {
 		store.Messages = newMap
 		store.uids = msg.Uids
 		update = true
+	case *types.MessagesAdded:
+		for _, uid := range msg.Uids {
+			if _, ok := store.Messages[uid]; ok {
+				continue
+			}
+			store.Messages[uid] = nil
+			store.uids = append(store.uids, uid)
+			directoryChange = true
+		}
+		update = true
 	case *types.MessageInfo:
 		if existing, ok := store.Messages[msg.Info.Uid]; ok && existing != nil {
 			merge(existing, msg.Info)
@@ -215,12 +227,12 @@ func (store *MessageStore) Update(msg types.WorkerMessage) {
 				delete(store.Deleted, uid)
 			}
 		}
-		uids := make([]uint32, len(store.uids)-len(msg.Uids))
-		j := 0
+		// Some of the UIDs may be unknown, e.g. if the backend noticed
+		// the deletion twice
+		uids := make([]uint32, 0, len(store.uids))
 		for _, uid := range store.uids {
-			if _, deleted := toDelete[uid]; !deleted && j < len(uids) {
-				uids[j] = uid
-				j += 1
+			if _, deleted := toDelete[uid]; !deleted {
+				uids = append(uids, uid)
 			}
 		}
 		store.uids = uids
@@ -311,6 +323,25 @@ func (store *MessageStore) Read(uids []uint32, read bool,
 	}, cb)
 }
 
+func (store *MessageStore) Flag(uids []uint32, flag models.Flag, enable bool,
+	cb func(msg types.WorkerMessage)) {
+
+	store.worker.PostAction(&types.FlagMessages{
+		Flag:   flag,
+		Enable: enable,
+		Uids:   uids,
+	}, cb)
+}
+
+func (store *MessageStore) Tag(uids []uint32, tags []string,
+	cb func(msg types.WorkerMessage)) {
+
+	store.worker.PostAction(&types.TagMessages{
+		Tags: tags,
+		Uids: uids,
+	}, cb)
+}
+
 func (store *MessageStore) Uids() []uint32 {
 	if store.filter {
 		return store.results
@@ -0,0 +1,449 @@
+package notify
+
+// Just enough of the D-Bus wire protocol to call methods with simple
+// arguments on the session bus.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dbusMethodCall   = 1
+	dbusMethodReturn = 2
+	dbusError        = 3
+
+	dbusFieldPath        = 1
+	dbusFieldInterface   = 2
+	dbusFieldMember      = 3
+	dbusFieldErrorName   = 4
+	dbusFieldReplySerial = 5
+	dbusFieldDestination = 6
+	dbusFieldSignature   = 8
+)
+
+// How long connecting to the bus, or a call, may take
+const dbusTimeout = 5 * time.Second
+
+type dbusConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	serial uint32
+	mutex  sync.Mutex
+}
+
+// dbusVariant is a string variant, as used in notification hints
+type dbusVariant string
+
+// dbusDict is an a{sv} dictionary of string variants
+type dbusDict map[string]dbusVariant
+
+// Connects to the bus in DBUS_SESSION_BUS_ADDRESS
+func dialSessionBus() (*dbusConn, error) {
+	addrs := os.Getenv("DBUS_SESSION_BUS_ADDRESS")
+	if addrs == "" {
+		return nil, errors.New("No D-Bus session bus")
+	}
+	var err error = errors.New("No usable D-Bus address")
+	for _, addr := range strings.Split(addrs, ";") {
+		var conn net.Conn
+		conn, err = dialAddress(addr)
+		if err != nil {
+			continue
+		}
+		bus := &dbusConn{conn: conn, reader: bufio.NewReader(conn)}
+		conn.SetDeadline(time.Now().Add(dbusTimeout))
+		if err = bus.auth(); err != nil {
+			conn.Close()
+			continue
+		}
+		if _, err = bus.Call("org.freedesktop.DBus", "/org/freedesktop/DBus",
+			"org.freedesktop.DBus", "Hello", ""); err != nil {
+
+			conn.Close()
+			continue
+		}
+		return bus, nil
+	}
+	return nil, err
+}
+
+func dialAddress(addr string) (net.Conn, error) {
+	if !strings.HasPrefix(addr, "unix:") {
+		return nil, fmt.Errorf("Unsupported D-Bus address %s", addr)
+	}
+	for _, param := range strings.Split(addr[len("unix:"):], ",") {
+		eq := strings.IndexRune(param, '=')
+		if eq < 0 {
+			continue
+		}
+		switch param[:eq] {
+		case "path":
+			return net.DialTimeout("unix", param[eq+1:], dbusTimeout)
+		case "abstract":
+			return net.DialTimeout("unix", "@"+param[eq+1:], dbusTimeout)
+		}
+	}
+	return nil, fmt.Errorf("Unsupported D-Bus address %s", addr)
+}
+
+func (bus *dbusConn) auth() error {
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err := bus.conn.Write(
+		[]byte("\x00AUTH EXTERNAL " + uid + "\r\n")); err != nil {
+		return err
+	}
+	line, err := bus.reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return fmt.Errorf("D-Bus authentication failed: %s",
+			strings.TrimSpace(line))
+	}
+	_, err = bus.conn.Write([]byte("BEGIN\r\n"))
+	return err
+}
+
+func (bus *dbusConn) Close() error {
+	return bus.conn.Close()
+}
+
+// Call calls a method and waits up to dbusTimeout for its reply. The arguments
+// must match the signature; supported types are s, u, i, as and a{sv}. The
+// values of the reply are returned for the same types.
+func (bus *dbusConn) Call(dest, path, iface, member, signature string,
+	args ...interface{}) ([]interface{}, error) {
+
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.conn.SetDeadline(time.Now().Add(dbusTimeout))
+
+	bus.serial++
+	serial := bus.serial
+
+	body := &dbusEncoder{}
+	sig := signature
+	for _, arg := range args {
+		var err error
+		if sig, err = body.value(sig, arg); err != nil {
+			return nil, err
+		}
+	}
+	if sig != "" {
+		return nil, errors.New("Not enough arguments for signature")
+	}
+
+	msg := &dbusEncoder{}
+	msg.buf.Write([]byte{'l', dbusMethodCall, 0, 1})
+	msg.uint32(uint32(body.buf.Len()))
+	msg.uint32(serial)
+	fields := []struct {
+		code byte
+		sig  string
+		val  string
+	}{
+		{dbusFieldPath, "o", path},
+		{dbusFieldInterface, "s", iface},
+		{dbusFieldMember, "s", member},
+		{dbusFieldDestination, "s", dest},
+		{dbusFieldSignature, "g", signature},
+	}
+	msg.array(8, func() {
+		for _, field := range fields {
+			if field.val == "" {
+				continue
+			}
+			msg.align(8)
+			msg.buf.WriteByte(field.code)
+			msg.signature(field.sig)
+			if field.sig == "g" {
+				msg.signature(field.val)
+			} else {
+				msg.string(field.val)
+			}
+		}
+	})
+	msg.align(8)
+	msg.buf.Write(body.buf.Bytes())
+	if _, err := bus.conn.Write(msg.buf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	for {
+		reply, err := bus.readMessage()
+		if err != nil {
+			return nil, err
+		}
+		if reply.replySerial != serial {
+			// Signals and such
+			continue
+		}
+		switch reply.msgType {
+		case dbusMethodReturn:
+			return reply.body, nil
+		case dbusError:
+			if len(reply.body) > 0 {
+				if text, ok := reply.body[0].(string); ok {
+					return nil, fmt.Errorf("%s: %s", reply.errorName, text)
+				}
+			}
+			return nil, errors.New(reply.errorName)
+		}
+	}
+}
+
+type dbusMessage struct {
+	msgType     byte
+	replySerial uint32
+	errorName   string
+	body        []interface{}
+}
+
+func (bus *dbusConn) readMessage() (*dbusMessage, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(bus.reader, fixed); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if fixed[0] == 'B' {
+		order = binary.BigEndian
+	}
+	bodyLen := order.Uint32(fixed[4:8])
+	fieldsLen := order.Uint32(fixed[12:16])
+	headerLen := 16 + fieldsLen
+	if pad := headerLen % 8; pad != 0 {
+		headerLen += 8 - pad
+	}
+	if headerLen+bodyLen > 1<<27 {
+		return nil, errors.New("D-Bus message too long")
+	}
+	buf := make([]byte, headerLen+bodyLen)
+	copy(buf, fixed)
+	if _, err := io.ReadFull(bus.reader, buf[16:]); err != nil {
+		return nil, err
+	}
+
+	msg := &dbusMessage{msgType: fixed[1]}
+	dec := &dbusDecoder{buf: buf[:16+fieldsLen], pos: 16, order: order}
+	var signature string
+	for dec.pos < len(dec.buf) {
+		dec.align(8)
+		code, err := dec.byte()
+		if err != nil {
+			return nil, err
+		}
+		sig, err := dec.signature()
+		if err != nil {
+			return nil, err
+		}
+		val, _, err := dec.value(sig)
+		if err != nil {
+			return nil, err
+		}
+		switch code {
+		case dbusFieldReplySerial:
+			msg.replySerial, _ = val.(uint32)
+		case dbusFieldErrorName:
+			msg.errorName, _ = val.(string)
+		case dbusFieldSignature:
+			signature, _ = val.(string)
+		}
+	}
+
+	// Offsets in the body are aligned relative to the start of the message
+	dec = &dbusDecoder{buf: buf, pos: int(headerLen), order: order}
+	for signature != "" {
+		val, rest, err := dec.value(signature)
+		if err != nil {
+			// Types we don't know about; the header is what matters
+			break
+		}
+		msg.body = append(msg.body, val)
+		signature = rest
+	}
+	return msg, nil
+}
+
+type dbusEncoder struct {
+	buf bytes.Buffer
+}
+
+func (enc *dbusEncoder) align(n int) {
+	for enc.buf.Len()%n != 0 {
+		enc.buf.WriteByte(0)
+	}
+}
+
+func (enc *dbusEncoder) uint32(v uint32) {
+	enc.align(4)
+	binary.Write(&enc.buf, binary.LittleEndian, v)
+}
+
+func (enc *dbusEncoder) string(s string) {
+	enc.uint32(uint32(len(s)))
+	enc.buf.WriteString(s)
+	enc.buf.WriteByte(0)
+}
+
+func (enc *dbusEncoder) signature(s string) {
+	enc.buf.WriteByte(byte(len(s)))
+	enc.buf.WriteString(s)
+	enc.buf.WriteByte(0)
+}
+
+// Writes an array whose elements are aligned to elemAlign and written by fn
+func (enc *dbusEncoder) array(elemAlign int, fn func()) {
+	enc.uint32(0)
+	lenPos := enc.buf.Len() - 4
+	enc.align(elemAlign)
+	start := enc.buf.Len()
+	fn()
+	binary.LittleEndian.PutUint32(
+		enc.buf.Bytes()[lenPos:], uint32(enc.buf.Len()-start))
+}
+
+// Encodes a value for the first type of signature and returns the rest
+func (enc *dbusEncoder) value(signature string,
+	v interface{}) (string, error) {
+
+	switch {
+	case strings.HasPrefix(signature, "s"):
+		s, ok := v.(string)
+		if !ok {
+			return "", fmt.Errorf("Expected string, got %T", v)
+		}
+		enc.string(s)
+		return signature[1:], nil
+	case strings.HasPrefix(signature, "u"):
+		u, ok := v.(uint32)
+		if !ok {
+			return "", fmt.Errorf("Expected uint32, got %T", v)
+		}
+		enc.uint32(u)
+		return signature[1:], nil
+	case strings.HasPrefix(signature, "i"):
+		i, ok := v.(int32)
+		if !ok {
+			return "", fmt.Errorf("Expected int32, got %T", v)
+		}
+		enc.uint32(uint32(i))
+		return signature[1:], nil
+	case strings.HasPrefix(signature, "as"):
+		list, ok := v.([]string)
+		if !ok {
+			return "", fmt.Errorf("Expected []string, got %T", v)
+		}
+		enc.array(4, func() {
+			for _, s := range list {
+				enc.string(s)
+			}
+		})
+		return signature[2:], nil
+	case strings.HasPrefix(signature, "a{sv}"):
+		dict, ok := v.(dbusDict)
+		if !ok {
+			return "", fmt.Errorf("Expected dbusDict, got %T", v)
+		}
+		enc.array(8, func() {
+			for key, val := range dict {
+				enc.align(8)
+				enc.string(key)
+				enc.signature("s")
+				enc.string(string(val))
+			}
+		})
+		return signature[5:], nil
+	}
+	return "", fmt.Errorf("Unsupported D-Bus signature %s", signature)
+}
+
+type dbusDecoder struct {
+	buf   []byte
+	pos   int
+	order binary.ByteOrder
+}
+
+var errShortMessage = errors.New("Short D-Bus message")
+
+func (dec *dbusDecoder) align(n int) {
+	for dec.pos%n != 0 {
+		dec.pos++
+	}
+}
+
+func (dec *dbusDecoder) byte() (byte, error) {
+	if dec.pos >= len(dec.buf) {
+		return 0, errShortMessage
+	}
+	dec.pos++
+	return dec.buf[dec.pos-1], nil
+}
+
+func (dec *dbusDecoder) uint32() (uint32, error) {
+	dec.align(4)
+	if dec.pos+4 > len(dec.buf) {
+		return 0, errShortMessage
+	}
+	dec.pos += 4
+	return dec.order.Uint32(dec.buf[dec.pos-4:]), nil
+}
+
+func (dec *dbusDecoder) string() (string, error) {
+	n, err := dec.uint32()
+	if err != nil {
+		return "", err
+	}
+	if dec.pos+int(n)+1 > len(dec.buf) {
+		return "", errShortMessage
+	}
+	s := string(dec.buf[dec.pos : dec.pos+int(n)])
+	dec.pos += int(n) + 1
+	return s, nil
+}
+
+func (dec *dbusDecoder) signature() (string, error) {
+	n, err := dec.byte()
+	if err != nil {
+		return "", err
+	}
+	if dec.pos+int(n)+1 > len(dec.buf) {
+		return "", errShortMessage
+	}
+	s := string(dec.buf[dec.pos : dec.pos+int(n)])
+	dec.pos += int(n) + 1
+	return s, nil
+}
+
+// Decodes a value of the first type of signature and returns the rest
+func (dec *dbusDecoder) value(signature string) (interface{}, string, error) {
+	if signature == "" {
+		return nil, "", errShortMessage
+	}
+	switch signature[0] {
+	case 's', 'o':
+		s, err := dec.string()
+		return s, signature[1:], err
+	case 'g':
+		s, err := dec.signature()
+		return s, signature[1:], err
+	case 'u':
+		u, err := dec.uint32()
+		return u, signature[1:], err
+	case 'i':
+		u, err := dec.uint32()
+		return int32(u), signature[1:], err
+	}
+	return nil, "", fmt.Errorf("Unsupported D-Bus signature %s", signature)
+}
@@ -0,0 +1,132 @@
+package notify
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"git.sr.ht/~sircmpwn/aerc/config"
+)
+
+// Starts a session bus and returns a function stopping it
+func startBus(t *testing.T) func() {
+	path, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not found")
+	}
+	cmd := exec.Command(path, "--session", "--nofork", "--print-address")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot start dbus-daemon: %v", err)
+	}
+	stop := func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+	addr, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil {
+		stop()
+		t.Fatal(err)
+	}
+	old := os.Getenv("DBUS_SESSION_BUS_ADDRESS")
+	os.Setenv("DBUS_SESSION_BUS_ADDRESS", strings.TrimSpace(addr))
+	return func() {
+		os.Setenv("DBUS_SESSION_BUS_ADDRESS", old)
+		stop()
+	}
+}
+
+func TestDBusCall(t *testing.T) {
+	defer startBus(t)()
+	bus, err := dialSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus.Close()
+
+	reply, err := bus.Call("org.freedesktop.DBus", "/org/freedesktop/DBus",
+		"org.freedesktop.DBus", "GetNameOwner", "s", "org.freedesktop.DBus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply) != 1 || reply[0] != "org.freedesktop.DBus" {
+		t.Errorf("unexpected reply %v", reply)
+	}
+
+	// Nothing provides notifications on this bus, but the bus has to parse
+	// the call to tell us so
+	_, err = bus.Call("org.freedesktop.Notifications",
+		"/org/freedesktop/Notifications", "org.freedesktop.Notifications",
+		"Notify", "susssasa{sv}i",
+		"aerc", uint32(0), "", "summary", "body", []string{},
+		dbusDict{"category": "email.arrived"}, int32(-1))
+	if err == nil || !strings.HasPrefix(err.Error(),
+		"org.freedesktop.DBus.Error.ServiceUnknown") {
+
+		t.Errorf("expected ServiceUnknown, got %v", err)
+	}
+}
+
+func TestHungBus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aerc-dbus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bus")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// The bus accepts connections, but never answers
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+	old := os.Getenv("DBUS_SESSION_BUS_ADDRESS")
+	os.Setenv("DBUS_SESSION_BUS_ADDRESS", "unix:path="+path)
+	defer os.Setenv("DBUS_SESSION_BUS_ADDRESS", old)
+
+	n := NewNotifier(&config.NotificationsConfig{
+		Enabled:    true,
+		GroupDelay: time.Hour,
+	})
+	shown := make(chan error, 1)
+	go func() {
+		shown <- n.show(notification{summary: "hello"})
+	}()
+	conn := <-accepted
+	defer conn.Close()
+
+	// Messages are still added while the bus hangs
+	added := make(chan struct{})
+	go func() {
+		n.add("work", "INBOX", notification{summary: "new"})
+		close(added)
+	}()
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatal("adding a message waited for the bus")
+	}
+	select {
+	case err := <-shown:
+		if err == nil {
+			t.Error("no error from a hung bus")
+		}
+	case <-time.After(2 * dbusTimeout):
+		t.Fatal("the call to a hung bus did not time out")
+	}
+}
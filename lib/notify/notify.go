@@ -0,0 +1,198 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/shlex"
+
+	"git.sr.ht/~sircmpwn/aerc/config"
+	"git.sr.ht/~sircmpwn/aerc/lib/format"
+	"git.sr.ht/~sircmpwn/aerc/models"
+)
+
+// How many messages are listed in the notification for a group
+const maxGroupLines = 5
+
+type notification struct {
+	summary string
+	body    string
+}
+
+// A group collects the new messages of a folder until it is shown
+type group struct {
+	account  string
+	folder   string
+	messages []notification
+	timer    *time.Timer
+}
+
+// Notifier shows desktop notifications for new messages. Messages arriving in
+// the same folder within the group delay are shown in a single notification.
+type Notifier struct {
+	conf *config.NotificationsConfig
+	// Called with errors showing notifications, from any goroutine
+	OnError func(err error)
+
+	groups map[string]*group
+	mutex  sync.Mutex
+	// The bus is only used from the goroutines showing notifications, and
+	// has a mutex of its own, so that adding messages never waits for it
+	bus      *dbusConn
+	busMutex sync.Mutex
+}
+
+func NewNotifier(conf *config.NotificationsConfig) *Notifier {
+	return &Notifier{
+		conf:   conf,
+		groups: make(map[string]*group),
+	}
+}
+
+// NewMail queues a notification for a new message in the given folder
+func (n *Notifier) NewMail(account string, folder string,
+	timestampFormat string, msg *models.MessageInfo) {
+
+	if !n.conf.Enabled || msg == nil || msg.Envelope == nil {
+		return
+	}
+	summary, err := formatMessage(n.conf.SummaryFormat,
+		timestampFormat, account, msg)
+	if err == nil {
+		var body string
+		body, err = formatMessage(n.conf.BodyFormat,
+			timestampFormat, account, msg)
+		if err == nil {
+			n.add(account, folder, notification{summary, body})
+			return
+		}
+	}
+	n.error(err)
+}
+
+func formatMessage(formatstr string, timestampFormat string,
+	account string, msg *models.MessageInfo) (string, error) {
+
+	formatstr, args, err := format.ParseMessageFormat(formatstr,
+		timestampFormat, account, 0, msg)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(formatstr, args...), nil
+}
+
+func (n *Notifier) add(account string, folder string, msg notification) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	key := account + "/" + folder
+	g, ok := n.groups[key]
+	if !ok {
+		g = &group{account: account, folder: folder}
+		n.groups[key] = g
+		g.timer = time.AfterFunc(n.conf.GroupDelay, func() {
+			n.flush(key)
+		})
+	} else {
+		// Wait for the sync to settle down
+		g.timer.Reset(n.conf.GroupDelay)
+	}
+	g.messages = append(g.messages, msg)
+}
+
+// Shows the notification of a group. It runs on the goroutine of the timer of
+// the group, as showing it may take a while.
+func (n *Notifier) flush(key string) {
+	n.mutex.Lock()
+	g, ok := n.groups[key]
+	delete(n.groups, key)
+	n.mutex.Unlock()
+	if !ok || len(g.messages) == 0 {
+		return
+	}
+	n.error(n.show(g.summary()))
+}
+
+func (g *group) summary() notification {
+	if len(g.messages) == 1 {
+		return g.messages[0]
+	}
+	var lines []string
+	for i, msg := range g.messages {
+		if i == maxGroupLines {
+			lines = append(lines, fmt.Sprintf("and %d more",
+				len(g.messages)-maxGroupLines))
+			break
+		}
+		lines = append(lines, msg.summary+": "+msg.body)
+	}
+	return notification{
+		summary: fmt.Sprintf("%d new messages in %s/%s",
+			len(g.messages), g.account, g.folder),
+		body: strings.Join(lines, "\n"),
+	}
+}
+
+// Shows a notification over D-Bus if possible, and with the configured
+// command otherwise
+func (n *Notifier) show(msg notification) error {
+	err := n.showDBus(msg)
+	if err == nil {
+		return nil
+	}
+	if n.conf.Command == "" {
+		return err
+	}
+	return n.showCommand(msg)
+}
+
+func (n *Notifier) showDBus(msg notification) error {
+	n.busMutex.Lock()
+	defer n.busMutex.Unlock()
+	if n.bus == nil {
+		bus, err := dialSessionBus()
+		if err != nil {
+			return err
+		}
+		n.bus = bus
+	}
+	_, err := n.bus.Call("org.freedesktop.Notifications",
+		"/org/freedesktop/Notifications", "org.freedesktop.Notifications",
+		"Notify", "susssasa{sv}i",
+		"aerc", uint32(0), "", msg.summary, msg.body, []string{},
+		dbusDict{"category": "email.arrived"}, int32(-1))
+	if err != nil {
+		// Reconnect next time, in case the bus went away
+		n.bus.Close()
+		n.bus = nil
+	}
+	return err
+}
+
+func (n *Notifier) showCommand(msg notification) error {
+	args, err := shlex.Split(n.conf.Command)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return errors.New("Notification command empty")
+	}
+	args = append(args, msg.summary, msg.body)
+	cmd := exec.Command(args[0], args[1:]...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if text := strings.TrimSpace(string(out)); text != "" {
+			return fmt.Errorf("%v: %s", err, text)
+		}
+		return err
+	}
+	return nil
+}
+
+func (n *Notifier) error(err error) {
+	if err != nil && n.OnError != nil {
+		n.OnError(fmt.Errorf("Notification: %v", err))
+	}
+}
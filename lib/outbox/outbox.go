@@ -0,0 +1,384 @@
+// Package outbox keeps the messages to send on disk until they are sent, so that
+// none is lost when the network is down or aerc quits. Messages which cannot be
+// sent are retried with a growing delay, and messages can be scheduled to be
+// sent at a later time.
+package outbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// The delays between attempts to send a message double from firstRetry
+	// up to maxRetry
+	firstRetry = 30 * time.Second
+	maxRetry   = time.Hour
+)
+
+// ErrLocked is returned by New when another aerc uses the outbox
+var ErrLocked = errors.New("the outbox is used by another aerc")
+
+// A Message waiting in the outbox. Its content is kept next to it.
+type Message struct {
+	ID      string    `json:"-"`
+	Account string    `json:"account"`
+	From    string    `json:"from"`
+	Rcpts   []string  `json:"rcpts"`
+	Subject string    `json:"subject"`
+	Queued  time.Time `json:"queued"`
+	// When to send the message; zero is as soon as possible
+	At time.Time `json:"at,omitempty"`
+
+	// The failed attempts to send the message, the last error, and when to
+	// try again
+	Attempts int       `json:"attempts,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Retry    time.Time `json:"retry,omitempty"`
+}
+
+// Due returns when the message is to be sent next
+func (msg *Message) Due() time.Time {
+	if msg.Retry.After(msg.At) {
+		return msg.Retry
+	}
+	return msg.At
+}
+
+// A SendFunc sends the content of a message with the transport of its account.
+type SendFunc func(msg *Message, content []byte) error
+
+// An Outbox keeps messages in a directory, and sends them in turn when due.
+type Outbox struct {
+	// Called when messages are queued, sent, or fail to be sent. It may be
+	// called from any goroutine.
+	OnChange func()
+
+	m        sync.Mutex
+	dir      string
+	send     SendFunc
+	messages []*Message
+	// The message being sent
+	sending string
+	wake    chan struct{}
+	// Held while the outbox is open, so that two aercs don't both send its
+	// messages. The system releases it when aerc exits.
+	lock *os.File
+}
+
+// New creates an Outbox which keeps its messages in dir, including those left
+// from previous runs. Run sends them. Only one Outbox can use dir at a time,
+// across processes: the others get ErrLocked.
+func New(dir string, send SendFunc) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	lock, err := os.OpenFile(filepath.Join(dir, ".lock"),
+		os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		lock.Close()
+		return nil, ErrLocked
+	} else if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	o := &Outbox{
+		dir:  dir,
+		send: send,
+		wake: make(chan struct{}, 1),
+		lock: lock,
+	}
+	if err := o.load(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return o, nil
+}
+
+// Close lets another Outbox use the directory
+func (o *Outbox) Close() error {
+	return o.lock.Close()
+}
+
+// Reads the messages left in the directory
+func (o *Outbox) load() error {
+	files, err := filepath.Glob(filepath.Join(o.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		msg := &Message{}
+		if err := json.Unmarshal(data, msg); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		msg.ID = strings.TrimSuffix(filepath.Base(file), ".json")
+		o.messages = append(o.messages, msg)
+	}
+	sort.Slice(o.messages, func(i, j int) bool {
+		return o.messages[i].Queued.Before(o.messages[j].Queued)
+	})
+	return nil
+}
+
+func (o *Outbox) path(id, ext string) string {
+	return filepath.Join(o.dir, id+ext)
+}
+
+// Writes a file atomically
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (o *Outbox) save(msg *Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return writeFile(o.path(msg.ID, ".json"), data)
+}
+
+func (o *Outbox) changed() {
+	if o.OnChange != nil {
+		o.OnChange()
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Queue adds a message to the outbox, and returns its ID.
+func (o *Outbox) Queue(msg Message, content []byte) (string, error) {
+	o.m.Lock()
+	now := time.Now()
+	msg.ID = strconv.FormatInt(now.UnixNano(), 36)
+	msg.Queued = now
+	err := writeFile(o.path(msg.ID, ".eml"), content)
+	if err == nil {
+		// The message only counts once its content is written
+		err = o.save(&msg)
+	}
+	if err != nil {
+		os.Remove(o.path(msg.ID, ".eml"))
+		o.m.Unlock()
+		return "", err
+	}
+	o.messages = append(o.messages, &msg)
+	o.m.Unlock()
+	o.changed()
+	return msg.ID, nil
+}
+
+// Messages returns a copy of the messages in the outbox, oldest first.
+func (o *Outbox) Messages() []Message {
+	o.m.Lock()
+	defer o.m.Unlock()
+	messages := make([]Message, len(o.messages))
+	for i, msg := range o.messages {
+		messages[i] = *msg
+	}
+	return messages
+}
+
+func (o *Outbox) find(id string) (int, error) {
+	for i, msg := range o.messages {
+		if msg.ID == id {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("No message %s in the outbox", id)
+}
+
+// Cancel removes a message from the outbox, unless it is being sent.
+func (o *Outbox) Cancel(id string) error {
+	o.m.Lock()
+	i, err := o.find(id)
+	if err == nil && o.sending == id {
+		err = fmt.Errorf("Message %s is being sent", id)
+	}
+	if err != nil {
+		o.m.Unlock()
+		return err
+	}
+	o.remove(i)
+	o.m.Unlock()
+	o.changed()
+	return nil
+}
+
+// MoveTo moves the messages of the outbox to another outbox directory, where
+// they are sent once an Outbox uses it again. The message being sent is kept.
+func (o *Outbox) MoveTo(dir string) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	var kept []*Message
+	for i, msg := range o.messages {
+		if msg.ID == o.sending {
+			kept = append(kept, msg)
+			continue
+		}
+		// The content goes first, as in Queue
+		for _, ext := range []string{".eml", ".json"} {
+			err := os.Rename(o.path(msg.ID, ext),
+				filepath.Join(dir, msg.ID+ext))
+			if err != nil {
+				o.messages = append(kept, o.messages[i:]...)
+				return err
+			}
+		}
+	}
+	o.messages = kept
+	return nil
+}
+
+func (o *Outbox) remove(i int) {
+	msg := o.messages[i]
+	os.Remove(o.path(msg.ID, ".json"))
+	os.Remove(o.path(msg.ID, ".eml"))
+	o.messages = append(o.messages[:i], o.messages[i+1:]...)
+}
+
+// SendNow makes a scheduled or failing message due now.
+func (o *Outbox) SendNow(id string) error {
+	o.m.Lock()
+	i, err := o.find(id)
+	if err != nil {
+		o.m.Unlock()
+		return err
+	}
+	msg := o.messages[i]
+	msg.At = time.Time{}
+	msg.Retry = time.Time{}
+	err = o.save(msg)
+	o.m.Unlock()
+	o.changed()
+	return err
+}
+
+// Status sums up the outbox for the status line, e.g. "2 queued, 1 scheduled",
+// or returns an empty string if it is empty.
+func (o *Outbox) Status() string {
+	o.m.Lock()
+	defer o.m.Unlock()
+	var queued, scheduled, failing int
+	now := time.Now()
+	for _, msg := range o.messages {
+		if msg.Attempts > 0 {
+			failing++
+		} else if msg.At.After(now) {
+			scheduled++
+		} else {
+			queued++
+		}
+	}
+	var parts []string
+	if queued > 0 {
+		parts = append(parts, fmt.Sprintf("%d queued", queued))
+	}
+	if scheduled > 0 {
+		parts = append(parts, fmt.Sprintf("%d scheduled", scheduled))
+	}
+	if failing > 0 {
+		parts = append(parts, fmt.Sprintf("%d failing", failing))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Returns the first message due, or when the next one is due
+func (o *Outbox) next() (*Message, time.Time) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	now := time.Now()
+	var next time.Time
+	for _, msg := range o.messages {
+		due := msg.Due()
+		if !due.After(now) {
+			o.sending = msg.ID
+			// SendNow and Cancel may change the message meanwhile
+			snapshot := *msg
+			return &snapshot, time.Time{}
+		}
+		if next.IsZero() || due.Before(next) {
+			next = due
+		}
+	}
+	return nil, next
+}
+
+// Run sends the messages as they are due, and never returns.
+func (o *Outbox) Run() {
+	for {
+		msg, next := o.next()
+		if msg != nil {
+			o.deliver(msg)
+			continue
+		}
+		var timer <-chan time.Time
+		if !next.IsZero() {
+			timer = time.After(time.Until(next))
+		}
+		select {
+		case <-o.wake:
+		case <-timer:
+		}
+	}
+}
+
+func (o *Outbox) deliver(msg *Message) {
+	content, err := ioutil.ReadFile(o.path(msg.ID, ".eml"))
+	if err == nil {
+		// The message is not changed while it is being sent
+		err = o.send(msg, content)
+	}
+
+	o.m.Lock()
+	o.sending = ""
+	if i, ferr := o.find(msg.ID); ferr != nil {
+		// Canceled meanwhile
+	} else if err == nil {
+		o.remove(i)
+	} else {
+		msg := o.messages[i]
+		msg.Attempts++
+		msg.Error = err.Error()
+		msg.Retry = time.Now().Add(retryDelay(msg.Attempts))
+		o.save(msg)
+	}
+	o.m.Unlock()
+	if o.OnChange != nil {
+		o.OnChange()
+	}
+}
+
+func retryDelay(attempts int) time.Duration {
+	delay := firstRetry
+	for i := 1; i < attempts && delay < maxRetry; i++ {
+		delay *= 2
+	}
+	if delay > maxRetry {
+		delay = maxRetry
+	}
+	return delay
+}
@@ -0,0 +1,156 @@
+package outbox
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	// A Wednesday
+	now := time.Date(2020, 1, 15, 10, 30, 0, 0, time.Local)
+	for s, want := range map[string]time.Time{
+		"now":              now,
+		"2h":               now.Add(2 * time.Hour),
+		"+90m":             now.Add(90 * time.Minute),
+		"in 1h30m":         now.Add(90 * time.Minute),
+		"tomorrow 9:00":    time.Date(2020, 1, 16, 9, 0, 0, 0, time.Local),
+		"Tomorrow 5pm":     time.Date(2020, 1, 16, 17, 0, 0, 0, time.Local),
+		"today 3:15pm":     time.Date(2020, 1, 15, 15, 15, 0, 0, time.Local),
+		"tomorrow":         time.Date(2020, 1, 16, 10, 30, 0, 0, time.Local),
+		"friday 8:00":      time.Date(2020, 1, 17, 8, 0, 0, 0, time.Local),
+		"wednesday 8:00":   time.Date(2020, 1, 22, 8, 0, 0, 0, time.Local),
+		"2020-02-01 14:00": time.Date(2020, 2, 1, 14, 0, 0, 0, time.Local),
+		"2020-02-01":       time.Date(2020, 2, 1, 10, 30, 0, 0, time.Local),
+		"11:00":            time.Date(2020, 1, 15, 11, 0, 0, 0, time.Local),
+		"9:00":             time.Date(2020, 1, 16, 9, 0, 0, 0, time.Local),
+	} {
+		got, err := ParseTime(s, now)
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+		} else if !got.Equal(want) {
+			t.Errorf("%q: got %v, want %v", s, got, want)
+		}
+	}
+	for _, s := range []string{"", "soon", "tomorrow noon", "9:00 tomorrow"} {
+		if _, err := ParseTime(s, now); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aerc-outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sent := make(chan string, 10)
+	fail := true
+	o, err := New(dir, func(msg *Message, content []byte) error {
+		if fail {
+			return errors.New("network is down")
+		}
+		sent <- string(content)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := o.Queue(Message{Account: "work", Rcpts: []string{"a@b"}},
+		[]byte("now"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = o.Queue(Message{Account: "work", At: time.Now().Add(time.Hour)},
+		[]byte("later"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := o.Status(); status != "1 queued, 1 scheduled" {
+		t.Errorf("wrong status: %q", status)
+	}
+
+	// The first attempt fails, and the message stays
+	msg, _ := o.next()
+	if msg == nil || msg.ID != id {
+		t.Fatalf("wrong message due: %v", msg)
+	}
+	o.deliver(msg)
+	if status := o.Status(); status != "1 scheduled, 1 failing" {
+		t.Errorf("wrong status: %q", status)
+	}
+	if msg, next := o.next(); msg != nil ||
+		time.Until(next) > firstRetry {
+
+		t.Errorf("wrong next message: %v at %v", msg, next)
+	}
+
+	// Another aerc can't use the outbox meanwhile
+	if _, err := New(dir, o.send); err != ErrLocked {
+		t.Errorf("outbox not locked: %v", err)
+	}
+
+	// The outbox is kept across runs
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	o, err = New(dir, o.send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := o.Messages()
+	if len(messages) != 2 || messages[0].ID != id ||
+		messages[0].Attempts != 1 || messages[0].Error != "network is down" {
+
+		t.Fatalf("wrong messages: %+v", messages)
+	}
+
+	fail = false
+	if err := o.SendNow(id); err != nil {
+		t.Fatal(err)
+	}
+	msg, _ = o.next()
+	o.deliver(msg)
+	if content := <-sent; content != "now" {
+		t.Errorf("wrong message sent: %q", content)
+	}
+	if err := o.Cancel(messages[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(o.Messages()) != 0 {
+		t.Errorf("messages left: %+v", o.Messages())
+	}
+	// Only the lock is left
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 1 {
+		t.Errorf("files left: %v", files)
+	}
+
+	// The messages of another outbox are moved to this one
+	other, err := New(filepath.Join(dir, "other"), o.send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err = other.Queue(Message{Account: "work"}, []byte("moved"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.MoveTo(dir); err != nil {
+		t.Fatal(err)
+	}
+	if len(other.Messages()) != 0 {
+		t.Errorf("messages not moved: %+v", other.Messages())
+	}
+	o.Close()
+	o, err = New(dir, o.send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messages := o.Messages(); len(messages) != 1 || messages[0].ID != id {
+		t.Errorf("wrong messages: %+v", messages)
+	}
+}
@@ -0,0 +1,102 @@
+package outbox
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	dateLayouts = []string{"2006-01-02"}
+	timeLayouts = []string{"15:04", "3:04pm", "3pm"}
+	weekdays    = map[string]time.Weekday{
+		"sunday":    time.Sunday,
+		"monday":    time.Monday,
+		"tuesday":   time.Tuesday,
+		"wednesday": time.Wednesday,
+		"thursday":  time.Thursday,
+		"friday":    time.Friday,
+		"saturday":  time.Saturday,
+	}
+)
+
+// ParseTime parses when to send a message, relative to now. It takes a
+// duration, e.g. "2h30m", "+2h30m" or "in 2h30m", or a day, a time or both, e.g.
+// "tomorrow 9:00", "friday 5pm", "2020-01-31 14:00" or "9:00". The day is today,
+// tomorrow, the next such weekday or a date.
+//
+// A time alone is the next one to come, and a day alone keeps the time of now.
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "now" {
+		return now, nil
+	}
+	if d, err := time.ParseDuration(strings.TrimPrefix(
+		strings.TrimPrefix(s, "in "), "+")); err == nil {
+
+		return now.Add(d), nil
+	}
+
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return time.Time{}, fmt.Errorf("Invalid time %q", s)
+	}
+	var (
+		day    time.Time
+		hasDay bool
+		err    error
+	)
+	if day, hasDay = parseDay(fields[0], now); hasDay {
+		fields = fields[1:]
+	} else {
+		day = now
+	}
+	hour, min := now.Hour(), now.Minute()
+	if len(fields) == 1 {
+		if hour, min, err = parseClock(fields[0]); err != nil {
+			return time.Time{}, fmt.Errorf("Invalid time %q", s)
+		}
+	} else if !hasDay {
+		return time.Time{}, fmt.Errorf("Invalid time %q", s)
+	}
+
+	t := time.Date(day.Year(), day.Month(), day.Day(), hour, min, 0, 0,
+		now.Location())
+	if !hasDay && !t.After(now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+func parseDay(s string, now time.Time) (time.Time, bool) {
+	switch s {
+	case "today":
+		return now, true
+	case "tomorrow":
+		return now.AddDate(0, 0, 1), true
+	}
+	if weekday, ok := weekdays[s]; ok {
+		days := (int(weekday) - int(now.Weekday()) + 7) % 7
+		if days == 0 {
+			days = 7
+		}
+		return now.AddDate(0, 0, days), true
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func parseClock(s string) (int, int, error) {
+	var err error
+	for _, layout := range timeLayouts {
+		var t time.Time
+		if t, err = time.Parse(layout, s); err == nil {
+			return t.Hour(), t.Minute(), nil
+		}
+	}
+	return 0, 0, err
+}
@@ -2,6 +2,7 @@ package lib
 
 import (
 	"bufio"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -9,27 +10,141 @@ import (
 	"net/url"
 	"path"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/kyoh86/xdg"
 )
 
+// Error codes defined by JSON-RPC 2.0
+const (
+	RPC_PARSE_ERROR      = -32700
+	RPC_INVALID_REQUEST  = -32600
+	RPC_METHOD_NOT_FOUND = -32601
+	RPC_INVALID_PARAMS   = -32602
+	RPC_SERVER_ERROR     = -32000
+)
+
+// A JSON-RPC 2.0 request. Requests without an id are notifications and get no
+// response.
+type RPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Id      interface{}     `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type RPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Id      interface{}     `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (err *RPCError) Error() string {
+	return err.Message
+}
+
+// Sent to subscribed clients when an event occurs
+type RPCEvent struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+type rpcNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type rpcSubscribeParams struct {
+	Events []string `json:"events"`
+}
+
+const (
+	// How many events may wait for a client which does not read them, before
+	// it is disconnected
+	eventQueueSize = 64
+	// How long writing to a client may take
+	writeTimeout = 10 * time.Second
+)
+
+type rpcClient struct {
+	conn       net.Conn
+	writeMutex sync.Mutex
+	// The events to write, in order, by the goroutine of writeEvents
+	queue chan []byte
+	// Closed once the connection is closed
+	closed chan struct{}
+
+	// Protected by AercServer.clientsMutex
+	subscribed bool
+	events     map[string]bool
+}
+
+func (client *rpcClient) write(v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return client.writeLine(buf)
+}
+
+func (client *rpcClient) writeLine(buf []byte) error {
+	client.writeMutex.Lock()
+	defer client.writeMutex.Unlock()
+	client.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := client.conn.Write(append(buf, '\n'))
+	return err
+}
+
+// Writes the queued events until the connection is closed, or a write fails
+func (client *rpcClient) writeEvents(logger *log.Logger) {
+	for {
+		select {
+		case buf := <-client.queue:
+			if err := client.writeLine(buf); err != nil {
+				logger.Printf("Failed to notify Unix client: %v", err)
+				client.conn.Close()
+				return
+			}
+		case <-client.closed:
+			return
+		}
+	}
+}
+
 type AercServer struct {
 	logger   *log.Logger
 	listener net.Listener
 	OnMailto func(addr *url.URL) error
+	// Handles a JSON-RPC method call. Return an *RPCError to control the
+	// error code sent to the client.
+	OnRequest func(method string, params json.RawMessage) (interface{}, error)
+
+	clients      map[int64]*rpcClient
+	clientsMutex sync.Mutex
+}
+
+func socketPath() string {
+	return path.Join(xdg.RuntimeDir(), "aerc.sock")
 }
 
 func StartServer(logger *log.Logger) (*AercServer, error) {
-	sockpath := path.Join(xdg.RuntimeDir(), "aerc.sock")
-	l, err := net.Listen("unix", sockpath)
+	l, err := net.Listen("unix", socketPath())
 	if err != nil {
 		return nil, err
 	}
 	as := &AercServer{
 		logger:   logger,
 		listener: l,
+		clients:  make(map[int64]*rpcClient),
 	}
 	// TODO: stash clients and close them on exit... bleh racey
 	go func() {
@@ -51,20 +166,81 @@ func (as *AercServer) Close() {
 	as.listener.Close()
 }
 
+// Notify queues an event for every client subscribed to it, without waiting
+// for them to read it. Clients which fall too far behind are disconnected. It
+// may be called from any goroutine.
+func (as *AercServer) Notify(event string, data interface{}) {
+	buf, err := json.Marshal(rpcNotification{
+		JSONRPC: "2.0",
+		Method:  "event",
+		Params:  RPCEvent{Type: event, Data: data},
+	})
+	if err != nil {
+		as.logger.Printf("Failed to encode %s event: %v", event, err)
+		return
+	}
+	var clients []*rpcClient
+	as.clientsMutex.Lock()
+	for _, client := range as.clients {
+		if !client.subscribed {
+			continue
+		}
+		if len(client.events) == 0 || client.events[event] {
+			clients = append(clients, client)
+		}
+	}
+	as.clientsMutex.Unlock()
+	for _, client := range clients {
+		select {
+		case client.queue <- buf:
+		default:
+			as.logger.Printf("Unix client does not read its events, " +
+				"disconnecting it")
+			client.conn.Close()
+		}
+	}
+}
+
 var lastId int64 = 0 // access via atomic
 
 func (as *AercServer) handleClient(conn net.Conn) {
 	clientId := atomic.AddInt64(&lastId, 1)
 	as.logger.Printf("Accepted Unix connection %d", clientId)
+	client := &rpcClient{
+		conn:   conn,
+		queue:  make(chan []byte, eventQueueSize),
+		closed: make(chan struct{}),
+	}
+	as.clientsMutex.Lock()
+	as.clients[clientId] = client
+	as.clientsMutex.Unlock()
+	go client.writeEvents(as.logger)
+	defer func() {
+		as.clientsMutex.Lock()
+		delete(as.clients, clientId)
+		as.clientsMutex.Unlock()
+		close(client.closed)
+		conn.Close()
+	}()
+
 	scanner := bufio.NewScanner(conn)
 	conn.SetDeadline(time.Now().Add(1 * time.Minute))
 	for scanner.Scan() {
-		conn.SetDeadline(time.Now().Add(1 * time.Minute))
+		as.clientsMutex.Lock()
+		if !client.subscribed {
+			conn.SetDeadline(time.Now().Add(1 * time.Minute))
+		}
+		as.clientsMutex.Unlock()
 		msg := scanner.Text()
+		as.logger.Printf("unix:%d: got message %s", clientId, msg)
+		if strings.HasPrefix(msg, "{") {
+			as.handleRequest(client, msg)
+			continue
+		}
 		if !strings.ContainsRune(msg, ':') {
 			conn.Write([]byte("error: invalid command\n"))
+			continue
 		}
-		as.logger.Printf("unix:%d: got message %s", clientId, msg)
 		prefix := msg[:strings.IndexRune(msg, ':')]
 		switch prefix {
 		case "mailto":
@@ -82,18 +258,119 @@ func (as *AercServer) handleClient(conn net.Conn) {
 	as.logger.Printf("Closed Unix connection %d", clientId)
 }
 
-func ConnectAndExec(msg string) error {
-	sockpath := path.Join(xdg.RuntimeDir(), "aerc.sock")
-	conn, err := net.Dial("unix", sockpath)
+func (as *AercServer) handleRequest(client *rpcClient, msg string) {
+	var req RPCRequest
+	if err := json.Unmarshal([]byte(msg), &req); err != nil {
+		client.write(RPCResponse{
+			JSONRPC: "2.0",
+			Error:   &RPCError{RPC_PARSE_ERROR, err.Error()},
+		})
+		return
+	}
+	result, err := as.call(client, req)
+	if req.Id == nil {
+		return
+	}
+	resp := RPCResponse{JSONRPC: "2.0", Id: req.Id}
+	if err == nil {
+		resp.Result, err = json.Marshal(result)
+	}
 	if err != nil {
-		return err
+		rpcErr, ok := err.(*RPCError)
+		if !ok {
+			rpcErr = &RPCError{RPC_SERVER_ERROR, err.Error()}
+		}
+		resp.Result = nil
+		resp.Error = rpcErr
+	}
+	if err := client.write(resp); err != nil {
+		as.logger.Printf("Failed to respond to Unix client: %v", err)
+	}
+}
+
+func (as *AercServer) call(client *rpcClient,
+	req RPCRequest) (interface{}, error) {
+
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return nil, &RPCError{RPC_INVALID_REQUEST, "invalid request"}
+	}
+	switch req.Method {
+	case "subscribe":
+		var params rpcSubscribeParams
+		if len(req.Params) != 0 {
+			if err := json.Unmarshal(req.Params, &params); err != nil {
+				return nil, &RPCError{RPC_INVALID_PARAMS, err.Error()}
+			}
+		}
+		as.clientsMutex.Lock()
+		client.subscribed = true
+		client.events = make(map[string]bool)
+		for _, event := range params.Events {
+			client.events[event] = true
+		}
+		as.clientsMutex.Unlock()
+		// Subscribers wait for events as long as they like, but writes to
+		// them still time out
+		client.conn.SetReadDeadline(time.Time{})
+		return nil, nil
+	case "unsubscribe":
+		as.clientsMutex.Lock()
+		client.subscribed = false
+		client.events = nil
+		as.clientsMutex.Unlock()
+		client.conn.SetDeadline(time.Now().Add(1 * time.Minute))
+		return nil, nil
 	}
+	if as.OnRequest == nil {
+		return nil, &RPCError{RPC_METHOD_NOT_FOUND,
+			"unknown method " + req.Method}
+	}
+	return as.OnRequest(req.Method, req.Params)
+}
+
+// ConnectAndExec sends a line to a running aerc and returns its reply
+func ConnectAndExec(msg string) (string, error) {
+	conn, err := net.Dial("unix", socketPath())
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
 	conn.Write([]byte(msg + "\n"))
 	scanner := bufio.NewScanner(conn)
 	if !scanner.Scan() {
-		return errors.New("No response from server")
+		return "", errors.New("No response from server")
+	}
+	return scanner.Text(), nil
+}
+
+// ConnectAndCall calls a JSON-RPC method of a running aerc, decoding the
+// result into result unless it is nil.
+func ConnectAndCall(method string, params interface{},
+	result interface{}) error {
+
+	req := struct {
+		JSONRPC string      `json:"jsonrpc"`
+		Id      int         `json:"id"`
+		Method  string      `json:"method"`
+		Params  interface{} `json:"params,omitempty"`
+	}{"2.0", 1, method, params}
+	buf, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	line, err := ConnectAndExec(string(buf))
+	if err != nil {
+		return err
+	}
+	var resp RPCResponse
+	if err := json.Unmarshal([]byte(line), &resp); err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result != nil {
+		return json.Unmarshal(resp.Result, result)
 	}
-	result := scanner.Text()
-	fmt.Println(result)
 	return nil
 }
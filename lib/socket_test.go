@@ -0,0 +1,61 @@
+package lib
+
+import (
+	"bufio"
+	"io/ioutil"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNotifyStalledClient(t *testing.T) {
+	as := &AercServer{
+		logger:  log.New(ioutil.Discard, "", 0),
+		clients: make(map[int64]*rpcClient),
+	}
+	server, conn := net.Pipe()
+	go as.handleClient(server)
+	defer conn.Close()
+
+	conn.Write([]byte(`{"jsonrpc":"2.0","id":1,"method":"subscribe"}` + "\n"))
+	reader := bufio.NewReader(conn)
+	if _, err := reader.ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+
+	// The client reads nothing more, which must not block the notifier
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 2*eventQueueSize; i++ {
+			as.Notify("mail-received", i)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Notify blocked on a stalled client")
+	}
+
+	// It was disconnected once its queue was full
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, err := reader.ReadString('\n'); err != nil {
+			break
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		as.clientsMutex.Lock()
+		n := len(as.clients)
+		as.clientsMutex.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stalled client not disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
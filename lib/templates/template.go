@@ -0,0 +1,207 @@
+package templates
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+
+	"git.sr.ht/~sircmpwn/aerc/models"
+)
+
+type TemplateData struct {
+	To      []*mail.Address
+	Cc      []*mail.Address
+	Bcc     []*mail.Address
+	From    []*mail.Address
+	Date    time.Time
+	Subject string
+	// Only available when replying or forwarding
+	OriginalText     string
+	OriginalFrom     []*models.Address
+	OriginalDate     time.Time
+	OriginalMIMEType string
+	OriginalMessage  *models.MessageInfo
+}
+
+func ParseTemplateData(defaults map[string]string,
+	original models.OriginalMail) TemplateData {
+
+	td := TemplateData{
+		To:      parseAddressList(defaults["To"]),
+		Cc:      parseAddressList(defaults["Cc"]),
+		Bcc:     parseAddressList(defaults["Bcc"]),
+		From:    parseAddressList(defaults["From"]),
+		Date:    time.Now(),
+		Subject: defaults["Subject"],
+
+		OriginalText:     original.Text,
+		OriginalMIMEType: original.MIMEType,
+		OriginalMessage:  original.Info,
+	}
+	if original.Info != nil && original.Info.Envelope != nil {
+		td.OriginalFrom = original.Info.Envelope.From
+		td.OriginalDate = original.Info.Envelope.Date
+	}
+	return td
+}
+
+func parseAddressList(list string) []*mail.Address {
+	addrs, err := mail.ParseAddressList(list)
+	if err != nil {
+		return nil
+	}
+	return addrs
+}
+
+// wrapLine wraps a single line of text on word boundaries so that no line
+// exceeds lineWidth, unless a single word is longer than that.
+func wrapLine(text string, lineWidth int) string {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return text
+	}
+	var wrapped strings.Builder
+	wrapped.WriteString(words[0])
+	spaceLeft := lineWidth - len(words[0])
+	for _, word := range words[1:] {
+		if len(word)+1 > spaceLeft {
+			wrapped.WriteRune('\n')
+			wrapped.WriteString(word)
+			spaceLeft = lineWidth - len(word)
+		} else {
+			wrapped.WriteRune(' ')
+			wrapped.WriteString(word)
+			spaceLeft -= 1 + len(word)
+		}
+	}
+	return wrapped.String()
+}
+
+// wrap wraps each line of text to lineWidth. Its argument order allows it to
+// be used in pipelines, e.g. {{.OriginalText | wrap 72}}
+func wrap(lineWidth int, text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		// Leave quoted text alone, rewrapping it would mangle the quote
+		if strings.HasPrefix(line, ">") {
+			continue
+		}
+		lines[i] = wrapLine(line, lineWidth)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// quote prefixes each line of text with "> "
+func quote(text string) string {
+	text = strings.TrimRight(text, "\n")
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ">") {
+			lines[i] = ">" + line
+		} else if line == "" {
+			lines[i] = ">"
+		} else {
+			lines[i] = "> " + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// cmd pipes text into the given shell command and returns its output
+func cmd(command, text string) (string, error) {
+	var out bytes.Buffer
+	sh := exec.Command("sh", "-c", command)
+	sh.Stdin = strings.NewReader(text)
+	sh.Stdout = &out
+	if err := sh.Run(); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+func dateFormat(date time.Time, layout string) string {
+	return date.Format(layout)
+}
+
+func toLocal(t time.Time) time.Time {
+	return t.Local()
+}
+
+var templateFuncs = template.FuncMap{
+	"quote":      quote,
+	"wrap":       wrap,
+	"dateFormat": dateFormat,
+	"toLocal":    toLocal,
+	"exec":       cmd,
+}
+
+func findTemplate(templateName string, templateDirs []string) (string, error) {
+	if templateName == "" {
+		return "", errors.New("No template name given")
+	}
+	if path.IsAbs(templateName) || strings.HasPrefix(templateName, "~") {
+		expanded, err := homedir.Expand(templateName)
+		if err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(expanded); err != nil {
+			return "", err
+		}
+		return expanded, nil
+	}
+	for _, dir := range templateDirs {
+		dir, err := homedir.Expand(dir)
+		if err != nil {
+			continue
+		}
+		templateFile := path.Join(dir, templateName)
+		if _, err := os.Stat(templateFile); os.IsNotExist(err) {
+			continue
+		}
+		return templateFile, nil
+	}
+	return "", fmt.Errorf(
+		"Can't find template %q in any of %v", templateName, templateDirs)
+}
+
+// ParseTemplateFromFile finds the named template in one of the template
+// directories and executes it with the given data.
+func ParseTemplateFromFile(templateName string, templateDirs []string,
+	data interface{}) ([]byte, error) {
+
+	templateFile, err := findTemplate(templateName, templateDirs)
+	if err != nil {
+		return nil, err
+	}
+	emailTemplate, err := template.New(path.Base(templateFile)).
+		Funcs(templateFuncs).ParseFiles(templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var outString bytes.Buffer
+	if err := emailTemplate.Execute(&outString, data); err != nil {
+		return nil, err
+	}
+	return outString.Bytes(), nil
+}
+
+// CheckTemplate ensures that the named template exists and parses.
+func CheckTemplate(templateName string, templateDirs []string) error {
+	templateFile, err := findTemplate(templateName, templateDirs)
+	if err != nil {
+		return err
+	}
+	_, err = template.New(path.Base(templateFile)).
+		Funcs(templateFuncs).ParseFiles(templateFile)
+	return err
+}
@@ -0,0 +1,91 @@
+package templates
+
+import (
+	"testing"
+	"time"
+
+	"git.sr.ht/~sircmpwn/aerc/models"
+)
+
+func TestWrap(t *testing.T) {
+	type tc struct {
+		width    int
+		text     string
+		expected string
+	}
+	cases := []*tc{
+		&tc{10, "", ""},
+		&tc{10, "short", "short"},
+		&tc{10, "this line is too long", "this line\nis too\nlong"},
+		&tc{5, "unbreakable", "unbreakable"},
+		&tc{10, "> quoted text is left alone", "> quoted text is left alone"},
+		&tc{10, "first line\n\nsecond line", "first line\n\nsecond\nline"},
+	}
+	for _, c := range cases {
+		result := wrap(c.width, c.text)
+		if result != c.expected {
+			t.Errorf("wrap(%d, %q): expected %q but got %q",
+				c.width, c.text, c.expected, result)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	type tc struct {
+		text     string
+		expected string
+	}
+	cases := []*tc{
+		&tc{"hello", "> hello"},
+		&tc{"hello\n\nworld\n", "> hello\n>\n> world"},
+		&tc{"> already quoted", ">> already quoted"},
+	}
+	for _, c := range cases {
+		result := quote(c.text)
+		if result != c.expected {
+			t.Errorf("quote(%q): expected %q but got %q",
+				c.text, c.expected, result)
+		}
+	}
+}
+
+func TestDefaultTemplates(t *testing.T) {
+	original := models.OriginalMail{
+		Text:     "Hello world\n",
+		MIMEType: "text/plain",
+		Info: &models.MessageInfo{
+			Envelope: &models.Envelope{
+				Date:    time.Date(2019, 8, 1, 15, 4, 0, 0, time.Local),
+				Subject: "Greetings",
+				From: []*models.Address{
+					&models.Address{
+						Name:    "Alex Smith",
+						Mailbox: "smith",
+						Host:    "example.net",
+					},
+				},
+			},
+		},
+	}
+	data := ParseTemplateData(map[string]string{
+		"To":      "Jane Plain <jane@example.org>",
+		"Subject": "Re: Greetings",
+	}, original)
+	dirs := []string{"../../templates"}
+
+	out, err := ParseTemplateFromFile("quoted_reply", dirs, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "On Thu Aug 1, 2019 at 3:04 PM, Alex Smith wrote:\n" +
+		"> Hello world\n"
+	if string(out) != expected {
+		t.Errorf("expected %q but got %q", expected, string(out))
+	}
+
+	for _, name := range []string{"new_message", "forward_as_body"} {
+		if err := CheckTemplate(name, dirs); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package ui
 
 import (
 	"github.com/gdamore/tcell"
+
+	"git.sr.ht/~sircmpwn/aerc/config"
 )
 
 const (
@@ -16,12 +18,16 @@ type Bordered struct {
 	borders      uint
 	content      Drawable
 	onInvalidate func(d Drawable)
+	uiConfig     *config.UIConfig
 }
 
-func NewBordered(content Drawable, borders uint) *Bordered {
+func NewBordered(content Drawable, borders uint,
+	uiConf *config.UIConfig) *Bordered {
+
 	b := &Bordered{
-		borders: borders,
-		content: content,
+		borders:  borders,
+		content:  content,
+		uiConfig: uiConf,
 	}
 	content.OnInvalidate(b.contentInvalidated)
 	return b
@@ -44,7 +50,7 @@ func (bordered *Bordered) Draw(ctx *Context) {
 	y := 0
 	width := ctx.Width()
 	height := ctx.Height()
-	style := tcell.StyleDefault.Reverse(true)
+	style := bordered.uiConfig.GetStyle(config.STYLE_BORDER)
 	if bordered.borders&BORDER_LEFT != 0 {
 		ctx.Fill(0, 0, 1, ctx.Height(), ' ', style)
 		x += 1
@@ -66,3 +72,20 @@ func (bordered *Bordered) Draw(ctx *Context) {
 	subctx := ctx.Subcontext(x, y, width, height)
 	bordered.content.Draw(subctx)
 }
+
+func (bordered *Bordered) MouseEvent(localX int, localY int, event tcell.Event) {
+	clickable, ok := bordered.content.(Clickable)
+	if !ok {
+		return
+	}
+	if bordered.borders&BORDER_LEFT != 0 {
+		localX -= 1
+	}
+	if bordered.borders&BORDER_TOP != 0 {
+		localY -= 1
+	}
+	if localX < 0 || localY < 0 {
+		return
+	}
+	clickable.MouseEvent(localX, localY, event)
+}
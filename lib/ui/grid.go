@@ -5,6 +5,8 @@ import (
 	"math"
 	"sync"
 	"sync/atomic"
+
+	"github.com/gdamore/tcell"
 )
 
 type Grid struct {
@@ -141,6 +143,39 @@ func (grid *Grid) Draw(ctx *Context) {
 	}
 }
 
+// Passes a mouse event on to the clickable cell under the cursor
+func (grid *Grid) MouseEvent(localX int, localY int, event tcell.Event) {
+	if grid.rowLayout == nil || grid.columnLayout == nil {
+		// Not drawn yet
+		return
+	}
+
+	grid.mutex.RLock()
+	defer grid.mutex.RUnlock()
+
+	for _, cell := range grid.cells {
+		rows := grid.rowLayout[cell.Row : cell.Row+cell.RowSpan]
+		cols := grid.columnLayout[cell.Column : cell.Column+cell.ColSpan]
+		x := cols[0].Offset
+		y := rows[0].Offset
+		width := 0
+		height := 0
+		for _, col := range cols {
+			width += col.Size
+		}
+		for _, row := range rows {
+			height += row.Size
+		}
+		if localX < x || localX >= x+width || localY < y || localY >= y+height {
+			continue
+		}
+		if clickable, ok := cell.Content.(Clickable); ok {
+			clickable.MouseEvent(localX-x, localY-y, event)
+		}
+		return
+	}
+}
+
 func (grid *Grid) reflow(ctx *Context) {
 	grid.rowLayout = nil
 	grid.columnLayout = nil
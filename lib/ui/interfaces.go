@@ -53,6 +53,7 @@ type Container interface {
 // A drawable that can be clicked
 type Clickable interface {
 	Drawable
-
-	MouseEvent(event tcell.Event)
+	// Handles a mouse event. The position is relative to the top-left corner
+	// of the area this drawable was last drawn in.
+	MouseEvent(localX int, localY int, event tcell.Event)
 }
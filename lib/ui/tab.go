@@ -3,6 +3,8 @@ package ui
 import (
 	"github.com/gdamore/tcell"
 	"github.com/mattn/go-runewidth"
+
+	"git.sr.ht/~sircmpwn/aerc/config"
 )
 
 type Tabs struct {
@@ -11,6 +13,7 @@ type Tabs struct {
 	TabContent *TabContent
 	Selected   int
 	history    []int
+	uiConfig   *config.UIConfig
 
 	onInvalidateStrip   func(d Drawable)
 	onInvalidateContent func(d Drawable)
@@ -26,8 +29,8 @@ type Tab struct {
 type TabStrip Tabs
 type TabContent Tabs
 
-func NewTabs() *Tabs {
-	tabs := &Tabs{}
+func NewTabs(uiConf *config.UIConfig) *Tabs {
+	tabs := &Tabs{uiConfig: uiConf}
 	tabs.TabStrip = (*TabStrip)(tabs)
 	tabs.TabContent = (*TabContent)(tabs)
 	tabs.history = []int{}
@@ -39,10 +42,15 @@ func (tabs *Tabs) Add(content Drawable, name string) *Tab {
 		Content: content,
 		Name:    name,
 	}
+	tabs.AddTab(tab)
+	return tab
+}
+
+// AddTab adds a tab, e.g. one which was removed before
+func (tabs *Tabs) AddTab(tab *Tab) {
 	tabs.Tabs = append(tabs.Tabs, tab)
 	tabs.TabStrip.Invalidate()
-	content.OnInvalidate(tabs.invalidateChild)
-	return tab
+	tab.Content.OnInvalidate(tabs.invalidateChild)
 }
 
 func (tabs *Tabs) invalidateChild(d Drawable) {
@@ -147,19 +155,6 @@ func (tabs *Tabs) removeHistory(index int) {
 	tabs.history = newHist
 }
 
-func (tabs *Tabs) MouseEvent(event tcell.Event) {
-	switch event := event.(type) {
-	case *tcell.EventMouse:
-		if event.Buttons()&tcell.Button1 != 0 {
-			x, y := event.Position()
-			selectedTab, ok := tabs.TabStrip.Clicked(x, y)
-			if ok {
-				tabs.Select(selectedTab)
-			}
-		}
-	}
-}
-
 // Registers a handler for the tab to react to a close event
 func (tab *Tab) OnClose(fn func() bool) {
 	tab.onClose = fn
@@ -173,13 +168,13 @@ func (tab *Tab) CanClose() bool {
 	}
 }
 
-// TODO: Color repository
 func (strip *TabStrip) Draw(ctx *Context) {
 	x := 0
 	for i, tab := range strip.Tabs {
-		style := tcell.StyleDefault.Reverse(true)
+		style := strip.uiConfig.GetStyle(config.STYLE_TAB)
 		if strip.Selected == i {
-			style = tcell.StyleDefault
+			style = strip.uiConfig.GetComposedStyle(config.STYLE_TAB,
+				[]config.StyleObject{config.STYLE_TAB_SELECTED})
 		}
 		tabWidth := 32
 		if ctx.Width()-x < tabWidth {
@@ -191,7 +186,7 @@ func (strip *TabStrip) Draw(ctx *Context) {
 			break
 		}
 	}
-	style := tcell.StyleDefault.Reverse(true)
+	style := strip.uiConfig.GetStyle(config.STYLE_TAB)
 	ctx.Fill(x, 0, ctx.Width()-x, 1, ' ', style)
 }
 
@@ -205,17 +200,30 @@ func (strip *TabStrip) OnInvalidate(onInvalidate func(d Drawable)) {
 	strip.onInvalidateStrip = onInvalidate
 }
 
+func (strip *TabStrip) MouseEvent(localX int, localY int, event tcell.Event) {
+	switch event := event.(type) {
+	case *tcell.EventMouse:
+		if event.Buttons()&tcell.Button1 != 0 {
+			if selectedTab, ok := strip.Clicked(localX, localY); ok {
+				(*Tabs)(strip).Select(selectedTab)
+			}
+		}
+	}
+}
+
+// Returns the index of the tab drawn at the given position
 func (strip *TabStrip) Clicked(mouseX int, mouseY int) (int, bool) {
+	if mouseY != 0 {
+		return 0, false
+	}
 	x := 0
-	if mouseY == 0 {
-		for i, tab := range strip.Tabs {
-			trunc := runewidth.Truncate(tab.Name, 32, "…")
-			length := len(trunc) + 2
-			if x <= mouseX && mouseX < x+length {
-				return i, true
-			}
-			x += length
+	for i, tab := range strip.Tabs {
+		trunc := runewidth.Truncate(tab.Name, 32, "…")
+		length := runewidth.StringWidth(trunc) + 2
+		if x <= mouseX && mouseX < x+length {
+			return i, true
 		}
+		x += length
 	}
 	return 0, false
 }
@@ -232,13 +240,24 @@ func (content *TabContent) Draw(ctx *Context) {
 	if content.Selected >= len(content.Tabs) {
 		width := ctx.Width()
 		height := ctx.Height()
-		ctx.Fill(0, 0, width, height, ' ', tcell.StyleDefault)
+		ctx.Fill(0, 0, width, height, ' ',
+			content.uiConfig.GetStyle(config.STYLE_DEFAULT))
 	}
 
 	tab := content.Tabs[content.Selected]
 	tab.Content.Draw(ctx)
 }
 
+func (content *TabContent) MouseEvent(localX int, localY int, event tcell.Event) {
+	if content.Selected >= len(content.Tabs) {
+		return
+	}
+	tab := content.Tabs[content.Selected]
+	if clickable, ok := tab.Content.(Clickable); ok {
+		clickable.MouseEvent(localX, localY, event)
+	}
+}
+
 func (content *TabContent) Invalidate() {
 	if content.onInvalidateContent != nil {
 		content.onInvalidateContent(content)
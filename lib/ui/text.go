@@ -15,17 +15,13 @@ type Text struct {
 	Invalidatable
 	text     string
 	strategy uint
-	fg       tcell.Color
-	bg       tcell.Color
-	bold     bool
-	reverse  bool
+	style    tcell.Style
 }
 
 func NewText(text string) *Text {
 	return &Text{
-		bg:   tcell.ColorDefault,
-		fg:   tcell.ColorDefault,
-		text: text,
+		style: tcell.StyleDefault,
+		text:  text,
 	}
 }
 
@@ -42,20 +38,25 @@ func (t *Text) Strategy(strategy uint) *Text {
 }
 
 func (t *Text) Bold(bold bool) *Text {
-	t.bold = bold
+	t.style = t.style.Bold(bold)
 	t.Invalidate()
 	return t
 }
 
 func (t *Text) Color(fg tcell.Color, bg tcell.Color) *Text {
-	t.fg = fg
-	t.bg = bg
+	t.style = t.style.Foreground(fg).Background(bg)
 	t.Invalidate()
 	return t
 }
 
 func (t *Text) Reverse(reverse bool) *Text {
-	t.reverse = reverse
+	t.style = t.style.Reverse(reverse)
+	t.Invalidate()
+	return t
+}
+
+func (t *Text) Style(style tcell.Style) *Text {
+	t.style = style
 	t.Invalidate()
 	return t
 }
@@ -69,15 +70,8 @@ func (t *Text) Draw(ctx *Context) {
 	if t.strategy == TEXT_RIGHT {
 		x = ctx.Width() - size
 	}
-	style := tcell.StyleDefault.Background(t.bg).Foreground(t.fg)
-	if t.bold {
-		style = style.Bold(true)
-	}
-	if t.reverse {
-		style = style.Reverse(true)
-	}
-	ctx.Fill(0, 0, ctx.Width(), ctx.Height(), ' ', style)
-	ctx.Printf(x, 0, style, "%s", t.text)
+	ctx.Fill(0, 0, ctx.Width(), ctx.Height(), ' ', t.style)
+	ctx.Printf(x, 0, t.style, "%s", t.text)
 }
 
 func (t *Text) Invalidate() {
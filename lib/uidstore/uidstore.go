@@ -2,10 +2,17 @@
 // used by the UI and arbitrary string keys as used by different mail backends.
 //
 // Multiple Store instances can safely be created and the UIDs that they
-// generate will be globally unique.
+// generate will be globally unique, unless they are loaded from a file.
 package uidstore
 
 import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 )
@@ -17,6 +24,12 @@ type Store struct {
 	keyByUID map[uint32]string
 	uidByKey map[string]uint32
 	m        sync.Mutex
+
+	// The last UID given out
+	next *uint32
+	// The file which keeps the mapping across runs, if any
+	path    string
+	changed bool
 }
 
 // NewStore creates a new, empty Store.
@@ -24,7 +37,86 @@ func NewStore() *Store {
 	return &Store{
 		keyByUID: make(map[uint32]string),
 		uidByKey: make(map[string]uint32),
+		next:     &nextUID,
+	}
+}
+
+// Load creates a Store which keeps its mapping in a file, so that keys get the
+// same UIDs in every run. The UIDs are only unique within the store. The file
+// is created by Save if it doesn't exist.
+//
+// The file starts with the last UID given out, followed by a line per key with
+// its UID. Keys can't contain newlines.
+func Load(path string) (*Store, error) {
+	s := NewStore()
+	s.next = new(uint32)
+	s.path = path
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if n == 1 {
+			next, err := strconv.ParseUint(line, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid UID", path, n)
+			}
+			*s.next = uint32(next)
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		uid, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil || len(fields) != 2 || uint32(uid) > *s.next {
+			return nil, fmt.Errorf("%s:%d: invalid entry", path, n)
+		}
+		s.keyByUID[uint32(uid)] = fields[1]
+		s.uidByKey[fields[1]] = uint32(uid)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Save writes the mapping of a Store created with Load to its file, if it
+// changed since it was loaded or last saved.
+func (s *Store) Save() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.path == "" || !s.changed {
+		return nil
 	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	// Write a copy and swap it in, so that a crash can't leave half a file
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), ".uids")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	fmt.Fprintf(w, "%d\n", *s.next)
+	for uid, key := range s.keyByUID {
+		fmt.Fprintf(w, "%d %s\n", uid, key)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	s.changed = false
+	return nil
 }
 
 // GetOrInsert returns the UID for the provided key. If the key was already
@@ -36,9 +128,10 @@ func (s *Store) GetOrInsert(key string) uint32 {
 	if uid, ok := s.uidByKey[key]; ok {
 		return uid
 	}
-	uid := atomic.AddUint32(&nextUID, 1)
+	uid := atomic.AddUint32(s.next, 1)
 	s.keyByUID[uid] = key
 	s.uidByKey[key] = uid
+	s.changed = true
 	return uid
 }
 
@@ -57,6 +150,25 @@ func (s *Store) RemoveUID(uid uint32) {
 	key, ok := s.keyByUID[uid]
 	if ok {
 		delete(s.uidByKey, key)
+		s.changed = true
 	}
 	delete(s.keyByUID, uid)
 }
+
+// Retain removes the keys which are not in keys from the store, e.g. those of
+// messages which are gone.
+func (s *Store) Retain(keys []string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	retained := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		retained[key] = nil
+	}
+	for key, uid := range s.uidByKey {
+		if _, ok := retained[key]; !ok {
+			delete(s.uidByKey, key)
+			delete(s.keyByUID, uid)
+			s.changed = true
+		}
+	}
+}
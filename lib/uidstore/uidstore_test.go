@@ -0,0 +1,43 @@
+package uidstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPersistence(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "uidstore")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache", "INBOX")
+
+	s, err := Load(path)
+	assert.Nil(err)
+	a := s.GetOrInsert("1570000000.a")
+	b := s.GetOrInsert("1570000000.b")
+	c := s.GetOrInsert("1570000000.c")
+	assert.Equal(uint32(1), a)
+	s.Retain([]string{"1570000000.a", "1570000000.c"})
+	assert.Nil(s.Save())
+
+	s, err = Load(path)
+	assert.Nil(err)
+	assert.Equal(a, s.GetOrInsert("1570000000.a"))
+	assert.Equal(c, s.GetOrInsert("1570000000.c"))
+	_, ok := s.GetKey(b)
+	assert.False(ok)
+	// Listing the same keys again doesn't write the file
+	s.Retain([]string{"1570000000.a", "1570000000.c"})
+	assert.Nil(os.Remove(path))
+	assert.Nil(s.Save())
+	_, err = os.Stat(path)
+	assert.True(os.IsNotExist(err))
+	// UIDs of removed keys are not given out again
+	assert.Equal(c+1, s.GetOrInsert("1570000000.d"))
+}
@@ -75,6 +75,14 @@ type FullMessage struct {
 	Uid    uint32
 }
 
+// OriginalMail is the message being replied to or forwarded, along with the
+// text extracted from it
+type OriginalMail struct {
+	Text     string
+	MIMEType string
+	Info     *MessageInfo
+}
+
 type BodyStructure struct {
 	MIMEType          string
 	MIMESubType       string
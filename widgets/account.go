@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/gdamore/tcell"
 
@@ -15,31 +16,67 @@ import (
 	"git.sr.ht/~sircmpwn/aerc/worker/types"
 )
 
+const (
+	SPLIT_NONE = iota
+	SPLIT_HORIZONTAL
+	SPLIT_VERTICAL
+)
+
+// How long the selection must rest on a message before it is previewed, so
+// that scrolling through the list doesn't fetch every message on the way
+const splitDebounce = 250 * time.Millisecond
+
+// Identifies a message, e.g. the one shown in the split preview
+type messageKey struct {
+	store *lib.MessageStore
+	uid   uint32
+}
+
 type AccountView struct {
 	acct    *config.AccountConfig
 	conf    *config.AercConfig
+	content ui.Drawable
 	dirlist *DirectoryList
 	grid    *ui.Grid
 	host    TabHost
 	logger  *log.Logger
 	msglist *MessageList
+	sidebar ui.Drawable
+	uiConf  *config.UIConfig
 	worker  *types.Worker
+
+	split        *MessageViewer
+	splitGrid    *ui.Grid
+	splitPreview ui.Drawable
+	splitDir     int
+	splitSize    int
+	splitShown   messageKey
+	splitPending messageKey
+	splitSince   time.Time
+
+	// The new messages which rules have already been applied to, and those
+	// which arrived in folders which were not selected
+	ruled   map[messageKey]interface{}
+	unruled map[*lib.MessageStore][]uint32
 }
 
 func NewAccountView(conf *config.AercConfig, acct *config.AccountConfig,
 	logger *log.Logger, host TabHost) *AccountView {
 
+	uiConf := conf.GetUiConfig(map[config.ContextType]string{
+		config.UI_CONTEXT_ACCOUNT: acct.Name,
+	})
 	grid := ui.NewGrid().Rows([]ui.GridSpec{
 		{ui.SIZE_WEIGHT, 1},
 	}).Columns([]ui.GridSpec{
-		{ui.SIZE_EXACT, conf.Ui.SidebarWidth},
+		{ui.SIZE_EXACT, 0},
 		{ui.SIZE_WEIGHT, 1},
 	})
 
 	worker, err := worker.NewWorker(acct.Source, logger)
 	if err != nil {
 		host.SetStatus(fmt.Sprintf("%s: %s", acct.Name, err)).
-			Color(tcell.ColorDefault, tcell.ColorRed)
+			Style(config.STYLE_STATUSLINE_ERROR)
 		return &AccountView{
 			acct:   acct,
 			grid:   grid,
@@ -48,24 +85,27 @@ func NewAccountView(conf *config.AercConfig, acct *config.AccountConfig,
 		}
 	}
 
-	dirlist := NewDirectoryList(acct, &conf.Ui, logger, worker)
-	if conf.Ui.SidebarWidth > 0 {
-		grid.AddChild(ui.NewBordered(dirlist, ui.BORDER_RIGHT))
-	}
-
-	msglist := NewMessageList(conf, logger)
+	dirlist := NewDirectoryList(conf, acct, logger, worker)
+	msglist := NewMessageList(conf, acct, logger)
 	grid.AddChild(msglist).At(0, 1)
 
 	view := &AccountView{
 		acct:    acct,
 		conf:    conf,
+		content: msglist,
 		dirlist: dirlist,
 		grid:    grid,
 		host:    host,
 		logger:  logger,
 		msglist: msglist,
+		ruled:   make(map[messageKey]interface{}),
+		unruled: make(map[*lib.MessageStore][]uint32),
+		uiConf:  uiConf,
 		worker:  worker,
 	}
+	view.layoutSidebar()
+
+	msglist.OnOpen(view.openMessage)
 
 	go worker.Backend.Run()
 
@@ -80,6 +120,7 @@ func (acct *AccountView) Tick() bool {
 	if acct.worker == nil {
 		return false
 	}
+	acct.updateSplit()
 	select {
 	case msg := <-acct.worker.Messages:
 		msg = acct.worker.ProcessMessage(msg)
@@ -124,6 +165,10 @@ func (acct *AccountView) Draw(ctx *ui.Context) {
 	acct.grid.Draw(ctx)
 }
 
+func (acct *AccountView) MouseEvent(localX int, localY int, event tcell.Event) {
+	acct.grid.MouseEvent(localX, localY, event)
+}
+
 func (acct *AccountView) Focus(focus bool) {
 	// TODO: Unfocus children I guess
 }
@@ -185,17 +230,36 @@ func (acct *AccountView) SelectedMessagePart() *PartInfo {
 	return nil
 }
 
+func (acct *AccountView) openMessage(msg *models.MessageInfo) {
+	viewer := NewMessageViewer(acct, acct.conf, acct.msglist.Store(), msg)
+	acct.host.NewTab(viewer, msg.Envelope.Subject)
+}
+
 func (acct *AccountView) onMessage(msg types.WorkerMessage) {
 	switch msg := msg.(type) {
 	case *types.Done:
 		switch msg.InResponseTo().(type) {
 		case *types.OpenDirectory:
+			acct.host.Notify("folder-changed", map[string]interface{}{
+				"account": acct.Name(),
+				"folder":  acct.dirlist.Selected(),
+			})
+			acct.conf.Hooks.Run("folder-changed", config.HookContext{
+				Account: acct.Name(),
+				Folder:  acct.dirlist.Selected(),
+			})
+			acct.uiConf = acct.conf.GetUiConfig(map[config.ContextType]string{
+				config.UI_CONTEXT_ACCOUNT: acct.Name(),
+				config.UI_CONTEXT_FOLDER:  acct.dirlist.Selected(),
+			})
+			acct.layoutSidebar()
 			if store, ok := acct.dirlist.SelectedMsgStore(); ok {
 				// If we've opened this dir before, we can re-render it from
 				// memory while we wait for the update and the UI feels
 				// snappier. If not, we'll unset the store and show the spinner
 				// while we download the UID list.
 				acct.msglist.SetStore(store)
+				acct.applyPendingRules()
 			} else {
 				acct.msglist.SetStore(nil)
 			}
@@ -208,20 +272,42 @@ func (acct *AccountView) onMessage(msg types.WorkerMessage) {
 		} else {
 			store = lib.NewMessageStore(acct.worker, msg.Info,
 				func(msg *models.MessageInfo) {
-					acct.conf.Triggers.ExecNewEmail(acct.acct,
-						acct.conf, msg)
+					if err := acct.conf.Triggers.ExecNewEmail(acct.acct,
+						acct.conf, msg); err != nil {
+						acct.host.PushStatus(" "+err.Error(), 10*time.Second).
+							Style(config.STYLE_STATUSLINE_ERROR)
+					}
+					acct.conf.Hooks.Run("mail-received", config.HookContext{
+						Account:         acct.Name(),
+						Folder:          store.DirInfo.Name,
+						Message:         msg,
+						TimestampFormat: acct.uiConf.TimestampFormat,
+					})
+					acct.host.Notify("new-mail", map[string]interface{}{
+						"account": acct.Name(),
+						"folder":  store.DirInfo.Name,
+						"message": newRPCMessage(msg),
+					})
+					acct.host.NewMail(acct, store.DirInfo.Name, msg)
+					acct.applyNewRules(store, msg.Uid)
 				}, func() {
-					if acct.conf.Ui.NewMessageBell {
+					if acct.uiConf.NewMessageBell {
 						acct.host.Beep()
 					}
 				})
 			acct.dirlist.SetMsgStore(msg.Info.Name, store)
+			store.Update(msg)
 			store.OnUpdate(func(_ *lib.MessageStore) {
 				store.OnUpdate(nil)
 				acct.msglist.SetStore(store)
 			})
 		}
 	case *types.DirectoryContents:
+		if store, ok := acct.dirlist.SelectedMsgStore(); ok {
+			store.Update(msg)
+			acct.pruneRules(store)
+		}
+	case *types.MessagesAdded:
 		if store, ok := acct.dirlist.SelectedMsgStore(); ok {
 			store.Update(msg)
 		}
@@ -231,15 +317,213 @@ func (acct *AccountView) onMessage(msg types.WorkerMessage) {
 		}
 	case *types.MessageInfo:
 		if store, ok := acct.dirlist.SelectedMsgStore(); ok {
+			existing := store.Messages[msg.Info.Uid]
+			var flags []models.Flag
+			if existing != nil {
+				flags = existing.Flags
+			}
 			store.Update(msg)
+			if existing != nil {
+				acct.flagsChanged(store, existing, flags)
+			}
 		}
 	case *types.MessagesDeleted:
 		if store, ok := acct.dirlist.SelectedMsgStore(); ok {
 			store.Update(msg)
+			acct.pruneRules(store)
 		}
 	case *types.Error:
 		acct.logger.Printf("%v", msg.Error)
 		acct.host.SetStatus(fmt.Sprintf("%v", msg.Error)).
-			Color(tcell.ColorDefault, tcell.ColorRed)
+			Style(config.STYLE_STATUSLINE_ERROR)
+	}
+}
+
+// The flags the flag-changed hook is run for
+var hookFlags = []models.Flag{
+	models.SeenFlag, models.AnsweredFlag, models.FlaggedFlag,
+	models.DeletedFlag,
+}
+
+// Runs the flag-changed hook for each flag of a message which changed since
+// it was listed, whether in aerc or by another client
+func (acct *AccountView) flagsChanged(store *lib.MessageStore,
+	msg *models.MessageInfo, old []models.Flag) {
+
+	has := func(flags []models.Flag, flag models.Flag) bool {
+		for _, f := range flags {
+			if f == flag {
+				return true
+			}
+		}
+		return false
+	}
+	for _, flag := range hookFlags {
+		set := has(msg.Flags, flag)
+		if set == has(old, flag) {
+			continue
+		}
+		acct.conf.Hooks.Run("flag-changed", config.HookContext{
+			Account:         acct.Name(),
+			Folder:          store.DirInfo.Name,
+			Flag:            rpcFlagNames[flag],
+			FlagValue:       set,
+			Message:         msg,
+			TimestampFormat: acct.uiConf.TimestampFormat,
+		})
+	}
+}
+
+// Split shows a preview of the selected message below the message list, n
+// rows high. If n is zero, the preview-height option is used.
+func (acct *AccountView) Split(n int) error {
+	if n == 0 && acct.uiConf != nil {
+		n = acct.uiConf.PreviewHeight
+	}
+	return acct.setSplit(SPLIT_HORIZONTAL, n)
+}
+
+// Vsplit shows a preview of the selected message beside the message list, n
+// columns wide. If n is zero, the list and the preview are given equal space.
+func (acct *AccountView) Vsplit(n int) error {
+	return acct.setSplit(SPLIT_VERTICAL, n)
+}
+
+// CloseSplit removes the message preview
+func (acct *AccountView) CloseSplit() error {
+	return acct.setSplit(SPLIT_NONE, 0)
+}
+
+func (acct *AccountView) setSplit(dir int, n int) error {
+	if acct.msglist == nil {
+		return errors.New("No message list to split")
+	}
+	if n < 0 {
+		return errors.New("Split size must not be negative")
+	}
+	acct.splitDir = dir
+	acct.splitSize = n
+	acct.splitShown = acct.selectedSplitKey()
+	acct.splitPending = acct.splitShown
+	acct.layoutSplit()
+	return nil
+}
+
+func (acct *AccountView) selectedSplitKey() messageKey {
+	if acct.msglist.Empty() {
+		return messageKey{}
+	}
+	msg := acct.msglist.Selected()
+	if msg == nil {
+		// Headers are still loading
+		return messageKey{}
+	}
+	return messageKey{acct.msglist.Store(), msg.Uid}
+}
+
+// updateSplit previews the selected message once the selection has rested
+// on it for a while.
+func (acct *AccountView) updateSplit() {
+	if acct.splitDir == SPLIT_NONE {
+		return
+	}
+	key := acct.selectedSplitKey()
+	if key == acct.splitShown {
+		acct.splitPending = key
+		return
+	}
+	if key != acct.splitPending {
+		acct.splitPending = key
+		acct.splitSince = time.Now()
+		return
+	}
+	if time.Since(acct.splitSince) < splitDebounce {
+		return
+	}
+	acct.splitShown = key
+	acct.showSplit()
+}
+
+// Sizes the directory list after the sidebar-width of the current folder
+func (acct *AccountView) layoutSidebar() {
+	if acct.sidebar != nil {
+		acct.grid.RemoveChild(acct.sidebar)
+		acct.sidebar = nil
+	}
+	width := acct.uiConf.SidebarWidth
+	if width < 0 {
+		width = 0
+	}
+	acct.grid.Columns([]ui.GridSpec{
+		{ui.SIZE_EXACT, width},
+		{ui.SIZE_WEIGHT, 1},
+	})
+	if width > 0 {
+		acct.sidebar = ui.NewBordered(
+			acct.dirlist, ui.BORDER_RIGHT, acct.uiConf)
+		acct.grid.AddChild(acct.sidebar)
+	}
+	acct.grid.Invalidate()
+}
+
+// Lays out the message list and the preview after the split settings
+func (acct *AccountView) layoutSplit() {
+	acct.grid.RemoveChild(acct.content)
+	acct.content = acct.msglist
+	acct.splitGrid = nil
+	acct.splitPreview = nil
+	if acct.splitDir == SPLIT_NONE {
+		acct.closeSplit()
+	} else {
+		size := ui.GridSpec{ui.SIZE_WEIGHT, 1}
+		if acct.splitSize > 0 {
+			size = ui.GridSpec{ui.SIZE_EXACT, acct.splitSize}
+		}
+		grid := ui.NewGrid()
+		if acct.splitDir == SPLIT_HORIZONTAL {
+			grid.Rows([]ui.GridSpec{{ui.SIZE_WEIGHT, 1}, size}).
+				Columns([]ui.GridSpec{{ui.SIZE_WEIGHT, 1}})
+			grid.AddChild(ui.NewBordered(
+				acct.msglist, ui.BORDER_BOTTOM, acct.uiConf))
+		} else {
+			grid.Rows([]ui.GridSpec{{ui.SIZE_WEIGHT, 1}}).
+				Columns([]ui.GridSpec{{ui.SIZE_WEIGHT, 1}, size})
+			grid.AddChild(ui.NewBordered(
+				acct.msglist, ui.BORDER_RIGHT, acct.uiConf))
+		}
+		acct.splitGrid = grid
+		acct.content = grid
+		acct.showSplit()
+	}
+	acct.grid.AddChild(acct.content).At(0, 1)
+}
+
+// Shows the message to preview in the split, in place of the previous one
+func (acct *AccountView) showSplit() {
+	acct.closeSplit()
+	if acct.splitPreview != nil {
+		acct.splitGrid.RemoveChild(acct.splitPreview)
+	}
+	var preview ui.Drawable = ui.NewFill(' ')
+	if acct.splitShown.store != nil {
+		store := acct.splitShown.store
+		if msg := store.Messages[acct.splitShown.uid]; msg != nil {
+			acct.split = NewMessageViewer(acct, acct.conf, store, msg)
+			preview = acct.split
+		}
+	}
+	acct.splitPreview = preview
+	if acct.splitDir == SPLIT_HORIZONTAL {
+		acct.splitGrid.AddChild(preview).At(1, 0)
+	} else {
+		acct.splitGrid.AddChild(preview).At(0, 1)
+	}
+}
+
+// Stops the pagers of the message previewed
+func (acct *AccountView) closeSplit() {
+	if acct.split != nil {
+		acct.split.Close()
+		acct.split = nil
 	}
 }
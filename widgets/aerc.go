@@ -2,19 +2,23 @@ package widgets
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gdamore/tcell"
-	"github.com/google/shlex"
 
 	"git.sr.ht/~sircmpwn/aerc/config"
 	"git.sr.ht/~sircmpwn/aerc/lib"
+	"git.sr.ht/~sircmpwn/aerc/lib/notify"
+	"git.sr.ht/~sircmpwn/aerc/lib/outbox"
 	"git.sr.ht/~sircmpwn/aerc/lib/ui"
 	libui "git.sr.ht/~sircmpwn/aerc/lib/ui"
+	"git.sr.ht/~sircmpwn/aerc/models"
 )
 
 type Aerc struct {
@@ -26,6 +30,12 @@ type Aerc struct {
 	focused     libui.Interactive
 	grid        *libui.Grid
 	logger      *log.Logger
+	notifier    *notify.Notifier
+	onEvent     func(event string, data interface{})
+	outbox      *outbox.Outbox
+	pending     []*pendingSend
+	posted      []func()
+	postedMutex sync.Mutex
 	simulating  int
 	statusbar   *libui.Stack
 	statusline  *StatusLine
@@ -38,10 +48,10 @@ func NewAerc(conf *config.AercConfig, logger *log.Logger,
 	cmd func(cmd []string) error, complete func(cmd string) []string,
 	cmdHistory lib.History) *Aerc {
 
-	tabs := libui.NewTabs()
+	tabs := libui.NewTabs(&conf.Ui)
 
 	statusbar := ui.NewStack()
-	statusline := NewStatusLine()
+	statusline := NewStatusLine(&conf.Ui)
 	statusbar.Push(statusline)
 
 	grid := libui.NewGrid().Rows([]libui.GridSpec{
@@ -63,6 +73,7 @@ func NewAerc(conf *config.AercConfig, logger *log.Logger,
 		complete:   complete,
 		grid:       grid,
 		logger:     logger,
+		notifier:   notify.NewNotifier(&conf.Notifications),
 		statusbar:  statusbar,
 		statusline: statusline,
 		tabs:       tabs,
@@ -70,6 +81,10 @@ func NewAerc(conf *config.AercConfig, logger *log.Logger,
 
 	statusline.SetAerc(aerc)
 	conf.Triggers.ExecuteCommand = cmd
+	conf.Hooks.OnError = func(err error) {
+		aerc.PushError(" " + err.Error())
+	}
+	aerc.notifier.OnError = conf.Hooks.OnError
 
 	for i, acct := range conf.Accounts {
 		view := NewAccountView(conf, &conf.Accounts[i], logger, aerc)
@@ -100,14 +115,52 @@ func (aerc *Aerc) Beep() {
 	}
 }
 
+// NewMail shows a desktop notification for a new message, if enabled
+func (aerc *Aerc) NewMail(acct *AccountView, folder string,
+	msg *models.MessageInfo) {
+
+	aerc.notifier.NewMail(acct.Name(), folder,
+		acct.uiConf.TimestampFormat, msg)
+}
+
 func (aerc *Aerc) Tick() bool {
 	more := false
 	for _, acct := range aerc.accounts {
 		more = acct.Tick() || more
 	}
+	aerc.postedMutex.Lock()
+	posted := aerc.posted
+	aerc.posted = nil
+	aerc.postedMutex.Unlock()
+	for _, fn := range posted {
+		fn()
+		more = true
+	}
 	return more
 }
 
+// Post runs fn on the main goroutine during a later Tick, without waiting for
+// it. Functions run in the order they were posted. It may be called from any
+// goroutine.
+func (aerc *Aerc) Post(fn func()) {
+	aerc.postedMutex.Lock()
+	aerc.posted = append(aerc.posted, fn)
+	aerc.postedMutex.Unlock()
+}
+
+// Registers a function to call when something happens which may interest
+// clients of the control socket, e.g. new mail. It may be called from any
+// goroutine.
+func (aerc *Aerc) OnEvent(fn func(event string, data interface{})) {
+	aerc.onEvent = fn
+}
+
+func (aerc *Aerc) Notify(event string, data interface{}) {
+	if aerc.onEvent != nil {
+		aerc.onEvent(event, data)
+	}
+}
+
 func (aerc *Aerc) Children() []ui.Drawable {
 	return aerc.grid.Children()
 }
@@ -219,7 +272,9 @@ func (aerc *Aerc) Event(event tcell.Event) bool {
 			return false
 		}
 	case *tcell.EventMouse:
-		aerc.tabs.MouseEvent(event)
+		x, y := event.Position()
+		aerc.grid.MouseEvent(x, y, event)
+		return true
 	}
 	return false
 }
@@ -232,6 +287,18 @@ func (aerc *Aerc) Logger() *log.Logger {
 	return aerc.logger
 }
 
+// SetOutbox sets the outbox which messages are sent from
+func (aerc *Aerc) SetOutbox(o *outbox.Outbox) {
+	aerc.outbox = o
+	o.OnChange = aerc.statusline.Invalidate
+}
+
+// Outbox returns the outbox which messages are sent from, or nil if there is
+// none
+func (aerc *Aerc) Outbox() *outbox.Outbox {
+	return aerc.outbox
+}
+
 func (aerc *Aerc) SelectedAccount() *AccountView {
 	acct, ok := aerc.accounts[aerc.tabs.Tabs[aerc.tabs.Selected].Name]
 	if !ok {
@@ -240,6 +307,11 @@ func (aerc *Aerc) SelectedAccount() *AccountView {
 	return acct
 }
 
+// Account returns the view of the named account, or nil if there is none
+func (aerc *Aerc) Account(name string) *AccountView {
+	return aerc.accounts[name]
+}
+
 func (aerc *Aerc) SelectedTab() ui.Drawable {
 	return aerc.tabs.Tabs[aerc.tabs.Selected].Content
 }
@@ -250,6 +322,16 @@ func (aerc *Aerc) NewTab(drawable ui.Drawable, name string) *ui.Tab {
 	return tab
 }
 
+// HasTab reports whether content is shown in one of the tabs
+func (aerc *Aerc) HasTab(content ui.Drawable) bool {
+	for _, tab := range aerc.tabs.Tabs {
+		if tab.Content == content {
+			return true
+		}
+	}
+	return false
+}
+
 func (aerc *Aerc) RemoveTab(tab ui.Drawable) {
 	aerc.tabs.Remove(tab)
 }
@@ -320,12 +402,20 @@ func (aerc *Aerc) SetStatus(status string) *StatusMessage {
 	return aerc.statusline.Set(status)
 }
 
+func (aerc *Aerc) SetError(status string) {
+	aerc.statusline.Set(status).Style(config.STYLE_STATUSLINE_ERROR)
+}
+
 func (aerc *Aerc) PushStatus(text string, expiry time.Duration) *StatusMessage {
 	return aerc.statusline.Push(text, expiry)
 }
 
 func (aerc *Aerc) PushError(text string) {
-	aerc.PushStatus(text, 10*time.Second).Color(tcell.ColorDefault, tcell.ColorRed)
+	aerc.PushStatus(text, 10*time.Second).Style(config.STYLE_STATUSLINE_ERROR)
+}
+
+func (aerc *Aerc) PushSuccess(text string) {
+	aerc.PushStatus(text, 10*time.Second).Style(config.STYLE_STATUSLINE_SUCCESS)
 }
 
 func (aerc *Aerc) focus(item libui.Interactive) {
@@ -352,15 +442,8 @@ func (aerc *Aerc) focus(item libui.Interactive) {
 func (aerc *Aerc) BeginExCommand() {
 	previous := aerc.focused
 	exline := NewExLine(func(cmd string) {
-		parts, err := shlex.Split(cmd)
-		if err != nil {
-			aerc.PushStatus(" "+err.Error(), 10*time.Second).
-				Color(tcell.ColorDefault, tcell.ColorRed)
-		}
-		err = aerc.cmd(parts)
-		if err != nil {
-			aerc.PushStatus(" "+err.Error(), 10*time.Second).
-				Color(tcell.ColorDefault, tcell.ColorRed)
+		if err := aerc.RunCommands(cmd); err != nil {
+			aerc.PushError(" " + err.Error())
 		}
 		// only add to history if this is an unsimulated command,
 		// ie one not executed from a keybinding
@@ -379,6 +462,39 @@ func (aerc *Aerc) BeginExCommand() {
 	aerc.focus(exline)
 }
 
+// Confirm asks a yes or no question in the status bar, and calls fn with the
+// answer. Any other key than y or n is ignored, except escape for no.
+func (aerc *Aerc) Confirm(question string, fn func(yes bool)) {
+	previous := aerc.focused
+	confirm := NewConfirm(question, func(yes bool) {
+		aerc.statusbar.Pop()
+		aerc.focus(previous)
+		fn(yes)
+	}, &aerc.conf.Ui)
+	aerc.statusbar.Push(confirm)
+	aerc.focus(confirm)
+}
+
+// Complete returns the completions of a command line, as the command line does
+func (aerc *Aerc) Complete(cmd string) []string {
+	return aerc.complete(cmd)
+}
+
+// RunCommands runs a line of commands separated by semicolons. It stops at the
+// first command which fails.
+func (aerc *Aerc) RunCommands(line string) error {
+	cmds, err := lib.SplitCommands(line)
+	if err != nil {
+		return err
+	}
+	for _, args := range cmds {
+		if err := aerc.cmd(args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (aerc *Aerc) Mailto(addr *url.URL) error {
 	acct := aerc.SelectedAccount()
 	if acct == nil {
@@ -396,8 +512,15 @@ func (aerc *Aerc) Mailto(addr *url.URL) error {
 			defaults[header] = strings.Join(vals, ",")
 		}
 	}
-	composer := NewComposer(aerc.Config(),
-		acct.AccountConfig(), acct.Worker(), defaults)
+	composer, err := NewComposer(aerc.Config(), acct.AccountConfig(),
+		acct.Worker(), aerc.Config().Templates.NewMessage, defaults,
+		models.OriginalMail{})
+	if err != nil {
+		return err
+	}
+	if body := addr.Query().Get("body"); body != "" {
+		composer.SetContents(strings.NewReader(body))
+	}
 	composer.FocusSubject()
 	title := "New email"
 	if subj, ok := defaults["Subject"]; ok {
@@ -419,6 +542,76 @@ func (aerc *Aerc) Mailto(addr *url.URL) error {
 	return nil
 }
 
+// A message which :send waits to send, so that it can be undone
+type pendingSend struct {
+	tab      *ui.Tab
+	send     func() error
+	canceled bool
+}
+
+// SendLater removes the tab of a composer, and calls send after delay unless
+// UndoSend is called before. If send fails, the tab is added back.
+func (aerc *Aerc) SendLater(composer *Composer, delay time.Duration,
+	send func() error) {
+
+	tab := &ui.Tab{Content: composer, Name: "New email"}
+	for _, t := range aerc.tabs.Tabs {
+		if t.Content == composer {
+			tab = t
+		}
+	}
+	aerc.tabs.Remove(composer)
+	p := &pendingSend{tab: tab, send: send}
+	aerc.pending = append(aerc.pending, p)
+	aerc.PushStatus(fmt.Sprintf(" Sending in %v, :undo-send to cancel",
+		delay), delay)
+	time.AfterFunc(delay, func() {
+		aerc.Post(func() {
+			aerc.sendPending(p)
+		})
+	})
+}
+
+func (aerc *Aerc) sendPending(p *pendingSend) {
+	if p.canceled {
+		return
+	}
+	for i, q := range aerc.pending {
+		if q == p {
+			aerc.pending = append(aerc.pending[:i], aerc.pending[i+1:]...)
+			break
+		}
+	}
+	if err := p.send(); err != nil {
+		aerc.PushError(" " + err.Error())
+		aerc.tabs.AddTab(p.tab)
+		aerc.tabs.Select(len(aerc.tabs.Tabs) - 1)
+	}
+}
+
+// UndoSend cancels the last message waiting to be sent, and adds back the tab
+// of its composer as it was.
+func (aerc *Aerc) UndoSend() error {
+	if len(aerc.pending) == 0 {
+		return errors.New("No message waiting to be sent")
+	}
+	p := aerc.pending[len(aerc.pending)-1]
+	aerc.pending = aerc.pending[:len(aerc.pending)-1]
+	p.canceled = true
+	aerc.tabs.AddTab(p.tab)
+	aerc.tabs.Select(len(aerc.tabs.Tabs) - 1)
+	aerc.PushStatus(" Sending canceled", 10*time.Second)
+	return nil
+}
+
+// SendPending sends the messages waiting to be sent right away, e.g. before
+// aerc exits.
+func (aerc *Aerc) SendPending() {
+	for len(aerc.pending) != 0 {
+		aerc.sendPending(aerc.pending[0])
+	}
+}
+
 func (aerc *Aerc) CloseBackends() error {
 	var returnErr error
 	for _, acct := range aerc.accounts {
@@ -2,6 +2,7 @@ package widgets
 
 import (
 	"bufio"
+	"bytes"
 	"io"
 	"io/ioutil"
 	"mime"
@@ -17,10 +18,14 @@ import (
 	"github.com/emersion/go-message/mail"
 	"github.com/gdamore/tcell"
 	"github.com/mattn/go-runewidth"
+	"github.com/mitchellh/go-homedir"
 	"github.com/pkg/errors"
 
 	"git.sr.ht/~sircmpwn/aerc/config"
+	"git.sr.ht/~sircmpwn/aerc/lib/flowed"
+	"git.sr.ht/~sircmpwn/aerc/lib/templates"
 	"git.sr.ht/~sircmpwn/aerc/lib/ui"
+	"git.sr.ht/~sircmpwn/aerc/models"
 	"git.sr.ht/~sircmpwn/aerc/worker/types"
 )
 
@@ -34,10 +39,18 @@ type Composer struct {
 	editor      *Terminal
 	email       *os.File
 	attachments []string
-	grid        *ui.Grid
-	header      *ui.Grid
-	review      *reviewMessage
-	worker      *types.Worker
+	// Content types of attachments which are known in advance, by path
+	attachmentTypes map[string]attachmentType
+	grid            *ui.Grid
+	header          *ui.Grid
+	review          *reviewMessage
+	// The signature which was added to the message
+	sig string
+	// Whether the message is being checked before it is sent
+	sending bool
+	worker  *types.Worker
+	// The warnings of the checks last shown in the review
+	warnings []string
 
 	layout    HeaderLayout
 	focusable []ui.DrawableInteractive
@@ -47,7 +60,8 @@ type Composer struct {
 }
 
 func NewComposer(conf *config.AercConfig,
-	acct *config.AccountConfig, worker *types.Worker, defaults map[string]string) *Composer {
+	acct *config.AccountConfig, worker *types.Worker, template string,
+	defaults map[string]string, original models.OriginalMail) (*Composer, error) {
 
 	if defaults == nil {
 		defaults = make(map[string]string)
@@ -56,13 +70,13 @@ func NewComposer(conf *config.AercConfig,
 		defaults["From"] = acct.From
 	}
 
+	templateData := templates.ParseTemplateData(defaults, original)
 	layout, editors, focusable := buildComposeHeader(
-		conf.Compose.HeaderLayout, defaults)
+		&conf.Ui, conf.Compose.HeaderLayout, defaults)
 
 	email, err := ioutil.TempFile("", "aerc-compose-*.eml")
 	if err != nil {
-		// TODO: handle this better
-		return nil
+		return nil, err
 	}
 
 	c := &Composer{
@@ -78,13 +92,25 @@ func NewComposer(conf *config.AercConfig,
 		focusable: focusable,
 	}
 
+	if template != "" {
+		if err := c.AddTemplate(template, templateData); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if err := c.AddSignature(); err != nil {
+		c.Close()
+		return nil, err
+	}
+
 	c.updateGrid()
 	c.ShowTerminal()
 
-	return c
+	return c, nil
 }
 
-func buildComposeHeader(layout HeaderLayout, defaults map[string]string) (
+func buildComposeHeader(uiConf *config.UIConfig, layout HeaderLayout,
+	defaults map[string]string) (
 	newLayout HeaderLayout,
 	editors map[string]*headerEditor,
 	focusable []ui.DrawableInteractive,
@@ -94,7 +120,7 @@ func buildComposeHeader(layout HeaderLayout, defaults map[string]string) (
 
 	for _, row := range layout {
 		for _, h := range row {
-			e := newHeaderEditor(h, "")
+			e := newHeaderEditor(h, "", uiConf)
 			editors[h] = e
 			switch h {
 			case "From":
@@ -110,7 +136,7 @@ func buildComposeHeader(layout HeaderLayout, defaults map[string]string) (
 	for _, h := range []string{"Cc", "Bcc"} {
 		if val, ok := defaults[h]; ok && val != "" {
 			if _, ok := editors[h]; !ok {
-				e := newHeaderEditor(h, "")
+				e := newHeaderEditor(h, "", uiConf)
 				editors[h] = e
 				focusable = append(focusable, e)
 				layout = append(layout, []string{h})
@@ -128,16 +154,134 @@ func buildComposeHeader(layout HeaderLayout, defaults map[string]string) (
 	return layout, editors, focusable
 }
 
+// SetContents replaces the message with the contents of reader, and appends
+// the signature which was added to the message to it again.
+//
 // Note: this does not reload the editor. You must call this before the first
 // Draw() call.
 func (c *Composer) SetContents(reader io.Reader) *Composer {
 	c.email.Seek(0, os.SEEK_SET)
-	io.Copy(c.email, reader)
+	c.email.Truncate(0)
+	if c.sig == "" {
+		io.Copy(c.email, reader)
+	} else {
+		contents, _ := ioutil.ReadAll(reader)
+		c.email.WriteString(withSignature(string(contents), c.sig))
+	}
 	c.email.Sync()
 	c.email.Seek(0, os.SEEK_SET)
 	return c
 }
 
+// AddTemplate executes the named template with the given data and uses the
+// result as the contents of the message.
+func (c *Composer) AddTemplate(template string, data interface{}) error {
+	templateText, err := templates.ParseTemplateFromFile(
+		template, c.config.Templates.TemplateDirs, data)
+	if err != nil {
+		return err
+	}
+	c.SetContents(bytes.NewReader(templateText))
+	return nil
+}
+
+// AddSignature appends the account's configured signature to the message.
+// Like SetContents, this must be called before the first Draw() call.
+func (c *Composer) AddSignature() error {
+	sig, err := readSignature(c.acct.SignatureFile, c.acct.SignatureCmd)
+	if err != nil {
+		return err
+	}
+	return c.replaceSignature(sig)
+}
+
+// SetSignature replaces the signature which was added to the message with
+// the contents of file, or with the output of cmd if it is set. If both are
+// empty, the signature is removed.
+//
+// The editor must be closed, as it would write its own copy of the message
+// back otherwise.
+func (c *Composer) SetSignature(file, cmd string) error {
+	if c.editor != nil {
+		return errors.New(
+			"Close the editor before changing the signature")
+	}
+	sig, err := readSignature(file, cmd)
+	if err != nil {
+		return err
+	}
+	return c.replaceSignature(sig)
+}
+
+// Returns the lines which separate sig from the message and make it up
+func signatureBlock(sig string) string {
+	return "\n-- \n" + strings.TrimRight(sig, "\n") + "\n"
+}
+
+// Returns a message with sig appended to it
+func withSignature(contents, sig string) string {
+	return strings.TrimRight(contents, "\n") + "\n" + signatureBlock(sig)
+}
+
+// Removes the signature which was added to the message, if any, and appends
+// sig instead. Text which only looks like a signature, e.g. in a quoted
+// message, is kept.
+func (c *Composer) replaceSignature(sig string) error {
+	if c.sig == "" && sig == "" {
+		return nil
+	}
+	name := c.email.Name()
+	contents, err := ioutil.ReadFile(name)
+	if err != nil {
+		return errors.Wrap(err, "ReadFile")
+	}
+	body := string(contents)
+	if c.sig != "" {
+		block := signatureBlock(c.sig)
+		// The signature is left alone if it was edited
+		if i := strings.LastIndex(body, block); i != -1 {
+			body = body[:i] + body[i+len(block):]
+		}
+	}
+	if sig != "" {
+		body = withSignature(body, sig)
+	}
+	if err := ioutil.WriteFile(name, []byte(body), 0600); err != nil {
+		return errors.Wrap(err, "WriteFile")
+	}
+	c.sig = sig
+	c.email.Close()
+	c.email, err = os.OpenFile(name, os.O_RDWR, 0600)
+	if err != nil {
+		return errors.Wrap(err, "OpenFile")
+	}
+	return nil
+}
+
+// readSignature returns the output of cmd if set, or otherwise the contents
+// of file.
+func readSignature(file, cmd string) (string, error) {
+	if cmd != "" {
+		out, err := exec.Command("sh", "-c", cmd).Output()
+		if err != nil {
+			return "", errors.Wrap(err, "signature-cmd")
+		}
+		return string(out), nil
+	}
+	if file != "" {
+		path, err := homedir.Expand(file)
+		if err != nil {
+			return "", err
+		}
+		sig, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", errors.Wrap(err, "signature-file")
+		}
+		return string(sig), nil
+	}
+	return "", nil
+}
+
 func (c *Composer) FocusTerminal() *Composer {
 	if c.editor == nil {
 		return c
@@ -189,6 +333,15 @@ func (c *Composer) OnInvalidate(fn func(d ui.Drawable)) {
 	})
 }
 
+// Sending reports whether the message is being checked before it is sent
+func (c *Composer) Sending() bool {
+	return c.sending
+}
+
+func (c *Composer) SetSending(sending bool) {
+	c.sending = sending
+}
+
 func (c *Composer) Close() {
 	for _, onClose := range c.onClose {
 		onClose(c)
@@ -222,6 +375,10 @@ func (c *Composer) Event(event tcell.Event) bool {
 	return false
 }
 
+func (c *Composer) MouseEvent(localX int, localY int, event tcell.Event) {
+	c.grid.MouseEvent(localX, localY, event)
+}
+
 func (c *Composer) Focus(focus bool) {
 	c.focusable[c.focused].Focus(focus)
 }
@@ -320,6 +477,74 @@ func (c *Composer) PrepareHeader() (*mail.Header, []string, error) {
 	return &header, rcpts, nil
 }
 
+// OutgoingMessage returns what the checks of the [checks] section look at in
+// the message about to be sent, with the header from PrepareHeader and the
+// content written by WriteMessage. The checks may take a while, so they are
+// left to the caller to run.
+func (c *Composer) OutgoingMessage(header *mail.Header,
+	content []byte) (*config.OutgoingMessage, error) {
+
+	text, err := c.text()
+	if err != nil {
+		return nil, err
+	}
+	subject, _ := header.Subject()
+	return &config.OutgoingMessage{
+		Subject:     subject,
+		To:          addresses(header, "To"),
+		Cc:          addresses(header, "Cc"),
+		Bcc:         addresses(header, "Bcc"),
+		Body:        text,
+		Attachments: c.attachments,
+		Content:     content,
+	}, nil
+}
+
+// ShowWarnings shows the warnings of the checks in the review, until the
+// message is edited
+func (c *Composer) ShowWarnings(warnings []string) {
+	c.warnings = warnings
+	if c.review != nil {
+		c.grid.RemoveChild(c.review)
+		c.review = newReviewMessage(c, nil)
+		c.grid.AddChild(c.review).At(1, 0)
+	}
+}
+
+func addresses(header *mail.Header, key string) []string {
+	list, _ := header.AddressList(key)
+	addrs := make([]string, len(list))
+	for i, addr := range list {
+		addrs[i] = addr.Address
+	}
+	return addrs
+}
+
+// Returns the text of the message, as written in the editor
+func (c *Composer) text() (string, error) {
+	if err := c.reloadEmail(); err != nil {
+		return "", err
+	}
+	var body io.Reader
+	reader, err := mail.CreateReader(c.email)
+	if err == nil {
+		part, err := reader.NextPart()
+		if err != nil {
+			return "", errors.Wrap(err, "reader.NextPart")
+		}
+		body = part.Body
+		defer reader.Close()
+	} else {
+		c.email.Seek(0, os.SEEK_SET)
+		body = c.email
+	}
+	text, err := ioutil.ReadAll(body)
+	if err != nil {
+		return "", errors.Wrap(err, "ReadAll")
+	}
+	return string(text), nil
+}
+
 func (c *Composer) WriteMessage(header *mail.Header, writer io.Writer) error {
 	if err := c.reloadEmail(); err != nil {
 		return err
@@ -340,9 +565,19 @@ func (c *Composer) WriteMessage(header *mail.Header, writer io.Writer) error {
 		body = c.email
 	}
 
+	params := map[string]string{"charset": "UTF-8"}
+	if c.config.Compose.FormatFlowed {
+		buf := new(bytes.Buffer)
+		if _, err := buf.ReadFrom(body); err != nil {
+			return errors.Wrap(err, "ReadFrom")
+		}
+		body = strings.NewReader(flowed.Flow(buf.String(), 72))
+		params["format"] = "flowed"
+	}
+
 	if len(c.attachments) == 0 {
 		// don't create a multipart email if we only have text
-		return writeInlineBody(header, body, writer)
+		return writeInlineBody(header, params, body, writer)
 	}
 
 	// otherwise create a multipart email,
@@ -353,12 +588,12 @@ func (c *Composer) WriteMessage(header *mail.Header, writer io.Writer) error {
 	}
 	defer w.Close()
 
-	if err := writeMultipartBody(body, w); err != nil {
+	if err := writeMultipartBody(params, body, w); err != nil {
 		return errors.Wrap(err, "writeMultipartBody")
 	}
 
 	for _, a := range c.attachments {
-		if err := writeAttachment(a, w); err != nil {
+		if err := writeAttachment(a, c.attachmentTypes[a], w); err != nil {
 			return errors.Wrap(err, "writeAttachment")
 		}
 	}
@@ -366,8 +601,10 @@ func (c *Composer) WriteMessage(header *mail.Header, writer io.Writer) error {
 	return nil
 }
 
-func writeInlineBody(header *mail.Header, body io.Reader, writer io.Writer) error {
-	header.SetContentType("text/plain", map[string]string{"charset": "UTF-8"})
+func writeInlineBody(header *mail.Header, params map[string]string,
+	body io.Reader, writer io.Writer) error {
+
+	header.SetContentType("text/plain", params)
 	w, err := mail.CreateSingleInlineWriter(writer, *header)
 	if err != nil {
 		return errors.Wrap(err, "CreateSingleInlineWriter")
@@ -380,9 +617,11 @@ func writeInlineBody(header *mail.Header, body io.Reader, writer io.Writer) erro
 }
 
 // write the message body to the multipart message
-func writeMultipartBody(body io.Reader, w *mail.Writer) error {
+func writeMultipartBody(params map[string]string, body io.Reader,
+	w *mail.Writer) error {
+
 	bh := mail.InlineHeader{}
-	bh.SetContentType("text/plain", map[string]string{"charset": "UTF-8"})
+	bh.SetContentType("text/plain", params)
 
 	bi, err := w.CreateInline()
 	if err != nil {
@@ -401,8 +640,15 @@ func writeMultipartBody(body io.Reader, w *mail.Writer) error {
 	return nil
 }
 
+type attachmentType struct {
+	mimeType string
+	params   map[string]string
+}
+
 // write the attachment specified by path to the message
-func writeAttachment(path string, writer *mail.Writer) error {
+func writeAttachment(path string, known attachmentType,
+	writer *mail.Writer) error {
+
 	filename := filepath.Base(path)
 
 	f, err := os.Open(path)
@@ -420,12 +666,19 @@ func writeAttachment(path string, writer *mail.Writer) error {
 		return errors.Wrap(err, "Peek")
 	}
 
-	mimeString := http.DetectContentType(head)
-	// mimeString can contain type and params (like text encoding),
-	// so we need to break them apart before passing them to the headers
-	mimeType, params, err := mime.ParseMediaType(mimeString)
-	if err != nil {
-		return errors.Wrap(err, "ParseMediaType")
+	mimeType, params := known.mimeType, make(map[string]string)
+	if mimeType == "" {
+		mimeString := http.DetectContentType(head)
+		// mimeString can contain type and params (like text encoding),
+		// so we need to break them apart before passing them to the headers
+		mimeType, params, err = mime.ParseMediaType(mimeString)
+		if err != nil {
+			return errors.Wrap(err, "ParseMediaType")
+		}
+	} else {
+		for key, value := range known.params {
+			params[key] = value
+		}
 	}
 	params["name"] = filename
 
@@ -457,10 +710,23 @@ func (c *Composer) AddAttachment(path string) {
 	c.resetReview()
 }
 
+// AddPartAttachment attaches the file at path with the given content type,
+// rather than detecting it from the file's contents.
+func (c *Composer) AddPartAttachment(path string, mimeType string,
+	params map[string]string) {
+
+	if c.attachmentTypes == nil {
+		c.attachmentTypes = make(map[string]attachmentType)
+	}
+	c.attachmentTypes[path] = attachmentType{mimeType, params}
+	c.AddAttachment(path)
+}
+
 func (c *Composer) DeleteAttachment(path string) error {
 	for i, a := range c.attachments {
 		if a == path {
 			c.attachments = append(c.attachments[:i], c.attachments[i+1:]...)
+			delete(c.attachmentTypes, path)
 			c.resetReview()
 			return nil
 		}
@@ -470,6 +736,8 @@ func (c *Composer) DeleteAttachment(path string) error {
 }
 
 func (c *Composer) resetReview() {
+	// The warnings are for the message as it was
+	c.warnings = nil
 	if c.review != nil {
 		c.grid.RemoveChild(c.review)
 		c.review = newReviewMessage(c, nil)
@@ -478,6 +746,7 @@ func (c *Composer) resetReview() {
 }
 
 func (c *Composer) termClosed(err error) {
+	c.warnings = nil
 	c.grid.RemoveChild(c.editor)
 	c.review = newReviewMessage(c, err)
 	c.grid.AddChild(c.review).At(1, 0)
@@ -549,7 +818,7 @@ func (c *Composer) AddEditor(header string, value string, appendHeader bool) {
 		}
 		return
 	}
-	e := newHeaderEditor(header, value)
+	e := newHeaderEditor(header, value, &c.config.Ui)
 	c.editors[header] = e
 	c.layout = append(c.layout, []string{header})
 	// Insert focus of new editor before terminal editor
@@ -598,22 +867,27 @@ func (c *Composer) reloadEmail() error {
 }
 
 type headerEditor struct {
-	name  string
-	input *ui.TextInput
+	name     string
+	input    *ui.TextInput
+	uiConfig *config.UIConfig
 }
 
-func newHeaderEditor(name string, value string) *headerEditor {
+func newHeaderEditor(name string, value string,
+	uiConf *config.UIConfig) *headerEditor {
+
 	return &headerEditor{
-		input: ui.NewTextInput(value),
-		name:  name,
+		input:    ui.NewTextInput(value),
+		name:     name,
+		uiConfig: uiConf,
 	}
 }
 
 func (he *headerEditor) Draw(ctx *ui.Context) {
 	name := he.name + " "
 	size := runewidth.StringWidth(name)
-	ctx.Fill(0, 0, size, ctx.Height(), ' ', tcell.StyleDefault)
-	ctx.Printf(0, 0, tcell.StyleDefault.Bold(true), "%s", name)
+	ctx.Fill(0, 0, size, ctx.Height(), ' ',
+		he.uiConfig.GetStyle(config.STYLE_DEFAULT))
+	ctx.Printf(0, 0, he.uiConfig.GetStyle(config.STYLE_HEADER), "%s", name)
 	he.input.Draw(ctx.Subcontext(size, 0, ctx.Width()-size, 1))
 }
 
@@ -647,7 +921,17 @@ type reviewMessage struct {
 }
 
 func newReviewMessage(composer *Composer, err error) *reviewMessage {
-	spec := []ui.GridSpec{{ui.SIZE_EXACT, 2}, {ui.SIZE_EXACT, 1}}
+	var spec []ui.GridSpec
+	if len(composer.warnings) == 0 {
+		spec = append(spec, ui.GridSpec{ui.SIZE_EXACT, 2})
+	} else {
+		spec = append(spec, ui.GridSpec{ui.SIZE_EXACT, 1})
+		for i := 0; i < len(composer.warnings)-1; i++ {
+			spec = append(spec, ui.GridSpec{ui.SIZE_EXACT, 1})
+		}
+		spec = append(spec, ui.GridSpec{ui.SIZE_EXACT, 2})
+	}
+	spec = append(spec, ui.GridSpec{ui.SIZE_EXACT, 1})
 	for i := 0; i < len(composer.attachments)-1; i++ {
 		spec = append(spec, ui.GridSpec{ui.SIZE_EXACT, 1})
 	}
@@ -660,19 +944,26 @@ func newReviewMessage(composer *Composer, err error) *reviewMessage {
 
 	if err != nil {
 		grid.AddChild(ui.NewText(err.Error()).
-			Color(tcell.ColorRed, tcell.ColorDefault))
+			Style(composer.config.Ui.GetStyle(config.STYLE_ERROR)))
 		grid.AddChild(ui.NewText("Press [q] to close this tab.")).At(1, 0)
 	} else {
 		// TODO: source this from actual keybindings?
 		grid.AddChild(ui.NewText(
 			"Send this email? [y]es/[n]o/[e]dit/[a]ttach")).At(0, 0)
+		row := 1
+		for _, warning := range composer.warnings {
+			grid.AddChild(ui.NewText(warning).
+				Style(composer.config.Ui.GetStyle(config.STYLE_WARNING))).
+				At(row, 0)
+			row++
+		}
 		grid.AddChild(ui.NewText("Attachments:").
-			Reverse(true)).At(1, 0)
+			Style(composer.config.Ui.GetStyle(config.STYLE_TITLE))).At(row, 0)
 		if len(composer.attachments) == 0 {
-			grid.AddChild(ui.NewText("(none)")).At(2, 0)
+			grid.AddChild(ui.NewText("(none)")).At(row+1, 0)
 		} else {
 			for i, a := range composer.attachments {
-				grid.AddChild(ui.NewText(a)).At(i+2, 0)
+				grid.AddChild(ui.NewText(a)).At(row+i+1, 0)
 			}
 		}
 	}
@@ -0,0 +1,56 @@
+package widgets
+
+import (
+	"github.com/gdamore/tcell"
+
+	"git.sr.ht/~sircmpwn/aerc/config"
+	"git.sr.ht/~sircmpwn/aerc/lib/ui"
+)
+
+// A yes or no question in the status bar
+type Confirm struct {
+	ui.Invalidatable
+	question string
+	answer   func(yes bool)
+	uiConfig *config.UIConfig
+}
+
+func NewConfirm(question string, answer func(yes bool),
+	uiConfig *config.UIConfig) *Confirm {
+
+	return &Confirm{
+		question: question,
+		answer:   answer,
+		uiConfig: uiConfig,
+	}
+}
+
+func (c *Confirm) Invalidate() {
+	c.DoInvalidate(c)
+}
+
+func (c *Confirm) Draw(ctx *ui.Context) {
+	style := c.uiConfig.GetStyle(config.STYLE_STATUSLINE_ERROR)
+	ctx.Fill(0, 0, ctx.Width(), ctx.Height(), ' ', style)
+	ctx.Printf(0, 0, style, " %s [y/n]", c.question)
+}
+
+func (c *Confirm) Focus(focus bool) {
+}
+
+func (c *Confirm) Event(event tcell.Event) bool {
+	if event, ok := event.(*tcell.EventKey); ok {
+		switch event.Key() {
+		case tcell.KeyRune:
+			switch event.Rune() {
+			case 'y', 'Y':
+				c.answer(true)
+			case 'n', 'N':
+				c.answer(false)
+			}
+		case tcell.KeyEsc, tcell.KeyCtrlC:
+			c.answer(false)
+		}
+	}
+	return true
+}
@@ -15,6 +15,7 @@ import (
 
 type DirectoryList struct {
 	ui.Invalidatable
+	aercConf  *config.AercConfig
 	acctConf  *config.AccountConfig
 	uiConf    *config.UIConfig
 	store     *lib.DirStore
@@ -26,16 +27,19 @@ type DirectoryList struct {
 	worker    *types.Worker
 }
 
-func NewDirectoryList(acctConf *config.AccountConfig, uiConf *config.UIConfig,
+func NewDirectoryList(conf *config.AercConfig, acctConf *config.AccountConfig,
 	logger *log.Logger, worker *types.Worker) *DirectoryList {
 
 	dirlist := &DirectoryList{
+		aercConf: conf,
 		acctConf: acctConf,
-		uiConf:   uiConf,
-		logger:   logger,
-		spinner:  NewSpinner(),
-		store:    lib.NewDirStore(),
-		worker:   worker,
+		uiConf: conf.GetUiConfig(map[config.ContextType]string{
+			config.UI_CONTEXT_ACCOUNT: acctConf.Name,
+		}),
+		logger:  logger,
+		spinner: NewSpinner(),
+		store:   lib.NewDirStore(),
+		worker:  worker,
 	}
 	dirlist.spinner.OnInvalidate(func(_ ui.Drawable) {
 		dirlist.Invalidate()
@@ -79,6 +83,11 @@ func (dirlist *DirectoryList) Select(name string) {
 				dirlist.selecting = ""
 			case *types.Done:
 				dirlist.selected = dirlist.selecting
+				dirlist.uiConf = dirlist.aercConf.GetUiConfig(
+					map[config.ContextType]string{
+						config.UI_CONTEXT_ACCOUNT: dirlist.acctConf.Name,
+						config.UI_CONTEXT_FOLDER:  dirlist.selected,
+					})
 				dirlist.filterDirsByFoldersConfig()
 				hasSelected := false
 				for _, d := range dirlist.dirs {
@@ -106,7 +115,8 @@ func (dirlist *DirectoryList) Invalidate() {
 }
 
 func (dirlist *DirectoryList) Draw(ctx *ui.Context) {
-	ctx.Fill(0, 0, ctx.Width(), ctx.Height(), ' ', tcell.StyleDefault)
+	ctx.Fill(0, 0, ctx.Width(), ctx.Height(), ' ',
+		dirlist.uiConf.GetStyle(config.STYLE_DIRLIST_DEFAULT))
 
 	if dirlist.spinner.IsRunning() {
 		dirlist.spinner.Draw(ctx)
@@ -114,7 +124,7 @@ func (dirlist *DirectoryList) Draw(ctx *ui.Context) {
 	}
 
 	if len(dirlist.dirs) == 0 {
-		style := tcell.StyleDefault
+		style := dirlist.uiConf.GetStyle(config.STYLE_DIRLIST_DEFAULT)
 		ctx.Printf(0, 0, style, dirlist.uiConf.EmptyDirlist)
 		return
 	}
@@ -124,19 +134,40 @@ func (dirlist *DirectoryList) Draw(ctx *ui.Context) {
 		if row >= ctx.Height() {
 			break
 		}
-		style := tcell.StyleDefault
+		var styles []config.StyleObject
 		if name == dirlist.selected {
-			style = style.Reverse(true)
+			styles = append(styles, config.STYLE_DIRLIST_SELECTED)
 		} else if name == dirlist.selecting {
-			style = style.Reverse(true)
-			style = style.Foreground(tcell.ColorGray)
+			styles = append(styles, config.STYLE_DIRLIST_SELECTING)
 		}
+		style := dirlist.uiConf.GetComposedStyle(
+			config.STYLE_DIRLIST_DEFAULT, styles)
 		ctx.Fill(0, row, ctx.Width(), 1, ' ', style)
 		ctx.Printf(0, row, style, "%s", name)
 		row++
 	}
 }
 
+func (dirlist *DirectoryList) MouseEvent(localX int, localY int, event tcell.Event) {
+	switch event := event.(type) {
+	case *tcell.EventMouse:
+		switch event.Buttons() {
+		case tcell.Button1:
+			if localY >= len(dirlist.dirs) {
+				return
+			}
+			name := dirlist.dirs[localY]
+			if name != dirlist.selected && name != dirlist.selecting {
+				dirlist.Select(name)
+			}
+		case tcell.WheelDown:
+			dirlist.Next()
+		case tcell.WheelUp:
+			dirlist.Prev()
+		}
+	}
+}
+
 func (dirlist *DirectoryList) NextPrev(delta int) {
 	curIdx := sort.SearchStrings(dirlist.dirs, dirlist.selected)
 	if curIdx == len(dirlist.dirs) {
@@ -3,6 +3,7 @@ package widgets
 import (
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/gdamore/tcell"
 	"github.com/mattn/go-runewidth"
@@ -16,7 +17,9 @@ import (
 
 type MessageList struct {
 	ui.Invalidatable
+	acctConf      *config.AccountConfig
 	conf          *config.AercConfig
+	uiConf        *config.UIConfig
 	logger        *log.Logger
 	height        int
 	scroll        int
@@ -24,11 +27,26 @@ type MessageList struct {
 	spinner       *Spinner
 	store         *lib.MessageStore
 	isInitalizing bool
+	onOpen        func(msg *models.MessageInfo)
+
+	// The last click, to recognize double clicks
+	lastClickTime time.Time
+	lastClickX    int
+	lastClickY    int
 }
 
-func NewMessageList(conf *config.AercConfig, logger *log.Logger) *MessageList {
+// Two clicks on the same cell within this interval open the message
+const doubleClickInterval = 400 * time.Millisecond
+
+func NewMessageList(conf *config.AercConfig, acctConf *config.AccountConfig,
+	logger *log.Logger) *MessageList {
+
 	ml := &MessageList{
-		conf:          conf,
+		acctConf: acctConf,
+		conf:     conf,
+		uiConf: conf.GetUiConfig(map[config.ContextType]string{
+			config.UI_CONTEXT_ACCOUNT: acctConf.Name,
+		}),
 		logger:        logger,
 		spinner:       NewSpinner(),
 		isInitalizing: true,
@@ -47,7 +65,8 @@ func (ml *MessageList) Invalidate() {
 
 func (ml *MessageList) Draw(ctx *ui.Context) {
 	ml.height = ctx.Height()
-	ctx.Fill(0, 0, ctx.Width(), ctx.Height(), ' ', tcell.StyleDefault)
+	ctx.Fill(0, 0, ctx.Width(), ctx.Height(), ' ',
+		ml.uiConf.GetStyle(config.STYLE_MSGLIST_DEFAULT))
 
 	store := ml.Store()
 	if store == nil {
@@ -82,31 +101,41 @@ func (ml *MessageList) Draw(ctx *ui.Context) {
 			continue
 		}
 
-		style := tcell.StyleDefault
-
-		// current row
-		if row == ml.store.SelectedIndex()-ml.scroll {
-			style = style.Reverse(true)
-		}
-		// deleted message
-		if _, ok := store.Deleted[msg.Uid]; ok {
-			style = style.Foreground(tcell.ColorGray)
-		}
+		var styles []config.StyleObject
 		// unread message
 		seen := false
+		flagged := false
 		for _, flag := range msg.Flags {
-			if flag == models.SeenFlag {
+			switch flag {
+			case models.SeenFlag:
 				seen = true
+			case models.FlaggedFlag:
+				flagged = true
 			}
 		}
-		if !seen {
-			style = style.Bold(true)
+		if seen {
+			styles = append(styles, config.STYLE_MSGLIST_READ)
+		} else {
+			styles = append(styles, config.STYLE_MSGLIST_UNREAD)
+		}
+		if flagged {
+			styles = append(styles, config.STYLE_MSGLIST_FLAGGED)
 		}
+		// deleted message
+		if _, ok := store.Deleted[msg.Uid]; ok {
+			styles = append(styles, config.STYLE_MSGLIST_DELETED)
+		}
+		// current row
+		if row == ml.store.SelectedIndex()-ml.scroll {
+			styles = append(styles, config.STYLE_MSGLIST_SELECTED)
+		}
+		style := ml.uiConf.GetComposedStyle(
+			config.STYLE_MSGLIST_DEFAULT, styles)
 
 		ctx.Fill(0, row, ctx.Width(), 1, ' ', style)
 		fmtStr, args, err := format.ParseMessageFormat(
-			ml.conf.Ui.IndexFormat,
-			ml.conf.Ui.TimestampFormat, "", i, msg)
+			ml.uiConf.IndexFormat,
+			ml.uiConf.TimestampFormat, "", i, msg)
 		if err != nil {
 			ctx.Printf(0, row, style, "%v", err)
 		} else {
@@ -130,6 +159,48 @@ func (ml *MessageList) Draw(ctx *ui.Context) {
 	}
 }
 
+func (ml *MessageList) MouseEvent(localX int, localY int, event tcell.Event) {
+	switch event := event.(type) {
+	case *tcell.EventMouse:
+		if ml.Empty() {
+			return
+		}
+		switch event.Buttons() {
+		case tcell.Button1:
+			index := ml.scroll + localY
+			if index >= len(ml.Store().Uids()) {
+				return
+			}
+			ml.Select(index)
+			// Dragging only reports cells the cursor moves to, so a second
+			// event on the same cell is a second click
+			now := time.Now()
+			double := localX == ml.lastClickX && localY == ml.lastClickY &&
+				now.Sub(ml.lastClickTime) < doubleClickInterval
+			ml.lastClickTime = now
+			ml.lastClickX = localX
+			ml.lastClickY = localY
+			if double && ml.onOpen != nil {
+				ml.lastClickTime = time.Time{}
+				if msg := ml.Selected(); msg != nil {
+					ml.onOpen(msg)
+				}
+			}
+		case tcell.WheelDown:
+			ml.Store().Next()
+			ml.Scroll()
+		case tcell.WheelUp:
+			ml.Store().Prev()
+			ml.Scroll()
+		}
+	}
+}
+
+// Registers a function to call when a message is double clicked
+func (ml *MessageList) OnOpen(fn func(msg *models.MessageInfo)) {
+	ml.onOpen = fn
+}
+
 func (ml *MessageList) Height() int {
 	return ml.height
 }
@@ -167,6 +238,13 @@ func (ml *MessageList) SetStore(store *lib.MessageStore) {
 		ml.scroll = 0
 	}
 	ml.store = store
+	params := map[config.ContextType]string{
+		config.UI_CONTEXT_ACCOUNT: ml.acctConf.Name,
+	}
+	if store != nil {
+		params[config.UI_CONTEXT_FOLDER] = store.DirInfo.Name
+	}
+	ml.uiConf = ml.conf.GetUiConfig(params)
 	if store != nil {
 		ml.spinner.Stop()
 		ml.nmsgs = len(store.Uids())
@@ -221,7 +299,7 @@ func (ml *MessageList) Scroll() {
 }
 
 func (ml *MessageList) drawEmptyMessage(ctx *ui.Context) {
-	msg := ml.conf.Ui.EmptyMessage
+	msg := ml.uiConf.EmptyMessage
 	ctx.Printf((ctx.Width()/2)-(len(msg)/2), 0,
-		tcell.StyleDefault, "%s", msg)
+		ml.uiConf.GetStyle(config.STYLE_MSGLIST_DEFAULT), "%s", msg)
 }
@@ -34,25 +34,34 @@ type MessageViewer struct {
 	msg      *models.MessageInfo
 	switcher *PartSwitcher
 	store    *lib.MessageStore
+	uiConfig *config.UIConfig
 }
 
 type PartSwitcher struct {
 	ui.Invalidatable
+	height         int
 	parts          []*PartViewer
 	selected       int
 	showHeaders    bool
 	alwaysShowMime bool
+	uiConfig       *config.UIConfig
 }
 
 func NewMessageViewer(acct *AccountView, conf *config.AercConfig,
 	store *lib.MessageStore, msg *models.MessageInfo) *MessageViewer {
 
+	// The styles of the account and folder of the message
+	uiConfig := &conf.Ui
+	if acct != nil && acct.uiConf != nil {
+		uiConfig = acct.uiConf
+	}
 	layout := HeaderLayout(conf.Viewer.HeaderLayout).forMessage(msg)
 	header, headerHeight := layout.grid(
 		func(header string) ui.Drawable {
 			return &HeaderView{
-				Name:  header,
-				Value: fmtHeader(msg, header),
+				Name:     header,
+				Value:    fmtHeader(msg, header),
+				uiConfig: uiConfig,
 			}
 		},
 	)
@@ -64,13 +73,15 @@ func NewMessageViewer(acct *AccountView, conf *config.AercConfig,
 		{ui.SIZE_WEIGHT, 1},
 	})
 
-	switcher := &PartSwitcher{}
+	switcher := &PartSwitcher{uiConfig: uiConfig}
 	err := createSwitcher(switcher, conf, store, msg)
 	if err != nil {
 		return &MessageViewer{
-			err:  err,
-			grid: grid,
-			msg:  msg,
+			conf:     conf,
+			err:      err,
+			grid:     grid,
+			msg:      msg,
+			uiConfig: uiConfig,
 		}
 	}
 
@@ -84,6 +95,7 @@ func NewMessageViewer(acct *AccountView, conf *config.AercConfig,
 		msg:      msg,
 		store:    store,
 		switcher: switcher,
+		uiConfig: uiConfig,
 	}
 }
 
@@ -182,18 +194,36 @@ func createSwitcher(switcher *PartSwitcher, conf *config.AercConfig,
 			}
 		}
 	}
+	// The parts are drawn with the styles of the viewer
+	for _, pv := range switcher.parts {
+		pv.uiConfig = switcher.uiConfig
+	}
 	return nil
 }
 
 func (mv *MessageViewer) Draw(ctx *ui.Context) {
 	if mv.err != nil {
-		ctx.Fill(0, 0, ctx.Width(), ctx.Height(), ' ', tcell.StyleDefault)
-		ctx.Printf(0, 0, tcell.StyleDefault, "%s", mv.err.Error())
+		style := mv.uiConfig.GetStyle(config.STYLE_DEFAULT)
+		ctx.Fill(0, 0, ctx.Width(), ctx.Height(), ' ', style)
+		ctx.Printf(0, 0, style, "%s", mv.err.Error())
 		return
 	}
 	mv.grid.Draw(ctx)
 }
 
+// Close stops the pagers of every part of the message
+func (mv *MessageViewer) Close() {
+	if mv.switcher == nil {
+		return
+	}
+	for _, pv := range mv.switcher.parts {
+		if pv.term != nil {
+			pv.term.Close(nil)
+			pv.term.Destroy()
+		}
+	}
+}
+
 func (mv *MessageViewer) Invalidate() {
 	mv.grid.Invalidate()
 }
@@ -244,31 +274,28 @@ func (mv *MessageViewer) SelectedMessagePart() *PartInfo {
 }
 
 func (mv *MessageViewer) PreviousPart() {
-	switcher := mv.switcher
-	for {
-		switcher.selected--
-		if switcher.selected < 0 {
-			switcher.selected = len(switcher.parts) - 1
-		}
-		if switcher.parts[switcher.selected].part.MIMEType != "multipart" {
-			break
-		}
-	}
+	mv.switcher.nextPrev(-1)
 	mv.Invalidate()
 }
 
 func (mv *MessageViewer) NextPart() {
-	switcher := mv.switcher
+	mv.switcher.nextPrev(1)
+	mv.Invalidate()
+}
+
+// Selects the next (or previous) part which isn't a multipart container
+func (ps *PartSwitcher) nextPrev(delta int) {
 	for {
-		switcher.selected++
-		if switcher.selected >= len(switcher.parts) {
-			switcher.selected = 0
+		ps.selected += delta
+		if ps.selected < 0 {
+			ps.selected = len(ps.parts) - 1
+		} else if ps.selected >= len(ps.parts) {
+			ps.selected = 0
 		}
-		if switcher.parts[switcher.selected].part.MIMEType != "multipart" {
+		if ps.parts[ps.selected].part.MIMEType != "multipart" {
 			break
 		}
 	}
-	mv.Invalidate()
 }
 
 func (ps *PartSwitcher) Invalidate() {
@@ -289,6 +316,7 @@ func (ps *PartSwitcher) Event(event tcell.Event) bool {
 }
 
 func (ps *PartSwitcher) Draw(ctx *ui.Context) {
+	ps.height = ctx.Height()
 	height := len(ps.parts)
 	if height == 1 && !ps.alwaysShowMime {
 		ps.parts[ps.selected].Draw(ctx)
@@ -297,7 +325,11 @@ func (ps *PartSwitcher) Draw(ctx *ui.Context) {
 	// TODO: cap height and add scrolling for messages with many parts
 	y := ctx.Height() - height
 	for i, part := range ps.parts {
-		style := tcell.StyleDefault.Reverse(ps.selected == i)
+		style := ps.uiConfig.GetStyle(config.STYLE_PART_SWITCHER)
+		if ps.selected == i {
+			style = ps.uiConfig.GetComposedStyle(config.STYLE_PART_SWITCHER,
+				[]config.StyleObject{config.STYLE_PART_SWITCHER_SELECTED})
+		}
 		ctx.Fill(0, y+i, ctx.Width(), 1, ' ', style)
 		name := fmt.Sprintf("%s/%s",
 			strings.ToLower(part.part.MIMEType),
@@ -311,6 +343,53 @@ func (ps *PartSwitcher) Draw(ctx *ui.Context) {
 		0, 0, ctx.Width(), ctx.Height()-height))
 }
 
+func (ps *PartSwitcher) MouseEvent(localX int, localY int, event tcell.Event) {
+	term := ps.parts[ps.selected].term
+	nparts := len(ps.parts)
+	if nparts == 1 && !ps.alwaysShowMime {
+		if term != nil {
+			term.MouseEvent(localX, localY, event)
+		}
+		return
+	}
+	y := ps.height - nparts
+	if localY < y {
+		if term != nil {
+			term.MouseEvent(localX, localY, event)
+		}
+		return
+	}
+	selected := ps.selected
+	switch event := event.(type) {
+	case *tcell.EventMouse:
+		switch event.Buttons() {
+		case tcell.Button1:
+			i := localY - y
+			if ps.parts[i].part.MIMEType != "multipart" {
+				ps.selected = i
+			}
+		case tcell.WheelDown:
+			ps.nextPrev(1)
+		case tcell.WheelUp:
+			ps.nextPrev(-1)
+		}
+	}
+	if ps.selected != selected {
+		// The pager only redraws damaged cells
+		if term := ps.parts[ps.selected].term; term != nil {
+			term.Invalidate()
+		}
+		ps.Invalidate()
+	}
+}
+
+func (mv *MessageViewer) MouseEvent(localX int, localY int, event tcell.Event) {
+	if mv.err != nil {
+		return
+	}
+	mv.grid.MouseEvent(localX, localY, event)
+}
+
 func (mv *MessageViewer) Event(event tcell.Event) bool {
 	return mv.switcher.Event(event)
 }
@@ -334,6 +413,7 @@ type PartViewer struct {
 	source      io.Reader
 	store       *lib.MessageStore
 	term        *Terminal
+	uiConfig    *config.UIConfig
 }
 
 func NewPartViewer(conf *config.AercConfig,
@@ -406,6 +486,7 @@ func NewPartViewer(conf *config.AercConfig,
 		sink:        pipe,
 		store:       store,
 		term:        term,
+		uiConfig:    &conf.Ui,
 	}
 
 	if term != nil {
@@ -506,8 +587,9 @@ func (pv *PartViewer) Invalidate() {
 func (pv *PartViewer) Draw(ctx *ui.Context) {
 	if pv.filter == nil {
 		// TODO: Let them download it directly or something
-		ctx.Fill(0, 0, ctx.Width(), ctx.Height(), ' ', tcell.StyleDefault)
-		ctx.Printf(0, 0, tcell.StyleDefault.Foreground(tcell.ColorRed),
+		style := pv.uiConfig.GetStyle(config.STYLE_DEFAULT)
+		ctx.Fill(0, 0, ctx.Width(), ctx.Height(), ' ', style)
+		ctx.Printf(0, 0, pv.uiConfig.GetStyle(config.STYLE_ERROR),
 			"No filter configured for this mimetype")
 		return
 	}
@@ -516,8 +598,9 @@ func (pv *PartViewer) Draw(ctx *ui.Context) {
 		pv.fetched = true
 	}
 	if pv.err != nil {
-		ctx.Fill(0, 0, ctx.Width(), ctx.Height(), ' ', tcell.StyleDefault)
-		ctx.Printf(0, 0, tcell.StyleDefault, "%s", pv.err.Error())
+		style := pv.uiConfig.GetStyle(config.STYLE_DEFAULT)
+		ctx.Fill(0, 0, ctx.Width(), ctx.Height(), ' ', style)
+		ctx.Printf(0, 0, style, "%s", pv.err.Error())
 		return
 	}
 	pv.term.Draw(ctx)
@@ -525,8 +608,9 @@ func (pv *PartViewer) Draw(ctx *ui.Context) {
 
 type HeaderView struct {
 	ui.Invalidatable
-	Name  string
-	Value string
+	Name     string
+	Value    string
+	uiConfig *config.UIConfig
 }
 
 func (hv *HeaderView) Draw(ctx *ui.Context) {
@@ -535,16 +619,12 @@ func (hv *HeaderView) Draw(ctx *ui.Context) {
 	lim := ctx.Width() - size - 1
 	value := runewidth.Truncate(" "+hv.Value, lim, "…")
 	var (
-		hstyle tcell.Style
-		vstyle tcell.Style
+		hstyle = hv.uiConfig.GetStyle(config.STYLE_HEADER)
+		vstyle = hv.uiConfig.GetStyle(config.STYLE_DEFAULT)
 	)
 	// TODO: Make this more robust and less dumb
 	if hv.Name == "PGP" {
-		vstyle = tcell.StyleDefault.Foreground(tcell.ColorGreen)
-		hstyle = tcell.StyleDefault.Bold(true)
-	} else {
-		vstyle = tcell.StyleDefault
-		hstyle = tcell.StyleDefault.Bold(true)
+		vstyle = hv.uiConfig.GetStyle(config.STYLE_SUCCESS)
 	}
 	ctx.Fill(0, 0, ctx.Width(), ctx.Height(), ' ', vstyle)
 	ctx.Printf(0, 0, hstyle, name)
@@ -0,0 +1,229 @@
+package widgets
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"git.sr.ht/~sircmpwn/aerc/lib"
+	"git.sr.ht/~sircmpwn/aerc/models"
+)
+
+type rpcAccount struct {
+	Name     string `json:"name"`
+	Folder   string `json:"folder"`
+	Selected bool   `json:"selected"`
+}
+
+type rpcFolder struct {
+	Name     string `json:"name"`
+	Selected bool   `json:"selected"`
+	// Counts are only known for folders which have been opened
+	Exists int `json:"exists"`
+	Recent int `json:"recent"`
+	Unseen int `json:"unseen"`
+}
+
+type rpcMessage struct {
+	Uid     uint32    `json:"uid"`
+	Subject string    `json:"subject"`
+	From    []string  `json:"from"`
+	To      []string  `json:"to"`
+	Date    time.Time `json:"date"`
+	Flags   []string  `json:"flags"`
+}
+
+var rpcFlagNames = map[models.Flag]string{
+	models.SeenFlag:     "seen",
+	models.RecentFlag:   "recent",
+	models.AnsweredFlag: "answered",
+	models.DeletedFlag:  "deleted",
+	models.FlaggedFlag:  "flagged",
+}
+
+type rpcSelection struct {
+	Account string      `json:"account,omitempty"`
+	Folder  string      `json:"folder,omitempty"`
+	Message *rpcMessage `json:"message,omitempty"`
+}
+
+type rpcParams struct {
+	Account string `json:"account"`
+	Command string `json:"command"`
+	URL     string `json:"url"`
+}
+
+func newRPCMessage(msg *models.MessageInfo) *rpcMessage {
+	m := &rpcMessage{Uid: msg.Uid, Flags: []string{}}
+	if msg.Envelope != nil {
+		m.Subject = msg.Envelope.Subject
+		m.Date = msg.Envelope.Date
+		m.From = formatAddresses(msg.Envelope.From)
+		m.To = formatAddresses(msg.Envelope.To)
+	}
+	for _, flag := range msg.Flags {
+		if name, ok := rpcFlagNames[flag]; ok {
+			m.Flags = append(m.Flags, name)
+		}
+	}
+	return m
+}
+
+func formatAddresses(addrs []*models.Address) []string {
+	list := make([]string, len(addrs))
+	for i, addr := range addrs {
+		list[i] = addr.Format()
+	}
+	return list
+}
+
+// Runs fn on the main goroutine during the next Tick and waits for it to
+// return.
+func (aerc *Aerc) runOnMain(fn func()) {
+	done := make(chan interface{})
+	aerc.Post(func() {
+		fn()
+		close(done)
+	})
+	<-done
+}
+
+// HandleRequest answers the JSON-RPC requests received on the control socket.
+// It may be called from any goroutine.
+func (aerc *Aerc) HandleRequest(method string,
+	raw json.RawMessage) (interface{}, error) {
+
+	var params rpcParams
+	if len(raw) != 0 {
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return nil, invalidParams(err.Error())
+		}
+	}
+	var (
+		result interface{}
+		err    error
+	)
+	aerc.runOnMain(func() {
+		result, err = aerc.handleRequest(method, params)
+	})
+	return result, err
+}
+
+func (aerc *Aerc) handleRequest(method string,
+	params rpcParams) (interface{}, error) {
+
+	switch method {
+	case "command":
+		if strings.TrimSpace(params.Command) == "" {
+			return nil, invalidParams("no command given")
+		}
+		return nil, aerc.RunCommands(params.Command)
+	case "mailto":
+		addr, err := url.Parse(params.URL)
+		if err != nil {
+			return nil, invalidParams(err.Error())
+		}
+		return nil, aerc.Mailto(addr)
+	case "accounts":
+		selected := aerc.SelectedAccount()
+		accounts := []rpcAccount{}
+		for _, acct := range aerc.conf.Accounts {
+			view := aerc.accounts[acct.Name]
+			info := rpcAccount{
+				Name:     acct.Name,
+				Selected: view == selected,
+			}
+			if view.dirlist != nil {
+				info.Folder = view.dirlist.Selected()
+			}
+			accounts = append(accounts, info)
+		}
+		return accounts, nil
+	case "folders":
+		acct, err := aerc.rpcAccount(params.Account)
+		if err != nil {
+			return nil, err
+		}
+		folders := []rpcFolder{}
+		for _, name := range acct.dirlist.List() {
+			folder := rpcFolder{
+				Name:     name,
+				Selected: name == acct.dirlist.Selected(),
+			}
+			if store, ok := acct.dirlist.MsgStore(name); ok {
+				folder.Exists = store.DirInfo.Exists
+				folder.Recent = store.DirInfo.Recent
+				folder.Unseen = store.DirInfo.Unseen
+			}
+			folders = append(folders, folder)
+		}
+		return folders, nil
+	case "unread":
+		acct, err := aerc.rpcAccount(params.Account)
+		if err != nil {
+			return nil, err
+		}
+		unread := make(map[string]int)
+		for _, name := range acct.dirlist.List() {
+			if store, ok := acct.dirlist.MsgStore(name); ok {
+				unread[name] = store.DirInfo.Unseen
+			}
+		}
+		return unread, nil
+	case "selected":
+		var selection rpcSelection
+		acct, err := aerc.rpcAccount("")
+		if err != nil {
+			return selection, nil
+		}
+		selection.Account = acct.Name()
+		selection.Folder = acct.dirlist.Selected()
+		var msg *models.MessageInfo
+		switch tab := aerc.SelectedTab().(type) {
+		case *AccountView:
+			if !tab.msglist.Empty() {
+				msg = tab.msglist.Selected()
+			}
+		case *MessageViewer:
+			msg, _ = tab.SelectedMessage()
+		}
+		if msg != nil {
+			selection.Message = newRPCMessage(msg)
+		}
+		return selection, nil
+	}
+	return nil, &lib.RPCError{Code: lib.RPC_METHOD_NOT_FOUND,
+		Message: "unknown method " + method}
+}
+
+func invalidParams(msg string) error {
+	return &lib.RPCError{Code: lib.RPC_INVALID_PARAMS, Message: msg}
+}
+
+// Returns the named account, or the selected one if name is empty
+func (aerc *Aerc) rpcAccount(name string) (*AccountView, error) {
+	var acct *AccountView
+	if name == "" {
+		acct = aerc.SelectedAccount()
+		if acct == nil {
+			if mv, ok := aerc.SelectedTab().(*MessageViewer); ok {
+				acct = mv.SelectedAccount()
+			}
+		}
+		if acct == nil {
+			return nil, errors.New("No account selected")
+		}
+	} else {
+		var ok bool
+		acct, ok = aerc.accounts[name]
+		if !ok {
+			return nil, invalidParams("no such account " + name)
+		}
+	}
+	if acct.dirlist == nil {
+		return nil, errors.New("Account " + acct.Name() + " is not connected")
+	}
+	return acct, nil
+}
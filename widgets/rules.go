@@ -0,0 +1,164 @@
+package widgets
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"time"
+
+	"git.sr.ht/~sircmpwn/aerc/config"
+	"git.sr.ht/~sircmpwn/aerc/lib"
+	"git.sr.ht/~sircmpwn/aerc/models"
+	"git.sr.ht/~sircmpwn/aerc/worker/types"
+)
+
+// Applies the rules to a new message, once. Workers only act on the selected
+// folder, so new mail in other folders waits until the folder is opened.
+func (acct *AccountView) applyNewRules(store *lib.MessageStore, uid uint32) {
+	if len(acct.conf.Rules) == 0 {
+		return
+	}
+	key := messageKey{store, uid}
+	if _, ok := acct.ruled[key]; ok {
+		return
+	}
+	if selected, ok := acct.dirlist.SelectedMsgStore(); !ok ||
+		selected != store {
+		acct.unruled[store] = append(acct.unruled[store], uid)
+		return
+	}
+	msg := store.Messages[uid]
+	if msg == nil || msg.Envelope == nil {
+		return
+	}
+	acct.ruled[key] = nil
+	acct.ApplyRules([]*models.MessageInfo{msg})
+}
+
+// Applies the rules to the new messages which arrived in the selected folder
+// while another one was open
+func (acct *AccountView) applyPendingRules() {
+	store, ok := acct.dirlist.SelectedMsgStore()
+	if !ok {
+		return
+	}
+	uids := acct.unruled[store]
+	delete(acct.unruled, store)
+	for _, uid := range uids {
+		acct.applyNewRules(store, uid)
+	}
+}
+
+// Forgets the messages which are no longer in the store
+func (acct *AccountView) pruneRules(store *lib.MessageStore) {
+	for key := range acct.ruled {
+		if _, ok := store.Messages[key.uid]; key.store == store && !ok {
+			delete(acct.ruled, key)
+		}
+	}
+	var uids []uint32
+	for _, uid := range acct.unruled[store] {
+		if _, ok := store.Messages[uid]; ok {
+			uids = append(uids, uid)
+		}
+	}
+	if len(uids) == 0 {
+		delete(acct.unruled, store)
+	} else {
+		acct.unruled[store] = uids
+	}
+}
+
+// ApplyRules applies the rules of rules.conf to messages of the selected
+// folder, in order, and returns how many messages matched a rule. The rules
+// don't see messages which an earlier rule moved away or stopped at.
+func (acct *AccountView) ApplyRules(msgs []*models.MessageInfo) int {
+	store, ok := acct.dirlist.SelectedMsgStore()
+	if !ok {
+		return 0
+	}
+	var remaining []*models.MessageInfo
+	for _, msg := range msgs {
+		if msg == nil {
+			continue
+		}
+		if _, deleted := store.Deleted[msg.Uid]; !deleted {
+			remaining = append(remaining, msg)
+		}
+	}
+	matched := make(map[uint32]interface{})
+	for i := range acct.conf.Rules {
+		rule := &acct.conf.Rules[i]
+		if !rule.AppliesTo(acct.Name(), store.DirInfo.Name) {
+			continue
+		}
+		var uids []uint32
+		var rest []*models.MessageInfo
+		for _, msg := range remaining {
+			if !rule.Matches(msg) {
+				rest = append(rest, msg)
+				continue
+			}
+			uids = append(uids, msg.Uid)
+			matched[msg.Uid] = nil
+			if rule.Move == "" && !rule.Stop {
+				rest = append(rest, msg)
+			}
+		}
+		remaining = rest
+		if len(uids) != 0 {
+			acct.runRule(store, rule, uids)
+		}
+	}
+	return len(matched)
+}
+
+func (acct *AccountView) runRule(store *lib.MessageStore,
+	rule *config.RuleConfig, uids []uint32) {
+
+	done := func(msg types.WorkerMessage) {
+		switch msg := msg.(type) {
+		case *types.Error:
+			acct.ruleError(rule, msg.Error)
+		case *types.Unsupported:
+			acct.ruleError(rule,
+				errors.New("action not supported by this account"))
+		}
+	}
+	if rule.Copy != "" {
+		store.Copy(uids, rule.Copy, false, done)
+	}
+	for _, flag := range rule.SetFlags {
+		store.Flag(uids, flag, true, done)
+	}
+	if rule.Read != nil {
+		store.Read(uids, *rule.Read, done)
+	}
+	if len(rule.Tags) != 0 {
+		store.Tag(uids, rule.Tags, done)
+	}
+	if rule.Pipe != nil {
+		store.FetchFull(uids, func(reader io.Reader) {
+			cmd := exec.Command(rule.Pipe[0], rule.Pipe[1:]...)
+			cmd.Stdin = reader
+			go func() {
+				if err := cmd.Run(); err != nil {
+					acct.host.Post(func() {
+						acct.ruleError(rule, err)
+					})
+				}
+			}()
+		})
+	}
+	// Last, since the other actions need the messages to be here
+	if rule.Move != "" {
+		store.Move(uids, rule.Move, false, done)
+	}
+}
+
+func (acct *AccountView) ruleError(rule *config.RuleConfig, err error) {
+	acct.logger.Printf("rule %s: %v", rule.Name, err)
+	acct.host.PushStatus(fmt.Sprintf(" rule %s: %v", rule.Name, err),
+		10*time.Second).Style(config.STYLE_STATUSLINE_ERROR)
+}
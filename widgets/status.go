@@ -3,9 +3,9 @@ package widgets
 import (
 	"time"
 
-	"github.com/gdamore/tcell"
 	"github.com/mattn/go-runewidth"
 
+	"git.sr.ht/~sircmpwn/aerc/config"
 	"git.sr.ht/~sircmpwn/aerc/lib/ui"
 )
 
@@ -14,21 +14,21 @@ type StatusLine struct {
 	stack    []*StatusMessage
 	fallback StatusMessage
 	aerc     *Aerc
+	uiConfig *config.UIConfig
 }
 
 type StatusMessage struct {
-	bg      tcell.Color
-	fg      tcell.Color
+	style   config.StyleObject
 	message string
 }
 
-func NewStatusLine() *StatusLine {
+func NewStatusLine(uiConf *config.UIConfig) *StatusLine {
 	return &StatusLine{
 		fallback: StatusMessage{
-			bg:      tcell.ColorDefault,
-			fg:      tcell.ColorDefault,
+			style:   config.STYLE_STATUSLINE_DEFAULT,
 			message: "Idle",
 		},
+		uiConfig: uiConf,
 	}
 }
 
@@ -41,23 +41,27 @@ func (status *StatusLine) Draw(ctx *ui.Context) {
 	if len(status.stack) != 0 {
 		line = status.stack[len(status.stack)-1]
 	}
-	style := tcell.StyleDefault.
-		Background(line.bg).Foreground(line.fg).Reverse(true)
+	style := status.uiConfig.GetStyle(line.style)
 	ctx.Fill(0, 0, ctx.Width(), ctx.Height(), ' ', style)
-	pendingKeys := ""
+	right := ""
 	if status.aerc != nil {
+		if status.aerc.outbox != nil {
+			if queue := status.aerc.outbox.Status(); queue != "" {
+				right = "Outbox: " + queue + " "
+			}
+		}
 		for _, pendingKey := range status.aerc.pendingKeys {
-			pendingKeys += string(pendingKey.Rune)
+			right += string(pendingKey.Rune)
 		}
 	}
-	message := runewidth.FillRight(line.message, ctx.Width()-len(pendingKeys)-5)
-	ctx.Printf(0, 0, style, "%s%s", message, pendingKeys)
+	message := runewidth.FillRight(line.message,
+		ctx.Width()-runewidth.StringWidth(right)-5)
+	ctx.Printf(0, 0, style, "%s%s", message, right)
 }
 
 func (status *StatusLine) Set(text string) *StatusMessage {
 	status.fallback = StatusMessage{
-		bg:      tcell.ColorDefault,
-		fg:      tcell.ColorDefault,
+		style:   config.STYLE_STATUSLINE_DEFAULT,
 		message: text,
 	}
 	status.Invalidate()
@@ -66,8 +70,7 @@ func (status *StatusLine) Set(text string) *StatusMessage {
 
 func (status *StatusLine) Push(text string, expiry time.Duration) *StatusMessage {
 	msg := &StatusMessage{
-		bg:      tcell.ColorDefault,
-		fg:      tcell.ColorDefault,
+		style:   config.STYLE_STATUSLINE_DEFAULT,
 		message: text,
 	}
 	status.stack = append(status.stack, msg)
@@ -92,7 +95,6 @@ func (status *StatusLine) SetAerc(aerc *Aerc) {
 	status.aerc = aerc
 }
 
-func (msg *StatusMessage) Color(bg tcell.Color, fg tcell.Color) {
-	msg.bg = bg
-	msg.fg = fg
+func (msg *StatusMessage) Style(style config.StyleObject) {
+	msg.style = style
 }
@@ -2,11 +2,18 @@ package widgets
 
 import (
 	"time"
+
+	"git.sr.ht/~sircmpwn/aerc/lib/ui"
+	"git.sr.ht/~sircmpwn/aerc/models"
 )
 
 type TabHost interface {
 	BeginExCommand()
+	NewTab(drawable ui.Drawable, name string) *ui.Tab
 	SetStatus(status string) *StatusMessage
 	PushStatus(text string, expiry time.Duration) *StatusMessage
 	Beep()
+	NewMail(acct *AccountView, folder string, msg *models.MessageInfo)
+	Notify(event string, data interface{})
+	Post(fn func())
 }
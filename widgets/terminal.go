@@ -1,6 +1,8 @@
 package widgets
 
 import (
+	"bytes"
+	"fmt"
 	"os"
 	"os/exec"
 	"sync"
@@ -87,8 +89,17 @@ func init() {
 	keyMap[tcell.KeyDEL] = directKey(vterm.KeyBackspace)
 }
 
+// Mouse reporting modes requested by the application, see VTERM_PROP_MOUSE
+const (
+	mouseNone = iota
+	mouseClick
+	mouseDrag
+	mouseMove
+)
+
 type Terminal struct {
 	ui.Invalidatable
+	altScreen   bool
 	closed      bool
 	cmd         *exec.Cmd
 	ctx         *ui.Context
@@ -97,6 +108,9 @@ type Terminal struct {
 	destroyed   bool
 	err         error
 	focus       bool
+	mouseMode   int
+	mouseSGR    bool
+	mousePushed tcell.ButtonMask
 	pty         *os.File
 	start       chan interface{}
 	vterm       *vterm.VTerm
@@ -130,6 +144,7 @@ func NewTerminal(cmd *exec.Cmd) (*Terminal, error) {
 				term.Close(nil)
 				return
 			}
+			term.scanMouseEncoding(buf[:n])
 			term.writeMutex.Lock()
 			n, err = term.vterm.Write(buf[:n])
 			term.writeMutex.Unlock()
@@ -186,7 +201,9 @@ func (term *Terminal) Close(err error) {
 		term.OnClose(err)
 	}
 	term.closed = true
-	term.ctx.HideCursor()
+	if term.ctx != nil {
+		term.ctx.HideCursor()
+	}
 }
 
 func (term *Terminal) Destroy() {
@@ -377,6 +394,126 @@ func (term *Terminal) Event(event tcell.Event) bool {
 	return false
 }
 
+// libvterm doesn't tell us which encoding the application asked mouse reports
+// to use, so look for the SGR (1006) mode switch ourselves.
+func (term *Terminal) scanMouseEncoding(buf []byte) {
+	on := bytes.LastIndex(buf, []byte("\x1b[?1006h"))
+	off := bytes.LastIndex(buf, []byte("\x1b[?1006l"))
+	if on > off {
+		term.mouseSGR = true
+	} else if off > on {
+		term.mouseSGR = false
+	}
+}
+
+func (term *Terminal) MouseEvent(localX int, localY int, event tcell.Event) {
+	if term.closed || term.pty == nil {
+		return
+	}
+	mouse, ok := event.(*tcell.EventMouse)
+	if !ok {
+		return
+	}
+	buttons := mouse.Buttons()
+	if term.mouseMode == mouseNone {
+		// Like xterm's alternate scroll mode, scroll full screen
+		// applications which don't handle the mouse with the arrow keys
+		if !term.altScreen {
+			return
+		}
+		key := vterm.KeyNone
+		if buttons&tcell.WheelUp != 0 {
+			key = vterm.KeyUp
+		} else if buttons&tcell.WheelDown != 0 {
+			key = vterm.KeyDown
+		}
+		if key != vterm.KeyNone {
+			for i := 0; i < 3; i++ {
+				term.vterm.KeyboardKey(key, vterm.ModNone)
+			}
+			term.flushTerminal()
+		}
+		return
+	}
+
+	var (
+		code    int
+		release bool
+	)
+	pressed := buttons & (tcell.Button1 | tcell.Button2 | tcell.Button3)
+	switch {
+	case buttons&tcell.WheelUp != 0:
+		code = 64
+	case buttons&tcell.WheelDown != 0:
+		code = 65
+	case pressed != 0 && pressed == term.mousePushed:
+		if term.mouseMode < mouseDrag {
+			return
+		}
+		code = mouseButtonCode(pressed) + 32
+	case pressed != 0:
+		code = mouseButtonCode(pressed)
+	case term.mousePushed != 0:
+		code = mouseButtonCode(term.mousePushed)
+		release = true
+	default:
+		if term.mouseMode < mouseMove {
+			return
+		}
+		code = 3 + 32
+	}
+	term.mousePushed = pressed
+
+	mods := mouse.Modifiers()
+	if mods&tcell.ModShift != 0 {
+		code |= 4
+	}
+	if mods&tcell.ModAlt != 0 {
+		code |= 8
+	}
+	if mods&tcell.ModCtrl != 0 {
+		code |= 16
+	}
+
+	var report string
+	if term.mouseSGR {
+		final := 'M'
+		if release {
+			final = 'm'
+		}
+		report = fmt.Sprintf("\x1b[<%d;%d;%d%c",
+			code, localX+1, localY+1, final)
+	} else {
+		if release {
+			code = code&^3 | 3
+		}
+		if localX > 222 || localY > 222 {
+			// Can't be encoded
+			return
+		}
+		report = string([]byte{'\x1b', '[', 'M', byte(32 + code),
+			byte(33 + localX), byte(33 + localY)})
+	}
+	term.writeMutex.Lock()
+	_, err := term.pty.Write([]byte(report))
+	term.writeMutex.Unlock()
+	if err != nil {
+		term.Close(err)
+	}
+}
+
+// Returns the xterm mouse report code of the lowest pressed button
+func mouseButtonCode(buttons tcell.ButtonMask) int {
+	switch {
+	case buttons&tcell.Button1 != 0:
+		return 0
+	case buttons&tcell.Button2 != 0:
+		return 1
+	default:
+		return 2
+	}
+}
+
 func (term *Terminal) styleFromCell(cell *vterm.ScreenCell) tcell.Style {
 	style := tcell.StyleDefault
 
@@ -451,6 +588,10 @@ func (term *Terminal) onSetTermProp(prop int, val *vterm.VTermValue) int {
 	case vterm.VTERM_PROP_CURSORVISIBLE:
 		term.cursorShown = val.Boolean
 		term.invalidate()
+	case vterm.VTERM_PROP_ALTSCREEN:
+		term.altScreen = val.Boolean
+	case vterm.VTERM_PROP_MOUSE:
+		term.mouseMode = val.Number
 	}
 	return 1
 }
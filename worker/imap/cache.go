@@ -0,0 +1,290 @@
+package imap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+
+	"git.sr.ht/~sircmpwn/aerc/models"
+)
+
+// A cache keeps what the worker got from the server, to serve it offline: the
+// folders, and for each folder its messages, their headers and the bodies
+// which were read.
+type cache struct {
+	dir     string
+	folders []*models.Directory
+	// The folder loaded last, i.e. the selected one
+	name   string
+	folder *cachedFolder
+	dirty  bool
+}
+
+type cachedFolder struct {
+	UidValidity uint32
+	Uids        []uint32
+	Messages    map[uint32]*cachedMessage
+}
+
+type cachedMessage struct {
+	Flags         []string
+	InternalDate  time.Time
+	Envelope      *models.Envelope
+	BodyStructure *models.BodyStructure
+	Header        []byte
+}
+
+func newCache(dir string) (*cache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &cache{dir: dir}, nil
+}
+
+func (c *cache) folderDir(name string) string {
+	return filepath.Join(c.dir, "folders", url.PathEscape(name))
+}
+
+// Writes a file atomically
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (c *cache) setFolders(dirs []*models.Directory) error {
+	c.folders = dirs
+	data, err := json.Marshal(dirs)
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(c.dir, "folders.json"), data)
+}
+
+func (c *cache) loadFolders() ([]*models.Directory, error) {
+	if c.folders != nil {
+		return c.folders, nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(c.dir, "folders.json"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no folders available offline")
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.folders); err != nil {
+		return nil, err
+	}
+	return c.folders, nil
+}
+
+// Loads the cache of a folder. A UID validity other than the cached one means
+// the cached messages are not those on the server anymore; zero takes what is
+// cached, if anything.
+func (c *cache) open(name string, uidValidity uint32) error {
+	if err := c.flush(); err != nil {
+		return err
+	}
+	c.name = name
+	c.folder = &cachedFolder{
+		UidValidity: uidValidity,
+		Messages:    make(map[uint32]*cachedMessage),
+	}
+	data, err := ioutil.ReadFile(filepath.Join(c.folderDir(name), "index.json"))
+	if os.IsNotExist(err) {
+		if uidValidity == 0 {
+			c.folder = nil
+			return fmt.Errorf("%s is not available offline", name)
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var folder cachedFolder
+	if err := json.Unmarshal(data, &folder); err != nil {
+		return err
+	}
+	if uidValidity != 0 && folder.UidValidity != uidValidity {
+		c.dirty = true
+		return os.RemoveAll(c.folderDir(name))
+	}
+	if folder.Messages == nil {
+		folder.Messages = make(map[uint32]*cachedMessage)
+	}
+	c.folder = &folder
+	return nil
+}
+
+// Writes the changes to the selected folder
+func (c *cache) flush() error {
+	if !c.dirty || c.folder == nil {
+		return nil
+	}
+	data, err := json.Marshal(c.folder)
+	if err != nil {
+		return err
+	}
+	c.dirty = false
+	return writeFile(filepath.Join(c.folderDir(c.name), "index.json"), data)
+}
+
+// Sets the messages of the selected folder, and forgets those gone
+func (c *cache) setUids(uids []uint32) {
+	if c.folder == nil {
+		return
+	}
+	listed := make(map[uint32]bool, len(uids))
+	for _, uid := range uids {
+		listed[uid] = true
+	}
+	for uid := range c.folder.Messages {
+		if !listed[uid] {
+			c.remove(uid)
+		}
+	}
+	c.folder.Uids = uids
+	c.dirty = true
+}
+
+func (c *cache) putMessage(uid uint32, msg *cachedMessage) {
+	if c.folder == nil {
+		return
+	}
+	c.folder.Messages[uid] = msg
+	c.dirty = true
+}
+
+func (c *cache) message(uid uint32) (*cachedMessage, bool) {
+	if c.folder == nil {
+		return nil, false
+	}
+	msg, ok := c.folder.Messages[uid]
+	return msg, ok
+}
+
+// Sets or clears flags of messages of the selected folder
+func (c *cache) updateFlags(uids []uint32, flags []string, enable bool) {
+	for _, uid := range uids {
+		msg, ok := c.message(uid)
+		if !ok {
+			continue
+		}
+		var updated []string
+		for _, f := range msg.Flags {
+			if !hasString(flags, f) {
+				updated = append(updated, f)
+			}
+		}
+		if enable {
+			updated = append(updated, flags...)
+		}
+		msg.Flags = updated
+		c.dirty = true
+	}
+}
+
+func hasString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *cache) setFlags(uid uint32, flags []string) {
+	if msg, ok := c.message(uid); ok {
+		msg.Flags = flags
+		c.dirty = true
+	}
+}
+
+// Forgets a message of the selected folder
+func (c *cache) remove(uid uint32) {
+	if c.folder == nil {
+		return
+	}
+	delete(c.folder.Messages, uid)
+	for i, u := range c.folder.Uids {
+		if u == uid {
+			c.folder.Uids = append(c.folder.Uids[:i:i], c.folder.Uids[i+1:]...)
+			break
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(c.folderDir(c.name),
+		strconv.FormatUint(uint64(uid), 10)+".*"))
+	for _, file := range files {
+		os.Remove(file)
+	}
+	c.dirty = true
+}
+
+// Returns the file of a body part of a message; no part is the whole message
+func (c *cache) bodyPath(uid uint32, part []int) string {
+	name := strconv.FormatUint(uint64(uid), 10)
+	var path []string
+	for _, n := range part {
+		path = append(path, strconv.Itoa(n))
+	}
+	if len(path) == 0 {
+		name += ".eml"
+	} else {
+		name += "." + strings.Join(path, ".") + ".part"
+	}
+	return filepath.Join(c.folderDir(c.name), name)
+}
+
+func (c *cache) putBody(uid uint32, part []int, body []byte) error {
+	if _, ok := c.message(uid); !ok {
+		return nil
+	}
+	return writeFile(c.bodyPath(uid, part), body)
+}
+
+func (c *cache) body(uid uint32, part []int) ([]byte, error) {
+	body, err := ioutil.ReadFile(c.bodyPath(uid, part))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("message %d is not available offline", uid)
+	}
+	return body, err
+}
+
+// Returns the info of a cached message for the UI
+func (msg *cachedMessage) info(uid uint32) *models.MessageInfo {
+	info := &models.MessageInfo{
+		BodyStructure: msg.BodyStructure,
+		Envelope:      msg.Envelope,
+		Flags:         translateFlags(msg.Flags),
+		InternalDate:  msg.InternalDate,
+		Uid:           uid,
+	}
+	if header, err := parseHeader(msg.Header); err == nil {
+		info.RFC822Headers = header
+	}
+	return info
+}
+
+// Counts the messages of the selected folder, and the unseen ones
+func (c *cache) counts() (int, int) {
+	unseen := 0
+	for _, uid := range c.folder.Uids {
+		if msg, ok := c.folder.Messages[uid]; ok &&
+			!hasString(msg.Flags, imap.SeenFlag) {
+
+			unseen++
+		}
+	}
+	return len(c.folder.Uids), unseen
+}
@@ -2,6 +2,9 @@ package imap
 
 import (
 	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
 
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-message"
@@ -73,25 +76,34 @@ func (imapw *IMAPWorker) handleFetchMessages(
 			imapw.seqMap[_msg.SeqNum-1] = _msg.Uid
 			switch msg.(type) {
 			case *types.FetchMessageHeaders:
-				reader := _msg.GetBody(section)
-				textprotoHeader, err := textproto.ReadHeader(bufio.NewReader(reader))
-				var header *mail.Header
-				if err == nil {
-					header = &mail.Header{message.Header{textprotoHeader}}
+				var raw []byte
+				if reader := _msg.GetBody(section); reader != nil {
+					raw, _ = ioutil.ReadAll(reader)
+				}
+				header, _ := parseHeader(raw)
+				info := &models.MessageInfo{
+					BodyStructure: translateBodyStructure(_msg.BodyStructure),
+					Envelope:      translateEnvelope(_msg.Envelope),
+					Flags:         translateFlags(_msg.Flags),
+					InternalDate:  _msg.InternalDate,
+					RFC822Headers: header,
+					Uid:           _msg.Uid,
+				}
+				if imapw.cache != nil {
+					imapw.cache.putMessage(_msg.Uid, &cachedMessage{
+						Flags:         _msg.Flags,
+						InternalDate:  _msg.InternalDate,
+						Envelope:      info.Envelope,
+						BodyStructure: info.BodyStructure,
+						Header:        raw,
+					})
 				}
 				imapw.worker.PostMessage(&types.MessageInfo{
 					Message: types.RespondTo(msg),
-					Info: &models.MessageInfo{
-						BodyStructure: translateBodyStructure(_msg.BodyStructure),
-						Envelope:      translateEnvelope(_msg.Envelope),
-						Flags:         translateFlags(_msg.Flags),
-						InternalDate:  _msg.InternalDate,
-						RFC822Headers: header,
-						Uid:           _msg.Uid,
-					},
+					Info:    info,
 				}, nil)
 			case *types.FetchFullMessages:
-				reader := _msg.GetBody(section)
+				reader := imapw.cacheBody(_msg, nil, _msg.GetBody(section))
 				imapw.worker.PostMessage(&types.FullMessage{
 					Message: types.RespondTo(msg),
 					Content: &models.FullMessage{
@@ -108,7 +120,8 @@ func (imapw *IMAPWorker) handleFetchMessages(
 					},
 				}, nil)
 			case *types.FetchMessageBodyPart:
-				reader := _msg.GetBody(section)
+				reader := imapw.cacheBody(_msg, section.Path,
+					_msg.GetBody(section))
 				imapw.worker.PostMessage(&types.MessageBodyPart{
 					Message: types.RespondTo(msg),
 					Part: &models.MessageBodyPart{
@@ -141,3 +154,31 @@ func (imapw *IMAPWorker) handleFetchMessages(
 			&types.Done{types.RespondTo(msg)}, nil)
 	}
 }
+
+// Keeps a body in the cache, if any, and returns a reader of it to use instead
+// of the one read from the server
+func (imapw *IMAPWorker) cacheBody(msg *imap.Message, part []int,
+	reader io.Reader) io.Reader {
+
+	if imapw.cache == nil || reader == nil {
+		return reader
+	}
+	body, err := ioutil.ReadAll(reader)
+	if err == nil {
+		imapw.cache.setFlags(msg.Uid, msg.Flags)
+		err = imapw.cache.putBody(msg.Uid, part, body)
+	}
+	if err != nil {
+		imapw.worker.Logger.Printf("could not cache message %d: %v",
+			msg.Uid, err)
+	}
+	return bytes.NewReader(body)
+}
+
+func parseHeader(raw []byte) (*mail.Header, error) {
+	header, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		return nil, err
+	}
+	return &mail.Header{Header: message.Header{Header: header}}, nil
+}
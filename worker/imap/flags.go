@@ -35,6 +35,11 @@ func (imapw *IMAPWorker) handleDeleteMessages(msg *types.DeleteMessages) {
 		}, nil)
 	} else {
 		<-done
+		if imapw.cache != nil {
+			for _, uid := range deleted {
+				imapw.cache.remove(uid)
+			}
+		}
 		imapw.worker.PostMessage(&types.MessagesDeleted{
 			Message: types.RespondTo(msg),
 			Uids:    deleted,
@@ -50,13 +55,50 @@ func (imapw *IMAPWorker) handleReadMessages(msg *types.ReadMessages) {
 		item = imap.FormatFlagsOp(imap.RemoveFlags, true)
 		flags = []interface{}{imap.SeenFlag}
 	}
-	uids := toSeqSet(msg.Uids)
-	if err := imapw.client.UidStore(uids, item, flags, nil); err != nil {
+	imapw.storeFlags(msg, msg.Uids, item, flags)
+}
+
+func (imapw *IMAPWorker) handleFlagMessages(msg *types.FlagMessages) {
+	var imapFlag string
+	for name, flag := range flagMap {
+		if flag == msg.Flag {
+			imapFlag = name
+		}
+	}
+	item := imap.FormatFlagsOp(imap.AddFlags, true)
+	if !msg.Enable {
+		item = imap.FormatFlagsOp(imap.RemoveFlags, true)
+	}
+	imapw.storeFlags(msg, msg.Uids, item, []interface{}{imapFlag})
+}
+
+func (imapw *IMAPWorker) handleTagMessages(msg *types.TagMessages) {
+	item := imap.FormatFlagsOp(imap.AddFlags, true)
+	flags := make([]interface{}, len(msg.Tags))
+	for i, tag := range msg.Tags {
+		flags[i] = tag
+	}
+	imapw.storeFlags(msg, msg.Uids, item, flags)
+}
+
+func (imapw *IMAPWorker) storeFlags(msg types.WorkerMessage, uids []uint32,
+	item imap.StoreItem, flags []interface{}) {
+
+	if err := imapw.client.UidStore(toSeqSet(uids), item, flags,
+		nil); err != nil {
 		imapw.worker.PostMessage(&types.Error{
 			Message: types.RespondTo(msg),
 			Error:   err,
 		}, nil)
 		return
 	}
-	imapw.worker.PostMessage(&types.Done{types.RespondTo(msg)}, nil)
+	if imapw.cache != nil {
+		names := make([]string, len(flags))
+		for i, flag := range flags {
+			names[i] = flag.(string)
+		}
+		imapw.cache.updateFlags(uids, names,
+			item == imap.FormatFlagsOp(imap.AddFlags, true))
+	}
+	imapw.worker.PostMessage(&types.Done{Message: types.RespondTo(msg)}, nil)
 }
@@ -0,0 +1,213 @@
+package imap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/emersion/go-imap"
+
+	"git.sr.ht/~sircmpwn/aerc/worker/types"
+)
+
+// An operation made offline, for the server once the worker connects again
+type operation struct {
+	// flags, copy, delete or append
+	Op     string `json:"op"`
+	Folder string `json:"folder,omitempty"`
+	// The UID validity of the folder which the UIDs belong to
+	UidValidity uint32    `json:"uidvalidity,omitempty"`
+	Uids        []uint32  `json:"uids,omitempty"`
+	Flags       []string  `json:"flags,omitempty"`
+	Enable      bool      `json:"enable,omitempty"`
+	Destination string    `json:"destination,omitempty"`
+	Date        time.Time `json:"date,omitempty"`
+	// The message to append, in the outbox of the cache
+	File string `json:"file,omitempty"`
+}
+
+// A journal keeps the operations made offline, in order, across restarts
+type journal struct {
+	path string
+	ops  []*operation
+}
+
+func loadJournal(path string) (*journal, error) {
+	j := &journal{path: path}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return j, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		var op operation
+		if err := json.Unmarshal(scanner.Bytes(), &op); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		j.ops = append(j.ops, &op)
+	}
+	return j, scanner.Err()
+}
+
+func (j *journal) save() error {
+	var buf bytes.Buffer
+	for _, op := range j.ops {
+		line, err := json.Marshal(op)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return writeFile(j.path, buf.Bytes())
+}
+
+func (j *journal) add(op *operation) error {
+	j.ops = append(j.ops, op)
+	return j.save()
+}
+
+// Keeps a message to append in the outbox, and returns its file
+func (j *journal) keep(content []byte) (string, error) {
+	dir := filepath.Join(filepath.Dir(j.path), "outbox")
+	name := strconv.FormatInt(time.Now().UnixNano(), 10) + ".eml"
+	if err := writeFile(filepath.Join(dir, name), content); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// Replays the journal on the server, oldest operation first. Operations which
+// no longer apply, e.g. to messages which vanished on the server, are reported
+// and dropped. Replaying stops if the connection is lost again.
+func (w *IMAPWorker) replay() {
+	j := w.journal
+	if len(j.ops) == 0 {
+		return
+	}
+	w.worker.Logger.Printf("Replaying %d offline changes", len(j.ops))
+	// The updates sent while replaying are about other folders than the one
+	// open in the UI, which is fetched again afterwards. They are dropped, so
+	// that the client does not block on them while this goroutine replays.
+	stop := make(chan struct{})
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		for {
+			select {
+			case <-w.updates:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-drained
+	}()
+	for len(j.ops) != 0 {
+		op := j.ops[0]
+		err := w.replayOp(op)
+		if err != nil && w.disconnected() {
+			w.worker.Logger.Printf("Replay interrupted: %v", err)
+			return
+		}
+		if err != nil {
+			w.worker.PostMessage(&types.Error{
+				Error: fmt.Errorf("offline change not applied: %v", err),
+			}, nil)
+		}
+		if op.File != "" {
+			os.Remove(op.File)
+		}
+		j.ops = j.ops[1:]
+		if err := j.save(); err != nil {
+			w.worker.Logger.Printf("could not save journal: %v", err)
+		}
+	}
+}
+
+func (w *IMAPWorker) replayOp(op *operation) error {
+	c := w.client
+	if op.Op == "append" {
+		content, err := ioutil.ReadFile(op.File)
+		if err != nil {
+			return err
+		}
+		return c.Append(op.Destination, op.Flags, op.Date, &appendLiteral{
+			Reader: bytes.NewReader(content),
+			Length: len(content),
+		})
+	}
+
+	status, err := c.Select(op.Folder, false)
+	if err != nil {
+		return err
+	}
+	if status.UidValidity != op.UidValidity {
+		return fmt.Errorf("%s was recreated on the server", op.Folder)
+	}
+	found, err := c.UidSearch(&imap.SearchCriteria{Uid: toSeqSet(op.Uids)})
+	if err != nil {
+		return err
+	}
+	var vanished error
+	if len(found) < len(op.Uids) {
+		vanished = fmt.Errorf("%d of the messages of a %s in %s vanished "+
+			"on the server", len(op.Uids)-len(found), op.Op, op.Folder)
+	}
+	if len(found) == 0 {
+		return vanished
+	}
+	set := toSeqSet(found)
+	switch op.Op {
+	case "flags":
+		var flags []interface{}
+		for _, flag := range op.Flags {
+			flags = append(flags, flag)
+		}
+		item := imap.FormatFlagsOp(imap.AddFlags, true)
+		if !op.Enable {
+			item = imap.FormatFlagsOp(imap.RemoveFlags, true)
+		}
+		err = c.UidStore(set, item, flags, nil)
+	case "copy":
+		err = c.UidCopy(set, op.Destination)
+	case "delete":
+		item := imap.FormatFlagsOp(imap.AddFlags, true)
+		flags := []interface{}{imap.DeletedFlag}
+		if err = c.UidStore(set, item, flags, nil); err == nil {
+			err = expunge(c)
+		}
+	default:
+		err = fmt.Errorf("unknown operation %q", op.Op)
+	}
+	if err != nil {
+		return err
+	}
+	return vanished
+}
+
+// Expunges the selected folder, without sending the expunged messages to the
+// updates of the client
+func expunge(c *imapClient) error {
+	ch := make(chan uint32)
+	done := make(chan struct{})
+	go func() {
+		for range ch {
+		}
+		close(done)
+	}()
+	err := c.Expunge(ch)
+	<-done
+	return err
+}
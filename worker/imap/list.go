@@ -11,6 +11,7 @@ func (imapw *IMAPWorker) handleListDirectories(msg *types.ListDirectories) {
 	mailboxes := make(chan *imap.MailboxInfo)
 	imapw.worker.Logger.Println("Listing mailboxes")
 	done := make(chan interface{})
+	var dirs []*models.Directory
 
 	go func() {
 		for mbox := range mailboxes {
@@ -18,12 +19,14 @@ func (imapw *IMAPWorker) handleListDirectories(msg *types.ListDirectories) {
 				// no need to pass this to handlers if it can't be opened
 				continue
 			}
+			dir := &models.Directory{
+				Name:       mbox.Name,
+				Attributes: mbox.Attributes,
+			}
+			dirs = append(dirs, dir)
 			imapw.worker.PostMessage(&types.Directory{
 				Message: types.RespondTo(msg),
-				Dir: &models.Directory{
-					Name:       mbox.Name,
-					Attributes: mbox.Attributes,
-				},
+				Dir:     dir,
 			}, nil)
 		}
 		done <- nil
@@ -37,6 +40,11 @@ func (imapw *IMAPWorker) handleListDirectories(msg *types.ListDirectories) {
 		}, nil)
 	} else {
 		<-done
+		if imapw.cache != nil {
+			if err := imapw.cache.setFolders(dirs); err != nil {
+				imapw.worker.Logger.Printf("could not cache folders: %v", err)
+			}
+		}
 		imapw.worker.PostMessage(
 			&types.Done{types.RespondTo(msg)}, nil)
 	}
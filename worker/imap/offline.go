@@ -0,0 +1,306 @@
+package imap
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/backendutil"
+	"github.com/emersion/go-message"
+
+	"git.sr.ht/~sircmpwn/aerc/models"
+	"git.sr.ht/~sircmpwn/aerc/worker/lib"
+	"git.sr.ht/~sircmpwn/aerc/worker/types"
+)
+
+var errNoFolder = fmt.Errorf("no folder open")
+
+// Serves the UI from the cache while the client is not connected. Changes are
+// made to the cache and queued in the journal, for the server.
+func (w *IMAPWorker) handleOffline(msg types.WorkerMessage) error {
+	var err error
+	switch msg := msg.(type) {
+	case *types.ListDirectories:
+		err = w.offlineListDirectories(msg)
+	case *types.OpenDirectory:
+		err = w.offlineOpenDirectory(msg)
+	case *types.FetchDirectoryContents:
+		err = w.offlineFetchDirectoryContents(msg)
+	case *types.CreateDirectory:
+		if msg.Quiet {
+			return nil
+		}
+		err = fmt.Errorf("folders cannot be created offline")
+	case *types.FetchMessageHeaders:
+		err = w.offlineFetchMessageHeaders(msg)
+	case *types.FetchMessageBodyPart:
+		err = w.offlineFetchMessageBodyPart(msg)
+	case *types.FetchFullMessages:
+		err = w.offlineFetchFullMessages(msg)
+	case *types.DeleteMessages:
+		err = w.offlineDeleteMessages(msg)
+	case *types.ReadMessages:
+		err = w.offlineFlags(msg, msg.Uids, []string{imap.SeenFlag}, msg.Read)
+	case *types.FlagMessages:
+		var flag string
+		for name, f := range flagMap {
+			if f == msg.Flag {
+				flag = name
+			}
+		}
+		err = w.offlineFlags(msg, msg.Uids, []string{flag}, msg.Enable)
+	case *types.TagMessages:
+		err = w.offlineFlags(msg, msg.Uids, msg.Tags, true)
+	case *types.CopyMessages:
+		err = w.offlineCopyMessages(msg)
+	case *types.AppendMessage:
+		err = w.offlineAppendMessage(msg)
+	case *types.SearchDirectory:
+		err = w.offlineSearchDirectory(msg)
+	default:
+		return errUnsupported
+	}
+	if err != nil {
+		return err
+	}
+	w.worker.PostMessage(&types.Done{Message: types.RespondTo(msg)}, nil)
+	return nil
+}
+
+func (w *IMAPWorker) offlineListDirectories(msg *types.ListDirectories) error {
+	dirs, err := w.cache.loadFolders()
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		w.worker.PostMessage(&types.Directory{
+			Message: types.RespondTo(msg),
+			Dir:     dir,
+		}, nil)
+	}
+	return nil
+}
+
+func (w *IMAPWorker) offlineOpenDirectory(msg *types.OpenDirectory) error {
+	if err := w.cache.open(msg.Directory, 0); err != nil {
+		return err
+	}
+	exists, unseen := w.cache.counts()
+	w.worker.PostMessage(&types.DirectoryInfo{
+		Info: &models.DirectoryInfo{
+			Name:   msg.Directory,
+			Exists: exists,
+			Unseen: unseen,
+		},
+	}, nil)
+	return nil
+}
+
+func (w *IMAPWorker) offlineFetchDirectoryContents(
+	msg *types.FetchDirectoryContents) error {
+
+	if w.cache.folder == nil {
+		return errNoFolder
+	}
+	w.worker.PostMessage(&types.DirectoryContents{
+		Message: types.RespondTo(msg),
+		Uids:    w.cache.folder.Uids,
+	}, nil)
+	return nil
+}
+
+func (w *IMAPWorker) offlineFetchMessageHeaders(
+	msg *types.FetchMessageHeaders) error {
+
+	for _, uid := range msg.Uids {
+		cached, ok := w.cache.message(uid)
+		if !ok {
+			continue
+		}
+		w.worker.PostMessage(&types.MessageInfo{
+			Message: types.RespondTo(msg),
+			Info:    cached.info(uid),
+		}, nil)
+	}
+	return nil
+}
+
+func (w *IMAPWorker) offlineFetchFullMessages(
+	msg *types.FetchFullMessages) error {
+
+	for _, uid := range msg.Uids {
+		body, err := w.cache.body(uid, nil)
+		if err != nil {
+			return err
+		}
+		w.worker.PostMessage(&types.FullMessage{
+			Message: types.RespondTo(msg),
+			Content: &models.FullMessage{
+				Reader: bytes.NewReader(body),
+				Uid:    uid,
+			},
+		}, nil)
+	}
+	return nil
+}
+
+func (w *IMAPWorker) offlineFetchMessageBodyPart(
+	msg *types.FetchMessageBodyPart) error {
+
+	body, err := w.cache.body(msg.Uid, msg.Part)
+	if err != nil {
+		// The part may be in the whole message
+		full, ferr := w.cache.body(msg.Uid, nil)
+		if ferr != nil {
+			return err
+		}
+		entity, err := message.Read(bytes.NewReader(full))
+		if err != nil {
+			return err
+		}
+		reader, err := lib.FetchEntityPartReader(entity, msg.Part)
+		if err != nil {
+			return err
+		}
+		if body, err = ioutil.ReadAll(reader); err != nil {
+			return err
+		}
+	}
+	w.worker.PostMessage(&types.MessageBodyPart{
+		Message: types.RespondTo(msg),
+		Part: &models.MessageBodyPart{
+			Reader: bytes.NewReader(body),
+			Uid:    msg.Uid,
+		},
+	}, nil)
+
+	// As the server would, mark the message as read
+	if cached, ok := w.cache.message(msg.Uid); ok &&
+		!hasString(cached.Flags, imap.SeenFlag) {
+
+		return w.offlineFlags(msg, []uint32{msg.Uid},
+			[]string{imap.SeenFlag}, true)
+	}
+	return nil
+}
+
+// Sets or clears flags in the cache, and queues the change
+func (w *IMAPWorker) offlineFlags(msg types.WorkerMessage, uids []uint32,
+	flags []string, enable bool) error {
+
+	if w.cache.folder == nil {
+		return errNoFolder
+	}
+	err := w.journal.add(&operation{
+		Op:          "flags",
+		Folder:      w.cache.name,
+		UidValidity: w.cache.folder.UidValidity,
+		Uids:        uids,
+		Flags:       flags,
+		Enable:      enable,
+	})
+	if err != nil {
+		return err
+	}
+	w.cache.updateFlags(uids, flags, enable)
+	for _, uid := range uids {
+		if cached, ok := w.cache.message(uid); ok {
+			w.worker.PostMessage(&types.MessageInfo{
+				Message: types.RespondTo(msg),
+				Info: &models.MessageInfo{
+					Flags: translateFlags(cached.Flags),
+					Uid:   uid,
+				},
+			}, nil)
+		}
+	}
+	return nil
+}
+
+func (w *IMAPWorker) offlineDeleteMessages(msg *types.DeleteMessages) error {
+	if w.cache.folder == nil {
+		return errNoFolder
+	}
+	err := w.journal.add(&operation{
+		Op:          "delete",
+		Folder:      w.cache.name,
+		UidValidity: w.cache.folder.UidValidity,
+		Uids:        msg.Uids,
+	})
+	if err != nil {
+		return err
+	}
+	for _, uid := range msg.Uids {
+		w.cache.remove(uid)
+	}
+	w.worker.PostMessage(&types.MessagesDeleted{
+		Message: types.RespondTo(msg),
+		Uids:    msg.Uids,
+	}, nil)
+	return nil
+}
+
+func (w *IMAPWorker) offlineCopyMessages(msg *types.CopyMessages) error {
+	if w.cache.folder == nil {
+		return errNoFolder
+	}
+	return w.journal.add(&operation{
+		Op:          "copy",
+		Folder:      w.cache.name,
+		UidValidity: w.cache.folder.UidValidity,
+		Uids:        msg.Uids,
+		Destination: msg.Destination,
+	})
+}
+
+func (w *IMAPWorker) offlineAppendMessage(msg *types.AppendMessage) error {
+	content, err := ioutil.ReadAll(msg.Reader)
+	if err != nil {
+		return err
+	}
+	file, err := w.journal.keep(content)
+	if err != nil {
+		return err
+	}
+	return w.journal.add(&operation{
+		Op:          "append",
+		Destination: msg.Destination,
+		Flags:       msg.Flags,
+		Date:        msg.Date,
+		File:        file,
+	})
+}
+
+// Searches the cached messages; only the headers of those whose body was not
+// read are searched
+func (w *IMAPWorker) offlineSearchDirectory(msg *types.SearchDirectory) error {
+	if w.cache.folder == nil {
+		return errNoFolder
+	}
+	var uids []uint32
+	for i, uid := range w.cache.folder.Uids {
+		cached, ok := w.cache.message(uid)
+		if !ok {
+			continue
+		}
+		content, err := w.cache.body(uid, nil)
+		if err != nil {
+			content = cached.Header
+		}
+		entity, err := message.Read(bytes.NewReader(content))
+		if err != nil {
+			continue
+		}
+		ok, err = backendutil.Match(entity, uint32(i+1), uid,
+			cached.InternalDate, cached.Flags, msg.Criteria)
+		if err == nil && ok {
+			uids = append(uids, uid)
+		}
+	}
+	w.worker.PostMessage(&types.SearchResults{
+		Message: types.RespondTo(msg),
+		Uids:    uids,
+	}, nil)
+	return nil
+}
@@ -0,0 +1,206 @@
+package imap
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+
+	"git.sr.ht/~sircmpwn/aerc/config"
+	"git.sr.ht/~sircmpwn/aerc/models"
+	"git.sr.ht/~sircmpwn/aerc/worker/types"
+)
+
+func serve(t *testing.T, be *memory.Backend, addr string) (*server.Server,
+	string) {
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.New(be)
+	s.AllowInsecureAuth = true
+	go s.Serve(ln)
+	return s, ln.Addr().String()
+}
+
+// Posts an action, and returns the messages up to its Done
+func post(t *testing.T, w *types.Worker, action types.WorkerMessage) []types.WorkerMessage {
+	w.PostAction(action, nil)
+	var msgs []types.WorkerMessage
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-w.Messages:
+			msgs = append(msgs, msg)
+			if msg.InResponseTo() != action {
+				continue
+			}
+			switch msg := msg.(type) {
+			case *types.Done:
+				return msgs
+			case *types.Error:
+				t.Fatalf("%T: %v", action, msg.Error)
+			}
+		case <-timeout:
+			t.Fatalf("%T: timed out", action)
+		}
+	}
+}
+
+// Waits for an unsolicited error
+func waitError(t *testing.T, w *types.Worker, substr string) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-w.Messages:
+			if msg, ok := msg.(*types.Error); ok &&
+				strings.Contains(msg.Error.Error(), substr) {
+
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no %q error", substr)
+		}
+	}
+}
+
+func TestOffline(t *testing.T) {
+	reconnectInterval = 100 * time.Millisecond
+	dir, err := ioutil.TempDir("", "aerc-imap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("XDG_CACHE_HOME", dir)
+
+	be := memory.New()
+	s, addr := serve(t, be, "127.0.0.1:0")
+
+	w := types.NewWorker(log.New(ioutil.Discard, "", 0))
+	backend, _ := NewIMAPWorker(w)
+	w.Backend = backend
+	go backend.Run()
+
+	w.PostAction(&types.Configure{Config: &config.AccountConfig{
+		Source: "imap+insecure://username:password@" + addr,
+		Params: map[string]string{"offline": "yes"},
+	}}, nil)
+	post(t, w, &types.Connect{})
+	post(t, w, &types.ListDirectories{})
+	post(t, w, &types.OpenDirectory{Directory: "INBOX"})
+	post(t, w, &types.FetchDirectoryContents{})
+	post(t, w, &types.FetchMessageHeaders{Uids: []uint32{6}})
+	post(t, w, &types.FetchFullMessages{Uids: []uint32{6}})
+
+	s.Close()
+	waitError(t, w, "working offline")
+
+	var dirs []string
+	for _, msg := range post(t, w, &types.ListDirectories{}) {
+		if msg, ok := msg.(*types.Directory); ok {
+			dirs = append(dirs, msg.Dir.Name)
+		}
+	}
+	if len(dirs) != 1 || dirs[0] != "INBOX" {
+		t.Errorf("wrong folders offline: %v", dirs)
+	}
+	post(t, w, &types.OpenDirectory{Directory: "INBOX"})
+	var uids []uint32
+	for _, msg := range post(t, w, &types.FetchDirectoryContents{}) {
+		if msg, ok := msg.(*types.DirectoryContents); ok {
+			uids = msg.Uids
+		}
+	}
+	if len(uids) != 1 || uids[0] != 6 {
+		t.Errorf("wrong messages offline: %v", uids)
+	}
+	var subject string
+	for _, msg := range post(t, w, &types.FetchMessageHeaders{Uids: uids}) {
+		if msg, ok := msg.(*types.MessageInfo); ok {
+			subject = msg.Info.Envelope.Subject
+		}
+	}
+	if subject != "A little message, just for you" {
+		t.Errorf("wrong subject offline: %q", subject)
+	}
+	var body []byte
+	for _, msg := range post(t, w, &types.FetchFullMessages{Uids: uids}) {
+		if msg, ok := msg.(*types.FullMessage); ok {
+			body, _ = ioutil.ReadAll(msg.Content.Reader)
+		}
+	}
+	if !bytes.HasSuffix(body, []byte("Hi there :)")) {
+		t.Errorf("wrong body offline: %q", body)
+	}
+
+	post(t, w, &types.FlagMessages{
+		Enable: true,
+		Flag:   models.FlaggedFlag,
+		Uids:   []uint32{6, 7},
+	})
+	sent := "Subject: sent\r\n\r\nsent\r\n"
+	post(t, w, &types.AppendMessage{
+		Destination: "INBOX",
+		Flags:       []string{imap.SeenFlag},
+		Date:        time.Now(),
+		Reader:      strings.NewReader(sent),
+		Length:      len(sent),
+	})
+
+	// Back online, the changes are made on the server
+	s, _ = serve(t, be, addr)
+	defer s.Close()
+	waitError(t, w, "1 of the messages of a flags in INBOX vanished")
+
+	// The server is checked through a client of its own, as its mailboxes
+	// are not safe to read from the test
+	c, err := client.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Logout()
+	if err := c.Login("username", "password"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, err := c.Select("INBOX", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Messages == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("message not appended")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	section := &imap.BodySectionName{Peek: true}
+	messages := make(chan *imap.Message, 2)
+	seqSet := &imap.SeqSet{}
+	seqSet.AddRange(1, 2)
+	err = c.Fetch(seqSet, []imap.FetchItem{imap.FetchFlags,
+		section.FetchItem()}, messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := <-messages, <-messages
+	if !hasString(first.Flags, imap.FlaggedFlag) {
+		t.Errorf("message not flagged: %v", first.Flags)
+	}
+	appended, _ := ioutil.ReadAll(second.GetBody(section))
+	if string(appended) != sent {
+		t.Errorf("wrong message appended: %q", appended)
+	}
+}
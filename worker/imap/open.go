@@ -9,7 +9,10 @@ import (
 func (imapw *IMAPWorker) handleOpenDirectory(msg *types.OpenDirectory) {
 	imapw.worker.Logger.Printf("Opening %s", msg.Directory)
 
-	_, err := imapw.client.Select(msg.Directory, false)
+	status, err := imapw.client.Select(msg.Directory, false)
+	if err == nil && imapw.cache != nil {
+		err = imapw.cache.open(msg.Directory, status.UidValidity)
+	}
 	if err != nil {
 		imapw.worker.PostMessage(&types.Error{
 			Message: types.RespondTo(msg),
@@ -17,9 +20,7 @@ func (imapw *IMAPWorker) handleOpenDirectory(msg *types.OpenDirectory) {
 		}, nil)
 	} else {
 		imapw.worker.PostMessage(&types.Done{types.RespondTo(msg)}, nil)
-		if imapw.idleStop == nil {
-			imapw.idleStop = make(chan struct{})
-		}
+		imapw.idle = true
 	}
 }
 
@@ -41,6 +42,9 @@ func (imapw *IMAPWorker) handleFetchDirectoryContents(
 	} else {
 		imapw.worker.Logger.Printf("Found %d UIDs", len(uids))
 		imapw.seqMap = make([]uint32, len(uids))
+		if imapw.cache != nil {
+			imapw.cache.setUids(uids)
+		}
 		imapw.worker.PostMessage(&types.DirectoryContents{
 			Message: types.RespondTo(msg),
 			Uids:    uids,
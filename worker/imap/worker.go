@@ -4,11 +4,15 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net/url"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/emersion/go-imap"
 	idle "github.com/emersion/go-imap-idle"
 	"github.com/emersion/go-imap/client"
+	"github.com/kyoh86/xdg"
 	"golang.org/x/oauth2"
 
 	"git.sr.ht/~sircmpwn/aerc/lib"
@@ -22,7 +26,13 @@ func init() {
 	handlers.RegisterWorkerFactory("imaps", NewIMAPWorker)
 }
 
-var errUnsupported = fmt.Errorf("unsupported command")
+var (
+	errUnsupported  = fmt.Errorf("unsupported command")
+	errNotConnected = fmt.Errorf("not connected")
+)
+
+// How long to wait before trying to connect again, when offline
+var reconnectInterval = time.Minute
 
 type imapClient struct {
 	*client.Client
@@ -39,7 +49,9 @@ type IMAPWorker struct {
 		oauthBearer lib.OAuthBearer
 	}
 
-	client   *imapClient
+	client *imapClient
+	// Whether to idle between messages, once a folder is selected
+	idle     bool
 	idleStop chan struct{}
 	idleDone chan error
 	selected imap.MailboxStatus
@@ -47,24 +59,29 @@ type IMAPWorker struct {
 	worker   *types.Worker
 	// Map of sequence numbers to UIDs, index 0 is seq number 1
 	seqMap []uint32
+
+	// Only set in offline mode: what is served and queued while the client
+	// is not connected
+	cache   *cache
+	journal *journal
+	retry   <-chan time.Time
+	// How long to wait before trying to connect again
+	retryInterval time.Duration
 }
 
 func NewIMAPWorker(worker *types.Worker) (types.Backend, error) {
 	return &IMAPWorker{
-		idleDone: make(chan error),
-		updates:  make(chan client.Update, 50),
-		worker:   worker,
+		idleDone:      make(chan error),
+		updates:       make(chan client.Update, 50),
+		worker:        worker,
+		retryInterval: reconnectInterval,
 	}, nil
 }
 
 func (w *IMAPWorker) handleMessage(msg types.WorkerMessage) error {
-	if w.idleStop != nil {
-		close(w.idleStop)
-		if err := <-w.idleDone; err != nil {
-			w.worker.PostMessage(&types.Error{Error: err}, nil)
-		}
-	}
+	w.stopIdle()
 
+	var err error
 	switch msg := msg.(type) {
 	case *types.Unsupported:
 		// No-op
@@ -101,58 +118,45 @@ func (w *IMAPWorker) handleMessage(msg types.WorkerMessage) error {
 
 		w.config.user = u.User
 		w.config.folders = msg.Config.Folders
-	case *types.Connect:
-		var (
-			c   *client.Client
-			err error
-		)
-		switch w.config.scheme {
-		case "imap":
-			c, err = client.Dial(w.config.addr)
-			if err != nil {
-				return err
-			}
 
-			if !w.config.insecure {
-				if err := c.StartTLS(&tls.Config{}); err != nil {
-					return err
-				}
-			}
-		case "imaps":
-			c, err = client.DialTLS(w.config.addr, &tls.Config{})
-			if err != nil {
+		if msg.Config.Params["offline"] == "yes" {
+			if err := w.setupOffline(u); err != nil {
 				return err
 			}
-		default:
-			return fmt.Errorf("Unknown IMAP scheme %s", w.config.scheme)
 		}
-		c.ErrorLog = w.worker.Logger
-
-		if w.config.user != nil {
-			username := w.config.user.Username()
-			password, hasPassword := w.config.user.Password()
-			if !hasPassword {
-				// TODO: ask password
-			}
-
-			if w.config.oauthBearer.Enabled {
-				if err := w.config.oauthBearer.Authenticate(username, password, c); err != nil {
-					return err
-				}
-			} else if err := c.Login(username, password); err != nil {
+	case *types.Connect:
+		if err := w.connect(); err != nil {
+			if w.cache == nil {
 				return err
 			}
+			w.goOffline(err)
+		} else if w.journal != nil {
+			w.replay()
 		}
+		w.worker.PostMessage(&types.Done{types.RespondTo(msg)}, nil)
+	default:
+		if w.client == nil {
+			if w.cache == nil {
+				return errNotConnected
+			}
+			err = w.handleOffline(msg)
+		} else {
+			err = w.handleOnline(msg)
+		}
+	}
 
-		c.SetDebug(w.worker.Logger.Writer())
-
-		if _, err := c.Select(imap.InboxName, false); err != nil {
-			return err
+	if w.cache != nil {
+		w.flushCache()
+		if w.client != nil && w.disconnected() {
+			w.goOffline(fmt.Errorf("connection lost"))
 		}
+	}
+	w.resumeIdle()
+	return err
+}
 
-		c.Updates = w.updates
-		w.client = &imapClient{c, idle.NewClient(c)}
-		w.worker.PostMessage(&types.Done{types.RespondTo(msg)}, nil)
+func (w *IMAPWorker) handleOnline(msg types.WorkerMessage) error {
+	switch msg := msg.(type) {
 	case *types.ListDirectories:
 		w.handleListDirectories(msg)
 	case *types.OpenDirectory:
@@ -171,6 +175,10 @@ func (w *IMAPWorker) handleMessage(msg types.WorkerMessage) error {
 		w.handleDeleteMessages(msg)
 	case *types.ReadMessages:
 		w.handleReadMessages(msg)
+	case *types.FlagMessages:
+		w.handleFlagMessages(msg)
+	case *types.TagMessages:
+		w.handleTagMessages(msg)
 	case *types.CopyMessages:
 		w.handleCopyMessages(msg)
 	case *types.AppendMessage:
@@ -180,16 +188,205 @@ func (w *IMAPWorker) handleMessage(msg types.WorkerMessage) error {
 	default:
 		return errUnsupported
 	}
+	return nil
+}
 
-	if w.idleStop != nil {
-		w.idleStop = make(chan struct{})
-		go func() {
-			w.idleDone <- w.client.idle.IdleWithFallback(w.idleStop, 0)
-		}()
+func (w *IMAPWorker) connect() error {
+	var (
+		c   *client.Client
+		err error
+	)
+	switch w.config.scheme {
+	case "imap":
+		c, err = client.Dial(w.config.addr)
+		if err != nil {
+			return err
+		}
+
+		if !w.config.insecure {
+			if err := c.StartTLS(&tls.Config{}); err != nil {
+				return err
+			}
+		}
+	case "imaps":
+		c, err = client.DialTLS(w.config.addr, &tls.Config{})
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Unknown IMAP scheme %s", w.config.scheme)
 	}
+	c.ErrorLog = w.worker.Logger
+
+	if w.config.user != nil {
+		username := w.config.user.Username()
+		password, hasPassword := w.config.user.Password()
+		if !hasPassword {
+			// TODO: ask password
+		}
+
+		if w.config.oauthBearer.Enabled {
+			if err := w.config.oauthBearer.Authenticate(username, password, c); err != nil {
+				return err
+			}
+		} else if err := c.Login(username, password); err != nil {
+			return err
+		}
+	}
+
+	c.SetDebug(w.worker.Logger.Writer())
+
+	if _, err := c.Select(imap.InboxName, false); err != nil {
+		return err
+	}
+
+	c.Updates = w.updates
+	w.client = &imapClient{c, idle.NewClient(c)}
 	return nil
 }
 
+// Sets up the cache and the journal of the offline mode
+func (w *IMAPWorker) setupOffline(u *url.URL) error {
+	var user string
+	if u.User != nil {
+		user = u.User.Username()
+	}
+	dir := filepath.Join(xdg.CacheHome(), "aerc", "imap",
+		url.PathEscape(user+"@"+u.Host))
+	cache, err := newCache(dir)
+	if err != nil {
+		return err
+	}
+	journal, err := loadJournal(filepath.Join(dir, "journal"))
+	if err != nil {
+		return err
+	}
+	w.cache = cache
+	w.journal = journal
+	return nil
+}
+
+func (w *IMAPWorker) flushCache() {
+	if err := w.cache.flush(); err != nil {
+		w.worker.Logger.Printf("could not write the cache: %v", err)
+	}
+}
+
+func (w *IMAPWorker) stopIdle() {
+	if w.idleStop != nil {
+		close(w.idleStop)
+		w.idleStop = nil
+		if err := <-w.idleDone; err != nil {
+			w.worker.PostMessage(&types.Error{Error: err}, nil)
+		}
+	}
+}
+
+// Idles in the selected folder until the next message, if connected
+func (w *IMAPWorker) resumeIdle() {
+	if w.client != nil && w.idle {
+		w.startIdle()
+	}
+}
+
+func (w *IMAPWorker) startIdle() {
+	stop := make(chan struct{})
+	c := w.client
+	w.idleStop = stop
+	go func() {
+		w.idleDone <- c.idle.IdleWithFallback(stop, 0)
+	}()
+}
+
+func (w *IMAPWorker) disconnected() bool {
+	if w.client == nil {
+		return true
+	}
+	select {
+	case <-w.client.LoggedOut():
+		return true
+	default:
+		return false
+	}
+}
+
+// Returns a channel closed when the connection is lost, if there is an offline
+// mode to switch to
+func (w *IMAPWorker) loggedOut() <-chan struct{} {
+	if w.cache == nil || w.client == nil {
+		return nil
+	}
+	return w.client.LoggedOut()
+}
+
+// Switches to the offline mode, and tries to connect again later. The idle
+// goroutine must be stopped.
+func (w *IMAPWorker) goOffline(err error) {
+	w.worker.Logger.Printf("Working offline: %v", err)
+	w.client = nil
+	w.idleStop = nil
+	w.retry = time.After(w.retryInterval)
+	w.worker.PostMessage(&types.Error{
+		Error: fmt.Errorf("working offline: %v", err),
+	}, nil)
+}
+
+// Connects again, replays the journal and selects the folder open in the UI
+func (w *IMAPWorker) reconnect() {
+	w.retry = nil
+	if err := w.connect(); err != nil {
+		w.worker.Logger.Printf("Could not reconnect: %v", err)
+		w.retry = time.After(w.retryInterval)
+		return
+	}
+	w.worker.Logger.Printf("Reconnected")
+	w.replay()
+	if w.disconnected() {
+		w.goOffline(fmt.Errorf("connection lost"))
+		return
+	}
+	if w.cache.name == "" {
+		return
+	}
+	status, err := w.client.Select(w.cache.name, false)
+	if err != nil {
+		w.worker.PostMessage(&types.Error{Error: err}, nil)
+		return
+	}
+	if err := w.cache.open(w.cache.name, status.UidValidity); err != nil {
+		w.worker.Logger.Printf("could not open the cache: %v", err)
+	}
+	// The folder changed while offline, and the worker may have started
+	// offline without a map of its sequence numbers
+	uids, err := w.fetchUids(status.Messages)
+	if err != nil {
+		w.worker.PostMessage(&types.Error{Error: err}, nil)
+		return
+	}
+	w.selected.Name = status.Name
+	w.selected.Messages = status.Messages
+	w.seqMap = uids
+	w.cache.setUids(uids)
+	w.worker.PostMessage(&types.DirectoryContents{Uids: uids}, nil)
+	w.resumeIdle()
+}
+
+// Returns the UIDs of the messages of the selected folder, in the order of
+// their sequence numbers
+func (w *IMAPWorker) fetchUids(messages uint32) ([]uint32, error) {
+	if messages == 0 {
+		return nil, nil
+	}
+	seqSet := &imap.SeqSet{}
+	seqSet.AddRange(1, messages)
+	uids, err := w.client.UidSearch(&imap.SearchCriteria{SeqNum: seqSet})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids, nil
+}
+
 func (w *IMAPWorker) handleImapUpdate(update client.Update) {
 	w.worker.Logger.Printf("(= %T", update)
 	switch update := update.(type) {
@@ -212,8 +409,16 @@ func (w *IMAPWorker) handleImapUpdate(update client.Update) {
 	case *client.MessageUpdate:
 		msg := update.Message
 		if msg.Uid == 0 {
+			if msg.SeqNum == 0 || int(msg.SeqNum) > len(w.seqMap) {
+				w.worker.Logger.Printf("update of unknown message %d",
+					msg.SeqNum)
+				return
+			}
 			msg.Uid = w.seqMap[msg.SeqNum-1]
 		}
+		if w.cache != nil && msg.Flags != nil {
+			w.cache.setFlags(msg.Uid, msg.Flags)
+		}
 		w.worker.PostMessage(&types.MessageInfo{
 			Info: &models.MessageInfo{
 				BodyStructure: translateBodyStructure(msg.BodyStructure),
@@ -224,13 +429,24 @@ func (w *IMAPWorker) handleImapUpdate(update client.Update) {
 			},
 		}, nil)
 	case *client.ExpungeUpdate:
+		if update.SeqNum == 0 || int(update.SeqNum) > len(w.seqMap) {
+			w.worker.Logger.Printf("expunge of unknown message %d",
+				update.SeqNum)
+			return
+		}
 		i := update.SeqNum - 1
 		uid := w.seqMap[i]
 		w.seqMap = append(w.seqMap[:i], w.seqMap[i+1:]...)
+		if w.cache != nil {
+			w.cache.remove(uid)
+		}
 		w.worker.PostMessage(&types.MessagesDeleted{
 			Uids: []uint32{uid},
 		}, nil)
 	}
+	if w.cache != nil {
+		w.flushCache()
+	}
 }
 
 func (w *IMAPWorker) Run() {
@@ -250,6 +466,11 @@ func (w *IMAPWorker) Run() {
 			}
 		case update := <-w.updates:
 			w.handleImapUpdate(update)
+		case <-w.loggedOut():
+			w.stopIdle()
+			w.goOffline(fmt.Errorf("connection lost"))
+		case <-w.retry:
+			w.reconnect()
 		}
 	}
 }
@@ -0,0 +1,303 @@
+package jmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	capCore       = "urn:ietf:params:jmap:core"
+	capMail       = "urn:ietf:params:jmap:mail"
+	capSubmission = "urn:ietf:params:jmap:submission"
+)
+
+// The JMAP session resource (RFC 8620, section 2)
+type session struct {
+	APIURL          string            `json:"apiUrl"`
+	DownloadURL     string            `json:"downloadUrl"`
+	UploadURL       string            `json:"uploadUrl"`
+	EventSourceURL  string            `json:"eventSourceUrl"`
+	PrimaryAccounts map[string]string `json:"primaryAccounts"`
+}
+
+// A client makes the requests of a worker to a JMAP server
+type client struct {
+	http *http.Client
+	// The URL of the session resource
+	url      string
+	user     string
+	password string
+	// Whether the password is an OAuth 2.0 bearer token
+	bearer    bool
+	session   *session
+	accountID string
+}
+
+// The arguments of a method call
+type args map[string]interface{}
+
+// A method call or response
+type invocation struct {
+	Name string
+	Args interface{}
+	ID   string
+}
+
+func (inv invocation) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{inv.Name, inv.Args, inv.ID})
+}
+
+func (inv *invocation) UnmarshalJSON(data []byte) error {
+	var args json.RawMessage
+	fields := []interface{}{&inv.Name, &args, &inv.ID}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	inv.Args = args
+	return nil
+}
+
+// A method level error
+type methodError struct {
+	Method      string
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+func (err *methodError) Error() string {
+	if err.Description != "" {
+		return fmt.Sprintf("%s: %s: %s", err.Method, err.Type, err.Description)
+	}
+	return fmt.Sprintf("%s: %s", err.Method, err.Type)
+}
+
+// An error about a record of a /set or /import call
+type setError struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+func (err *setError) Error() string {
+	if err.Description != "" {
+		return err.Type + ": " + err.Description
+	}
+	return err.Type
+}
+
+func newClient(source *url.URL) (*client, error) {
+	c := &client{http: &http.Client{Timeout: 2 * time.Minute}}
+	scheme := "https"
+	for _, opt := range strings.Split(source.Scheme, "+")[1:] {
+		switch opt {
+		case "insecure":
+			scheme = "http"
+		case "oauthbearer":
+			c.bearer = true
+		default:
+			return nil, fmt.Errorf("unknown JMAP scheme %s", source.Scheme)
+		}
+	}
+	if source.Host == "" {
+		return nil, fmt.Errorf("no JMAP server given")
+	}
+	path := source.Path
+	if path == "" || path == "/" {
+		path = "/.well-known/jmap"
+	}
+	c.url = (&url.URL{Scheme: scheme, Host: source.Host, Path: path}).String()
+	if source.User != nil {
+		c.user = source.User.Username()
+		c.password, _ = source.User.Password()
+	}
+	return c, nil
+}
+
+func (c *client) do(req *http.Request) (*http.Response, error) {
+	return c.doWith(c.http, req)
+}
+
+func (c *client) doWith(hc *http.Client, req *http.Request) (*http.Response,
+	error) {
+
+	if c.bearer {
+		req.Header.Set("Authorization", "Bearer "+c.password)
+	} else if c.user != "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s", req.Method, req.URL, resp.Status)
+	}
+	return resp, nil
+}
+
+// Resolves a URL of the session, which may be relative to the session resource
+func (c *client) resolve(ref string) (string, error) {
+	base, err := url.Parse(c.url)
+	if err != nil {
+		return "", err
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// Fetches the session resource and picks the primary mail account
+func (c *client) connect() error {
+	req, err := http.NewRequest("GET", c.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var s session
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return fmt.Errorf("could not read JMAP session: %v", err)
+	}
+	c.accountID = s.PrimaryAccounts[capMail]
+	if c.accountID == "" {
+		return fmt.Errorf("the JMAP server has no mail account")
+	}
+	if s.APIURL == "" {
+		return fmt.Errorf("the JMAP session has no API URL")
+	}
+	// The other URLs are templates, resolved once filled in
+	if s.APIURL, err = c.resolve(s.APIURL); err != nil {
+		return err
+	}
+	c.session = &s
+	return nil
+}
+
+// Makes the method calls in a single request, and decodes the arguments of
+// their responses into results, in order. A method error fails the request.
+func (c *client) call(calls []invocation, results ...interface{}) error {
+	using := []string{capCore, capMail}
+	for i := range calls {
+		calls[i].ID = fmt.Sprint(i)
+		if strings.HasPrefix(calls[i].Name, "EmailSubmission/") ||
+			strings.HasPrefix(calls[i].Name, "Identity/") {
+
+			using = append(using, capSubmission)
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"using":       using,
+		"methodCalls": calls,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", c.session.APIURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var response struct {
+		MethodResponses []invocation `json:"methodResponses"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("could not read JMAP response: %v", err)
+	}
+	for _, inv := range response.MethodResponses {
+		var i int
+		if _, err := fmt.Sscan(inv.ID, &i); err != nil || i >= len(calls) {
+			continue
+		}
+		raw := inv.Args.(json.RawMessage)
+		if inv.Name == "error" {
+			err := &methodError{Method: calls[i].Name}
+			if jerr := json.Unmarshal(raw, err); jerr != nil {
+				return jerr
+			}
+			return err
+		}
+		if i < len(results) && results[i] != nil {
+			if err := json.Unmarshal(raw, results[i]); err != nil {
+				return fmt.Errorf("could not read %s response: %v",
+					inv.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Fills in the variables of a URL template of the session
+func expand(template string, vars map[string]string) string {
+	for name, value := range vars {
+		template = strings.ReplaceAll(template, "{"+name+"}",
+			url.PathEscape(value))
+	}
+	return template
+}
+
+// Downloads the content of a blob, e.g. a whole message
+func (c *client) download(blobID string) ([]byte, error) {
+	u, err := c.resolve(expand(c.session.DownloadURL, map[string]string{
+		"accountId": c.accountID,
+		"blobId":    blobID,
+		"type":      "message/rfc822",
+		"name":      "message.eml",
+	}))
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Uploads a message and returns the ID of its blob
+func (c *client) upload(r io.Reader) (string, error) {
+	u, err := c.resolve(expand(c.session.UploadURL, map[string]string{
+		"accountId": c.accountID,
+	}))
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", u, r)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "message/rfc822")
+	resp, err := c.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var blob struct {
+		BlobID string `json:"blobId"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&blob); err != nil {
+		return "", fmt.Errorf("could not read upload response: %v", err)
+	}
+	return blob.BlobID, nil
+}
@@ -0,0 +1,197 @@
+package jmap
+
+import (
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/mail"
+
+	"git.sr.ht/~sircmpwn/aerc/models"
+)
+
+// The properties of an Email fetched for the message list
+var emailProperties = []string{
+	"id", "blobId", "mailboxIds", "keywords", "size", "receivedAt", "sentAt",
+	"subject", "from", "to", "cc", "bcc", "replyTo", "messageId", "headers",
+	"bodyStructure",
+}
+
+var bodyProperties = []string{
+	"type", "charset", "name", "disposition", "headers", "subParts",
+}
+
+var keywordFlags = map[string]models.Flag{
+	"$seen":     models.SeenFlag,
+	"$answered": models.AnsweredFlag,
+	"$flagged":  models.FlaggedFlag,
+}
+
+// Returns the keyword of a flag, if JMAP has one
+func flagKeyword(flag models.Flag) (string, bool) {
+	for keyword, f := range keywordFlags {
+		if f == flag {
+			return keyword, true
+		}
+	}
+	return "", false
+}
+
+type mailbox struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	ParentID     string `json:"parentId"`
+	Role         string `json:"role"`
+	TotalEmails  int    `json:"totalEmails"`
+	UnreadEmails int    `json:"unreadEmails"`
+}
+
+type emailAddress struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type emailHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type bodyPart struct {
+	Type        string        `json:"type"`
+	Charset     string        `json:"charset"`
+	Name        string        `json:"name"`
+	Disposition string        `json:"disposition"`
+	Headers     []emailHeader `json:"headers"`
+	SubParts    []*bodyPart   `json:"subParts"`
+}
+
+type email struct {
+	ID            string          `json:"id"`
+	BlobID        string          `json:"blobId"`
+	MailboxIDs    map[string]bool `json:"mailboxIds"`
+	Keywords      map[string]bool `json:"keywords"`
+	Size          uint32          `json:"size"`
+	ReceivedAt    time.Time       `json:"receivedAt"`
+	SentAt        *time.Time      `json:"sentAt"`
+	Subject       string          `json:"subject"`
+	From          []emailAddress  `json:"from"`
+	To            []emailAddress  `json:"to"`
+	Cc            []emailAddress  `json:"cc"`
+	Bcc           []emailAddress  `json:"bcc"`
+	ReplyTo       []emailAddress  `json:"replyTo"`
+	MessageID     []string        `json:"messageId"`
+	Headers       []emailHeader   `json:"headers"`
+	BodyStructure *bodyPart       `json:"bodyStructure"`
+}
+
+func (e *email) flags() []models.Flag {
+	var flags []models.Flag
+	for keyword, set := range e.Keywords {
+		if flag, ok := keywordFlags[keyword]; ok && set {
+			flags = append(flags, flag)
+		}
+	}
+	return flags
+}
+
+// Builds a header from the raw header fields of JMAP, in order
+func makeHeader(fields []emailHeader) *mail.Header {
+	unfold := strings.NewReplacer("\r\n", "", "\n", "")
+	h := &mail.Header{}
+	for _, f := range fields {
+		h.Add(f.Name, strings.TrimSpace(unfold.Replace(f.Value)))
+	}
+	return h
+}
+
+func convertAddresses(addrs []emailAddress) []*models.Address {
+	var converted []*models.Address
+	for _, addr := range addrs {
+		var mbox, host string
+		if i := strings.LastIndex(addr.Email, "@"); i >= 0 {
+			mbox, host = addr.Email[:i], addr.Email[i+1:]
+		} else {
+			mbox = addr.Email
+		}
+		converted = append(converted, &models.Address{
+			Name:    addr.Name,
+			Mailbox: mbox,
+			Host:    host,
+		})
+	}
+	return converted
+}
+
+func (p *bodyPart) structure() *models.BodyStructure {
+	h := makeHeader(p.Headers)
+	bs := &models.BodyStructure{
+		Params:            map[string]string{},
+		Description:       h.Get("Content-Description"),
+		Encoding:          h.Get("Content-Transfer-Encoding"),
+		Disposition:       p.Disposition,
+		DispositionParams: map[string]string{},
+		Parts:             []*models.BodyStructure{},
+	}
+	mimeType := strings.SplitN(strings.ToLower(p.Type), "/", 2)
+	bs.MIMEType = mimeType[0]
+	if len(mimeType) == 2 {
+		bs.MIMESubType = mimeType[1]
+	}
+	if _, params, err := h.ContentType(); err == nil && params != nil {
+		bs.Params = params
+	}
+	if p.Charset != "" && bs.Params["charset"] == "" {
+		bs.Params["charset"] = p.Charset
+	}
+	if p.Name != "" && bs.Params["name"] == "" && p.Disposition == "" {
+		bs.Params["name"] = p.Name
+	}
+	if p.Disposition != "" {
+		_, params, err := h.ContentDisposition()
+		if err == nil && params != nil {
+			bs.DispositionParams = params
+		}
+		if p.Name != "" && bs.DispositionParams["filename"] == "" {
+			bs.DispositionParams["filename"] = p.Name
+		}
+	}
+	for _, sub := range p.SubParts {
+		bs.Parts = append(bs.Parts, sub.structure())
+	}
+	return bs
+}
+
+func (e *email) messageInfo(uid uint32) *models.MessageInfo {
+	date := e.ReceivedAt
+	if e.SentAt != nil {
+		date = *e.SentAt
+	}
+	env := &models.Envelope{
+		Date:    date,
+		Subject: e.Subject,
+		From:    convertAddresses(e.From),
+		ReplyTo: convertAddresses(e.ReplyTo),
+		To:      convertAddresses(e.To),
+		Cc:      convertAddresses(e.Cc),
+		Bcc:     convertAddresses(e.Bcc),
+	}
+	if len(e.MessageID) != 0 {
+		env.MessageId = "<" + e.MessageID[0] + ">"
+	}
+	bs := &models.BodyStructure{
+		MIMEType:    "text",
+		MIMESubType: "plain",
+		Parts:       []*models.BodyStructure{},
+	}
+	if e.BodyStructure != nil {
+		bs = e.BodyStructure.structure()
+	}
+	return &models.MessageInfo{
+		BodyStructure: bs,
+		Envelope:      env,
+		Flags:         e.flags(),
+		InternalDate:  e.ReceivedAt,
+		RFC822Headers: makeHeader(e.Headers),
+		Size:          e.Size,
+		Uid:           uid,
+	}
+}
@@ -0,0 +1,74 @@
+package jmap
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// How long to wait before connecting to the event source again
+const pushRetryDelay = 30 * time.Second
+
+// Listens to the event source of the server (RFC 8620, section 7.3) and
+// signals changes on the channel, until the server offers none. Lost
+// connections are made again.
+func (c *client) listen(s *session, changes chan<- struct{},
+	logger *log.Logger) {
+
+	if s.EventSourceURL == "" {
+		logger.Printf("the JMAP server does not push changes")
+		return
+	}
+	for {
+		err := c.readEvents(s, changes)
+		logger.Printf("JMAP event source: %v, retrying in %v", err,
+			pushRetryDelay)
+		time.Sleep(pushRetryDelay)
+	}
+}
+
+func (c *client) readEvents(s *session, changes chan<- struct{}) error {
+	u, err := c.resolve(expand(s.EventSourceURL, map[string]string{
+		"types":      "Email,Mailbox",
+		"closeafter": "no",
+		"ping":       "300",
+	}))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	// The stream stays open for good, which the timeout of API calls forbids
+	resp, err := c.doWith(&http.Client{}, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	event := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			event = ""
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(line[len("event:"):])
+		case strings.HasPrefix(line, "data:") && event == "state":
+			select {
+			case changes <- struct{}{}:
+			default:
+				// A change is already pending
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("connection closed")
+}
@@ -0,0 +1,115 @@
+package jmap
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"git.sr.ht/~sircmpwn/aerc/worker/types"
+)
+
+type identity struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+}
+
+// Returns the identity to send mail from an address, which may be one of a
+// whole domain, like *@example.org
+func (w *Worker) identity(from string) (string, error) {
+	var res struct {
+		List []identity `json:"list"`
+	}
+	err := w.c.call([]invocation{{Name: "Identity/get", Args: args{
+		"accountId": w.c.accountID,
+		"ids":       nil,
+	}}}, &res)
+	if err != nil {
+		return "", err
+	}
+	wildcard := ""
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		wildcard = "*" + from[i:]
+	}
+	id := ""
+	for _, ident := range res.List {
+		if strings.EqualFold(ident.Email, from) {
+			return ident.ID, nil
+		} else if strings.EqualFold(ident.Email, wildcard) {
+			id = ident.ID
+		}
+	}
+	if id == "" {
+		return "", fmt.Errorf("no JMAP identity may send as %s", from)
+	}
+	return id, nil
+}
+
+// Submits a message with EmailSubmission (RFC 8621, section 7). The message
+// is kept in the drafts mailbox until it is sent, and then moved to the sent
+// mailbox.
+func (w *Worker) handleSendMessage(msg *types.SendMessage) error {
+	if err := w.fetchMailboxes(); err != nil {
+		return err
+	}
+	identityID, err := w.identity(msg.From)
+	if err != nil {
+		return err
+	}
+	drafts, sent := w.mailboxByRole("drafts"), w.mailboxByRole("sent")
+	var onSuccess args
+	keywords := args{"$seen": true}
+	var mailboxIDs args
+	switch {
+	case drafts != nil:
+		mailboxIDs = args{drafts.ID: true}
+		keywords["$draft"] = true
+		if sent != nil {
+			onSuccess = args{
+				"mailboxIds/" + drafts.ID: nil,
+				"mailboxIds/" + sent.ID:   true,
+				"keywords/$draft":         nil,
+			}
+		} else {
+			onSuccess = args{"keywords/$draft": nil}
+		}
+	case sent != nil:
+		mailboxIDs = args{sent.ID: true}
+	default:
+		return fmt.Errorf("no drafts or sent mailbox to send mail from")
+	}
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(msg.Reader); err != nil {
+		return err
+	}
+	var rcpts []args
+	for _, rcpt := range msg.Rcpts {
+		rcpts = append(rcpts, args{"email": rcpt})
+	}
+	submission := args{
+		"accountId": w.c.accountID,
+		"create": args{
+			"submission": args{
+				"identityId": identityID,
+				"emailId":    "#email",
+				"envelope": args{
+					"mailFrom": args{"email": msg.From},
+					"rcptTo":   rcpts,
+				},
+			},
+		},
+	}
+	if onSuccess != nil {
+		submission["onSuccessUpdateEmail"] = args{"#submission": onSuccess}
+	}
+	var res setResponse
+	err = w.importMessage(buf.Bytes(), mailboxIDs, keywords, time.Now(),
+		[]invocation{{Name: "EmailSubmission/set", Args: submission}}, &res)
+	if err != nil {
+		return err
+	}
+	if err := res.NotCreated["submission"]; err != nil {
+		return fmt.Errorf("could not send message: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,926 @@
+package jmap
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-message"
+
+	"git.sr.ht/~sircmpwn/aerc/lib/uidstore"
+	"git.sr.ht/~sircmpwn/aerc/models"
+	"git.sr.ht/~sircmpwn/aerc/worker/handlers"
+	"git.sr.ht/~sircmpwn/aerc/worker/lib"
+	"git.sr.ht/~sircmpwn/aerc/worker/types"
+)
+
+func init() {
+	handlers.RegisterWorkerFactory("jmap", NewWorker)
+}
+
+var errUnsupported = fmt.Errorf("unsupported command")
+
+var errNoSelection = fmt.Errorf("no folder selected")
+
+var errNotConnected = fmt.Errorf("not connected")
+
+// How long to gather the changes pushed by the server before asking for them
+const syncDelay = 250 * time.Millisecond
+
+// A Worker handles interfacing between aerc's UI and a JMAP server (RFC 8620
+// and RFC 8621).
+type Worker struct {
+	c      *client
+	worker *types.Worker
+	uids   *uidstore.Store
+	// The mailboxes by folder name
+	mailboxes map[string]*mailbox
+	selected  *mailbox
+	// The name of the selected folder
+	selectedName string
+	// The flags of the messages of the selected folder, as the UI knows them
+	known map[uint32][]models.Flag
+	// The state of the emails as of known
+	emailState string
+	// Signaled by the event source when something changed on the server
+	pushed chan struct{}
+	// Fires when the pushed changes should be looked at
+	changed <-chan time.Time
+	// Whether the event source is listened to
+	listening bool
+}
+
+// NewWorker creates a new JMAP worker with the provided worker.
+func NewWorker(worker *types.Worker) (types.Backend, error) {
+	return &Worker{
+		worker:    worker,
+		uids:      uidstore.NewStore(),
+		mailboxes: make(map[string]*mailbox),
+		pushed:    make(chan struct{}, 1),
+	}, nil
+}
+
+// Run starts the worker's message handling loop.
+func (w *Worker) Run() {
+	for {
+		select {
+		case action := <-w.worker.Actions:
+			w.handleAction(action)
+		case <-w.pushed:
+			if w.changed == nil {
+				w.changed = time.After(syncDelay)
+			}
+		case <-w.changed:
+			w.changed = nil
+			if err := w.sync(); err != nil {
+				w.worker.Logger.Printf("could not get JMAP changes: %v", err)
+			}
+		}
+	}
+}
+
+func (w *Worker) handleAction(action types.WorkerMessage) {
+	msg := w.worker.ProcessAction(action)
+	if err := w.handleMessage(msg); err == errUnsupported {
+		w.worker.PostMessage(&types.Unsupported{
+			Message: types.RespondTo(msg),
+		}, nil)
+	} else if err != nil {
+		w.worker.PostMessage(&types.Error{
+			Message: types.RespondTo(msg),
+			Error:   err,
+		}, nil)
+	} else {
+		w.worker.PostMessage(&types.Done{Message: types.RespondTo(msg)}, nil)
+	}
+}
+
+func (w *Worker) err(msg types.WorkerMessage, err error) {
+	w.worker.PostMessage(&types.Error{
+		Message: types.RespondTo(msg),
+		Error:   err,
+	}, nil)
+}
+
+func (w *Worker) handleMessage(msg types.WorkerMessage) error {
+	switch msg := msg.(type) {
+	case *types.Unsupported:
+		// No-op
+	case *types.Configure:
+		return w.handleConfigure(msg)
+	case *types.Connect:
+		return w.handleConnect(msg)
+	}
+	// The client has no session until it connects
+	if w.c == nil || w.c.session == nil {
+		return errNotConnected
+	}
+	switch msg := msg.(type) {
+	case *types.ListDirectories:
+		return w.handleListDirectories(msg)
+	case *types.OpenDirectory:
+		return w.handleOpenDirectory(msg)
+	case *types.FetchDirectoryContents:
+		return w.handleFetchDirectoryContents(msg)
+	case *types.CreateDirectory:
+		return w.handleCreateDirectory(msg)
+	case *types.FetchMessageHeaders:
+		return w.fetchHeaders(msg, msg.Uids)
+	case *types.FetchMessageBodyPart:
+		return w.handleFetchMessageBodyPart(msg)
+	case *types.FetchFullMessages:
+		return w.handleFetchFullMessages(msg)
+	case *types.DeleteMessages:
+		return w.handleDeleteMessages(msg)
+	case *types.ReadMessages:
+		return w.setKeywords(msg, msg.Uids, []string{"$seen"}, msg.Read)
+	case *types.FlagMessages:
+		keyword, ok := flagKeyword(msg.Flag)
+		if !ok {
+			return errUnsupported
+		}
+		return w.setKeywords(msg, msg.Uids, []string{keyword}, msg.Enable)
+	case *types.TagMessages:
+		return w.setKeywords(msg, msg.Uids, msg.Tags, true)
+	case *types.CopyMessages:
+		return w.handleCopyMessages(msg)
+	case *types.AppendMessage:
+		return w.handleAppendMessage(msg)
+	case *types.SearchDirectory:
+		return w.handleSearchDirectory(msg)
+	case *types.SendMessage:
+		return w.handleSendMessage(msg)
+	}
+	return errUnsupported
+}
+
+func (w *Worker) handleConfigure(msg *types.Configure) error {
+	u, err := url.Parse(msg.Config.Source)
+	if err != nil {
+		w.worker.Logger.Printf("error configuring JMAP worker: %v", err)
+		return err
+	}
+	w.c, err = newClient(u)
+	if err != nil {
+		return err
+	}
+	w.worker.Logger.Printf("configured JMAP: %s", w.c.url)
+	return nil
+}
+
+func (w *Worker) handleConnect(msg *types.Connect) error {
+	if w.c == nil {
+		return fmt.Errorf("JMAP worker not configured")
+	}
+	if err := w.c.connect(); err != nil {
+		return err
+	}
+	// The listener connects again by itself, and keeps the session it was
+	// started with
+	if !w.listening {
+		w.listening = true
+		go w.c.listen(w.c.session, w.pushed, w.worker.Logger)
+	}
+	return nil
+}
+
+// Fetches the mailboxes and names the folders after their path. The inbox is
+// INBOX, as aerc expects.
+func (w *Worker) fetchMailboxes() error {
+	var res struct {
+		List []*mailbox `json:"list"`
+	}
+	err := w.c.call([]invocation{{Name: "Mailbox/get", Args: args{
+		"accountId": w.c.accountID,
+		"ids":       nil,
+	}}}, &res)
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]*mailbox, len(res.List))
+	for _, mb := range res.List {
+		byID[mb.ID] = mb
+	}
+	var name func(mb *mailbox, depth int) string
+	name = func(mb *mailbox, depth int) string {
+		parent := byID[mb.ParentID]
+		switch {
+		case mb.Role == "inbox" && parent == nil:
+			return "INBOX"
+		case parent == nil || depth > len(res.List):
+			// The depth bounds loops in a broken tree
+			return mb.Name
+		}
+		return name(parent, depth+1) + "/" + mb.Name
+	}
+	w.mailboxes = make(map[string]*mailbox, len(res.List))
+	for _, mb := range res.List {
+		w.mailboxes[name(mb, 0)] = mb
+	}
+	if w.selected != nil {
+		w.selected = w.mailboxes[w.selectedName]
+	}
+	return nil
+}
+
+// Returns the mailbox with a given role, if any
+func (w *Worker) mailboxByRole(role string) *mailbox {
+	for _, mb := range w.mailboxes {
+		if mb.Role == role {
+			return mb
+		}
+	}
+	return nil
+}
+
+func (w *Worker) mailbox(name string) (*mailbox, error) {
+	mb, ok := w.mailboxes[name]
+	if !ok {
+		return nil, fmt.Errorf("no folder %s", name)
+	}
+	return mb, nil
+}
+
+func (w *Worker) handleListDirectories(msg *types.ListDirectories) error {
+	if err := w.fetchMailboxes(); err != nil {
+		w.worker.Logger.Printf("error listing directories: %v", err)
+		return err
+	}
+	var names []string
+	for name := range w.mailboxes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		w.worker.PostMessage(&types.Directory{
+			Message: types.RespondTo(msg),
+			Dir: &models.Directory{
+				Name:       name,
+				Attributes: []string{},
+			},
+		}, nil)
+	}
+	return nil
+}
+
+func (w *Worker) handleOpenDirectory(msg *types.OpenDirectory) error {
+	w.worker.Logger.Printf("opening %s", msg.Directory)
+	if err := w.fetchMailboxes(); err != nil {
+		return err
+	}
+	mb, err := w.mailbox(msg.Directory)
+	if err != nil {
+		return err
+	}
+	w.selected = mb
+	w.selectedName = msg.Directory
+	w.known = nil
+	w.emailState = ""
+	w.worker.PostMessage(w.dirInfo(), nil)
+	return nil
+}
+
+// Returns the info of the selected mailbox
+func (w *Worker) dirInfo() *types.DirectoryInfo {
+	return &types.DirectoryInfo{
+		Info: &models.DirectoryInfo{
+			Name:     w.selectedName,
+			Flags:    []string{},
+			ReadOnly: false,
+			Exists:   w.selected.TotalEmails,
+			Recent:   0,
+			Unseen:   w.selected.UnreadEmails,
+		},
+	}
+}
+
+// Returns the IDs of the emails of the selected mailbox matching a filter,
+// oldest first, and the state of the emails
+func (w *Worker) query(filter args) ([]string, string, error) {
+	if w.selected == nil {
+		return nil, "", errNoSelection
+	}
+	conditions := []interface{}{args{"inMailbox": w.selected.ID}}
+	if filter != nil {
+		conditions = append(conditions, filter)
+	}
+	var res struct {
+		IDs   []string `json:"ids"`
+		Total int      `json:"total"`
+	}
+	var state struct {
+		State string `json:"state"`
+	}
+	calls := []invocation{
+		{Name: "Email/query", Args: args{
+			"accountId": w.c.accountID,
+			"filter": args{
+				"operator":   "AND",
+				"conditions": conditions,
+			},
+			"sort": []args{
+				{"property": "receivedAt", "isAscending": true},
+			},
+			"calculateTotal": true,
+		}},
+		{Name: "Email/get", Args: args{
+			"accountId": w.c.accountID,
+			"ids":       []string{},
+		}},
+	}
+	if err := w.c.call(calls, &res, &state); err != nil {
+		return nil, "", err
+	}
+	// Servers may cap the results of a query
+	for len(res.IDs) < res.Total {
+		var more struct {
+			IDs []string `json:"ids"`
+		}
+		calls[0].Args.(args)["position"] = len(res.IDs)
+		if err := w.c.call(calls[:1], &more); err != nil {
+			return nil, "", err
+		}
+		if len(more.IDs) == 0 {
+			break
+		}
+		res.IDs = append(res.IDs, more.IDs...)
+	}
+	return res.IDs, state.State, nil
+}
+
+func (w *Worker) handleFetchDirectoryContents(
+	msg *types.FetchDirectoryContents) error {
+
+	ids, state, err := w.query(nil)
+	if err != nil {
+		return err
+	}
+	emails, err := w.getEmails(ids, []string{"id", "keywords"})
+	if err != nil {
+		return err
+	}
+	w.known = make(map[uint32][]models.Flag, len(ids))
+	for _, e := range emails {
+		w.known[w.uids.GetOrInsert(e.ID)] = e.flags()
+	}
+	w.emailState = state
+	uids := make([]uint32, len(ids))
+	for i, id := range ids {
+		uids[i] = w.uids.GetOrInsert(id)
+	}
+	w.worker.PostMessage(&types.DirectoryContents{
+		Message: types.RespondTo(msg),
+		Uids:    uids,
+	}, nil)
+	return nil
+}
+
+func (w *Worker) handleCreateDirectory(msg *types.CreateDirectory) error {
+	if err := w.fetchMailboxes(); err != nil {
+		return err
+	}
+	if _, ok := w.mailboxes[msg.Directory]; ok {
+		if msg.Quiet {
+			return nil
+		}
+		return fmt.Errorf("folder %s already exists", msg.Directory)
+	}
+	// Missing parents are created along
+	var parentID interface{}
+	path := strings.Split(msg.Directory, "/")
+	for i, name := range path {
+		folder := strings.Join(path[:i+1], "/")
+		if mb, ok := w.mailboxes[folder]; ok {
+			parentID = mb.ID
+			continue
+		}
+		var res setResponse
+		err := w.c.call([]invocation{{Name: "Mailbox/set", Args: args{
+			"accountId": w.c.accountID,
+			"create": args{
+				"new": args{"name": name, "parentId": parentID},
+			},
+		}}}, &res)
+		if err != nil {
+			return err
+		}
+		if err := res.NotCreated["new"]; err != nil {
+			return fmt.Errorf("could not create %s: %v", folder, err)
+		}
+		parentID = res.Created["new"].ID
+	}
+	return w.fetchMailboxes()
+}
+
+// The response of a /set call
+type setResponse struct {
+	Created      map[string]struct{ ID string } `json:"created"`
+	NotCreated   map[string]*setError           `json:"notCreated"`
+	NotUpdated   map[string]*setError           `json:"notUpdated"`
+	NotDestroyed map[string]*setError           `json:"notDestroyed"`
+}
+
+// Gets emails by ID; those which are gone are left out
+func (w *Worker) getEmails(ids []string, properties []string) ([]*email,
+	error) {
+
+	var emails []*email
+	// Servers cap the objects of a single call, to 500 at least
+	for len(ids) != 0 {
+		batch := ids
+		if len(batch) > 500 {
+			batch = batch[:500]
+		}
+		ids = ids[len(batch):]
+		var res struct {
+			List []*email `json:"list"`
+		}
+		err := w.c.call([]invocation{{Name: "Email/get", Args: args{
+			"accountId":      w.c.accountID,
+			"ids":            batch,
+			"properties":     properties,
+			"bodyProperties": bodyProperties,
+		}}}, &res)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, res.List...)
+	}
+	return emails, nil
+}
+
+// Returns the JMAP IDs of UIDs
+func (w *Worker) emailIDs(uids []uint32) ([]string, error) {
+	ids := make([]string, len(uids))
+	for i, uid := range uids {
+		id, ok := w.uids.GetKey(uid)
+		if !ok {
+			return nil, fmt.Errorf("could not find message with uid %d", uid)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+func (w *Worker) fetchHeaders(msg types.WorkerMessage, uids []uint32) error {
+	ids, err := w.emailIDs(uids)
+	if err != nil {
+		return err
+	}
+	emails, err := w.getEmails(ids, emailProperties)
+	if err != nil {
+		return err
+	}
+	for _, e := range emails {
+		w.worker.PostMessage(&types.MessageInfo{
+			Message: types.RespondTo(msg),
+			Info:    e.messageInfo(w.uids.GetOrInsert(e.ID)),
+		}, nil)
+	}
+	return nil
+}
+
+// Downloads a whole message
+func (w *Worker) content(uid uint32) ([]byte, error) {
+	ids, err := w.emailIDs([]uint32{uid})
+	if err != nil {
+		return nil, err
+	}
+	emails, err := w.getEmails(ids, []string{"id", "blobId"})
+	if err != nil {
+		return nil, err
+	}
+	if len(emails) == 0 {
+		return nil, fmt.Errorf("message %d is gone", uid)
+	}
+	return w.c.download(emails[0].BlobID)
+}
+
+func (w *Worker) handleFetchMessageBodyPart(
+	msg *types.FetchMessageBodyPart) error {
+
+	content, err := w.content(msg.Uid)
+	if err != nil {
+		return err
+	}
+	entity, err := message.Read(bytes.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("could not read message: %v", err)
+	}
+	r, err := lib.FetchEntityPartReader(entity, msg.Part)
+	if err != nil {
+		w.worker.Logger.Printf(
+			"could not get body part reader for message=%d, parts=%#v: %v",
+			msg.Uid, msg.Part, err)
+		return err
+	}
+	w.worker.PostMessage(&types.MessageBodyPart{
+		Message: types.RespondTo(msg),
+		Part: &models.MessageBodyPart{
+			Reader: r,
+			Uid:    msg.Uid,
+		},
+	}, nil)
+	return nil
+}
+
+func (w *Worker) handleFetchFullMessages(msg *types.FetchFullMessages) error {
+	for _, uid := range msg.Uids {
+		content, err := w.content(uid)
+		if err != nil {
+			return err
+		}
+		w.worker.PostMessage(&types.FullMessage{
+			Message: types.RespondTo(msg),
+			Content: &models.FullMessage{
+				Uid:    uid,
+				Reader: bytes.NewReader(content),
+			},
+		}, nil)
+	}
+	return nil
+}
+
+// Applies patches to emails, by UID, and reports those which failed
+func (w *Worker) update(msg types.WorkerMessage,
+	patches map[uint32]args) (map[uint32]bool, error) {
+
+	update := make(args, len(patches))
+	for uid, patch := range patches {
+		id, ok := w.uids.GetKey(uid)
+		if !ok {
+			return nil, fmt.Errorf("could not find message with uid %d", uid)
+		}
+		update[id] = patch
+	}
+	var res setResponse
+	err := w.c.call([]invocation{{Name: "Email/set", Args: args{
+		"accountId": w.c.accountID,
+		"update":    update,
+	}}}, &res)
+	if err != nil {
+		return nil, err
+	}
+	failed := make(map[uint32]bool)
+	for id, err := range res.NotUpdated {
+		failed[w.uids.GetOrInsert(id)] = true
+		w.err(msg, fmt.Errorf("could not update message: %v", err))
+	}
+	return failed, nil
+}
+
+// Sets or clears keywords of messages, and posts their new info
+func (w *Worker) setKeywords(msg types.WorkerMessage, uids []uint32,
+	keywords []string, enable bool) error {
+
+	patches := make(map[uint32]args, len(uids))
+	for _, uid := range uids {
+		patch := args{}
+		for _, keyword := range keywords {
+			if enable {
+				patch["keywords/"+keyword] = true
+			} else {
+				patch["keywords/"+keyword] = nil
+			}
+		}
+		patches[uid] = patch
+	}
+	failed, err := w.update(msg, patches)
+	if err != nil {
+		return err
+	}
+	var updated []uint32
+	for _, uid := range uids {
+		if !failed[uid] {
+			updated = append(updated, uid)
+		}
+	}
+	ids, err := w.emailIDs(updated)
+	if err != nil {
+		return err
+	}
+	emails, err := w.getEmails(ids, emailProperties)
+	if err != nil {
+		return err
+	}
+	for _, e := range emails {
+		uid := w.uids.GetOrInsert(e.ID)
+		if w.known != nil {
+			w.known[uid] = e.flags()
+		}
+		w.worker.PostMessage(&types.MessageInfo{
+			Message: types.RespondTo(msg),
+			Info:    e.messageInfo(uid),
+		}, nil)
+	}
+	return nil
+}
+
+// Removes messages from the selected folder. An email in no other mailbox is
+// destroyed; one in other mailboxes as well, e.g. after a move, stays there.
+func (w *Worker) handleDeleteMessages(msg *types.DeleteMessages) error {
+	if w.selected == nil {
+		return errNoSelection
+	}
+	ids, err := w.emailIDs(msg.Uids)
+	if err != nil {
+		return err
+	}
+	emails, err := w.getEmails(ids, []string{"id", "mailboxIds"})
+	if err != nil {
+		return err
+	}
+	var destroy []string
+	update := args{}
+	for _, e := range emails {
+		if !e.MailboxIDs[w.selected.ID] {
+			continue
+		}
+		if len(e.MailboxIDs) == 1 {
+			destroy = append(destroy, e.ID)
+		} else {
+			update[e.ID] = args{"mailboxIds/" + w.selected.ID: nil}
+		}
+	}
+	var res struct {
+		setResponse
+		Updated   map[string]interface{} `json:"updated"`
+		Destroyed []string               `json:"destroyed"`
+	}
+	err = w.c.call([]invocation{{Name: "Email/set", Args: args{
+		"accountId": w.c.accountID,
+		"update":    update,
+		"destroy":   destroy,
+	}}}, &res)
+	if err != nil {
+		return err
+	}
+	for _, err := range res.NotUpdated {
+		w.err(msg, fmt.Errorf("could not delete message: %v", err))
+	}
+	for _, err := range res.NotDestroyed {
+		w.err(msg, fmt.Errorf("could not delete message: %v", err))
+	}
+	var uids []uint32
+	for id := range res.Updated {
+		uids = append(uids, w.uids.GetOrInsert(id))
+	}
+	for _, id := range res.Destroyed {
+		uids = append(uids, w.uids.GetOrInsert(id))
+	}
+	for _, uid := range uids {
+		delete(w.known, uid)
+	}
+	if len(uids) != 0 {
+		w.worker.PostMessage(&types.MessagesDeleted{
+			Message: types.RespondTo(msg),
+			Uids:    uids,
+		}, nil)
+	}
+	return nil
+}
+
+// Adds messages to a mailbox. The emails stay the same, in more mailboxes.
+func (w *Worker) handleCopyMessages(msg *types.CopyMessages) error {
+	dest, err := w.mailbox(msg.Destination)
+	if err != nil {
+		return err
+	}
+	patches := make(map[uint32]args, len(msg.Uids))
+	for _, uid := range msg.Uids {
+		patches[uid] = args{"mailboxIds/" + dest.ID: true}
+	}
+	_, err = w.update(msg, patches)
+	return err
+}
+
+var imapKeywords = map[string]string{
+	imap.SeenFlag:     "$seen",
+	imap.AnsweredFlag: "$answered",
+	imap.FlaggedFlag:  "$flagged",
+	imap.DraftFlag:    "$draft",
+}
+
+// Uploads a message and imports it into mailboxes, along with other method
+// calls of the same request which may refer to it as #email
+func (w *Worker) importMessage(content []byte, mailboxIDs args,
+	keywords args, date time.Time, calls []invocation,
+	results ...interface{}) error {
+
+	blobID, err := w.c.upload(bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	if date.IsZero() {
+		date = time.Now()
+	}
+	var res setResponse
+	calls = append([]invocation{{Name: "Email/import", Args: args{
+		"accountId": w.c.accountID,
+		"emails": args{
+			"email": args{
+				"blobId":     blobID,
+				"mailboxIds": mailboxIDs,
+				"keywords":   keywords,
+				"receivedAt": date.UTC().Format(time.RFC3339),
+			},
+		},
+	}}}, calls...)
+	if err := w.c.call(calls, append([]interface{}{&res}, results...)...); err != nil {
+		return err
+	}
+	if err := res.NotCreated["email"]; err != nil {
+		return fmt.Errorf("could not import message: %v", err)
+	}
+	return nil
+}
+
+func (w *Worker) handleAppendMessage(msg *types.AppendMessage) error {
+	if err := w.fetchMailboxes(); err != nil {
+		return err
+	}
+	dest, err := w.mailbox(msg.Destination)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(msg.Reader); err != nil {
+		w.worker.Logger.Printf("could not read message: %v", err)
+		return err
+	}
+	keywords := args{}
+	for _, flag := range msg.Flags {
+		if keyword, ok := imapKeywords[flag]; ok {
+			keywords[keyword] = true
+		}
+	}
+	return w.importMessage(buf.Bytes(), args{dest.ID: true}, keywords,
+		msg.Date, nil)
+}
+
+// Translates search criteria to a JMAP filter
+func searchFilter(criteria *imap.SearchCriteria) args {
+	var conditions []interface{}
+	for name, values := range criteria.Header {
+		for _, value := range values {
+			switch field := strings.ToLower(name); field {
+			case "from", "to", "cc", "bcc", "subject":
+				conditions = append(conditions, args{field: value})
+			default:
+				conditions = append(conditions,
+					args{"header": []string{name, value}})
+			}
+		}
+	}
+	for _, value := range criteria.Body {
+		conditions = append(conditions, args{"body": value})
+	}
+	for _, value := range criteria.Text {
+		conditions = append(conditions, args{"text": value})
+	}
+	for _, flag := range criteria.WithFlags {
+		if keyword, ok := imapKeywords[flag]; ok {
+			conditions = append(conditions, args{"hasKeyword": keyword})
+		}
+	}
+	for _, flag := range criteria.WithoutFlags {
+		if keyword, ok := imapKeywords[flag]; ok {
+			conditions = append(conditions, args{"notKeyword": keyword})
+		}
+	}
+	if !criteria.Since.IsZero() {
+		conditions = append(conditions,
+			args{"after": criteria.Since.UTC().Format(time.RFC3339)})
+	}
+	if !criteria.Before.IsZero() {
+		conditions = append(conditions,
+			args{"before": criteria.Before.UTC().Format(time.RFC3339)})
+	}
+	return args{"operator": "AND", "conditions": conditions}
+}
+
+func (w *Worker) handleSearchDirectory(msg *types.SearchDirectory) error {
+	ids, _, err := w.query(searchFilter(msg.Criteria))
+	if err != nil {
+		return err
+	}
+	uids := make([]uint32, len(ids))
+	for i, id := range ids {
+		uids[i] = w.uids.GetOrInsert(id)
+	}
+	w.worker.PostMessage(&types.SearchResults{
+		Message: types.RespondTo(msg),
+		Uids:    uids,
+	}, nil)
+	return nil
+}
+
+func sameFlags(a []models.Flag, b []models.Flag) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, flag := range a {
+		found := false
+		for _, f := range b {
+			if f == flag {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// sync asks the server what changed since the selected folder was listed, and
+// posts the differences: messages removed, reflagged or added.
+func (w *Worker) sync() error {
+	if w.selected == nil || w.emailState == "" {
+		return nil
+	}
+	var changed, destroyed []string
+	for {
+		var res struct {
+			NewState       string   `json:"newState"`
+			HasMoreChanges bool     `json:"hasMoreChanges"`
+			Created        []string `json:"created"`
+			Updated        []string `json:"updated"`
+			Destroyed      []string `json:"destroyed"`
+		}
+		err := w.c.call([]invocation{{Name: "Email/changes", Args: args{
+			"accountId":  w.c.accountID,
+			"sinceState": w.emailState,
+		}}}, &res)
+		if err, ok := err.(*methodError); ok &&
+			err.Type == "cannotCalculateChanges" {
+
+			// The UI lists the folder again upon new info
+			w.emailState = ""
+			return w.refreshDirInfo()
+		} else if err != nil {
+			return err
+		}
+		changed = append(changed, res.Created...)
+		changed = append(changed, res.Updated...)
+		destroyed = append(destroyed, res.Destroyed...)
+		w.emailState = res.NewState
+		if !res.HasMoreChanges {
+			break
+		}
+	}
+	emails, err := w.getEmails(changed, emailProperties)
+	if err != nil {
+		return err
+	}
+	var deleted []uint32
+	added := false
+	for _, e := range emails {
+		uid := w.uids.GetOrInsert(e.ID)
+		known, ok := w.known[uid]
+		switch {
+		case e.MailboxIDs[w.selected.ID] && !ok:
+			added = true
+		case e.MailboxIDs[w.selected.ID] && !sameFlags(known, e.flags()):
+			w.known[uid] = e.flags()
+			w.worker.PostMessage(&types.MessageInfo{
+				Info: e.messageInfo(uid),
+			}, nil)
+		case !e.MailboxIDs[w.selected.ID] && ok:
+			deleted = append(deleted, uid)
+		}
+	}
+	for _, id := range destroyed {
+		uid := w.uids.GetOrInsert(id)
+		if _, ok := w.known[uid]; ok {
+			deleted = append(deleted, uid)
+		}
+		w.uids.RemoveUID(uid)
+	}
+	for _, uid := range deleted {
+		delete(w.known, uid)
+	}
+	if len(deleted) != 0 {
+		w.worker.PostMessage(&types.MessagesDeleted{Uids: deleted}, nil)
+	}
+	if added {
+		// The UI fetches the new contents upon the updated counts
+		return w.refreshDirInfo()
+	}
+	return nil
+}
+
+func (w *Worker) refreshDirInfo() error {
+	if err := w.fetchMailboxes(); err != nil {
+		return err
+	}
+	if w.selected == nil {
+		return fmt.Errorf("folder %s is gone", w.selectedName)
+	}
+	w.worker.PostMessage(w.dirInfo(), nil)
+	return nil
+}
@@ -4,60 +4,246 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/url"
+	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 
 	"github.com/emersion/go-maildir"
+	"github.com/kyoh86/xdg"
 
 	"git.sr.ht/~sircmpwn/aerc/lib/uidstore"
 )
 
 // A Container is a directory which contains other directories which adhere to
-// the Maildir spec
+// the Maildir spec. The maildirs may be nested in other directories, or be
+// laid out as in Maildir++, where the container itself is the inbox and the
+// folders are dot-separated, e.g. .Lists.golang.
 type Container struct {
-	dir  string
-	log  *log.Logger
-	uids *uidstore.Store
+	dir string
+	log *log.Logger
+	// The UIDs of the messages of each maildir
+	uids map[maildir.Dir]*uidstore.Store
+
+	// Separates the levels of folder names, e.g. Lists/golang
+	separator string
+	// Directories of the folders, relative to dir
+	folders map[string]string
+	// Whether new folders are created as in Maildir++
+	maildirpp bool
 }
 
 // NewContainer creates a new container at the specified directory
 // TODO: return an error if the provided directory is not accessible
-func NewContainer(dir string, l *log.Logger) *Container {
-	return &Container{dir: dir, uids: uidstore.NewStore(), log: l}
+func NewContainer(dir string, separator string, l *log.Logger) *Container {
+	return &Container{
+		dir:       dir,
+		uids:      make(map[maildir.Dir]*uidstore.Store),
+		log:       l,
+		separator: separator,
+		folders:   make(map[string]string),
+	}
+}
+
+// Returns the UIDs of the messages of a maildir. They are kept in the cache
+// directory, so that messages keep their UIDs across runs.
+func (c *Container) store(d maildir.Dir) *uidstore.Store {
+	if store, ok := c.uids[d]; ok {
+		return store
+	}
+	abs, err := filepath.Abs(string(d))
+	if err != nil {
+		abs = string(d)
+	}
+	store, err := uidstore.Load(filepath.Join(xdg.CacheHome(), "aerc", "uids",
+		"maildir", url.PathEscape(abs)))
+	if err != nil {
+		c.log.Printf("could not load UIDs, they won't persist: %v", err)
+		store = uidstore.NewStore()
+	}
+	c.uids[d] = store
+	return store
+}
+
+// Saves the UIDs of a maildir, which failing is only worth a log entry
+func (c *Container) saveUIDs(d maildir.Dir) {
+	if err := c.store(d).Save(); err != nil {
+		c.log.Printf("could not save UIDs of %s: %v", d, err)
+	}
+}
+
+// Whether path holds a maildir rather than other folders
+func isMaildir(path string) bool {
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		if info, err := os.Stat(filepath.Join(path, sub)); err != nil ||
+			!info.IsDir() {
+
+			return false
+		}
+	}
+	return true
 }
 
 // ListFolders returns a list of maildir folders in the container
 func (c *Container) ListFolders() ([]string, error) {
-	files, err := ioutil.ReadDir(c.dir)
-	if err != nil {
+	c.folders = make(map[string]string)
+	c.maildirpp = isMaildir(c.dir)
+	if c.maildirpp {
+		c.folders["INBOX"] = ""
+	}
+	if err := c.listFolders("", nil); err != nil {
 		return nil, fmt.Errorf("error reading folders: %v", err)
 	}
 	dirnames := []string{}
+	for name := range c.folders {
+		dirnames = append(dirnames, name)
+	}
+	sort.Strings(dirnames)
+	return dirnames, nil
+}
+
+// Adds the folders under the directory rel, whose folder name is made of
+// levels
+func (c *Container) listFolders(rel string, levels []string) error {
+	files, err := ioutil.ReadDir(filepath.Join(c.dir, rel))
+	if err != nil {
+		return err
+	}
 	for _, f := range files {
-		if f.IsDir() {
-			dirnames = append(dirnames, f.Name())
+		name := f.Name()
+		if !f.IsDir() || (rel == "" && c.maildirpp &&
+			(name == "cur" || name == "new" || name == "tmp")) {
+
+			continue
+		}
+		path := filepath.Join(rel, name)
+		if strings.HasPrefix(name, ".") {
+			// Maildir++ folders live at the top only, and other dot
+			// directories are hidden, e.g. .notmuch
+			if rel == "" && len(name) > 1 &&
+				isMaildir(filepath.Join(c.dir, path)) {
+
+				c.maildirpp = true
+				folder := strings.Split(name[1:], ".")
+				c.folders[strings.Join(folder, c.separator)] = path
+			}
+			continue
+		}
+		sublevels := append(levels[:len(levels):len(levels)], name)
+		if isMaildir(filepath.Join(c.dir, path)) {
+			c.folders[strings.Join(sublevels, c.separator)] = path
+		}
+		if err := c.listFolders(path, sublevels); err != nil {
+			return err
 		}
 	}
-	return dirnames, nil
+	return nil
 }
 
 // OpenDirectory opens an existing maildir in the container by name, moves new
-// messages into cur, and registers the new keys in the UIDStore.
-func (c *Container) OpenDirectory(name string) (maildir.Dir, error) {
+// messages into cur, and registers the new keys in the UIDStore. It returns
+// how many messages were new.
+func (c *Container) OpenDirectory(name string) (maildir.Dir, int, error) {
 	dir := c.Dir(name)
 	keys, err := dir.Unseen()
 	if err != nil {
-		return dir, err
+		return dir, 0, err
 	}
 	for _, key := range keys {
-		c.uids.GetOrInsert(key)
+		c.store(dir).GetOrInsert(key)
 	}
-	return dir, nil
+	c.saveUIDs(dir)
+	return dir, len(keys), nil
 }
 
-// Dir returns a maildir.Dir with the specified name inside the container
+// Dir returns a maildir.Dir with the specified name inside the container. The
+// maildir may not exist yet, e.g. to be created.
 func (c *Container) Dir(name string) maildir.Dir {
-	return maildir.Dir(filepath.Join(c.dir, name))
+	if path, ok := c.folders[name]; ok {
+		return maildir.Dir(filepath.Join(c.dir, path))
+	}
+	levels := strings.Split(name, c.separator)
+	if c.maildirpp {
+		return maildir.Dir(filepath.Join(c.dir, "."+strings.Join(levels, ".")))
+	}
+	return maildir.Dir(filepath.Join(append([]string{c.dir}, levels...)...))
+}
+
+// CreateFolder creates a maildir, along with the directories above it
+func (c *Container) CreateFolder(name string) error {
+	dir := c.Dir(name)
+	if err := os.MkdirAll(filepath.Dir(string(dir)), 0700); err != nil {
+		return err
+	}
+	if err := dir.Create(); err != nil {
+		return err
+	}
+	path, err := filepath.Rel(c.dir, string(dir))
+	if err != nil {
+		return err
+	}
+	c.folders[name] = path
+	return nil
+}
+
+// Flags returns the flags of the messages of a maildir by key, as the sorted
+// letters of the info part of their file names.
+func (c *Container) Flags(d maildir.Dir) (map[string]string, error) {
+	f, err := os.Open(filepath.Join(string(d), "cur"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(0)
+	if err != nil {
+		return nil, err
+	}
+	flags := make(map[string]string, len(names))
+	for _, name := range names {
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		key, info := name, ""
+		if i := strings.Index(name, ":"); i != -1 {
+			key, info = name[:i], strings.TrimPrefix(name[i+1:], "2,")
+		}
+		letters := strings.Split(info, "")
+		sort.Strings(letters)
+		flags[key] = strings.Join(letters, "")
+	}
+	return flags, nil
+}
+
+// Counts returns the number of messages of a maildir, and how many of them
+// are unread. The flags are read from the file names, which is much faster
+// than looking them up message by message.
+func (c *Container) Counts(d maildir.Dir) (int, int, error) {
+	exists, unseen := 0, 0
+	for _, sub := range []string{"new", "cur"} {
+		f, err := os.Open(filepath.Join(string(d), sub))
+		if err != nil {
+			return 0, 0, err
+		}
+		names, err := f.Readdirnames(0)
+		f.Close()
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, name := range names {
+			if strings.HasPrefix(name, ".") {
+				continue
+			}
+			exists++
+			i := strings.Index(name, ":2,")
+			if sub == "new" || i == -1 ||
+				!strings.ContainsRune(name[i+3:], rune(maildir.FlagSeen)) {
+
+				unseen++
+			}
+		}
+	}
+	return exists, unseen, nil
 }
 
 // UIDs fetches the unique message identifiers for the maildir
@@ -67,16 +253,21 @@ func (c *Container) UIDs(d maildir.Dir) ([]uint32, error) {
 		return nil, fmt.Errorf("could not get keys for %s: %v", d, err)
 	}
 	sort.Strings(keys)
+	store := c.store(d)
+	// Forget the messages which are gone
+	store.Retain(keys)
 	var uids []uint32
 	for _, key := range keys {
-		uids = append(uids, c.uids.GetOrInsert(key))
+		uids = append(uids, store.GetOrInsert(key))
 	}
+	// Only written if messages came or went since the last listing
+	c.saveUIDs(d)
 	return uids, nil
 }
 
 // Message returns a Message struct for the given UID and maildir
 func (c *Container) Message(d maildir.Dir, uid uint32) (*Message, error) {
-	if key, ok := c.uids.GetKey(uid); ok {
+	if key, ok := c.store(d).GetKey(uid); ok {
 		return &Message{
 			dir: d,
 			uid: uid,
@@ -116,7 +307,7 @@ func (c *Container) CopyAll(
 
 func (c *Container) copyMessage(
 	dest maildir.Dir, src maildir.Dir, uid uint32) error {
-	key, ok := c.uids.GetKey(uid)
+	key, ok := c.store(src).GetKey(uid)
 	if !ok {
 		return fmt.Errorf("could not find key for message id %d", uid)
 	}
@@ -72,6 +72,24 @@ func (m Message) MarkRead(seen bool) error {
 	return m.SetFlags(newFlags)
 }
 
+// SetFlag adds or removes a flag from the message.
+func (m Message) SetFlag(flag maildir.Flag, enable bool) error {
+	flags, err := m.Flags()
+	if err != nil {
+		return fmt.Errorf("could not read previous flags: %v", err)
+	}
+	var newFlags []maildir.Flag
+	for _, f := range flags {
+		if f != flag {
+			newFlags = append(newFlags, f)
+		}
+	}
+	if enable {
+		newFlags = append(newFlags, flag)
+	}
+	return m.SetFlags(newFlags)
+}
+
 // Remove deletes the email immediately.
 func (m Message) Remove() error {
 	return m.dir.Remove(m.key)
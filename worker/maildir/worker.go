@@ -6,6 +6,8 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"time"
 
 	"github.com/emersion/go-maildir"
 	"github.com/fsnotify/fsnotify"
@@ -21,12 +23,24 @@ func init() {
 
 var errUnsupported = fmt.Errorf("unsupported command")
 
+// How long to gather the changes of other programs to the selected maildir,
+// e.g. during a sync, before looking at them all at once
+const syncDelay = 250 * time.Millisecond
+
 // A Worker handles interfacing between aerc's UI and a group of maildirs.
 type Worker struct {
-	c        *Container
-	selected *maildir.Dir
-	worker   *types.Worker
-	watcher  *fsnotify.Watcher
+	c            *Container
+	selected     *maildir.Dir
+	selectedName string
+	// New messages since the selected directory was opened
+	recent int
+	// The flags of the messages of the selected directory by key, as the UI
+	// knows them
+	known map[string]string
+	// Fires when the changes to the selected directory should be looked at
+	changed <-chan time.Time
+	worker  *types.Worker
+	watcher *fsnotify.Watcher
 }
 
 // NewWorker creates a new maildir worker with the provided worker.
@@ -46,6 +60,9 @@ func (w *Worker) Run() {
 			w.handleAction(action)
 		case ev := <-w.watcher.Events:
 			w.handleFSEvent(ev)
+		case <-w.changed:
+			w.changed = nil
+			w.syncSelected()
 		}
 	}
 }
@@ -67,27 +84,106 @@ func (w *Worker) handleAction(action types.WorkerMessage) {
 }
 
 func (w *Worker) handleFSEvent(ev fsnotify.Event) {
-	// we only care about files being created
-	if ev.Op != fsnotify.Create {
+	// if there's not a selected directory to rescan, ignore
+	if w.selected == nil || ev.Op == fsnotify.Chmod {
 		return
 	}
-	// if there's not a selected directory to rescan, ignore
+	if w.changed == nil {
+		w.changed = time.After(syncDelay)
+	}
+}
+
+// Posts the changes to the selected directory since the UI listed it: the
+// messages which other programs delivered, removed or reflagged
+func (w *Worker) syncSelected() {
 	if w.selected == nil {
 		return
 	}
-	_, err := w.selected.Unseen()
+	keys, err := w.selected.Unseen()
 	if err != nil {
 		w.worker.Logger.Printf("could not move new to cur : %v", err)
 		return
 	}
-	uids, err := w.c.UIDs(*w.selected)
+	w.recent += len(keys)
+	current, err := w.c.Flags(*w.selected)
 	if err != nil {
-		w.worker.Logger.Printf("could not scan UIDs: %v", err)
+		w.worker.Logger.Printf("could not scan messages: %v", err)
 		return
 	}
-	w.worker.PostMessage(&types.DirectoryContents{
-		Uids: uids,
-	}, nil)
+	uids := w.c.store(*w.selected)
+
+	var deleted []uint32
+	for key := range w.known {
+		if _, ok := current[key]; !ok {
+			deleted = append(deleted, uids.GetOrInsert(key))
+		}
+	}
+	if len(deleted) != 0 {
+		w.worker.PostMessage(&types.MessagesDeleted{Uids: deleted}, nil)
+	}
+
+	var added []uint32
+	changed := len(deleted) != 0
+	for key, flags := range current {
+		known, ok := w.known[key]
+		if !ok {
+			added = append(added, uids.GetOrInsert(key))
+			continue
+		}
+		if flags == known {
+			continue
+		}
+		changed = true
+		m, err := w.c.Message(*w.selected, uids.GetOrInsert(key))
+		if err != nil {
+			w.worker.Logger.Printf("could not get message: %v", err)
+			continue
+		}
+		info, err := m.MessageInfo()
+		if err != nil {
+			w.worker.Logger.Printf("could not get message info: %v", err)
+			continue
+		}
+		w.worker.PostMessage(&types.MessageInfo{Info: info}, nil)
+	}
+	w.known = current
+
+	if len(added) != 0 {
+		w.c.saveUIDs(*w.selected)
+		sort.Slice(added, func(i, j int) bool { return added[i] < added[j] })
+		w.worker.PostMessage(&types.MessagesAdded{Uids: added}, nil)
+		changed = true
+	}
+	if changed {
+		info, err := w.dirInfo()
+		if err != nil {
+			w.worker.Logger.Printf("could not count messages: %v", err)
+			return
+		}
+		info.SkipContents = true
+		w.worker.PostMessage(info, nil)
+	}
+}
+
+// Returns the info of the selected directory
+func (w *Worker) dirInfo() (*types.DirectoryInfo, error) {
+	exists, unseen, err := w.c.Counts(*w.selected)
+	if err != nil {
+		return nil, err
+	}
+	return &types.DirectoryInfo{
+		Info: &models.DirectoryInfo{
+			Name:     w.selectedName,
+			Flags:    []string{},
+			ReadOnly: false,
+			// total messages
+			Exists: exists,
+			// new messages since mailbox was last opened
+			Recent: w.recent,
+			// total unread
+			Unseen: unseen,
+		},
+	}, nil
 }
 
 func (w *Worker) done(msg types.WorkerMessage) {
@@ -127,6 +223,8 @@ func (w *Worker) handleMessage(msg types.WorkerMessage) error {
 		return w.handleDeleteMessages(msg)
 	case *types.ReadMessages:
 		return w.handleReadMessages(msg)
+	case *types.FlagMessages:
+		return w.handleFlagMessages(msg)
 	case *types.CopyMessages:
 		return w.handleCopyMessages(msg)
 	case *types.AppendMessage:
@@ -151,7 +249,13 @@ func (w *Worker) handleConfigure(msg *types.Configure) error {
 		}
 		dir = filepath.Join(home, u.Path)
 	}
-	w.c = NewContainer(dir, w.worker.Logger)
+	separator, ok := msg.Config.Params["folder-separator"]
+	if !ok {
+		separator = "/"
+	} else if separator == "" {
+		return fmt.Errorf("folder-separator must not be empty")
+	}
+	w.c = NewContainer(dir, separator, w.worker.Logger)
 	w.worker.Logger.Printf("configured base maildir: %s", dir)
 	return nil
 }
@@ -181,57 +285,61 @@ func (w *Worker) handleListDirectories(msg *types.ListDirectories) error {
 func (w *Worker) handleOpenDirectory(msg *types.OpenDirectory) error {
 	w.worker.Logger.Printf("opening %s", msg.Directory)
 
-	// remove existing watch path
+	// remove existing watch paths
 	if w.selected != nil {
-		prevDir := filepath.Join(string(*w.selected), "new")
-		if err := w.watcher.Remove(prevDir); err != nil {
-			return fmt.Errorf("could not unwatch previous directory: %v", err)
+		for _, sub := range []string{"new", "cur"} {
+			prevDir := filepath.Join(string(*w.selected), sub)
+			if err := w.watcher.Remove(prevDir); err != nil {
+				return fmt.Errorf("could not unwatch previous directory: %v",
+					err)
+			}
 		}
 	}
 
 	// open the directory
-	dir, err := w.c.OpenDirectory(msg.Directory)
+	dir, recent, err := w.c.OpenDirectory(msg.Directory)
 	if err != nil {
 		return err
 	}
 	w.selected = &dir
+	w.selectedName = msg.Directory
+	w.recent = recent
+
+	w.known = nil
 
-	// add watch path
-	newDir := filepath.Join(string(*w.selected), "new")
-	if err := w.watcher.Add(newDir); err != nil {
-		return fmt.Errorf("could not add watch to directory: %v", err)
+	// add watch paths: new for deliveries, cur for the changes of other
+	// clients
+	for _, sub := range []string{"new", "cur"} {
+		if err := w.watcher.Add(filepath.Join(string(dir), sub)); err != nil {
+			return fmt.Errorf("could not add watch to directory: %v", err)
+		}
 	}
 
 	if err := dir.Clean(); err != nil {
 		return fmt.Errorf("could not clean directory: %v", err)
 	}
 
-	// TODO: why does this need to be sent twice??
-	info := &types.DirectoryInfo{
-		Info: &models.DirectoryInfo{
-			Name:     msg.Directory,
-			Flags:    []string{},
-			ReadOnly: false,
-			// total messages
-			Exists: 0,
-			// new messages since mailbox was last opened
-			Recent: 0,
-			// total unread
-			Unseen: 0,
-		},
+	info, err := w.dirInfo()
+	if err != nil {
+		return fmt.Errorf("could not count messages: %v", err)
 	}
 	w.worker.PostMessage(info, nil)
-	w.worker.PostMessage(info, nil)
 	return nil
 }
 
 func (w *Worker) handleFetchDirectoryContents(
 	msg *types.FetchDirectoryContents) error {
+	known, err := w.c.Flags(*w.selected)
+	if err != nil {
+		w.worker.Logger.Printf("error scanning messages: %v", err)
+		return err
+	}
 	uids, err := w.c.UIDs(*w.selected)
 	if err != nil {
 		w.worker.Logger.Printf("error scanning uids: %v", err)
 		return err
 	}
+	w.known = known
 	w.worker.PostMessage(&types.DirectoryContents{
 		Message: types.RespondTo(msg),
 		Uids:    uids,
@@ -240,8 +348,7 @@ func (w *Worker) handleFetchDirectoryContents(
 }
 
 func (w *Worker) handleCreateDirectory(msg *types.CreateDirectory) error {
-	dir := w.c.Dir(msg.Directory)
-	if err := dir.Create(); err != nil {
+	if err := w.c.CreateFolder(msg.Directory); err != nil {
 		w.worker.Logger.Printf("could not create directory %s: %v",
 			msg.Directory, err)
 		return err
@@ -341,6 +448,12 @@ func (w *Worker) handleFetchFullMessages(msg *types.FetchFullMessages) error {
 
 func (w *Worker) handleDeleteMessages(msg *types.DeleteMessages) error {
 	deleted, err := w.c.DeleteAll(*w.selected, msg.Uids)
+	for _, uid := range deleted {
+		// Don't report them again when the file system events come
+		if key, ok := w.c.store(*w.selected).GetKey(uid); ok {
+			delete(w.known, key)
+		}
+	}
 	if len(deleted) > 0 {
 		w.worker.PostMessage(&types.MessagesDeleted{
 			Message: types.RespondTo(msg),
@@ -381,6 +494,43 @@ func (w *Worker) handleReadMessages(msg *types.ReadMessages) error {
 	return nil
 }
 
+func (w *Worker) handleFlagMessages(msg *types.FlagMessages) error {
+	var flag maildir.Flag
+	found := false
+	for f, modelFlag := range flagMap {
+		if modelFlag == msg.Flag {
+			flag, found = f, true
+		}
+	}
+	if !found {
+		return errUnsupported
+	}
+	for _, uid := range msg.Uids {
+		m, err := w.c.Message(*w.selected, uid)
+		if err != nil {
+			w.worker.Logger.Printf("could not get message: %v", err)
+			w.err(msg, err)
+			continue
+		}
+		if err := m.SetFlag(flag, msg.Enable); err != nil {
+			w.worker.Logger.Printf("could not set message flag: %v", err)
+			w.err(msg, err)
+			continue
+		}
+		info, err := m.MessageInfo()
+		if err != nil {
+			w.worker.Logger.Printf("could not get message info: %v", err)
+			w.err(msg, err)
+			continue
+		}
+		w.worker.PostMessage(&types.MessageInfo{
+			Message: types.RespondTo(msg),
+			Info:    info,
+		}, nil)
+	}
+	return nil
+}
+
 func (w *Worker) handleCopyMessages(msg *types.CopyMessages) error {
 	dest := w.c.Dir(msg.Destination)
 	err := w.c.CopyAll(dest, *w.selected, msg.Uids)
@@ -0,0 +1,138 @@
+package maildir
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/emersion/go-maildir"
+
+	"git.sr.ht/~sircmpwn/aerc/worker/types"
+)
+
+func deliver(t *testing.T, d maildir.Dir, subject string) {
+	delivery, err := d.NewDelivery()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(delivery, "Date: Mon, 19 Oct 2020 10:00:00 +0000\r\n"+
+		"Subject: %s\r\n\r\nHello\r\n", subject)
+	if err := delivery.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Takes the messages the worker posted so far
+func posted(w *Worker) []types.WorkerMessage {
+	var msgs []types.WorkerMessage
+	for {
+		select {
+		case msg := <-w.worker.Messages:
+			msgs = append(msgs, msg)
+		default:
+			return msgs
+		}
+	}
+}
+
+func TestSyncSelected(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aerc-maildir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cache := os.Getenv("XDG_CACHE_HOME")
+	os.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+	defer os.Setenv("XDG_CACHE_HOME", cache)
+
+	inbox := maildir.Dir(filepath.Join(dir, "INBOX"))
+	if err := inbox.Create(); err != nil {
+		t.Fatal(err)
+	}
+	for _, subject := range []string{"one", "two", "three"} {
+		deliver(t, inbox, subject)
+	}
+
+	logger := log.New(ioutil.Discard, "", 0)
+	backend, err := NewWorker(types.NewWorker(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := backend.(*Worker)
+	w.c = NewContainer(dir, "/", logger)
+	if err := w.handleOpenDirectory(&types.OpenDirectory{
+		Directory: "INBOX",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	err = w.handleFetchDirectoryContents(&types.FetchDirectoryContents{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uids []uint32
+	for _, msg := range posted(w) {
+		if msg, ok := msg.(*types.DirectoryContents); ok {
+			uids = msg.Uids
+		}
+	}
+	if len(uids) != 3 {
+		t.Fatalf("listed %d messages instead of 3", len(uids))
+	}
+
+	// Another program removes a message, reads another and delivers a new one
+	key := func(uid uint32) string {
+		key, _ := w.c.store(inbox).GetKey(uid)
+		return key
+	}
+	if err := inbox.Remove(key(uids[0])); err != nil {
+		t.Fatal(err)
+	}
+	err = inbox.SetFlags(key(uids[1]), []maildir.Flag{maildir.FlagSeen})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliver(t, inbox, "four")
+	w.syncSelected()
+
+	var (
+		deleted, added []uint32
+		reflagged      []uint32
+		info           *types.DirectoryInfo
+	)
+	for _, msg := range posted(w) {
+		switch msg := msg.(type) {
+		case *types.MessagesDeleted:
+			deleted = append(deleted, msg.Uids...)
+		case *types.MessagesAdded:
+			added = append(added, msg.Uids...)
+		case *types.MessageInfo:
+			reflagged = append(reflagged, msg.Info.Uid)
+		case *types.DirectoryInfo:
+			info = msg
+		case *types.DirectoryContents:
+			t.Error("listed the whole directory again")
+		}
+	}
+	if len(deleted) != 1 || deleted[0] != uids[0] {
+		t.Errorf("deleted %v instead of %d", deleted, uids[0])
+	}
+	if len(reflagged) != 1 || reflagged[0] != uids[1] {
+		t.Errorf("reflagged %v instead of %d", reflagged, uids[1])
+	}
+	if len(added) != 1 || key(added[0]) == "" {
+		t.Errorf("added %v instead of the new message", added)
+	}
+	if info == nil {
+		t.Fatal("counts not refreshed")
+	}
+	if !info.SkipContents {
+		t.Error("the UI would fetch the whole directory again")
+	}
+	if info.Info.Exists != 3 || info.Info.Unseen != 2 {
+		t.Errorf("counted %d messages, %d unread, instead of 3, 2",
+			info.Info.Exists, info.Info.Unseen)
+	}
+}
@@ -0,0 +1,503 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"git.sr.ht/~sircmpwn/aerc/lib/uidstore"
+	"git.sr.ht/~sircmpwn/aerc/models"
+)
+
+// An entry of the index of an mbox file: a message. Only its position and
+// flags are kept in memory; the rest is read from the file when needed.
+type entry struct {
+	uid uint32
+	// Identifies the message across changes to the file: its Message-ID,
+	// or its From line if it has none
+	key string
+	// Where the From line starts, and where the next one does
+	offset int64
+	end    int64
+	flags  []models.Flag
+}
+
+// A mailbox is an mbox file, indexed by the offsets of its messages
+type mailbox struct {
+	path     string
+	messages []*entry
+	byUID    map[uint32]*entry
+	uids     *uidstore.Store
+	// The flags changed in memory but not written to the file yet, by UID
+	pending map[uint32][]models.Flag
+
+	// The state of the file when it was indexed, to notice the changes of
+	// other programs
+	size    int64
+	modTime time.Time
+}
+
+func newMailbox(path string) *mailbox {
+	return &mailbox{path: path, uids: uidstore.NewStore()}
+}
+
+// Whether a line starts a message. The From lines in messages are quoted
+// with > (mboxrd), and the messages are separated by blank lines.
+func isFromLine(line []byte, prev []byte) bool {
+	return bytes.HasPrefix(line, []byte("From ")) &&
+		(prev == nil || len(bytes.TrimRight(prev, "\r\n")) == 0)
+}
+
+// Returns the value of a header line if it is named name
+func headerValue(line []byte, name string) (string, bool) {
+	if len(line) <= len(name) || line[len(name)] != ':' ||
+		!strings.EqualFold(string(line[:len(name)]), name) {
+
+		return "", false
+	}
+	return strings.TrimSpace(string(line[len(name)+1:])), true
+}
+
+// The Status and X-Status headers hold the flags of mbox messages
+func parseFlags(status string, xstatus string) []models.Flag {
+	var flags []models.Flag
+	if strings.ContainsRune(status, 'R') {
+		flags = append(flags, models.SeenFlag)
+	}
+	if strings.ContainsRune(xstatus, 'A') {
+		flags = append(flags, models.AnsweredFlag)
+	}
+	if strings.ContainsRune(xstatus, 'F') {
+		flags = append(flags, models.FlaggedFlag)
+	}
+	if strings.ContainsRune(xstatus, 'D') {
+		flags = append(flags, models.DeletedFlag)
+	}
+	return flags
+}
+
+var xstatusLetters = map[models.Flag]string{
+	models.AnsweredFlag: "A",
+	models.FlaggedFlag:  "F",
+	models.DeletedFlag:  "D",
+}
+
+func hasFlag(flags []models.Flag, flag models.Flag) bool {
+	for _, f := range flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// setFlags changes the flags of a message in memory. They are written to the
+// file by the next rewrite, so that changing flags one by one doesn't write
+// the whole file each time.
+func (mb *mailbox) setFlags(msg *entry, flags []models.Flag) {
+	msg.flags = flags
+	if mb.pending == nil {
+		mb.pending = make(map[uint32][]models.Flag)
+	}
+	mb.pending[msg.uid] = flags
+}
+
+// index scans the file for its messages. The messages which were already
+// known keep their UIDs, and the flags which weren't written yet.
+func (mb *mailbox) index() error {
+	f, err := os.Open(mb.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var (
+		messages []*entry
+		msg      *entry
+		inHeader bool
+		status   string
+		xstatus  string
+		prev     []byte
+		pos      int64
+		keys     = make(map[string]int)
+	)
+	finish := func() {
+		if msg == nil {
+			return
+		}
+		msg.end = pos
+		msg.flags = parseFlags(status, xstatus)
+		keys[msg.key]++
+		if n := keys[msg.key]; n > 1 {
+			msg.key = fmt.Sprintf("%s#%d", msg.key, n)
+		}
+		msg.uid = mb.uids.GetOrInsert(msg.key)
+		messages = append(messages, msg)
+	}
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			if err != io.EOF {
+				return err
+			}
+			break
+		}
+		if isFromLine(line, prev) {
+			finish()
+			msg = &entry{
+				offset: pos,
+				key:    string(bytes.TrimRight(line, "\r\n")),
+			}
+			inHeader = true
+			status, xstatus = "", ""
+		} else if inHeader {
+			if len(bytes.TrimRight(line, "\r\n")) == 0 {
+				inHeader = false
+			} else if v, ok := headerValue(line, "Status"); ok {
+				status = v
+			} else if v, ok := headerValue(line, "X-Status"); ok {
+				xstatus = v
+			} else if v, ok := headerValue(line, "Message-ID"); ok &&
+				v != "" {
+
+				msg.key = v
+			}
+		}
+		pos += int64(len(line))
+		prev = line
+	}
+	finish()
+
+	mb.messages = messages
+	mb.byUID = make(map[uint32]*entry, len(messages))
+	for _, msg := range messages {
+		mb.byUID[msg.uid] = msg
+	}
+	for uid, flags := range mb.pending {
+		if msg, ok := mb.byUID[uid]; ok {
+			msg.flags = flags
+		} else {
+			delete(mb.pending, uid)
+		}
+	}
+	mb.size, mb.modTime = info.Size(), info.ModTime()
+	return nil
+}
+
+// changed reports whether the file changed since it was indexed
+func (mb *mailbox) changed() (bool, error) {
+	info, err := os.Stat(mb.path)
+	if err != nil {
+		return false, err
+	}
+	return info.Size() != mb.size || !info.ModTime().Equal(mb.modTime), nil
+}
+
+// update runs fn with the file locked, once the mailbox is indexed again if
+// another program changed the file
+func (mb *mailbox) update(fn func() error) error {
+	unlock, err := lock(mb.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if changed, err := mb.changed(); err != nil {
+		return err
+	} else if changed {
+		if err := mb.index(); err != nil {
+			return err
+		}
+	}
+	return fn()
+}
+
+// Returns the raw bytes of a message, From line included
+func (mb *mailbox) raw(msg *entry) ([]byte, error) {
+	f, err := os.Open(mb.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	raw := make([]byte, msg.end-msg.offset)
+	if _, err := f.ReadAt(raw, msg.offset); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// content returns a message as it was received: without its From line, the
+// blank line which separates it from the next one and the quoting of From
+// lines.
+func (mb *mailbox) content(msg *entry) ([]byte, error) {
+	raw, err := mb.raw(msg)
+	if err != nil {
+		return nil, err
+	}
+	return unquote(raw), nil
+}
+
+func unquote(raw []byte) []byte {
+	if i := bytes.IndexByte(raw, '\n'); i != -1 {
+		raw = raw[i+1:]
+	} else {
+		raw = nil
+	}
+	if bytes.HasSuffix(raw, []byte("\r\n\r\n")) {
+		raw = raw[:len(raw)-2]
+	} else if bytes.HasSuffix(raw, []byte("\n\n")) {
+		raw = raw[:len(raw)-1]
+	}
+	lines := bytes.SplitAfter(raw, []byte("\n"))
+	for i, line := range lines {
+		if quoted := bytes.TrimLeft(line, ">"); len(quoted) < len(line) &&
+			bytes.HasPrefix(quoted, []byte("From ")) {
+
+			lines[i] = line[1:]
+		}
+	}
+	return bytes.Join(lines, nil)
+}
+
+// Writes a message to an mbox file, From line included, quoting its From
+// lines and ending it with a blank line
+func writeMessage(w io.Writer, from string, content []byte) error {
+	if _, err := io.WriteString(w, from+"\n"); err != nil {
+		return err
+	}
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	for _, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		if bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")) {
+			if _, err := w.Write([]byte(">")); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+	}
+	end := "\n"
+	if len(content) != 0 && !bytes.HasSuffix(content, []byte("\n")) {
+		end = "\n\n"
+	}
+	_, err := io.WriteString(w, end)
+	return err
+}
+
+// A From line for a message received at date
+func fromLine(date time.Time) string {
+	if date.IsZero() {
+		date = time.Now()
+	}
+	return "From MAILER-DAEMON " + date.UTC().Format(time.ANSIC)
+}
+
+// setFlags replaces the Status and X-Status headers of a message
+func setFlags(content []byte, flags []models.Flag) []byte {
+	nl := []byte("\n")
+	if i := bytes.IndexByte(content, '\n'); i > 0 && content[i-1] == '\r' {
+		nl = []byte("\r\n")
+	}
+	end := bytes.Index(content, append(append([]byte{}, nl...), nl...))
+	if end == -1 {
+		end = len(content)
+	} else {
+		end += len(nl)
+	}
+	header, body := content[:end], content[end:]
+
+	var out bytes.Buffer
+	skipping := false
+	for _, line := range bytes.SplitAfter(header, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if skipping && (line[0] == ' ' || line[0] == '\t') {
+			continue
+		}
+		_, status := headerValue(line, "Status")
+		_, xstatus := headerValue(line, "X-Status")
+		skipping = status || xstatus
+		if !skipping {
+			out.Write(line)
+		}
+	}
+	if out.Len() != 0 && !bytes.HasSuffix(out.Bytes(), []byte("\n")) {
+		out.Write(nl)
+	}
+	status := "O"
+	if hasFlag(flags, models.SeenFlag) {
+		status = "RO"
+	}
+	fmt.Fprintf(&out, "Status: %s%s", status, nl)
+	var x string
+	for _, flag := range []models.Flag{
+		models.AnsweredFlag, models.FlaggedFlag, models.DeletedFlag,
+	} {
+		if hasFlag(flags, flag) {
+			x += xstatusLetters[flag]
+		}
+	}
+	if x != "" {
+		fmt.Fprintf(&out, "X-Status: %s%s", x, nl)
+	}
+	if end == len(content) {
+		out.Write(nl)
+	}
+	out.Write(body)
+	return out.Bytes()
+}
+
+// rewrite writes the file anew without the deleted messages and with the
+// pending flags of others, and indexes it again. The lock must be held.
+func (mb *mailbox) rewrite(deleted map[uint32]bool) error {
+
+	// Write a copy and swap it in, so that a crash can't leave half a file
+	tmp, err := ioutil.TempFile(filepath.Dir(mb.path), ".aerc-mbox")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if info, err := os.Stat(mb.path); err == nil {
+		tmp.Chmod(info.Mode())
+	}
+	w := bufio.NewWriter(tmp)
+	for _, msg := range mb.messages {
+		if deleted[msg.uid] {
+			continue
+		}
+		raw, err := mb.raw(msg)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		if newFlags, ok := mb.pending[msg.uid]; ok {
+			from := string(bytes.TrimRight(raw[:bytes.IndexByte(raw, '\n')+1],
+				"\r\n"))
+			err = writeMessage(w, from, setFlags(unquote(raw), newFlags))
+		} else {
+			_, err = w.Write(raw)
+			// The last message may lack the blank line
+			if err == nil && !bytes.HasSuffix(raw, []byte("\n\n")) {
+				if !bytes.HasSuffix(raw, []byte("\n")) {
+					_, err = w.WriteString("\n")
+				}
+				if err == nil {
+					_, err = w.WriteString("\n")
+				}
+			}
+		}
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), mb.path); err != nil {
+		return err
+	}
+	mb.pending = nil
+	return mb.index()
+}
+
+// appendMessage adds a message at the end of an mbox file, which is created
+// if needed. The lock must be held.
+func appendMessage(path string, date time.Time, content []byte) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w := bufio.NewWriter(f)
+	// Messages must be separated by a blank line
+	if size := info.Size(); size > 0 {
+		tail := make([]byte, 2)
+		n := int64(2)
+		if size < n {
+			n = size
+		}
+		if _, err := f.ReadAt(tail[:n], size-n); err != nil {
+			f.Close()
+			return err
+		}
+		if tail[n-1] != '\n' {
+			w.WriteString("\n\n")
+		} else if n < 2 || tail[0] != '\n' {
+			w.WriteString("\n")
+		}
+	}
+	if err := writeMessage(w, fromLine(date), content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// How long to wait for the lock of another program, and when to consider it
+// left behind by a crash
+const (
+	lockTimeout = 10 * time.Second
+	lockStale   = 5 * time.Minute
+)
+
+var errLocked = errors.New("mbox is locked by another program")
+
+// lock takes the dot lock of an mbox file, which mail delivery agents honor,
+// and returns a function to release it.
+func lock(path string) (func(), error) {
+	name := path + ".lock"
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			return func() { os.Remove(name) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("could not lock %s: %v", path, err)
+		}
+		if info, err := os.Stat(name); err == nil &&
+			time.Since(info.ModTime()) > lockStale {
+
+			os.Remove(name)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errLocked
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
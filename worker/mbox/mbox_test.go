@@ -0,0 +1,99 @@
+package mbox
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"git.sr.ht/~sircmpwn/aerc/models"
+)
+
+const testMbox = `From ann@example.org Mon Oct  7 10:00:00 2019
+Message-ID: <1@example.org>
+Subject: first
+Status: RO
+
+Hello
+>From the start
+
+From bob@example.org Mon Oct  7 11:00:00 2019
+Subject: second
+X-Status: F
+
+Hi
+From here on, no blank line above
+`
+
+func TestMailbox(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "mbox")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "INBOX")
+	assert.Nil(ioutil.WriteFile(path, []byte(testMbox), 0600))
+
+	mb := newMailbox(path)
+	assert.Nil(mb.index())
+	assert.Len(mb.messages, 2)
+	first, second := mb.messages[0], mb.messages[1]
+	assert.Equal("<1@example.org>", first.key)
+	assert.Equal([]models.Flag{models.SeenFlag}, first.flags)
+	assert.Equal([]models.Flag{models.FlaggedFlag}, second.flags)
+
+	content, err := mb.content(first)
+	assert.Nil(err)
+	assert.Equal("Message-ID: <1@example.org>\nSubject: first\n"+
+		"Status: RO\n\nHello\nFrom the start\n", string(content))
+
+	assert.Nil(appendMessage(path, time.Time{},
+		[]byte("Subject: third\n\nFrom me\n")))
+	assert.Nil(mb.index())
+	assert.Len(mb.messages, 3)
+	third := mb.messages[2]
+	content, err = mb.content(third)
+	assert.Nil(err)
+	assert.Equal("Subject: third\n\nFrom me\n", string(content))
+
+	// Flags are kept in memory until the file is written
+	uid := first.uid
+	mb.setFlags(third, []models.Flag{models.SeenFlag, models.AnsweredFlag})
+	assert.Nil(mb.index())
+	assert.Equal([]models.Flag{models.SeenFlag, models.AnsweredFlag},
+		mb.messages[2].flags)
+	assert.Nil(mb.rewrite(map[uint32]bool{second.uid: true}))
+	assert.Empty(mb.pending)
+	assert.Len(mb.messages, 2)
+	assert.Equal(uid, mb.messages[0].uid)
+	assert.Equal(third.uid, mb.messages[1].uid)
+	assert.Equal([]models.Flag{models.SeenFlag, models.AnsweredFlag},
+		mb.messages[1].flags)
+	content, err = mb.content(mb.messages[1])
+	assert.Nil(err)
+	assert.Equal("Subject: third\nStatus: RO\nX-Status: A\n\nFrom me\n",
+		string(content))
+	changed, err := mb.changed()
+	assert.Nil(err)
+	assert.False(changed)
+}
+
+func TestLock(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "mbox")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "INBOX")
+
+	unlock, err := lock(path)
+	assert.Nil(err)
+	_, err = os.Stat(path + ".lock")
+	assert.Nil(err)
+	unlock()
+	_, err = os.Stat(path + ".lock")
+	assert.True(os.IsNotExist(err))
+}
@@ -0,0 +1,755 @@
+package mbox
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/backendutil"
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+	"github.com/fsnotify/fsnotify"
+	"github.com/mitchellh/go-homedir"
+
+	"git.sr.ht/~sircmpwn/aerc/models"
+	"git.sr.ht/~sircmpwn/aerc/worker/handlers"
+	"git.sr.ht/~sircmpwn/aerc/worker/lib"
+	"git.sr.ht/~sircmpwn/aerc/worker/types"
+)
+
+func init() {
+	handlers.RegisterWorkerFactory("mbox", NewWorker)
+}
+
+var errNoSelection = fmt.Errorf("no folder selected")
+
+var errUnsupported = fmt.Errorf("unsupported command")
+
+// How long to gather the changes of other programs to the selected mbox
+// before looking at them all at once
+const syncDelay = 250 * time.Millisecond
+
+// How long to gather flag changes before writing them to the mbox files, which
+// are written anew each time
+const flushDelay = 5 * time.Second
+
+// A Worker handles interfacing between aerc's UI and mbox files: either a
+// directory of them, one per folder, or a single one.
+type Worker struct {
+	// The directory of mbox files, or the single one
+	path   string
+	single bool
+	// The mailboxes opened so far, by folder name
+	mailboxes map[string]*mailbox
+	selected  *mailbox
+	// The name of the selected folder
+	selectedName string
+	// The flags of the messages of the selected mbox, as the UI knows them
+	known map[uint32][]models.Flag
+	// Fires when the changes to the selected mbox should be looked at
+	changed <-chan time.Time
+	// Fires when the flag changes should be written to the files
+	flush <-chan time.Time
+	// Receives Close, which waits for the flags to be written
+	closing chan chan error
+	worker  *types.Worker
+	watcher *fsnotify.Watcher
+}
+
+// NewWorker creates a new mbox worker with the provided worker.
+func NewWorker(worker *types.Worker) (types.Backend, error) {
+	watch, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("could not create file system watcher: %v", err)
+	}
+	return &Worker{
+		mailboxes: make(map[string]*mailbox),
+		closing:   make(chan chan error),
+		worker:    worker,
+		watcher:   watch,
+	}, nil
+}
+
+// Run starts the worker's message handling loop.
+func (w *Worker) Run() {
+	for {
+		select {
+		case action := <-w.worker.Actions:
+			w.handleAction(action)
+		case ev := <-w.watcher.Events:
+			// Writers may replace the file, so its directory is watched
+			if w.selected != nil && ev.Name == w.selected.path &&
+				w.changed == nil {
+
+				w.changed = time.After(syncDelay)
+			}
+		case <-w.changed:
+			w.changed = nil
+			if err := w.sync(); err != nil {
+				w.worker.Logger.Printf("could not rescan mbox: %v", err)
+			}
+		case <-w.flush:
+			w.flush = nil
+			w.writeFlags()
+		case done := <-w.closing:
+			done <- w.writeFlags()
+		}
+	}
+}
+
+// Close writes the flag changes which weren't written yet, when aerc exits
+func (w *Worker) Close() error {
+	done := make(chan error)
+	w.closing <- done
+	return <-done
+}
+
+// Writes the flag changes of all the mailboxes. Those which can't be written,
+// e.g. because another program holds the lock, are tried again later.
+func (w *Worker) writeFlags() error {
+	var err error
+	for _, mb := range w.mailboxes {
+		if len(mb.pending) == 0 {
+			continue
+		}
+		var e error
+		if mb == w.selected {
+			e = w.modify(func(mb *mailbox) error {
+				return mb.rewrite(nil)
+			})
+		} else {
+			e = mb.update(func() error {
+				return mb.rewrite(nil)
+			})
+		}
+		if e != nil {
+			w.worker.Logger.Printf("could not write flags to %s: %v",
+				mb.path, e)
+			err = e
+		}
+	}
+	if err != nil && w.flush == nil {
+		w.flush = time.After(flushDelay)
+	}
+	return err
+}
+
+func (w *Worker) handleAction(action types.WorkerMessage) {
+	msg := w.worker.ProcessAction(action)
+	if err := w.handleMessage(msg); err == errUnsupported {
+		w.worker.PostMessage(&types.Unsupported{
+			Message: types.RespondTo(msg),
+		}, nil)
+	} else if err != nil {
+		w.worker.PostMessage(&types.Error{
+			Message: types.RespondTo(msg),
+			Error:   err,
+		}, nil)
+	} else {
+		w.worker.PostMessage(&types.Done{Message: types.RespondTo(msg)}, nil)
+	}
+}
+
+func (w *Worker) err(msg types.WorkerMessage, err error) {
+	w.worker.PostMessage(&types.Error{
+		Message: types.RespondTo(msg),
+		Error:   err,
+	}, nil)
+}
+
+func (w *Worker) handleMessage(msg types.WorkerMessage) error {
+	switch msg := msg.(type) {
+	case *types.Unsupported:
+		// No-op
+	case *types.Configure:
+		return w.handleConfigure(msg)
+	case *types.Connect:
+		return nil
+	case *types.ListDirectories:
+		return w.handleListDirectories(msg)
+	case *types.OpenDirectory:
+		return w.handleOpenDirectory(msg)
+	case *types.FetchDirectoryContents:
+		return w.handleFetchDirectoryContents(msg)
+	case *types.CreateDirectory:
+		return w.handleCreateDirectory(msg)
+	case *types.FetchMessageHeaders:
+		return w.handleFetchMessageHeaders(msg)
+	case *types.FetchMessageBodyPart:
+		return w.handleFetchMessageBodyPart(msg)
+	case *types.FetchFullMessages:
+		return w.handleFetchFullMessages(msg)
+	case *types.DeleteMessages:
+		return w.handleDeleteMessages(msg)
+	case *types.ReadMessages:
+		return w.setFlag(msg, msg.Uids, models.SeenFlag, msg.Read)
+	case *types.FlagMessages:
+		if _, ok := xstatusLetters[msg.Flag]; !ok &&
+			msg.Flag != models.SeenFlag {
+
+			return errUnsupported
+		}
+		return w.setFlag(msg, msg.Uids, msg.Flag, msg.Enable)
+	case *types.CopyMessages:
+		return w.handleCopyMessages(msg)
+	case *types.AppendMessage:
+		return w.handleAppendMessage(msg)
+	case *types.SearchDirectory:
+		return w.handleSearchDirectory(msg)
+	}
+	return errUnsupported
+}
+
+func (w *Worker) handleConfigure(msg *types.Configure) error {
+	u, err := url.Parse(msg.Config.Source)
+	if err != nil {
+		w.worker.Logger.Printf("error configuring mbox worker: %v", err)
+		return err
+	}
+	path := u.Path
+	if u.Host == "~" {
+		home, err := homedir.Dir()
+		if err != nil {
+			return fmt.Errorf("could not resolve home directory: %v", err)
+		}
+		path = filepath.Join(home, u.Path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	w.path = path
+	w.single = !info.IsDir()
+	w.worker.Logger.Printf("configured mbox: %s", path)
+	return nil
+}
+
+// Returns the path of the mbox file of a folder
+func (w *Worker) folderPath(name string) string {
+	if w.single {
+		return w.path
+	}
+	return filepath.Join(w.path, filepath.FromSlash(name))
+}
+
+func (w *Worker) handleListDirectories(msg *types.ListDirectories) error {
+	var names []string
+	if w.single {
+		names = []string{filepath.Base(w.path)}
+	} else {
+		err := filepath.Walk(w.path, func(path string, info os.FileInfo,
+			err error) error {
+
+			if err != nil {
+				return err
+			}
+			name := info.Name()
+			if path != w.path && strings.HasPrefix(name, ".") {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.Mode().IsRegular() && !strings.HasSuffix(name, ".lock") {
+				rel, err := filepath.Rel(w.path, path)
+				if err != nil {
+					return err
+				}
+				names = append(names, filepath.ToSlash(rel))
+			}
+			return nil
+		})
+		if err != nil {
+			w.worker.Logger.Printf("error listing directories: %v", err)
+			return err
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		w.worker.PostMessage(&types.Directory{
+			Message: types.RespondTo(msg),
+			Dir: &models.Directory{
+				Name:       name,
+				Attributes: []string{},
+			},
+		}, nil)
+	}
+	return nil
+}
+
+func (w *Worker) handleOpenDirectory(msg *types.OpenDirectory) error {
+	w.worker.Logger.Printf("opening %s", msg.Directory)
+	// The flags changed in the folder being left are written now
+	w.writeFlags()
+	mb, ok := w.mailboxes[msg.Directory]
+	if !ok {
+		mb = newMailbox(w.folderPath(msg.Directory))
+	}
+	if err := mb.index(); err != nil {
+		return err
+	}
+	w.mailboxes[msg.Directory] = mb
+
+	if w.selected != nil {
+		if err := w.watcher.Remove(filepath.Dir(w.selected.path)); err != nil {
+			return fmt.Errorf("could not unwatch previous mbox: %v", err)
+		}
+	}
+	w.selected = mb
+	w.selectedName = msg.Directory
+	w.known = nil
+	if err := w.watcher.Add(filepath.Dir(mb.path)); err != nil {
+		return fmt.Errorf("could not add watch to mbox: %v", err)
+	}
+	w.worker.PostMessage(w.dirInfo(), nil)
+	return nil
+}
+
+// Returns the info of the selected mbox
+func (w *Worker) dirInfo() *types.DirectoryInfo {
+	unseen := 0
+	for _, msg := range w.selected.messages {
+		if !hasFlag(msg.flags, models.SeenFlag) {
+			unseen++
+		}
+	}
+	return &types.DirectoryInfo{
+		Info: &models.DirectoryInfo{
+			Name:     w.selectedName,
+			Flags:    []string{},
+			ReadOnly: false,
+			Exists:   len(w.selected.messages),
+			Recent:   0,
+			Unseen:   unseen,
+		},
+	}
+}
+
+// Remembers the flags of the messages as the UI knows them
+func (w *Worker) snapshot() {
+	w.known = make(map[uint32][]models.Flag, len(w.selected.messages))
+	for _, msg := range w.selected.messages {
+		w.known[msg.uid] = msg.flags
+	}
+}
+
+// sync indexes the selected mbox again if another program changed it, and
+// posts the differences: messages removed, reflagged or added.
+func (w *Worker) sync() error {
+	if w.selected == nil {
+		return nil
+	}
+	if changed, err := w.selected.changed(); err != nil || !changed {
+		return err
+	}
+	if err := w.selected.index(); err != nil {
+		return err
+	}
+	var deleted []uint32
+	for uid := range w.known {
+		if _, ok := w.selected.byUID[uid]; !ok {
+			deleted = append(deleted, uid)
+		}
+	}
+	if len(deleted) != 0 {
+		w.worker.PostMessage(&types.MessagesDeleted{Uids: deleted}, nil)
+	}
+	added := false
+	for _, msg := range w.selected.messages {
+		known, ok := w.known[msg.uid]
+		if !ok {
+			added = true
+		} else if !sameFlags(known, msg.flags) {
+			if info, err := w.messageInfo(msg); err != nil {
+				w.worker.Logger.Printf("could not get message info: %v", err)
+			} else {
+				w.worker.PostMessage(&types.MessageInfo{Info: info}, nil)
+			}
+		}
+	}
+	w.snapshot()
+	if added {
+		// The UI fetches the new contents upon the updated counts
+		w.worker.PostMessage(w.dirInfo(), nil)
+	}
+	return nil
+}
+
+func sameFlags(a []models.Flag, b []models.Flag) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, flag := range a {
+		if !hasFlag(b, flag) {
+			return false
+		}
+	}
+	return true
+}
+
+// Runs fn with the selected mbox locked and up to date
+func (w *Worker) modify(fn func(mb *mailbox) error) error {
+	if w.selected == nil {
+		return errNoSelection
+	}
+	unlock, err := lock(w.selected.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := w.sync(); err != nil {
+		return err
+	}
+	return fn(w.selected)
+}
+
+func (w *Worker) handleFetchDirectoryContents(
+	msg *types.FetchDirectoryContents) error {
+
+	if w.selected == nil {
+		return errNoSelection
+	}
+	if err := w.sync(); err != nil {
+		return err
+	}
+	uids := make([]uint32, len(w.selected.messages))
+	for i, m := range w.selected.messages {
+		uids[i] = m.uid
+	}
+	w.snapshot()
+	w.worker.PostMessage(&types.DirectoryContents{
+		Message: types.RespondTo(msg),
+		Uids:    uids,
+	}, nil)
+	return nil
+}
+
+func (w *Worker) handleCreateDirectory(msg *types.CreateDirectory) error {
+	if w.single {
+		return fmt.Errorf("cannot create folders in a single mbox file")
+	}
+	path := w.folderPath(msg.Directory)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if os.IsExist(err) && msg.Quiet {
+			return nil
+		}
+		return err
+	}
+	return f.Close()
+}
+
+// A message of the selected mbox, for worker/lib
+type rawMessage struct {
+	mb  *mailbox
+	msg *entry
+}
+
+func (m rawMessage) NewReader() (io.Reader, error) {
+	content, err := m.mb.content(m.msg)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(content), nil
+}
+
+func (m rawMessage) ModelFlags() ([]models.Flag, error) {
+	return m.msg.flags, nil
+}
+
+func (m rawMessage) UID() uint32 {
+	return m.msg.uid
+}
+
+func (w *Worker) message(uid uint32) (*entry, error) {
+	if w.selected == nil {
+		return nil, errNoSelection
+	}
+	msg, ok := w.selected.byUID[uid]
+	if !ok {
+		return nil, fmt.Errorf("could not find message with uid %d in %s",
+			uid, w.selectedName)
+	}
+	return msg, nil
+}
+
+func (w *Worker) messageInfo(msg *entry) (*models.MessageInfo, error) {
+	info, err := lib.MessageInfo(rawMessage{w.selected, msg})
+	if err != nil {
+		return nil, err
+	}
+	info.Size = uint32(msg.end - msg.offset)
+	return info, nil
+}
+
+func (w *Worker) handleFetchMessageHeaders(
+	msg *types.FetchMessageHeaders) error {
+
+	for _, uid := range msg.Uids {
+		m, err := w.message(uid)
+		if err != nil {
+			w.worker.Logger.Printf("could not get message: %v", err)
+			w.err(msg, err)
+			continue
+		}
+		info, err := w.messageInfo(m)
+		if err != nil {
+			w.worker.Logger.Printf("could not get message info: %v", err)
+			w.err(msg, err)
+			continue
+		}
+		w.worker.PostMessage(&types.MessageInfo{
+			Message: types.RespondTo(msg),
+			Info:    info,
+		}, nil)
+	}
+	return nil
+}
+
+func (w *Worker) handleFetchMessageBodyPart(
+	msg *types.FetchMessageBodyPart) error {
+
+	m, err := w.message(msg.Uid)
+	if err != nil {
+		return err
+	}
+	content, err := w.selected.content(m)
+	if err != nil {
+		return err
+	}
+	entity, err := message.Read(bytes.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("could not read message: %v", err)
+	}
+	r, err := lib.FetchEntityPartReader(entity, msg.Part)
+	if err != nil {
+		w.worker.Logger.Printf(
+			"could not get body part reader for message=%d, parts=%#v: %v",
+			msg.Uid, msg.Part, err)
+		return err
+	}
+	w.worker.PostMessage(&types.MessageBodyPart{
+		Message: types.RespondTo(msg),
+		Part: &models.MessageBodyPart{
+			Reader: r,
+			Uid:    msg.Uid,
+		},
+	}, nil)
+	return nil
+}
+
+func (w *Worker) handleFetchFullMessages(msg *types.FetchFullMessages) error {
+	for _, uid := range msg.Uids {
+		m, err := w.message(uid)
+		if err != nil {
+			return err
+		}
+		content, err := w.selected.content(m)
+		if err != nil {
+			return err
+		}
+		w.worker.PostMessage(&types.FullMessage{
+			Message: types.RespondTo(msg),
+			Content: &models.FullMessage{
+				Uid:    uid,
+				Reader: bytes.NewReader(content),
+			},
+		}, nil)
+	}
+	return nil
+}
+
+func (w *Worker) handleDeleteMessages(msg *types.DeleteMessages) error {
+	deleted := make(map[uint32]bool)
+	err := w.modify(func(mb *mailbox) error {
+		for _, uid := range msg.Uids {
+			if _, ok := mb.byUID[uid]; ok {
+				deleted[uid] = true
+			}
+		}
+		return mb.rewrite(deleted)
+	})
+	if err != nil {
+		return err
+	}
+	var uids []uint32
+	for uid := range deleted {
+		uids = append(uids, uid)
+		delete(w.known, uid)
+	}
+	if len(uids) != 0 {
+		w.worker.PostMessage(&types.MessagesDeleted{
+			Message: types.RespondTo(msg),
+			Uids:    uids,
+		}, nil)
+	}
+	return nil
+}
+
+// Sets or clears a flag of messages of the selected mbox
+func (w *Worker) setFlag(msg types.WorkerMessage, uids []uint32,
+	flag models.Flag, enable bool) error {
+
+	if w.selected == nil {
+		return errNoSelection
+	}
+	// The flags are written in a while, with the other changes until then
+	if err := w.sync(); err != nil {
+		return err
+	}
+	mb := w.selected
+	for _, uid := range uids {
+		m, ok := mb.byUID[uid]
+		if !ok || hasFlag(m.flags, flag) == enable {
+			continue
+		}
+		var newFlags []models.Flag
+		for _, f := range m.flags {
+			if f != flag {
+				newFlags = append(newFlags, f)
+			}
+		}
+		if enable {
+			newFlags = append(newFlags, flag)
+		}
+		mb.setFlags(m, newFlags)
+	}
+	if len(mb.pending) != 0 && w.flush == nil {
+		w.flush = time.After(flushDelay)
+	}
+	for _, uid := range uids {
+		m, err := w.message(uid)
+		if err != nil {
+			w.err(msg, err)
+			continue
+		}
+		info, err := w.messageInfo(m)
+		if err != nil {
+			w.worker.Logger.Printf("could not get message info: %v", err)
+			w.err(msg, err)
+			continue
+		}
+		if w.known != nil {
+			w.known[uid] = m.flags
+		}
+		w.worker.PostMessage(&types.MessageInfo{
+			Message: types.RespondTo(msg),
+			Info:    info,
+		}, nil)
+	}
+	return nil
+}
+
+// Appends a message to the mbox of a folder
+func (w *Worker) deliver(folder string, date time.Time,
+	content []byte) error {
+
+	path := w.folderPath(folder)
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("no folder %s: %v", folder, err)
+	}
+	unlock, err := lock(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return appendMessage(path, date, content)
+}
+
+func (w *Worker) handleCopyMessages(msg *types.CopyMessages) error {
+	for _, uid := range msg.Uids {
+		m, err := w.message(uid)
+		if err != nil {
+			return err
+		}
+		content, err := w.selected.content(m)
+		if err != nil {
+			return err
+		}
+		var date time.Time
+		if entity, err := message.Read(bytes.NewReader(content)); err == nil {
+			date, _ = mailDate(entity)
+		}
+		err = w.deliver(msg.Destination, date, setFlags(content, m.flags))
+		if err != nil {
+			return fmt.Errorf("could not copy message %d: %v", uid, err)
+		}
+	}
+	return nil
+}
+
+// Returns the date in the header of a message
+func mailDate(entity *message.Entity) (time.Time, error) {
+	header := mail.Header{Header: entity.Header}
+	return header.Date()
+}
+
+var imapFlags = map[string]models.Flag{
+	imap.SeenFlag:     models.SeenFlag,
+	imap.AnsweredFlag: models.AnsweredFlag,
+	imap.FlaggedFlag:  models.FlaggedFlag,
+	imap.DeletedFlag:  models.DeletedFlag,
+}
+
+func (w *Worker) handleAppendMessage(msg *types.AppendMessage) error {
+	content, err := ioutil.ReadAll(msg.Reader)
+	if err != nil {
+		w.worker.Logger.Printf("could not read message: %v", err)
+		return err
+	}
+	var flags []models.Flag
+	for _, name := range msg.Flags {
+		if flag, ok := imapFlags[name]; ok {
+			flags = append(flags, flag)
+		}
+	}
+	return w.deliver(msg.Destination, msg.Date, setFlags(content, flags))
+}
+
+func (w *Worker) handleSearchDirectory(msg *types.SearchDirectory) error {
+	if w.selected == nil {
+		return errNoSelection
+	}
+	var uids []uint32
+	for i, m := range w.selected.messages {
+		content, err := w.selected.content(m)
+		if err != nil {
+			return err
+		}
+		entity, err := message.Read(bytes.NewReader(content))
+		if err != nil {
+			continue
+		}
+		var flags []string
+		for name, flag := range imapFlags {
+			if hasFlag(m.flags, flag) {
+				flags = append(flags, name)
+			}
+		}
+		date, _ := mailDate(entity)
+		ok, err := backendutil.Match(entity, uint32(i+1), m.uid, date, flags,
+			msg.Criteria)
+		if err != nil {
+			continue
+		}
+		if ok {
+			uids = append(uids, m.uid)
+		}
+	}
+	w.worker.PostMessage(&types.SearchResults{
+		Message: types.RespondTo(msg),
+		Uids:    uids,
+	}, nil)
+	return nil
+}
@@ -85,6 +85,14 @@ func (m Message) MarkRead(seen bool) error {
 	return nil
 }
 
+// SetTag adds or removes a notmuch tag of the message.
+func (m Message) SetTag(tag string, enable bool) error {
+	if enable {
+		return m.msg.AddTag(tag)
+	}
+	return m.msg.RemoveTag(tag)
+}
+
 // tags returns the notmuch tags of a message
 func (m Message) tags() []string {
 	ts := m.msg.Tags()
@@ -15,6 +15,7 @@ import (
 	"git.sr.ht/~sircmpwn/aerc/models"
 	"git.sr.ht/~sircmpwn/aerc/worker/handlers"
 	"git.sr.ht/~sircmpwn/aerc/worker/types"
+	"github.com/kyoh86/xdg"
 	"github.com/mitchellh/go-homedir"
 	notmuch "github.com/zenhack/go.notmuch"
 )
@@ -90,6 +91,10 @@ func (w *worker) handleMessage(msg types.WorkerMessage) error {
 		return w.handleFetchFullMessages(msg)
 	case *types.ReadMessages:
 		return w.handleReadMessages(msg)
+	case *types.FlagMessages:
+		return w.handleFlagMessages(msg)
+	case *types.TagMessages:
+		return w.handleTagMessages(msg)
 		// TODO
 		// 	return w.handleSearchDirectory(msg)
 		// case *types.DeleteMessages:
@@ -117,7 +122,13 @@ func (w *worker) handleConfigure(msg *types.Configure) error {
 		return fmt.Errorf("could not resolve home directory: %v", err)
 	}
 	w.pathToDB = filepath.Join(home, u.Path)
-	w.uidStore = uidstore.NewStore()
+	// Keep the UIDs across runs
+	w.uidStore, err = uidstore.Load(filepath.Join(xdg.CacheHome(), "aerc",
+		"uids", "notmuch", url.PathEscape(w.pathToDB)))
+	if err != nil {
+		w.w.Logger.Printf("could not load UIDs, they won't persist: %v", err)
+		w.uidStore = uidstore.NewStore()
+	}
 
 	if err = w.loadQueryMap(msg.Config); err != nil {
 		return fmt.Errorf("could not load query map: %v", err)
@@ -135,6 +146,9 @@ func (w *worker) handleConnect(msg *types.Connect) error {
 	if err != nil {
 		return fmt.Errorf("could not connect to notmuch db: %v", err)
 	}
+	if err := w.pruneUIDs(); err != nil {
+		w.w.Logger.Printf("could not prune UIDs: %v", err)
+	}
 	w.done(msg)
 	return nil
 }
@@ -169,7 +183,6 @@ func (w *worker) handleOpenDirectory(msg *types.OpenDirectory) error {
 			return err
 		}
 	}
-	//TODO: why does this need to be sent twice??
 	info := &types.DirectoryInfo{
 		Info: &models.DirectoryInfo{
 			Name:     msg.Directory,
@@ -184,7 +197,6 @@ func (w *worker) handleOpenDirectory(msg *types.OpenDirectory) error {
 		},
 	}
 	w.w.PostMessage(info, nil)
-	w.w.PostMessage(info, nil)
 	w.done(msg)
 	return nil
 }
@@ -240,9 +252,32 @@ func (w *worker) uidsFromQuery(query *notmuch.Query) ([]uint32, error) {
 		uids = append(uids, uid)
 
 	}
+	// Only written if new messages got a UID
+	if err := w.uidStore.Save(); err != nil {
+		w.w.Logger.Printf("could not save UIDs: %v", err)
+	}
 	return uids, nil
 }
 
+// Forgets the UIDs of the messages which are gone from the database. The UIDs
+// are shared by all the queries, so they are pruned against all the messages,
+// once per run.
+func (w *worker) pruneUIDs() error {
+	query := w.db.NewQuery("*")
+	defer query.Close()
+	msgs, err := query.Messages()
+	if err != nil {
+		return err
+	}
+	var msg *notmuch.Message
+	var keys []string
+	for msgs.Next(&msg) {
+		keys = append(keys, msg.ID())
+	}
+	w.uidStore.Retain(keys)
+	return w.uidStore.Save()
+}
+
 func (w *worker) msgFromUid(uid uint32) (*Message, error) {
 	key, ok := w.uidStore.GetKey(uid)
 	if !ok {
@@ -354,6 +389,62 @@ func (w *worker) handleReadMessages(msg *types.ReadMessages) error {
 	return nil
 }
 
+var flagTags = map[models.Flag]string{
+	models.AnsweredFlag: "replied",
+	models.FlaggedFlag:  "flagged",
+}
+
+func (w *worker) handleFlagMessages(msg *types.FlagMessages) error {
+	if msg.Flag == models.SeenFlag {
+		return w.handleReadMessages(&types.ReadMessages{
+			Message: msg.Message,
+			Read:    msg.Enable,
+			Uids:    msg.Uids,
+		})
+	}
+	tag, ok := flagTags[msg.Flag]
+	if !ok {
+		return errUnsupported
+	}
+	return w.modifyTags(msg, msg.Uids, []string{tag}, msg.Enable)
+}
+
+func (w *worker) handleTagMessages(msg *types.TagMessages) error {
+	return w.modifyTags(msg, msg.Uids, msg.Tags, true)
+}
+
+func (w *worker) modifyTags(msg types.WorkerMessage, uids []uint32,
+	tags []string, enable bool) error {
+
+	for _, uid := range uids {
+		m, err := w.msgFromUid(uid)
+		if err != nil {
+			w.w.Logger.Printf("could not get message: %v", err)
+			w.err(msg, err)
+			continue
+		}
+		for _, tag := range tags {
+			if err := m.SetTag(tag, enable); err != nil {
+				w.w.Logger.Printf("could not modify tags: %v", err)
+				w.err(msg, err)
+				continue
+			}
+		}
+		info, err := m.MessageInfo()
+		if err != nil {
+			w.w.Logger.Printf("could not get message info: %v", err)
+			w.err(msg, err)
+			continue
+		}
+		w.w.PostMessage(&types.MessageInfo{
+			Message: types.RespondTo(msg),
+			Info:    info,
+		}, nil)
+	}
+	w.done(msg)
+	return nil
+}
+
 func (w *worker) loadQueryMap(acctConfig *config.AccountConfig) error {
 	raw, ok := acctConfig.Params["query-map"]
 	if !ok {
@@ -0,0 +1,144 @@
+package pop3
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A conn is a POP3 session (RFC 1939), with STLS (RFC 2595)
+type conn struct {
+	text *textproto.Conn
+	net  net.Conn
+}
+
+const dialTimeout = 30 * time.Second
+
+// Connects to a server: with TLS for pop3s, with STLS for pop3 unless
+// insecure is set
+func dial(scheme string, addr string, insecure bool) (*conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{ServerName: host}
+	var nc net.Conn
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	switch scheme {
+	case "pop3s":
+		nc, err = tls.DialWithDialer(dialer, "tcp", addr, config)
+	case "pop3":
+		nc, err = dialer.Dial("tcp", addr)
+	default:
+		return nil, fmt.Errorf("Unknown POP3 scheme %s", scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{text: textproto.NewConn(nc), net: nc}
+	if _, err := c.response(); err != nil {
+		c.text.Close()
+		return nil, err
+	}
+	if scheme == "pop3" && !insecure {
+		if _, err := c.cmd("STLS"); err != nil {
+			c.text.Close()
+			return nil, fmt.Errorf("STLS: %v", err)
+		}
+		tc := tls.Client(nc, config)
+		if err := tc.Handshake(); err != nil {
+			c.text.Close()
+			return nil, err
+		}
+		c.text = textproto.NewConn(tc)
+		c.net = tc
+	}
+	return c, nil
+}
+
+// Reads a status line, and returns its text if it's +OK
+func (c *conn) response() (string, error) {
+	line, err := c.text.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case strings.HasPrefix(line, "+OK"):
+		return strings.TrimSpace(line[len("+OK"):]), nil
+	case strings.HasPrefix(line, "-ERR"):
+		return "", fmt.Errorf("%s", strings.TrimSpace(line[len("-ERR"):]))
+	}
+	return "", fmt.Errorf("unexpected POP3 response: %q", line)
+}
+
+func (c *conn) cmd(format string, args ...interface{}) (string, error) {
+	c.net.SetDeadline(time.Now().Add(dialTimeout))
+	if err := c.text.PrintfLine(format, args...); err != nil {
+		return "", err
+	}
+	return c.response()
+}
+
+func (c *conn) login(user string, password string) error {
+	if _, err := c.cmd("USER %s", user); err != nil {
+		return fmt.Errorf("USER: %v", err)
+	}
+	if _, err := c.cmd("PASS %s", password); err != nil {
+		return fmt.Errorf("PASS: %v", err)
+	}
+	return nil
+}
+
+// Returns the unique IDs of the messages on the server, by message number
+func (c *conn) uidl() (map[int]string, error) {
+	if _, err := c.cmd("UIDL"); err != nil {
+		return nil, fmt.Errorf("UIDL: %v", err)
+	}
+	lines, err := c.text.ReadDotLines()
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[int]string, len(lines))
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid UIDL line: %q", line)
+		}
+		n, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid UIDL line: %q", line)
+		}
+		ids[n] = fields[1]
+	}
+	return ids, nil
+}
+
+// Downloads a message, with LF line endings
+func (c *conn) retr(n int) ([]byte, error) {
+	if _, err := c.cmd("RETR %d", n); err != nil {
+		return nil, fmt.Errorf("RETR: %v", err)
+	}
+	// Messages may take a while
+	c.net.SetDeadline(time.Now().Add(10 * time.Minute))
+	return c.text.ReadDotBytes()
+}
+
+func (c *conn) dele(n int) error {
+	if _, err := c.cmd("DELE %d", n); err != nil {
+		return fmt.Errorf("DELE: %v", err)
+	}
+	return nil
+}
+
+// Ends the session, which is when the server deletes messages
+func (c *conn) quit() error {
+	defer c.text.Close()
+	if _, err := c.cmd("QUIT"); err != nil {
+		return fmt.Errorf("QUIT: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package pop3
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-maildir"
+)
+
+// A fetcher downloads the messages of a POP3 mailbox into a maildir
+type fetcher struct {
+	scheme   string
+	addr     string
+	insecure bool
+	user     string
+	password string
+	dir      maildir.Dir
+	// The file of the messages fetched so far
+	statePath string
+	// Whether messages stay on the server once fetched, and if so for how
+	// long; zero is forever
+	leave       bool
+	deleteAfter time.Duration
+}
+
+// Reads the messages fetched so far: a line per message, with its UIDL and
+// when it was fetched
+func loadState(path string) (map[string]time.Time, error) {
+	state := make(map[string]time.Time)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: invalid entry", path, n)
+		}
+		at, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid time", path, n)
+		}
+		state[fields[0]] = time.Unix(at, 0)
+	}
+	return state, scanner.Err()
+}
+
+func saveState(path string, state map[string]time.Time) error {
+	var ids []string
+	for id := range state {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var b strings.Builder
+	for _, id := range ids {
+		fmt.Fprintf(&b, "%s %d\n", id, state[id].Unix())
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(b.String()), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (f *fetcher) deliver(content []byte) error {
+	d, err := f.dir.NewDelivery()
+	if err != nil {
+		return err
+	}
+	if _, err := d.Write(content); err != nil {
+		d.Abort()
+		return err
+	}
+	return d.Close()
+}
+
+// fetch downloads the new messages, and deletes from the server those which
+// should not stay there. It returns how many messages it downloaded.
+//
+// Deletions only take effect once the session ends well, so messages are
+// never deleted unless they were delivered.
+func (f *fetcher) fetch() (int, error) {
+	state, err := loadState(f.statePath)
+	if err != nil {
+		return 0, err
+	}
+	c, err := dial(f.scheme, f.addr, f.insecure)
+	if err != nil {
+		return 0, err
+	}
+	defer c.text.Close()
+	if err := c.login(f.user, f.password); err != nil {
+		return 0, err
+	}
+	ids, err := c.uidl()
+	if err != nil {
+		return 0, err
+	}
+	var numbers []int
+	for n := range ids {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	now := time.Now()
+	fetched := 0
+	onServer := make(map[string]bool, len(ids))
+	var deleted []string
+	for _, n := range numbers {
+		id := ids[n]
+		onServer[id] = true
+		at, ok := state[id]
+		if !ok {
+			content, err := c.retr(n)
+			if err != nil {
+				return fetched, err
+			}
+			if err := f.deliver(content); err != nil {
+				return fetched, fmt.Errorf("could not deliver message: %v",
+					err)
+			}
+			at = now
+			state[id] = at
+			fetched++
+			// Saved along, so a broken session fetches nothing twice
+			if err := saveState(f.statePath, state); err != nil {
+				return fetched, err
+			}
+		}
+		if !f.leave || (f.deleteAfter != 0 && now.Sub(at) >= f.deleteAfter) {
+			if err := c.dele(n); err != nil {
+				return fetched, err
+			}
+			deleted = append(deleted, id)
+		}
+	}
+	if err := c.quit(); err != nil {
+		return fetched, err
+	}
+	// The messages gone from the server need no tracking anymore
+	for _, id := range deleted {
+		delete(onServer, id)
+	}
+	for id := range state {
+		if !onServer[id] {
+			delete(state, id)
+		}
+	}
+	return fetched, saveState(f.statePath, state)
+}
@@ -0,0 +1,195 @@
+package pop3
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-maildir"
+)
+
+// A POP3 server with a single mailbox
+type mockServer struct {
+	sync.Mutex
+	ln       net.Listener
+	uidls    []string
+	messages map[string]string
+}
+
+func newMockServer(t *testing.T, messages map[string]string) *mockServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &mockServer{ln: ln, messages: messages}
+	for uidl := range messages {
+		s.uidls = append(s.uidls, uidl)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *mockServer) serve(c net.Conn) {
+	defer c.Close()
+	s.Lock()
+	uidls := append([]string(nil), s.uidls...)
+	s.Unlock()
+	deleted := make(map[string]bool)
+	r := bufio.NewReader(c)
+	fmt.Fprintf(c, "+OK ready\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		var n int
+		cmd := strings.Fields(line)[0]
+		if len(strings.Fields(line)) > 1 {
+			fmt.Sscan(strings.Fields(line)[1], &n)
+		}
+		switch {
+		case cmd == "USER":
+			fmt.Fprintf(c, "+OK\r\n")
+		case cmd == "PASS" && strings.TrimSpace(line) == "PASS secret":
+			fmt.Fprintf(c, "+OK\r\n")
+		case cmd == "UIDL":
+			fmt.Fprintf(c, "+OK\r\n")
+			for i, uidl := range uidls {
+				fmt.Fprintf(c, "%d %s\r\n", i+1, uidl)
+			}
+			fmt.Fprintf(c, ".\r\n")
+		case cmd == "RETR" && n >= 1 && n <= len(uidls):
+			s.Lock()
+			content := s.messages[uidls[n-1]]
+			s.Unlock()
+			content = strings.ReplaceAll(content, "\n.", "\n..")
+			content = strings.ReplaceAll(content, "\n", "\r\n")
+			fmt.Fprintf(c, "+OK\r\n%s.\r\n", content)
+		case cmd == "DELE" && n >= 1 && n <= len(uidls):
+			deleted[uidls[n-1]] = true
+			fmt.Fprintf(c, "+OK\r\n")
+		case cmd == "QUIT":
+			s.Lock()
+			s.uidls = nil
+			for _, uidl := range uidls {
+				if deleted[uidl] {
+					delete(s.messages, uidl)
+				} else {
+					s.uidls = append(s.uidls, uidl)
+				}
+			}
+			s.Unlock()
+			fmt.Fprintf(c, "+OK\r\n")
+			return
+		default:
+			fmt.Fprintf(c, "-ERR no\r\n")
+		}
+	}
+}
+
+func newFetcher(t *testing.T, s *mockServer) *fetcher {
+	dir := t.TempDir()
+	d := maildir.Dir(dir)
+	if err := d.Create(); err != nil {
+		t.Fatal(err)
+	}
+	return &fetcher{
+		scheme:    "pop3",
+		addr:      s.ln.Addr().String(),
+		insecure:  true,
+		user:      "me",
+		password:  "secret",
+		dir:       d,
+		statePath: filepath.Join(dir, "pop3-uidl"),
+	}
+}
+
+func delivered(t *testing.T, f *fetcher) []string {
+	files, err := filepath.Glob(filepath.Join(string(f.dir), "new", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var contents []string
+	for _, file := range files {
+		content, _ := ioutil.ReadFile(file)
+		contents = append(contents, string(content))
+	}
+	return contents
+}
+
+func TestFetch(t *testing.T) {
+	s := newMockServer(t, map[string]string{
+		"a": "Subject: a\n\n.dotted\n",
+		"b": "Subject: b\n\nb\n",
+	})
+	f := newFetcher(t, s)
+	n, err := f.fetch()
+	if err != nil || n != 2 {
+		t.Fatalf("fetched %d: %v", n, err)
+	}
+	contents := delivered(t, f)
+	if len(contents) != 2 || (contents[0] != "Subject: a\n\n.dotted\n" &&
+		contents[1] != "Subject: a\n\n.dotted\n") {
+
+		t.Errorf("wrong messages delivered: %q", contents)
+	}
+	if len(s.messages) != 0 {
+		t.Errorf("messages left on server: %v", s.messages)
+	}
+}
+
+func TestLeaveOnServer(t *testing.T) {
+	s := newMockServer(t, map[string]string{
+		"a": "Subject: a\n\na\n",
+		"b": "Subject: b\n\nb\n",
+	})
+	f := newFetcher(t, s)
+	f.leave = true
+	f.deleteAfter = 24 * time.Hour
+	if n, err := f.fetch(); err != nil || n != 2 {
+		t.Fatalf("fetched %d: %v", n, err)
+	}
+	if n, err := f.fetch(); err != nil || n != 0 {
+		t.Fatalf("fetched %d again: %v", n, err)
+	}
+	if len(s.messages) != 2 {
+		t.Errorf("messages gone from server: %v", s.messages)
+	}
+
+	// Once old enough, they go
+	state, err := loadState(f.statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state["a"] = time.Now().Add(-48 * time.Hour)
+	if err := saveState(f.statePath, state); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.fetch(); err != nil || n != 0 {
+		t.Fatalf("fetched %d again: %v", n, err)
+	}
+	if _, ok := s.messages["a"]; ok || len(s.messages) != 1 {
+		t.Errorf("wrong messages on server: %v", s.messages)
+	}
+	if state, _ := loadState(f.statePath); len(state) != 1 {
+		t.Errorf("wrong state: %v", state)
+	}
+	if len(delivered(t, f)) != 2 {
+		t.Errorf("wrong messages delivered")
+	}
+}
@@ -0,0 +1,164 @@
+package pop3
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-maildir"
+	"github.com/kyoh86/xdg"
+	"github.com/mitchellh/go-homedir"
+
+	"git.sr.ht/~sircmpwn/aerc/worker/handlers"
+	maildirworker "git.sr.ht/~sircmpwn/aerc/worker/maildir"
+	"git.sr.ht/~sircmpwn/aerc/worker/types"
+)
+
+func init() {
+	handlers.RegisterWorkerFactory("pop3", NewWorker)
+	handlers.RegisterWorkerFactory("pop3s", NewWorker)
+}
+
+const defaultInterval = 5 * time.Minute
+
+// A Worker downloads the messages of a POP3 mailbox into a local maildir,
+// which a maildir worker serves to the UI.
+type Worker struct {
+	worker *types.Worker
+	// The maildir worker, which gets the actions of the UI
+	maildir  *types.Worker
+	fetcher  *fetcher
+	interval time.Duration
+	fetching bool
+}
+
+// NewWorker creates a new POP3 worker with the provided worker.
+func NewWorker(worker *types.Worker) (types.Backend, error) {
+	inner := types.NewWorker(worker.Logger)
+	inner.Messages = worker.Messages
+	backend, err := maildirworker.NewWorker(inner)
+	if err != nil {
+		return nil, err
+	}
+	inner.Backend = backend
+	return &Worker{worker: worker, maildir: inner}, nil
+}
+
+// Run starts the maildir worker, and hands it the actions of the UI.
+func (w *Worker) Run() {
+	go w.maildir.Backend.Run()
+	for action := range w.worker.Actions {
+		switch msg := action.(type) {
+		case *types.Configure:
+			config, err := w.configure(msg)
+			if err != nil {
+				w.worker.Logger.Printf("error configuring POP3 worker: %v",
+					err)
+				w.worker.PostMessage(&types.Error{
+					Message: types.RespondTo(msg),
+					Error:   err,
+				}, nil)
+				continue
+			}
+			action = config
+		case *types.Connect:
+			if !w.fetching && w.fetcher != nil {
+				w.fetching = true
+				go w.fetchLoop()
+			}
+		}
+		w.maildir.Actions <- action
+	}
+}
+
+// Sets up the fetcher, and returns the configuration of the maildir worker
+func (w *Worker) configure(msg *types.Configure) (*types.Configure, error) {
+	u, err := url.Parse(msg.Config.Source)
+	if err != nil {
+		return nil, err
+	}
+	params := msg.Config.Params
+	f := &fetcher{scheme: u.Scheme, addr: u.Host}
+	if strings.HasSuffix(f.scheme, "+insecure") {
+		f.scheme = strings.TrimSuffix(f.scheme, "+insecure")
+		f.insecure = true
+	}
+	if u.Port() == "" {
+		port := "110"
+		if f.scheme == "pop3s" {
+			port = "995"
+		}
+		f.addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	if u.User != nil {
+		f.user = u.User.Username()
+		f.password, _ = u.User.Password()
+	}
+
+	dir := filepath.Join(xdg.DataHome(), "aerc", "pop3",
+		url.PathEscape(f.user+"@"+u.Hostname()))
+	if path, ok := params["pop3-maildir"]; ok {
+		if dir, err = homedir.Expand(path); err != nil {
+			return nil, err
+		}
+		if dir, err = filepath.Abs(dir); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0700); err != nil {
+		return nil, err
+	}
+	f.dir = maildir.Dir(dir)
+	if err := f.dir.Create(); err != nil {
+		return nil, fmt.Errorf("could not create maildir %s: %v", dir, err)
+	}
+	f.statePath = filepath.Join(dir, "pop3-uidl")
+
+	if value, ok := params["pop3-leave-on-server"]; ok {
+		f.leave = value == "yes"
+	}
+	if value, ok := params["pop3-delete-after"]; ok {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 1 {
+			return nil, fmt.Errorf("invalid pop3-delete-after %q", value)
+		}
+		f.leave = true
+		f.deleteAfter = time.Duration(days) * 24 * time.Hour
+	}
+	w.interval = defaultInterval
+	if value, ok := params["pop3-interval"]; ok {
+		if w.interval, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("invalid pop3-interval %q", value)
+		}
+	}
+	w.fetcher = f
+	w.worker.Logger.Printf("configured POP3: %s into %s", f.addr, dir)
+
+	config := *msg.Config
+	config.Source = (&url.URL{Scheme: "maildir", Path: dir}).String()
+	return &types.Configure{Message: msg.Message, Config: &config}, nil
+}
+
+// Fetches the messages now and then. The maildir worker picks up the new ones
+// as other deliveries.
+func (w *Worker) fetchLoop() {
+	for {
+		n, err := w.fetcher.fetch()
+		if err != nil {
+			w.worker.PostMessage(&types.Error{
+				Error: fmt.Errorf("could not fetch POP3 messages: %v", err),
+			}, nil)
+		} else {
+			w.worker.Logger.Printf("fetched %d POP3 messages", n)
+		}
+		if w.interval <= 0 {
+			return
+		}
+		time.Sleep(w.interval)
+	}
+}
@@ -121,6 +121,21 @@ type ReadMessages struct {
 	Uids []uint32
 }
 
+// Sets or clears a flag of messages
+type FlagMessages struct {
+	Message
+	Flag   models.Flag
+	Enable bool
+	Uids   []uint32
+}
+
+// Adds tags to messages: labels with notmuch, keywords with IMAP
+type TagMessages struct {
+	Message
+	Tags []string
+	Uids []uint32
+}
+
 type CopyMessages struct {
 	Message
 	Destination string
@@ -136,6 +151,14 @@ type AppendMessage struct {
 	Length      int
 }
 
+// Sends a message through the backend, for those which submit mail as well
+type SendMessage struct {
+	Message
+	From   string
+	Rcpts  []string
+	Reader io.Reader
+}
+
 // Messages
 
 type Directory struct {
@@ -146,6 +169,8 @@ type Directory struct {
 type DirectoryInfo struct {
 	Message
 	Info *models.DirectoryInfo
+	// Only the counts changed, the UI keeps the contents it has
+	SkipContents bool
 }
 
 type DirectoryContents struct {
@@ -153,6 +178,12 @@ type DirectoryContents struct {
 	Uids []uint32
 }
 
+// New messages of the selected directory, after the ones the UI has
+type MessagesAdded struct {
+	Message
+	Uids []uint32
+}
+
 type SearchResults struct {
 	Message
 	Uids []uint32
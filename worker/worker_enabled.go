@@ -3,3 +3,6 @@ package worker
 // the following workers are always enabled
 import _ "git.sr.ht/~sircmpwn/aerc/worker/imap"
 import _ "git.sr.ht/~sircmpwn/aerc/worker/maildir"
+import _ "git.sr.ht/~sircmpwn/aerc/worker/mbox"
+import _ "git.sr.ht/~sircmpwn/aerc/worker/jmap"
+import _ "git.sr.ht/~sircmpwn/aerc/worker/pop3"